@@ -14,7 +14,6 @@ import (
 
 func TestAuthenticateWithWrongPasswordFailure(t *testing.T) {
 	tdb := setup(t)
-	defer tdb.teardown(t)
 	fixtures.AddUser(tdb.store.User, "foo", "bar", false)
 
 	app := fiber.New()
@@ -45,7 +44,6 @@ func TestAuthenticateWithWrongPasswordFailure(t *testing.T) {
 
 func TestAuthenticateSuccess(t *testing.T) {
 	tdb := setup(t)
-	defer tdb.teardown(t)
 	insertedUser := fixtures.AddUser(tdb.store.User, "foo", "bar", false)
 
 	app := fiber.New()
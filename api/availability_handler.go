@@ -0,0 +1,183 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kmogilevskii/hotel-reservation/db"
+	"github.com/kmogilevskii/hotel-reservation/errors"
+	"github.com/kmogilevskii/hotel-reservation/pricing"
+	"github.com/kmogilevskii/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type AvailabilityHandler struct {
+	store *db.Store
+}
+
+func NewAvailabilityHandler(store *db.Store) *AvailabilityHandler {
+	return &AvailabilityHandler{store: store}
+}
+
+type RoomOffer struct {
+	Room  *types.Room           `json:"room"`
+	Price *types.PriceBreakdown `json:"price"`
+}
+
+type HotelAvailability struct {
+	Hotel *types.Hotel `json:"hotel"`
+	Rooms []RoomOffer  `json:"rooms"`
+}
+
+// parseDate accepts both full RFC 3339 timestamps and plain dates.
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, s)
+}
+
+// HandleGetAvailability lists the hotels having at least one room free for the
+// whole stay that sleeps the guests, children included. Pagination applies to
+// the hotels that have availability.
+func (h *AvailabilityHandler) HandleGetAvailability(c *fiber.Ctx) error {
+	var params db.AvailabilityQueryParams
+	if err := c.QueryParser(&params); err != nil {
+		return errors.ErrBadRequest()
+	}
+	from, err := parseDate(params.From)
+	if err != nil {
+		return errors.NewError(http.StatusBadRequest, "invalid from date")
+	}
+	till, err := parseDate(params.Till)
+	if err != nil {
+		return errors.NewError(http.StatusBadRequest, "invalid till date")
+	}
+	if params.Guests == 0 {
+		params.Guests = 1
+	}
+	stay := types.BookParams{
+		FromDate:    types.Date{Time: from},
+		TillDate:    types.Date{Time: till},
+		NumPersons:  params.Guests,
+		NumChildren: params.Children,
+	}
+	if err := stay.Validate(); err != nil {
+		return errors.NewError(http.StatusBadRequest, err.Error())
+	}
+	cv, err := newConverter(c, h.store)
+	if err != nil {
+		return err
+	}
+
+	filter := db.Map{}
+	if params.Location != "" {
+		filter["location"] = params.Location
+	}
+	if params.MinRating > 0 {
+		filter["rating"] = db.Map{"$gte": params.MinRating}
+	}
+	hotels, err := h.store.Hotel.GetHotels(c.Context(), filter, &db.Pagination{})
+	if err != nil {
+		return err
+	}
+
+	skip := int((params.Page - 1) * params.Limit)
+	if skip < 0 {
+		skip = 0
+	}
+	results := []HotelAvailability{}
+	for _, hotel := range hotels {
+		if params.Limit > 0 && len(results) == skip+int(params.Limit) {
+			break
+		}
+		offers, err := h.availableRooms(c.Context(), cv, hotel, stay.LocalizeFor(hotel), params.MaxPrice)
+		if err != nil {
+			return err
+		}
+		if len(offers) == 0 {
+			continue
+		}
+		results = append(results, HotelAvailability{Hotel: hotel, Rooms: offers})
+	}
+	if skip > len(results) {
+		skip = len(results)
+	}
+	results = results[skip:]
+
+	resp := db.ResourceResponse{
+		Results: len(results),
+		Data:    results,
+		Page:    params.Page,
+	}
+	return c.JSON(resp)
+}
+
+// availableRooms lists the rooms of the hotel free for the stay and prices
+// them, converted by the converter. Rooms of a type are listed and priced
+// under the type's terms. The list price cap applies before conversion.
+func (h *AvailabilityHandler) availableRooms(ctx context.Context, cv *converter, hotel *types.Hotel, stay types.BookParams, maxPrice float64) ([]RoomOffer, error) {
+	if err := cv.hotel(hotel); err != nil {
+		return nil, err
+	}
+	rooms, err := h.store.Room.GetRooms(ctx, db.Map{"hotelID": hotel.ID}, &db.Pagination{})
+	if err != nil {
+		return nil, err
+	}
+	roomTypes := map[primitive.ObjectID]*types.RoomType{}
+	offers := []RoomOffer{}
+	for _, room := range rooms {
+		var roomType *types.RoomType
+		if !room.RoomTypeID.IsZero() {
+			roomType = roomTypes[room.RoomTypeID]
+			if roomType == nil {
+				if roomType, err = getRoomType(ctx, h.store.RoomType, room.RoomTypeID.Hex()); err != nil {
+					return nil, err
+				}
+				roomTypes[room.RoomTypeID] = roomType
+			}
+			sellAs(room, roomType)
+		}
+		if maxPrice > 0 && room.Price > maxPrice {
+			continue
+		}
+		if room.CheckOccupancy(stay.Adults(), stay.NumChildren) != nil {
+			continue
+		}
+		if types.CheckRestrictions(stayRestrictions(hotel, roomType, room), stay) != nil {
+			continue
+		}
+		ok, err := h.store.Booking.IsRoomAvailable(ctx, room.ID, stay.FromDate.Time, stay.TillDate.Time, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		if err := cv.room(room); err != nil {
+			return nil, err
+		}
+		price, err := pricing.ComputeRates(pricing.Rates(room, pricing.Currency(hotel)), stay.FromDate.Time, stay.TillDate.Time, stay.NumPersons)
+		if err != nil {
+			return nil, errors.NewError(http.StatusBadRequest, err.Error())
+		}
+		if err := pricing.ApplyTaxes(price, hotel.Taxes, stay); err != nil {
+			return nil, errors.NewError(http.StatusBadRequest, err.Error())
+		}
+		offers = append(offers, RoomOffer{
+			Room:  room,
+			Price: price,
+		})
+	}
+	return offers, nil
+}
+
+// sellAs lists a room with the terms of the type it is sold as: its size,
+// its rates and what it sleeps.
+func sellAs(room *types.Room, roomType *types.RoomType) {
+	room.Size, room.Seaside = roomType.Size, roomType.Seaside
+	room.Price, room.Rates = roomType.Price, roomType.Rates
+	room.Capacity = roomType.Capacity
+}
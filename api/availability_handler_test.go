@@ -0,0 +1,117 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kmogilevskii/hotel-reservation/db/fixtures"
+	"github.com/kmogilevskii/hotel-reservation/errors"
+	"github.com/kmogilevskii/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type availabilityResp struct {
+	Results int                 `json:"results"`
+	Data    []HotelAvailability `json:"data"`
+}
+
+func TestHandleGetAvailability(t *testing.T) {
+	tdb := setup(t)
+	var (
+		user         = fixtures.AddUser(tdb.store.User, "foo", "bar", false)
+		hilton       = fixtures.AddHotel(tdb.store.Hotel, "Hilton", "New York", 5)
+		plaza        = fixtures.AddHotel(tdb.store.Hotel, "Plaza", "New York", 3)
+		ritz         = fixtures.AddHotel(tdb.store.Hotel, "Ritz", "Paris", 5)
+		bookedRoom   = fixtures.AddRoom(tdb.store.Room, hilton.ID, "Single", 99.99)
+		freeRoom     = fixtures.AddRoom(tdb.store.Room, hilton.ID, "Double", 150)
+		plazaRoom    = fixtures.AddRoom(tdb.store.Room, plaza.ID, "Single", 80)
+		from         = time.Now().AddDate(0, 0, 2).Truncate(24 * time.Hour)
+		till         = from.AddDate(0, 0, 3)
+		app          = fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
+		availHandler = NewAvailabilityHandler(tdb.store)
+	)
+	fixtures.AddRoom(tdb.store.Room, ritz.ID, "Single", 120)
+	fixtures.AddBooking(tdb.store.Booking, user.ID, bookedRoom.ID, from.AddDate(0, 0, -1), from.AddDate(0, 0, 1), 1)
+	fixtures.AddBooking(tdb.store.Booking, user.ID, plazaRoom.ID, from, till, 1)
+	app.Get("/", JWTAuthentication(tdb.store.User), availHandler.HandleGetAvailability)
+
+	get := func(query string) (*http.Response, availabilityResp) {
+		req := httptest.NewRequest("GET", "/?"+query, nil)
+		req.Header.Set("X-Api-Token", CreateTokenFromUser(user))
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body availabilityResp
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp, body
+	}
+
+	stay := fmt.Sprintf("from=%s&till=%s", from.Format(time.RFC3339), till.Format(time.RFC3339))
+	resp, body := get(stay + "&location=New%20York")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code 200, got %d", resp.StatusCode)
+	}
+	if body.Results != 1 || body.Data[0].Hotel.ID != hilton.ID {
+		t.Fatalf("expected only the Hilton to have availability, got %+v", body.Data)
+	}
+	rooms := body.Data[0].Rooms
+	if len(rooms) != 1 || rooms[0].Room.ID != freeRoom.ID {
+		t.Fatalf("expected only the free room, got %+v", rooms)
+	}
+	price := rooms[0].Price
+	if len(price.Nights) != 3 || price.Total.Amount != 45000 || price.Total.Currency != "USD" {
+		t.Fatalf("expected 3 nights for 450 USD, got %d nights for %+v", len(price.Nights), price.Total)
+	}
+
+	_, body = get(stay + "&maxPrice=130")
+	if body.Results != 1 || body.Data[0].Hotel.ID != ritz.ID {
+		t.Fatalf("expected only the Ritz under the price cap, got %+v", body.Data)
+	}
+
+	_, body = get(stay + "&minRating=4&page=2&limit=1")
+	if body.Results != 1 || body.Data[0].Hotel.ID != ritz.ID {
+		t.Fatalf("expected the Ritz on the second page, got %+v", body.Data)
+	}
+
+	// rooms of a type are listed under the type's terms
+	savoy := fixtures.AddHotel(tdb.store.Hotel, "Savoy", "London", 5)
+	suite := &types.RoomType{ID: primitive.NewObjectID(), HotelID: savoy.ID, Name: "Suite", Size: "Suite", Price: 200, Capacity: types.Capacity{MaxAdults: 2}}
+	if _, err := tdb.store.RoomType.InsertRoomType(context.TODO(), suite); err != nil {
+		t.Fatal(err)
+	}
+	typed := &types.Room{ID: primitive.NewObjectID(), HotelID: savoy.ID, RoomTypeID: suite.ID, Size: "Single", Price: 50}
+	if _, err := tdb.store.Room.InsertRoom(context.TODO(), typed); err != nil {
+		t.Fatal(err)
+	}
+	london := stay + "&location=London"
+	if _, body = get(london + "&maxPrice=100"); body.Results != 0 {
+		t.Fatalf("expected the suite over the price cap, got %+v", body.Data)
+	}
+	if _, body = get(london + "&guests=3"); body.Results != 0 {
+		t.Fatalf("expected the suite not to sleep 3, got %+v", body.Data)
+	}
+	_, body = get(london + "&maxPrice=250&guests=2")
+	if body.Results != 1 || len(body.Data[0].Rooms) != 1 {
+		t.Fatalf("expected the suite to be available, got %+v", body.Data)
+	}
+	offer := body.Data[0].Rooms[0]
+	if offer.Room.ID != typed.ID || offer.Room.Size != "Suite" || offer.Price.Total.Amount != 60000 {
+		t.Fatalf("expected the room offered as a suite for 600 USD, got %+v for %+v", offer.Room, offer.Price.Total)
+	}
+
+	resp, _ = get(fmt.Sprintf("from=%s&till=%s", till.Format(time.DateOnly), from.Format(time.DateOnly)))
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status code 400 for reversed dates, got %d", resp.StatusCode)
+	}
+	resp, _ = get("from=tomorrow")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status code 400 for a malformed date, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kmogilevskii/hotel-reservation/db"
+	"github.com/kmogilevskii/hotel-reservation/errors"
+	"github.com/kmogilevskii/hotel-reservation/notify"
+	"github.com/kmogilevskii/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BlockHandler manages maintenance blocks. A block is stored as a booking in
+// StatusBlocked, so every availability check keeps the room off sale without
+// knowing about blocks.
+type BlockHandler struct {
+	store    *db.Store
+	notifier notify.Notifier
+}
+
+func NewBlockHandler(store *db.Store, notifier notify.Notifier) *BlockHandler {
+	return &BlockHandler{
+		store:    store,
+		notifier: notifier,
+	}
+}
+
+// HandlePostBlock takes the room off sale for the nights given. The room
+// must not be booked on any of them.
+func (h *BlockHandler) HandlePostBlock(c *fiber.Ctx) error {
+	var params types.BlockRoomParams
+	if err := c.BodyParser(&params); err != nil {
+		return errors.ErrBadRequest()
+	}
+	room, err := getRoom(c.Context(), h.store.Room, c.Params("id"))
+	if err != nil {
+		return err
+	}
+	hotel, err := getHotel(c.Context(), h.store.Hotel, room.HotelID.Hex())
+	if err != nil {
+		return err
+	}
+	user, ok := c.Context().Value("user").(*types.User)
+	if !ok {
+		return fmt.Errorf("authorization problems")
+	}
+	if err := params.Validate(); err != nil {
+		return errors.NewError(http.StatusBadRequest, err.Error())
+	}
+	// the nights are the hotel's calendar dates, so is today
+	stay := params.Stay().LocalizeFor(hotel)
+	if err := stay.Validate(); err != nil {
+		return errors.NewError(http.StatusBadRequest, err.Error())
+	}
+	block := &types.Booking{
+		ID:         primitive.NewObjectID(),
+		RoomID:     room.ID,
+		RoomTypeID: room.RoomTypeID,
+		UserID:     user.ID,
+		FromDate:   stay.FromDate.Time,
+		TillDate:   stay.TillDate.Time,
+		Status:     types.StatusBlocked,
+		Reason:     params.Reason,
+		StatusHistory: []types.StatusChange{{
+			Status: types.StatusBlocked,
+			By:     user.ID,
+			At:     time.Now(),
+		}},
+	}
+	inserted, err := h.store.Booking.BookRoom(c.Context(), block, time.Now())
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusCreated).JSON(inserted)
+}
+
+// HandleGetBlocks lists maintenance blocks, optionally only those of a room
+// or a hotel. Guest bookings are listed by the booking handler.
+func (h *BlockHandler) HandleGetBlocks(c *fiber.Ctx) error {
+	var params db.BlockQueryParams
+	if err := c.QueryParser(&params); err != nil {
+		return errors.ErrBadRequest()
+	}
+	filter := db.Map{"status": types.StatusBlocked}
+	switch {
+	case params.RoomID != "":
+		roomID, err := primitive.ObjectIDFromHex(params.RoomID)
+		if err != nil {
+			return errors.ErrInvalidID()
+		}
+		filter["roomID"] = roomID
+	case params.HotelID != "":
+		hotel, err := getHotel(c.Context(), h.store.Hotel, params.HotelID)
+		if err != nil {
+			return err
+		}
+		filter["roomID"] = db.Map{"$in": hotel.Rooms}
+	}
+	blocks, err := h.store.Booking.GetBookings(c.Context(), filter, &params.Pagination)
+	if err != nil {
+		return err
+	}
+	resp := db.ResourceResponse{
+		Results: len(blocks),
+		Data:    blocks,
+		Page:    params.Page,
+	}
+	return c.JSON(resp)
+}
+
+// HandleDeleteBlock puts the room back on sale and offers the nights to the
+// waitlist.
+func (h *BlockHandler) HandleDeleteBlock(c *fiber.Ctx) error {
+	id := c.Params("id")
+	block, err := h.store.Booking.GetBookingByID(c.Context(), id)
+	if err != nil {
+		return err
+	}
+	if block.Status != types.StatusBlocked {
+		return errors.ErrResourceNotFound("block")
+	}
+	user, ok := c.Context().Value("user").(*types.User)
+	if !ok {
+		return fmt.Errorf("authorization problems")
+	}
+	now := time.Now()
+	change := types.StatusChange{
+		Status: types.StatusCanceled,
+		By:     user.ID,
+		At:     now,
+	}
+	if err := h.store.Booking.UpdateBookingStatus(c.Context(), id, types.StatusBlocked, change); err != nil {
+		return err
+	}
+	releaseStay(c.Context(), h.store, h.notifier, block, now)
+	return c.JSON(genericResp{
+		Type: "success",
+		Msg:  "block removed",
+	})
+}
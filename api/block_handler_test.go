@@ -0,0 +1,132 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kmogilevskii/hotel-reservation/db/fixtures"
+	"github.com/kmogilevskii/hotel-reservation/errors"
+	"github.com/kmogilevskii/hotel-reservation/types"
+)
+
+type bookingsResp struct {
+	Results int              `json:"results"`
+	Data    []*types.Booking `json:"data"`
+}
+
+func TestMaintenanceBlocks(t *testing.T) {
+	tdb := setup(t)
+	var (
+		admin          = fixtures.AddUser(tdb.store.User, "admin", "admin", true)
+		user           = fixtures.AddUser(tdb.store.User, "foo", "bar", false)
+		hotel          = fixtures.AddHotel(tdb.store.Hotel, "Hilton", "New York", 5)
+		room           = fixtures.AddRoom(tdb.store.Room, hotel.ID, "Single", 100)
+		day            = time.Now().AddDate(0, 0, 1)
+		app            = fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
+		blockHandler   = NewBlockHandler(tdb.store, tdb.notifier)
+		roomHandler    = NewRoomHandler(tdb.store, tdb.payments)
+		bookingHandler = NewBookingHandler(tdb.store, tdb.payments, tdb.notifier)
+		availHandler   = NewAvailabilityHandler(tdb.store)
+	)
+	fixtures.AddBooking(tdb.store.Booking, user.ID, room.ID, hotel.CheckInAt(day), hotel.CheckOutAt(day.AddDate(0, 0, 2)), 1)
+	apiv1 := app.Group("/api", JWTAuthentication(tdb.store.User))
+	apiv1.Post("/room/:id/book", roomHandler.HandleBookRoom)
+	apiv1.Get("/availability", availHandler.HandleGetAvailability)
+	adminGroup := app.Group("/admin", JWTAuthentication(tdb.store.User), AdminAuth)
+	adminGroup.Post("/room/:id/block", blockHandler.HandlePostBlock)
+	adminGroup.Get("/block", blockHandler.HandleGetBlocks)
+	adminGroup.Delete("/block/:id", blockHandler.HandleDeleteBlock)
+	adminGroup.Get("/booking", bookingHandler.HandleGetBookings)
+
+	send := newSender(t, app)
+	blockURL := fmt.Sprintf("/admin/room/%s/block", room.ID.Hex())
+	stay := func(from, till int) types.BookParams {
+		return types.BookParams{FromDate: types.Date{Time: day.AddDate(0, 0, from)}, TillDate: types.Date{Time: day.AddDate(0, 0, till)}, NumPersons: 1}
+	}
+
+	if resp := send("POST", blockURL, types.BlockRoomParams{FromDate: types.Date{Time: day.AddDate(0, 0, 1)}, TillDate: types.Date{Time: day.AddDate(0, 0, 3)}, Reason: "broken AC"}, admin, nil); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected status code 409 when the room is booked, got %d", resp.StatusCode)
+	}
+	if resp := send("POST", blockURL, types.BlockRoomParams{FromDate: types.Date{Time: day.AddDate(0, 0, 3)}, TillDate: types.Date{Time: day.AddDate(0, 0, 5)}}, admin, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status code 400 without a reason, got %d", resp.StatusCode)
+	}
+	if resp := send("POST", blockURL, types.BlockRoomParams{FromDate: types.Date{Time: day.AddDate(0, 0, 3)}, TillDate: types.Date{Time: day.AddDate(0, 0, 5)}, Reason: "broken AC"}, user, nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected status code 401 for a regular user, got %d", resp.StatusCode)
+	}
+	var block types.Booking
+	resp := send("POST", blockURL, types.BlockRoomParams{FromDate: types.Date{Time: day.AddDate(0, 0, 2)}, TillDate: types.Date{Time: day.AddDate(0, 0, 5)}, Reason: "broken AC"}, admin, &block)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status code 201, got %d", resp.StatusCode)
+	}
+	if block.Status != types.StatusBlocked || block.Reason != "broken AC" || block.Price != nil {
+		t.Fatalf("unexpected block %+v", block)
+	}
+
+	bookURL := fmt.Sprintf("/api/room/%s/book", room.ID.Hex())
+	if resp := send("POST", bookURL, stay(4, 6), user, nil); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected status code 409 for a blocked room, got %d", resp.StatusCode)
+	}
+	var avail availabilityResp
+	query := fmt.Sprintf("/api/availability?from=%s&till=%s", day.AddDate(0, 0, 3).Format(time.DateOnly), day.AddDate(0, 0, 4).Format(time.DateOnly))
+	send("GET", query, nil, user, &avail)
+	if avail.Results != 0 {
+		t.Fatalf("expected the blocked room not to be listed, got %+v", avail.Data)
+	}
+
+	var bookings, blocks bookingsResp
+	send("GET", "/admin/booking", nil, admin, &bookings)
+	if bookings.Results != 1 || bookings.Data[0].Status == types.StatusBlocked {
+		t.Fatalf("expected only the guest booking, got %+v", bookings.Data)
+	}
+	send("GET", fmt.Sprintf("/admin/block?hotelID=%s", hotel.ID.Hex()), nil, admin, &blocks)
+	if blocks.Results != 1 || blocks.Data[0].ID != block.ID {
+		t.Fatalf("expected the block to be reported, got %+v", blocks.Data)
+	}
+
+	if resp := send("DELETE", fmt.Sprintf("/admin/block/%s", block.ID.Hex()), nil, admin, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code 200, got %d", resp.StatusCode)
+	}
+	if resp := send("POST", bookURL, stay(4, 6), user, nil); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected the room to be back on sale, got %d", resp.StatusCode)
+	}
+	send("GET", "/admin/block", nil, admin, &blocks)
+	if blocks.Results != 0 {
+		t.Fatalf("expected no blocks left, got %+v", blocks.Data)
+	}
+}
+
+func TestBlockHotelDates(t *testing.T) {
+	tdb := setup(t)
+	var (
+		admin        = fixtures.AddUser(tdb.store.User, "admin", "admin", true)
+		hotel        = fixtures.AddHotel(tdb.store.Hotel, "Hilton", "Kiritimati", 5)
+		room         = fixtures.AddRoom(tdb.store.Room, hotel.ID, "Single", 100)
+		app          = fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
+		blockHandler = NewBlockHandler(tdb.store, tdb.notifier)
+	)
+	// UTC+14, so the hotel's yesterday is still today in UTC most of the day
+	local := types.UpdateHotelParams{Timezone: "Pacific/Kiritimati", CheckInTime: "14:00", CheckOutTime: "10:00"}
+	if err := tdb.store.Hotel.UpdateHotel(context.TODO(), hotel.ID.Hex(), local); err != nil {
+		t.Fatal(err)
+	}
+	app.Post("/room/:id/block", JWTAuthentication(tdb.store.User), AdminAuth, blockHandler.HandlePostBlock)
+
+	send := newSender(t, app)
+	blockURL := fmt.Sprintf("/room/%s/block", room.ID.Hex())
+	loc, _ := time.LoadLocation("Pacific/Kiritimati")
+	today := time.Now().In(loc)
+	block := func(from time.Time) types.BlockRoomParams {
+		day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+		return types.BlockRoomParams{FromDate: types.Date{Time: day}, TillDate: types.Date{Time: day.AddDate(0, 0, 2)}, Reason: "broken AC"}
+	}
+	if resp := send("POST", blockURL, block(today.AddDate(0, 0, -1)), admin, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status code 400 for the hotel's yesterday, got %d", resp.StatusCode)
+	}
+	if resp := send("POST", blockURL, block(today), admin, nil); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected the hotel's today to be blockable, got %d", resp.StatusCode)
+	}
+}
@@ -1,21 +1,46 @@
 package api
 
 import (
+	"context"
 	"fmt"
+	"log"
 	"net/http"
+	"slices"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/kmogilevskii/hotel-reservation/db"
 	"github.com/kmogilevskii/hotel-reservation/errors"
+	"github.com/kmogilevskii/hotel-reservation/notify"
+	"github.com/kmogilevskii/hotel-reservation/payments"
+	"github.com/kmogilevskii/hotel-reservation/pricing"
 	"github.com/kmogilevskii/hotel-reservation/types"
 )
 
+type cancelResp struct {
+	genericResp
+	Cancellation types.Cancellation `json:"cancellation"`
+}
+
+type modifyResp struct {
+	Booking *types.Booking `json:"booking"`
+	// PriceDifference is what the guest owes on top of the previous price,
+	// negative when the new stay is cheaper.
+	PriceDifference types.Money `json:"priceDifference"`
+}
+
 type BookingHandler struct {
-	store *db.Store
+	store    *db.Store
+	payments payments.Provider
+	notifier notify.Notifier
 }
 
-func NewBookingHandler(store *db.Store) *BookingHandler {
-	return &BookingHandler{store: store}
+func NewBookingHandler(store *db.Store, provider payments.Provider, notifier notify.Notifier) *BookingHandler {
+	return &BookingHandler{
+		store:    store,
+		payments: provider,
+		notifier: notifier,
+	}
 }
 
 // should be admin authorized
@@ -24,7 +49,8 @@ func (b *BookingHandler) HandleGetBookings(c *fiber.Ctx) error {
 	if err := c.QueryParser(&params); err != nil {
 		return errors.ErrBadRequest()
 	}
-	filter := db.Map{}
+	// maintenance blocks are listed by the block handler
+	filter := db.Map{"status": db.Map{"$ne": types.StatusBlocked}}
 	if params.UserID != "" {
 		filter["userID"] = params.UserID
 	}
@@ -60,6 +86,42 @@ func (b *BookingHandler) HandleGetBooking(c *fiber.Ctx) error {
 	return c.JSON(booking)
 }
 
+// checkOpen makes sure the booking can still be modified or canceled.
+func checkOpen(booking *types.Booking, now time.Time) error {
+	if booking.Status == types.StatusCanceled {
+		return errors.ErrAlreadyCanceled()
+	}
+	if !slices.Contains(types.OpenStatuses, booking.Status) || !now.Before(booking.FromDate) {
+		return errors.ErrStayStarted()
+	}
+	return nil
+}
+
+// cancelBooking cancels an open booking on behalf of user, refunds the guest,
+// offers the freed dates to the waitlist and returns the cancellation
+// recorded on it.
+func cancelBooking(ctx context.Context, store *db.Store, provider payments.Provider, notifier notify.Notifier, booking *types.Booking, user *types.User, now time.Time) (types.Cancellation, error) {
+	if err := checkOpen(booking, now); err != nil {
+		return types.Cancellation{}, err
+	}
+	cancellation := pricing.Cancel(booking, now)
+	cancellation.CanceledBy = user.ID
+	// the refund is owed from the moment the booking is canceled, should
+	// paying it back fail it is retried by RetryRefunds
+	oweRefund(booking, cancellation.Refund, now)
+	if err := store.Booking.CancelBooking(ctx, booking.ID.Hex(), cancellation, booking.Payment); err != nil {
+		return types.Cancellation{}, err
+	}
+	if err := refundBooking(ctx, store.Booking, provider, booking); err != nil {
+		log.Printf("refunding booking %s: %v", booking.ID.Hex(), err)
+	}
+	if err := releasePromoCode(ctx, store, booking); err != nil {
+		log.Printf("releasing the promo code of booking %s: %v", booking.ID.Hex(), err)
+	}
+	releaseStay(ctx, store, notifier, booking, now)
+	return cancellation, nil
+}
+
 func (b *BookingHandler) HandleCancelBooking(c *fiber.Ctx) error {
 	id := c.Params("id")
 	booking, err := b.store.Booking.GetBookingByID(c.Context(), id)
@@ -76,11 +138,376 @@ func (b *BookingHandler) HandleCancelBooking(c *fiber.Ctx) error {
 			Msg:  "unauthorized",
 		})
 	}
-	if err := b.store.Booking.UpdateBooking(c.Context(), id); err != nil {
+	now := time.Now()
+	cancellation, err := cancelBooking(c.Context(), b.store, b.payments, b.notifier, booking, user, now)
+	if err != nil {
+		return err
+	}
+	return c.JSON(cancelResp{
+		genericResp: genericResp{
+			Type: "success",
+			Msg:  "booking canceled",
+		},
+		Cancellation: cancellation,
+	})
+}
+
+func (b *BookingHandler) HandleModifyBooking(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var params types.ModifyBookingParams
+	if err := c.BodyParser(&params); err != nil {
+		return errors.ErrBadRequest()
+	}
+	booking, err := b.store.Booking.GetBookingByID(c.Context(), id)
+	if err != nil {
+		return err
+	}
+	user, ok := c.Context().Value("user").(*types.User)
+	if !ok {
+		return fmt.Errorf("authorization problems")
+	}
+	if booking.UserID != user.ID && !user.IsAdmin {
+		return c.Status(http.StatusUnauthorized).JSON(genericResp{
+			Type: "error",
+			Msg:  "unauthorized",
+		})
+	}
+	if err := checkOpen(booking, time.Now()); err != nil {
+		return err
+	}
+
+	terms, err := getSaleTerms(c.Context(), b.store, booking)
+	if err != nil {
+		return err
+	}
+	// dates the guest keeps have to stay on the same local calendar dates
+	zone := terms.hotel.Zone()
+	booking.FromDate, booking.TillDate = booking.FromDate.In(zone), booking.TillDate.In(zone)
+	stay := params.Apply(booking)
+	freed, previous := *booking, booking.Price
+	booking.FromDate = stay.FromDate.Time
+	booking.TillDate = stay.TillDate.Time
+	booking.NumPersons = stay.NumPersons
+	booking.NumChildren = stay.NumChildren
+	if err := terms.price(booking); err != nil {
+		return err
+	}
+	now := time.Now()
+	booking.Balance = pricing.Reschedule(booking.PaymentSchedule, booking.Balance, booking.Price.Total, pricing.Paid(booking), booking.FromDate, now)
+	difference := booking.Price.Total
+	if previous != nil && previous.Total.Currency == difference.Currency {
+		difference.Amount -= previous.Total.Amount
+	}
+	// what the balance doesn't cover is settled now: charged before the
+	// change is stored or owed back along with it
+	owed := pricing.Outstanding(booking)
+	if owed.Amount > 0 {
+		payment, err := payments.Charge(c.Context(), b.payments, booking.Payment, owed, id)
+		if err != nil {
+			return paymentError(err)
+		}
+		booking.Payment = payment
+	}
+	oweRefund(booking, types.Money{Amount: -owed.Amount, Currency: owed.Currency}, now)
+	if err := b.store.Booking.ModifyBooking(c.Context(), booking, now); err != nil {
+		if owed.Amount > 0 {
+			if refundErr := payments.Refund(c.Context(), b.payments, booking.Payment, owed); refundErr != nil {
+				return refundErr
+			}
+		}
+		return err
+	}
+	if booking.Payment != nil && booking.Payment.PendingRefund != nil {
+		if err := refundBooking(c.Context(), b.store.Booking, b.payments, booking); err != nil {
+			log.Printf("refunding booking %s: %v", id, err)
+		}
+	}
+	// nights of the old stay the new one still covers stay taken, the
+	// waitlist only gets the others
+	releaseStay(c.Context(), b.store, b.notifier, &freed, now)
+	return c.JSON(modifyResp{
+		Booking:         booking,
+		PriceDifference: difference,
+	})
+}
+
+// HandlePayBalance charges the guest what is left to pay after the deposit.
+func (b *BookingHandler) HandlePayBalance(c *fiber.Ctx) error {
+	booking, err := b.store.Booking.GetBookingByID(c.Context(), c.Params("id"))
+	if err != nil {
+		return err
+	}
+	user, ok := c.Context().Value("user").(*types.User)
+	if !ok {
+		return fmt.Errorf("authorization problems")
+	}
+	if booking.UserID != user.ID && !user.IsAdmin {
+		return c.Status(http.StatusUnauthorized).JSON(genericResp{
+			Type: "error",
+			Msg:  "unauthorized",
+		})
+	}
+	if err := payBalance(c.Context(), b.store.Booking, b.payments, booking); err != nil {
+		return err
+	}
+	return c.JSON(booking)
+}
+
+// HandleGetBalances lists the balances still to be paid, optionally only
+// those of a hotel or only the upcoming or overdue ones, by due date.
+func (b *BookingHandler) HandleGetBalances(c *fiber.Ctx) error {
+	var params db.BalanceQueryParams
+	if err := c.QueryParser(&params); err != nil {
+		return errors.ErrBadRequest()
+	}
+	filter := db.Map{
+		"status":         db.Map{"$in": types.ActiveStatuses},
+		"balance.status": db.Map{"$in": types.UnpaidBalanceStatuses},
+	}
+	switch status := types.BalanceStatus(params.Status); status {
+	case "":
+	case types.BalanceDue, types.BalanceOverdue:
+		filter["balance.status"] = status
+	default:
+		return errors.NewError(http.StatusBadRequest, "status must be due or overdue")
+	}
+	if params.HotelID != "" {
+		hotel, err := getHotel(c.Context(), b.store.Hotel, params.HotelID)
+		if err != nil {
+			return err
+		}
+		// bookings of a room type may not have a room assigned yet
+		sold := []db.Map{{"roomID": db.Map{"$in": hotel.Rooms}}}
+		if len(hotel.RoomTypes) > 0 {
+			sold = append(sold, db.Map{"roomTypeID": db.Map{"$in": hotel.RoomTypes}})
+		}
+		filter["$or"] = sold
+	}
+	bookings, err := b.store.Booking.GetBalances(c.Context(), filter, &params.Pagination)
+	if err != nil {
+		return err
+	}
+	resp := db.ResourceResponse{
+		Results: len(bookings),
+		Data:    bookings,
+		Page:    params.Page,
+	}
+	return c.JSON(resp)
+}
+
+// relocationWindow is how far ahead the relocation report looks by default.
+const relocationWindow = 30 * 24 * time.Hour
+
+// HandleGetRelocations lists the arrivals at a hotel from..till that can't
+// be accommodated if every guest shows up, the earliest first. It defaults
+// to the arrivals of the next 30 days.
+func (b *BookingHandler) HandleGetRelocations(c *fiber.Ctx) error {
+	var params db.RelocationQueryParams
+	if err := c.QueryParser(&params); err != nil {
+		return errors.ErrBadRequest()
+	}
+	if params.HotelID == "" {
+		return errors.NewError(http.StatusBadRequest, "hotelID is required")
+	}
+	hotel, err := getHotel(c.Context(), b.store.Hotel, params.HotelID)
+	if err != nil {
+		return err
+	}
+	from := time.Now()
+	if params.From != "" {
+		if from, err = parseDate(params.From); err != nil {
+			return errors.NewError(http.StatusBadRequest, "invalid from date")
+		}
+	}
+	till := from.Add(relocationWindow)
+	if params.Till != "" {
+		if till, err = parseDate(params.Till); err != nil {
+			return errors.NewError(http.StatusBadRequest, "invalid till date")
+		}
+	}
+	if !from.Before(till) {
+		return errors.NewError(http.StatusBadRequest, "from date must be before till date")
+	}
+	pools, err := inventory(b.store).HotelPools(c.Context(), hotel)
+	if err != nil {
 		return err
 	}
-	return c.JSON(genericResp{
-		Type: "success",
-		Msg:  "booking canceled",
+	relocations := []types.Relocation{}
+	for _, pool := range pools {
+		bookings, err := findBookings(b.store.Booking)(c.Context(), pool.Overlapping(from, till, time.Now()))
+		if err != nil {
+			return err
+		}
+		for _, booking := range db.Overflow(bookings, pool.Rooms) {
+			// guests arriving earlier are already in the house
+			if booking.FromDate.Before(from) {
+				continue
+			}
+			relocations = append(relocations, types.Relocation{HotelID: hotel.ID, Booking: booking})
+		}
+	}
+	slices.SortStableFunc(relocations, func(x, y types.Relocation) int {
+		return x.Booking.FromDate.Compare(y.Booking.FromDate)
 	})
+	skip := int(max((params.Page-1)*params.Limit, 0))
+	if skip > len(relocations) {
+		skip = len(relocations)
+	}
+	relocations = relocations[skip:]
+	if params.Limit > 0 && int(params.Limit) < len(relocations) {
+		relocations = relocations[:params.Limit]
+	}
+	resp := db.ResourceResponse{
+		Results: len(relocations),
+		Data:    relocations,
+		Page:    params.Page,
+	}
+	return c.JSON(resp)
+}
+
+// inventory is what the booking store checks bookings against.
+func inventory(store *db.Store) db.Inventory {
+	return db.Inventory{Rooms: store.Room, RoomTypes: store.RoomType, Hotels: store.Hotel}
+}
+
+// findBookings lets the db helpers read bookings through the booking store.
+func findBookings(store db.BookingStore) db.FindBookings {
+	return func(ctx context.Context, filter db.Map) ([]*types.Booking, error) {
+		return store.GetBookings(ctx, filter, &db.Pagination{})
+	}
+}
+
+// ExpireHolds moves the holds that expired by now to the expired status and
+// gives back the promo code uses they redeemed. A guest who let a hold from
+// the waitlist expire is taken off it, the freed dates are offered to the
+// next guest waiting. It returns how many holds expired.
+func ExpireHolds(ctx context.Context, store *db.Store, notifier notify.Notifier, now time.Time) (int, error) {
+	holds, err := store.Booking.ExpireHolds(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+	for _, hold := range holds {
+		if err := releasePromoCode(ctx, store, hold); err != nil {
+			log.Printf("releasing the promo code of hold %s: %v", hold.ID.Hex(), err)
+		}
+		if err := store.Waitlist.LapseWaitlistOffer(ctx, hold.ID); err != nil {
+			log.Printf("lapsing the waitlist offer of hold %s: %v", hold.ID.Hex(), err)
+		}
+		releaseStay(ctx, store, notifier, hold, now)
+	}
+	return len(holds), nil
+}
+
+// HandleConfirmHold turns a hold into a confirmed booking, as long as it
+// hasn't expired yet.
+func (b *BookingHandler) HandleConfirmHold(c *fiber.Ctx) error {
+	id := c.Params("id")
+	booking, err := b.store.Booking.GetBookingByID(c.Context(), id)
+	if err != nil {
+		return err
+	}
+	user, ok := c.Context().Value("user").(*types.User)
+	if !ok {
+		return fmt.Errorf("authorization problems")
+	}
+	if booking.UserID != user.ID && !user.IsAdmin {
+		return c.Status(http.StatusUnauthorized).JSON(genericResp{
+			Type: "error",
+			Msg:  "unauthorized",
+		})
+	}
+	if booking.Status != types.StatusPending && booking.Status != types.StatusExpired {
+		return errors.ErrIllegalTransition(string(booking.Status), string(types.StatusConfirmed))
+	}
+	change := types.StatusChange{
+		Status: types.StatusConfirmed,
+		By:     user.ID,
+		At:     time.Now(),
+	}
+	// holds are only charged once confirmed
+	if err := authorizeBooking(c.Context(), b.payments, booking); err != nil {
+		return err
+	}
+	if err := b.store.Booking.ConfirmHold(c.Context(), id, change); err != nil {
+		return voidBookings(c.Context(), b.payments, []*types.Booking{booking}, err)
+	}
+	if err := captureBooking(c.Context(), b.store.Booking, b.payments, booking); err != nil {
+		return err
+	}
+	booking.Status = types.StatusConfirmed
+	booking.StatusHistory = append(booking.StatusHistory, change)
+	booking.HoldExpiresAt = nil
+	return c.JSON(booking)
+}
+
+// HandleAssignRoom lets the front desk put a booking of a room type into one
+// of the type's rooms, or move it to another one.
+func (b *BookingHandler) HandleAssignRoom(c *fiber.Ctx) error {
+	var params types.AssignRoomParams
+	if err := c.BodyParser(&params); err != nil {
+		return errors.ErrBadRequest()
+	}
+	if err := params.Validate(c.Context()); err != nil {
+		return errors.ErrBadRequest()
+	}
+	id := c.Params("id")
+	booking, err := b.store.Booking.GetBookingByID(c.Context(), id)
+	if err != nil {
+		return err
+	}
+	if booking.RoomTypeID.IsZero() {
+		return errors.NewError(http.StatusBadRequest, "only bookings of a room type can be assigned a room")
+	}
+	room, err := getRoom(c.Context(), b.store.Room, params.RoomID)
+	if err != nil {
+		return err
+	}
+	if room.RoomTypeID != booking.RoomTypeID {
+		return errors.NewError(http.StatusBadRequest, "room is not of the booked room type")
+	}
+	if !slices.Contains(types.ActiveStatuses, booking.Status) {
+		return errors.ErrIllegalTransition(string(booking.Status), "assigned")
+	}
+	if err := b.store.Booking.AssignRoom(c.Context(), id, room.ID, time.Now()); err != nil {
+		return err
+	}
+	booking.RoomID = room.ID
+	return c.JSON(booking)
+}
+
+// HandleUpdateStatus returns a front desk handler that moves a booking into
+// the given status, e.g. to check guests in or out.
+func (b *BookingHandler) HandleUpdateStatus(to types.BookingStatus) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		booking, err := b.store.Booking.GetBookingByID(c.Context(), id)
+		if err != nil {
+			return err
+		}
+		user, ok := c.Context().Value("user").(*types.User)
+		if !ok {
+			return fmt.Errorf("authorization problems")
+		}
+		if !booking.Status.CanTransitionTo(to) {
+			return errors.ErrIllegalTransition(string(booking.Status), string(to))
+		}
+		now := time.Now()
+		if to == types.StatusNoShow && now.Before(booking.FromDate) {
+			return errors.NewError(http.StatusConflict, "guest is not due to arrive yet")
+		}
+		if to == types.StatusCheckedIn && booking.RoomID.IsZero() {
+			return errors.NewError(http.StatusConflict, "assign a room before checking in")
+		}
+		change := types.StatusChange{
+			Status: to,
+			By:     user.ID,
+			At:     now,
+		}
+		if err := b.store.Booking.UpdateBookingStatus(c.Context(), id, booking.Status, change); err != nil {
+			return err
+		}
+		booking.Status = to
+		booking.StatusHistory = append(booking.StatusHistory, change)
+		return c.JSON(booking)
+	}
 }
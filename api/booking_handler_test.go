@@ -1,6 +1,8 @@
 package api
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -9,14 +11,16 @@ import (
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/kmogilevskii/hotel-reservation/db"
 	"github.com/kmogilevskii/hotel-reservation/db/fixtures"
 	"github.com/kmogilevskii/hotel-reservation/errors"
+	"github.com/kmogilevskii/hotel-reservation/payments"
 	"github.com/kmogilevskii/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func TestHandleGetBookings(t *testing.T) {
 	tdb := setup(t)
-	defer tdb.teardown(t)
 	var (
 		admin          = fixtures.AddUser(tdb.store.User, "admin", "admin", true)
 		user           = fixtures.AddUser(tdb.store.User, "foo", "bar", false)
@@ -24,7 +28,7 @@ func TestHandleGetBookings(t *testing.T) {
 		room           = fixtures.AddRoom(tdb.store.Room, hotel.ID, "Single", 99.99)
 		booking        = fixtures.AddBooking(tdb.store.Booking, user.ID, room.ID, time.Now().AddDate(0, 0, 2), time.Now().AddDate(0, 0, 8), 2)
 		app            = fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
-		bookingHandler = NewBookingHandler(tdb.store)
+		bookingHandler = NewBookingHandler(tdb.store, tdb.payments, tdb.notifier)
 	)
 	jwt := app.Group("/", JWTAuthentication(tdb.store.User), AdminAuth)
 	jwt.Get("/", bookingHandler.HandleGetBookings)
@@ -37,8 +41,11 @@ func TestHandleGetBookings(t *testing.T) {
 		t.Fatalf("expected status code 200, got %d", resp.StatusCode)
 	}
 
-	var bookings []*types.Booking
-	json.NewDecoder(resp.Body).Decode(&bookings)
+	var bookingsResp struct {
+		Data []*types.Booking `json:"data"`
+	}
+	json.NewDecoder(resp.Body).Decode(&bookingsResp)
+	bookings := bookingsResp.Data
 	if len(bookings) != 1 {
 		t.Fatalf("expected 1 booking, got %d", len(bookings))
 	}
@@ -63,7 +70,6 @@ func TestHandleGetBookings(t *testing.T) {
 
 func TestGetBooking(t *testing.T) {
 	tdb := setup(t)
-	defer tdb.teardown(t)
 	var (
 		admin          = fixtures.AddUser(tdb.store.User, "admin", "admin", true)
 		user           = fixtures.AddUser(tdb.store.User, "foo", "bar", false)
@@ -72,7 +78,7 @@ func TestGetBooking(t *testing.T) {
 		room           = fixtures.AddRoom(tdb.store.Room, hotel.ID, "Single", 99.99)
 		booking        = fixtures.AddBooking(tdb.store.Booking, user.ID, room.ID, time.Now().AddDate(0, 0, 2), time.Now().AddDate(0, 0, 8), 2)
 		app            = fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
-		bookingHandler = NewBookingHandler(tdb.store)
+		bookingHandler = NewBookingHandler(tdb.store, tdb.payments, tdb.notifier)
 	)
 	jwt := app.Group("/", JWTAuthentication(tdb.store.User))
 	jwt.Get("/:id", bookingHandler.HandleGetBooking)
@@ -125,7 +131,6 @@ func TestGetBooking(t *testing.T) {
 
 func TestCancelBooking(t *testing.T) {
 	tdb := setup(t)
-	defer tdb.teardown(t)
 	var (
 		//admin          = fixtures.AddUser(tdb.store.User, "admin", "admin", true)
 		user = fixtures.AddUser(tdb.store.User, "foo", "bar", false)
@@ -134,7 +139,7 @@ func TestCancelBooking(t *testing.T) {
 		room           = fixtures.AddRoom(tdb.store.Room, hotel.ID, "Single", 99.99)
 		booking        = fixtures.AddBooking(tdb.store.Booking, user.ID, room.ID, time.Now().AddDate(0, 0, 2), time.Now().AddDate(0, 0, 8), 2)
 		app            = fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
-		bookingHandler = NewBookingHandler(tdb.store)
+		bookingHandler = NewBookingHandler(tdb.store, tdb.payments, tdb.notifier)
 	)
 	jwt := app.Group("/", JWTAuthentication(tdb.store.User))
 	jwt.Delete("/:id", bookingHandler.HandleCancelBooking)
@@ -154,3 +159,570 @@ func TestCancelBooking(t *testing.T) {
 	}
 
 }
+
+func TestCancelBookingRefund(t *testing.T) {
+	tdb := setup(t)
+	var (
+		user           = fixtures.AddUser(tdb.store.User, "foo", "bar", false)
+		hotel          = fixtures.AddHotel(tdb.store.Hotel, "Hilton", "New York", 5)
+		room           = fixtures.AddRoom(tdb.store.Room, hotel.ID, "Single", 99.99)
+		started        = fixtures.AddBooking(tdb.store.Booking, user.ID, room.ID, time.Now().AddDate(0, 0, -1), time.Now().AddDate(0, 0, 2), 1)
+		app            = fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
+		bookingHandler = NewBookingHandler(tdb.store, tdb.payments, tdb.notifier)
+	)
+	booking := &types.Booking{
+		ID:                 primitive.NewObjectID(),
+		RoomID:             room.ID,
+		UserID:             user.ID,
+		FromDate:           time.Now().AddDate(0, 0, 2),
+		TillDate:           time.Now().AddDate(0, 0, 4),
+		NumPersons:         1,
+		Status:             types.StatusConfirmed,
+		Price:              &types.PriceBreakdown{Total: types.Money{Amount: 20000, Currency: "USD"}},
+		CancellationPolicy: &types.CancellationPolicy{FreeUntilDays: 7, PenaltyPercent: 30},
+	}
+	payment, err := payments.Authorize(context.TODO(), tdb.payments, booking.Price.Total, booking.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := payments.Capture(context.TODO(), tdb.payments, payment); err != nil {
+		t.Fatal(err)
+	}
+	booking.Payment = payment
+	if _, err := tdb.store.Booking.Insert(context.TODO(), booking); err != nil {
+		t.Fatal(err)
+	}
+	app.Delete("/:id", JWTAuthentication(tdb.store.User), bookingHandler.HandleCancelBooking)
+
+	cancel := func(id primitive.ObjectID) *http.Response {
+		req := httptest.NewRequest("DELETE", fmt.Sprintf("/%s", id.Hex()), nil)
+		req.Header.Set("X-Api-Token", CreateTokenFromUser(user))
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := cancel(booking.ID)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code 200, got %d", resp.StatusCode)
+	}
+	var cResp cancelResp
+	json.NewDecoder(resp.Body).Decode(&cResp)
+	if cResp.Cancellation.Refund.Amount != 14000 || cResp.Cancellation.Penalty.Amount != 6000 {
+		t.Fatalf("expected a refund of 140.00 and a penalty of 60.00, got %+v", cResp.Cancellation)
+	}
+	stored, _ := tdb.store.Booking.GetBookingByID(context.TODO(), booking.ID.Hex())
+	if stored.Status != types.StatusCanceled || stored.Cancellation == nil || stored.Cancellation.Refund.Amount != 14000 {
+		t.Fatalf("expected the cancellation to be stored, got %+v", stored)
+	}
+	if stored.Payment.Refunded.Amount != 14000 {
+		t.Fatalf("expected 140.00 to be refunded, got %+v", stored.Payment)
+	}
+	if stored.Cancellation.CanceledAt.IsZero() || stored.Cancellation.CanceledBy != user.ID {
+		t.Fatal("expected the cancellation timestamp and user to be stored")
+	}
+	if n := len(stored.StatusHistory); n == 0 || stored.StatusHistory[n-1].Status != types.StatusCanceled {
+		t.Fatalf("expected the cancellation in the status history, got %+v", stored.StatusHistory)
+	}
+
+	resp = cancel(booking.ID)
+	var errResp errors.Error
+	json.NewDecoder(resp.Body).Decode(&errResp)
+	if resp.StatusCode != http.StatusConflict || errResp != errors.ErrAlreadyCanceled() {
+		t.Fatalf("expected already canceled error, got %d %v", resp.StatusCode, errResp)
+	}
+
+	resp = cancel(started.ID)
+	json.NewDecoder(resp.Body).Decode(&errResp)
+	if resp.StatusCode != http.StatusConflict || errResp != errors.ErrStayStarted() {
+		t.Fatalf("expected stay started error, got %d %v", resp.StatusCode, errResp)
+	}
+}
+
+func TestModifyBooking(t *testing.T) {
+	tdb := setup(t)
+	var (
+		user           = fixtures.AddUser(tdb.store.User, "foo", "bar", false)
+		other          = fixtures.AddUser(tdb.store.User, "baz", "qux", false)
+		hotel          = fixtures.AddHotel(tdb.store.Hotel, "Hilton", "New York", 5)
+		room           = fixtures.AddRoom(tdb.store.Room, hotel.ID, "Single", 100)
+		day            = time.Now().AddDate(0, 0, 1)
+		booking        = fixtures.AddBooking(tdb.store.Booking, user.ID, room.ID, day.AddDate(0, 0, 1), day.AddDate(0, 0, 3), 1)
+		app            = fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
+		bookingHandler = NewBookingHandler(tdb.store, tdb.payments, tdb.notifier)
+	)
+	fixtures.AddBooking(tdb.store.Booking, other.ID, room.ID, hotel.CheckInAt(day.AddDate(0, 0, 5)), hotel.CheckOutAt(day.AddDate(0, 0, 7)), 1)
+	app.Patch("/:id", JWTAuthentication(tdb.store.User), bookingHandler.HandleModifyBooking)
+
+	modify := func(params types.ModifyBookingParams, as *types.User) *http.Response {
+		b, _ := json.Marshal(params)
+		req := httptest.NewRequest("PATCH", fmt.Sprintf("/%s", booking.ID.Hex()), bytes.NewReader(b))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Api-Token", CreateTokenFromUser(as))
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// shifting by a day overlaps only the booking itself
+	resp := modify(types.ModifyBookingParams{FromDate: types.Date{Time: day.AddDate(0, 0, 2)}, TillDate: types.Date{Time: day.AddDate(0, 0, 5)}}, user)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code 200, got %d", resp.StatusCode)
+	}
+	var mResp modifyResp
+	json.NewDecoder(resp.Body).Decode(&mResp)
+	if len(mResp.Booking.Price.Nights) != 3 || mResp.Booking.Price.Total.Amount != 30000 {
+		t.Fatalf("expected 3 nights for 300.00, got %+v", mResp.Booking.Price)
+	}
+	if mResp.PriceDifference.Amount != 30000 {
+		t.Fatalf("expected the whole price to be owed for an unpriced booking, got %+v", mResp.PriceDifference)
+	}
+
+	resp = modify(types.ModifyBookingParams{TillDate: types.Date{Time: day.AddDate(0, 0, 4)}}, user)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code 200, got %d", resp.StatusCode)
+	}
+	json.NewDecoder(resp.Body).Decode(&mResp)
+	if mResp.PriceDifference.Amount != -10000 {
+		t.Fatalf("expected one night to be given back, got %+v", mResp.PriceDifference)
+	}
+	stored, _ := tdb.store.Booking.GetBookingByID(context.TODO(), booking.ID.Hex())
+	if stored.Price.Total.Amount != 20000 || stored.NumPersons != 1 {
+		t.Fatalf("expected the new stay to be stored, got %+v", stored)
+	}
+
+	if resp := modify(types.ModifyBookingParams{TillDate: types.Date{Time: day.AddDate(0, 0, 6)}}, user); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected status code 409 when overlapping another booking, got %d", resp.StatusCode)
+	}
+	if resp := modify(types.ModifyBookingParams{FromDate: types.Date{Time: day.AddDate(0, 0, -3)}}, user); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status code 400 when moving into the past, got %d", resp.StatusCode)
+	}
+	if resp := modify(types.ModifyBookingParams{NumPersons: 2}, other); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected status code 401 for another user, got %d", resp.StatusCode)
+	}
+}
+
+func TestModifyBookingPayment(t *testing.T) {
+	tdb := setup(t)
+	var (
+		user           = fixtures.AddUser(tdb.store.User, "foo", "bar", false)
+		hotel          = fixtures.AddHotel(tdb.store.Hotel, "Hilton", "New York", 5)
+		room           = fixtures.AddRoom(tdb.store.Room, hotel.ID, "Single", 100)
+		day            = time.Now().AddDate(0, 0, 2)
+		app            = fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
+		roomHandler    = NewRoomHandler(tdb.store, tdb.payments)
+		bookingHandler = NewBookingHandler(tdb.store, tdb.payments, tdb.notifier)
+	)
+	apiv1 := app.Group("/", JWTAuthentication(tdb.store.User))
+	apiv1.Post("/room/:id/book", roomHandler.HandleBookRoom)
+	apiv1.Patch("/booking/:id", bookingHandler.HandleModifyBooking)
+	apiv1.Delete("/booking/:id", bookingHandler.HandleCancelBooking)
+
+	send := newSender(t, app)
+	var booking types.Booking
+	params := types.BookParams{FromDate: types.Date{Time: day}, TillDate: types.Date{Time: day.AddDate(0, 0, 2)}, NumPersons: 1}
+	if resp := send("POST", fmt.Sprintf("/room/%s/book", room.ID.Hex()), params, user, &booking); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status code 201, got %d", resp.StatusCode)
+	}
+	bookingURL := fmt.Sprintf("/booking/%s", booking.ID.Hex())
+	paid := func() *types.Payment {
+		stored, _ := tdb.store.Booking.GetBookingByID(context.TODO(), booking.ID.Hex())
+		return stored.Payment
+	}
+
+	// a night longer is charged right away
+	if resp := send("PATCH", bookingURL, types.ModifyBookingParams{TillDate: types.Date{Time: day.AddDate(0, 0, 3)}}, user, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code 200, got %d", resp.StatusCode)
+	}
+	if p := paid(); p.Captured.Amount != 30000 || p.Refunded.Amount != 0 {
+		t.Fatalf("expected 300.00 to be captured, got %+v", p)
+	}
+
+	// two nights shorter is owed back until the refund goes through
+	tdb.payments.FailRefunds = true
+	if resp := send("PATCH", bookingURL, types.ModifyBookingParams{TillDate: types.Date{Time: day.AddDate(0, 0, 1)}}, user, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the change to be kept while the refund fails, got %d", resp.StatusCode)
+	}
+	if p := paid(); p.Refunded.Amount != 0 || p.PendingRefund == nil || p.PendingRefund.Amount.Amount != 20000 {
+		t.Fatalf("expected 200.00 to be owed back, got %+v", p)
+	}
+	tdb.payments.FailRefunds = false
+	if n, err := RetryRefunds(context.TODO(), tdb.store.Booking, tdb.payments, time.Now().Add(time.Minute)); err != nil || n != 1 {
+		t.Fatalf("expected 1 refund to be retried, got %d %v", n, err)
+	}
+	if p := paid(); p.Refunded.Amount != 20000 || p.PendingRefund != nil {
+		t.Fatalf("expected 200.00 to be refunded, got %+v", p)
+	}
+
+	// a canceled booking is owed what is left
+	tdb.payments.FailRefunds = true
+	if resp := send("DELETE", bookingURL, nil, user, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the booking to be canceled while the refund fails, got %d", resp.StatusCode)
+	}
+	if p := paid(); p.PendingRefund == nil || p.PendingRefund.Amount.Amount != 10000 {
+		t.Fatalf("expected 100.00 to be owed back, got %+v", p)
+	}
+	tdb.payments.FailRefunds = false
+	if n, _ := RetryRefunds(context.TODO(), tdb.store.Booking, tdb.payments, time.Now().Add(time.Minute)); n != 1 {
+		t.Fatalf("expected 1 refund to be retried, got %d", n)
+	}
+	if p := paid(); p.Refunded.Amount != 30000 || p.Status != types.PaymentRefunded || p.PendingRefund != nil {
+		t.Fatalf("expected everything to be refunded, got %+v", p)
+	}
+}
+
+func TestBookingStatusTransitions(t *testing.T) {
+	tdb := setup(t)
+	var (
+		admin          = fixtures.AddUser(tdb.store.User, "admin", "admin", true)
+		user           = fixtures.AddUser(tdb.store.User, "foo", "bar", false)
+		hotel          = fixtures.AddHotel(tdb.store.Hotel, "Hilton", "New York", 5)
+		room           = fixtures.AddRoom(tdb.store.Room, hotel.ID, "Single", 99.99)
+		arrived        = fixtures.AddBooking(tdb.store.Booking, user.ID, room.ID, time.Now().Add(-time.Hour), time.Now().AddDate(0, 0, 2), 1)
+		upcoming       = fixtures.AddBooking(tdb.store.Booking, user.ID, room.ID, time.Now().AddDate(0, 0, 3), time.Now().AddDate(0, 0, 5), 1)
+		app            = fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
+		bookingHandler = NewBookingHandler(tdb.store, tdb.payments, tdb.notifier)
+	)
+	group := app.Group("/", JWTAuthentication(tdb.store.User), AdminAuth)
+	group.Post("/:id/check-in", bookingHandler.HandleUpdateStatus(types.StatusCheckedIn))
+	group.Post("/:id/check-out", bookingHandler.HandleUpdateStatus(types.StatusCheckedOut))
+	group.Post("/:id/no-show", bookingHandler.HandleUpdateStatus(types.StatusNoShow))
+
+	post := func(target string, as *types.User) (*http.Response, *types.Booking) {
+		req := httptest.NewRequest("POST", target, nil)
+		req.Header.Set("X-Api-Token", CreateTokenFromUser(as))
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var booking types.Booking
+		json.NewDecoder(resp.Body).Decode(&booking)
+		return resp, &booking
+	}
+
+	if resp, _ := post(fmt.Sprintf("/%s/check-in", arrived.ID.Hex()), user); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected status code 401 for a guest, got %d", resp.StatusCode)
+	}
+	if resp, _ := post(fmt.Sprintf("/%s/check-out", arrived.ID.Hex()), admin); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected status code 409 checking out before checking in, got %d", resp.StatusCode)
+	}
+	resp, booking := post(fmt.Sprintf("/%s/check-in", arrived.ID.Hex()), admin)
+	if resp.StatusCode != http.StatusOK || booking.Status != types.StatusCheckedIn {
+		t.Fatalf("expected the guest to be checked in, got %d %s", resp.StatusCode, booking.Status)
+	}
+	resp, booking = post(fmt.Sprintf("/%s/check-out", arrived.ID.Hex()), admin)
+	if resp.StatusCode != http.StatusOK || booking.Status != types.StatusCheckedOut {
+		t.Fatalf("expected the guest to be checked out, got %d %s", resp.StatusCode, booking.Status)
+	}
+	stored, _ := tdb.store.Booking.GetBookingByID(context.TODO(), arrived.ID.Hex())
+	if len(stored.StatusHistory) != 2 || stored.StatusHistory[1].By != admin.ID || stored.StatusHistory[1].Status != types.StatusCheckedOut {
+		t.Fatalf("expected both transitions in the history, got %+v", stored.StatusHistory)
+	}
+	if resp, _ := post(fmt.Sprintf("/%s/no-show", arrived.ID.Hex()), admin); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected status code 409 for a no-show after checking out, got %d", resp.StatusCode)
+	}
+
+	if resp, _ := post(fmt.Sprintf("/%s/no-show", upcoming.ID.Hex()), admin); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected status code 409 for a no-show before arrival, got %d", resp.StatusCode)
+	}
+}
+
+func TestBookingPayments(t *testing.T) {
+	tdb := setup(t)
+	var (
+		user           = fixtures.AddUser(tdb.store.User, "foo", "bar", false)
+		hotel          = fixtures.AddHotel(tdb.store.Hotel, "Hilton", "New York", 5)
+		room           = fixtures.AddRoom(tdb.store.Room, hotel.ID, "Single", 100)
+		day            = time.Now().AddDate(0, 0, 10)
+		app            = fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
+		roomHandler    = NewRoomHandler(tdb.store, tdb.payments)
+		bookingHandler = NewBookingHandler(tdb.store, tdb.payments, tdb.notifier)
+	)
+	app.Post("/room/:id/book", JWTAuthentication(tdb.store.User), roomHandler.HandleBookRoom)
+	app.Delete("/booking/:id", JWTAuthentication(tdb.store.User), bookingHandler.HandleCancelBooking)
+	tdb.payments.DeclineAbove = 25000
+
+	send := newSender(t, app)
+	book := func(nights int, out any) *http.Response {
+		params := types.BookParams{FromDate: types.Date{Time: day}, TillDate: types.Date{Time: day.AddDate(0, 0, nights)}, NumPersons: 1}
+		return send("POST", fmt.Sprintf("/room/%s/book", room.ID.Hex()), params, user, out)
+	}
+
+	var errResp errors.Error
+	if resp := book(3, &errResp); resp.StatusCode != http.StatusPaymentRequired || errResp != errors.ErrPaymentDeclined() {
+		t.Fatalf("expected the payment to be declined, got %d %v", resp.StatusCode, errResp)
+	}
+	// the declined booking must not keep the room
+	var booking types.Booking
+	if resp := book(2, &booking); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status code 201, got %d", resp.StatusCode)
+	}
+	payment := booking.Payment
+	if payment == nil || payment.Status != types.PaymentCaptured || payment.Captured.Amount != 20000 {
+		t.Fatalf("expected 200.00 to be captured, got %+v", payment)
+	}
+	if len(payment.Transactions) != 2 || payment.Transactions[1].ParentID != payment.Transactions[0].ID {
+		t.Fatalf("expected an authorization and its capture, got %+v", payment.Transactions)
+	}
+
+	if resp := send("DELETE", fmt.Sprintf("/booking/%s", booking.ID.Hex()), nil, user, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the booking to be canceled, got %d", resp.StatusCode)
+	}
+	stored, _ := tdb.store.Booking.GetBookingByID(context.TODO(), booking.ID.Hex())
+	if stored.Payment == nil || stored.Cancellation == nil || stored.Payment.Refunded != stored.Cancellation.Refund {
+		t.Fatalf("expected the refund to go back to the guest, got %+v", stored.Payment)
+	}
+	if last, ok := stored.Payment.Last(types.TransactionRefund); !ok || last.ParentID != payment.Transactions[1].ID {
+		t.Fatalf("expected a refund against the capture, got %+v", stored.Payment.Transactions)
+	}
+}
+
+func TestPaymentSchedules(t *testing.T) {
+	tdb := setup(t)
+	var (
+		admin          = fixtures.AddUser(tdb.store.User, "admin", "admin", true)
+		user           = fixtures.AddUser(tdb.store.User, "foo", "bar", false)
+		hotel          = fixtures.AddHotel(tdb.store.Hotel, "Hilton", "New York", 5)
+		depositRoom    = fixtures.AddRoom(tdb.store.Room, hotel.ID, "Single", 100)
+		prepaidRoom    = fixtures.AddRoom(tdb.store.Room, hotel.ID, "Double", 100)
+		app            = fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
+		roomHandler    = NewRoomHandler(tdb.store, tdb.payments)
+		bookingHandler = NewBookingHandler(tdb.store, tdb.payments, tdb.notifier)
+	)
+	// 20% at booking and the rest at check-in, or everything a week before
+	// arrival with a day's grace
+	schedules := map[*types.Room]*types.PaymentSchedule{
+		depositRoom: {DepositPercent: 20},
+		prepaidRoom: {BalanceDueDays: 7, AutoCancel: true, GraceHours: 24},
+	}
+	for room, schedule := range schedules {
+		rates := types.UpdateRoomParams{Rates: &types.RoomRates{Weekday: 10000, PaymentSchedule: schedule}}
+		if err := tdb.store.Room.UpdateRoom(context.TODO(), room.ID.Hex(), rates); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app.Post("/room/:id/book", JWTAuthentication(tdb.store.User), roomHandler.HandleBookRoom)
+	app.Post("/booking/:id/pay", JWTAuthentication(tdb.store.User), bookingHandler.HandlePayBalance)
+	app.Get("/admin/balance", JWTAuthentication(tdb.store.User), AdminAuth, bookingHandler.HandleGetBalances)
+
+	send := newSender(t, app)
+	book := func(room *types.Room, daysOut int) *types.Booking {
+		var booking types.Booking
+		from := time.Now().AddDate(0, 0, daysOut)
+		params := types.BookParams{FromDate: types.Date{Time: from}, TillDate: types.Date{Time: from.AddDate(0, 0, 2)}, NumPersons: 1}
+		if resp := send("POST", fmt.Sprintf("/room/%s/book", room.ID.Hex()), params, user, &booking); resp.StatusCode != http.StatusCreated {
+			t.Fatalf("expected status code 201, got %d", resp.StatusCode)
+		}
+		return &booking
+	}
+	balances := func(query string) []*types.Booking {
+		var resp bookingsResp
+		send("GET", "/admin/balance"+query, nil, admin, &resp)
+		return resp.Data
+	}
+
+	withDeposit := book(depositRoom, 10)
+	if withDeposit.Payment == nil || withDeposit.Payment.Captured.Amount != 4000 {
+		t.Fatalf("expected a deposit of 40.00 to be captured, got %+v", withDeposit.Payment)
+	}
+	if b := withDeposit.Balance; b == nil || b.Amount.Amount != 16000 || !b.DueAt.Equal(withDeposit.FromDate) || b.CancelAt != nil {
+		t.Fatalf("expected 160.00 to be due at check-in, got %+v", b)
+	}
+	prepaid := book(prepaidRoom, 30)
+	if prepaid.Payment != nil || prepaid.Balance == nil || prepaid.Balance.Amount.Amount != 20000 {
+		t.Fatalf("expected nothing to be charged yet, got %+v %+v", prepaid.Payment, prepaid.Balance)
+	}
+	// within the week everything is charged at once
+	if late := book(prepaidRoom, 3); late.Balance != nil || late.Payment.Captured.Amount != 20000 {
+		t.Fatalf("expected the full amount to be charged, got %+v %+v", late.Payment, late.Balance)
+	}
+
+	due := balances(fmt.Sprintf("?hotelID=%s", hotel.ID.Hex()))
+	if len(due) != 2 || due[0].ID != withDeposit.ID || due[1].ID != prepaid.ID {
+		t.Fatalf("expected both balances by due date, got %+v", due)
+	}
+
+	var paid types.Booking
+	if resp := send("POST", fmt.Sprintf("/booking/%s/pay", withDeposit.ID.Hex()), nil, user, &paid); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code 200, got %d", resp.StatusCode)
+	}
+	if paid.Balance.Status != types.BalancePaid || paid.Payment.Captured.Amount != 20000 {
+		t.Fatalf("expected the balance to be paid, got %+v %+v", paid.Balance, paid.Payment)
+	}
+	var errResp errors.Error
+	if resp := send("POST", fmt.Sprintf("/booking/%s/pay", withDeposit.ID.Hex()), nil, user, &errResp); resp.StatusCode != http.StatusConflict || errResp != errors.ErrNothingDue() {
+		t.Fatalf("expected nothing left to pay, got %d %v", resp.StatusCode, errResp)
+	}
+
+	// an hour after the balance was due it is overdue, a day later the
+	// booking is canceled
+	overdueAt := prepaid.Balance.DueAt.Add(time.Hour)
+	overdue, canceled, err := SettleOverdueBalances(context.TODO(), tdb.store, tdb.payments, tdb.notifier, overdueAt)
+	if err != nil || overdue != 1 || canceled != 0 {
+		t.Fatalf("expected one overdue balance, got %d %d %v", overdue, canceled, err)
+	}
+	if list := balances("?status=overdue"); len(list) != 1 || list[0].ID != prepaid.ID {
+		t.Fatalf("expected the overdue balance to be listed, got %+v", list)
+	}
+	if list := balances("?status=due"); len(list) != 0 {
+		t.Fatalf("expected no upcoming balances, got %+v", list)
+	}
+	if _, canceled, err = SettleOverdueBalances(context.TODO(), tdb.store, tdb.payments, tdb.notifier, overdueAt.Add(24*time.Hour)); err != nil || canceled != 1 {
+		t.Fatalf("expected the booking to be canceled, got %d %v", canceled, err)
+	}
+	stored, _ := tdb.store.Booking.GetBookingByID(context.TODO(), prepaid.ID.Hex())
+	if stored.Status != types.StatusCanceled || !stored.Cancellation.CanceledBy.IsZero() || stored.Cancellation.Refund.Amount != 0 {
+		t.Fatalf("expected the system to cancel without a refund, got %+v %+v", stored.Status, stored.Cancellation)
+	}
+	if list := balances(""); len(list) != 0 {
+		t.Fatalf("expected no balances left, got %+v", list)
+	}
+	if resp := send("GET", "/admin/balance?status=paid", nil, admin, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status code 400 for an unknown status, got %d", resp.StatusCode)
+	}
+}
+
+func TestOverbooking(t *testing.T) {
+	tdb := setup(t)
+	var (
+		admin           = fixtures.AddUser(tdb.store.User, "admin", "admin", true)
+		user            = fixtures.AddUser(tdb.store.User, "foo", "bar", false)
+		hotel           = fixtures.AddHotel(tdb.store.Hotel, "Hilton", "New York", 5)
+		untyped         = []*types.Room{fixtures.AddRoom(tdb.store.Room, hotel.ID, "Single", 100), fixtures.AddRoom(tdb.store.Room, hotel.ID, "Single", 100)}
+		app             = fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
+		hotelHandler    = NewHotelHandler(tdb.store)
+		roomHandler     = NewRoomHandler(tdb.store, tdb.payments)
+		roomTypeHandler = NewRoomTypeHandler(tdb.store, tdb.payments)
+		bookingHandler  = NewBookingHandler(tdb.store, tdb.payments, tdb.notifier)
+	)
+	apiv1 := app.Group("/api", JWTAuthentication(tdb.store.User))
+	apiv1.Post("/room/:id/book", roomHandler.HandleBookRoom)
+	apiv1.Post("/room-type/:id/book", roomTypeHandler.HandleBookRoomType)
+	adminGroup := app.Group("/admin", JWTAuthentication(tdb.store.User), AdminAuth)
+	adminGroup.Put("/hotel/:id", hotelHandler.HandlePutHotel)
+	adminGroup.Post("/room", roomHandler.HandlePostRoom)
+	adminGroup.Delete("/room/:id", roomHandler.HandleDeleteRoom)
+	adminGroup.Post("/room-type", roomTypeHandler.HandlePostRoomType)
+	adminGroup.Put("/room-type/:id", roomTypeHandler.HandlePutRoomType)
+	adminGroup.Post("/room/:id/block", NewBlockHandler(tdb.store, tdb.notifier).HandlePostBlock)
+	adminGroup.Get("/relocation", bookingHandler.HandleGetRelocations)
+
+	send := newSender(t, app)
+
+	overbooking := 50
+	if code := send("PUT", "/admin/hotel/"+hotel.ID.Hex(), types.UpdateHotelParams{Overbooking: &overbooking}, admin, nil).StatusCode; code != http.StatusOK {
+		t.Fatalf("expected status code 200, got %d", code)
+	}
+	strict := 0
+	addType := func(overbooking *int) (types.RoomType, []types.Room) {
+		var roomType types.RoomType
+		params := types.CreateRoomTypeParams{HotelID: hotel.ID.Hex(), Name: "Double", Size: "Double", Price: 150, MaxAdults: 2, Overbooking: overbooking}
+		if code := send("POST", "/admin/room-type", params, admin, &roomType).StatusCode; code != http.StatusCreated {
+			t.Fatalf("expected status code 201, got %d", code)
+		}
+		rooms := make([]types.Room, 2)
+		for i := range rooms {
+			params := types.CreateRoomParams{HotelID: hotel.ID.Hex(), RoomTypeID: roomType.ID.Hex(), Size: "Double", Price: 150}
+			if code := send("POST", "/admin/room", params, admin, &rooms[i]).StatusCode; code != http.StatusCreated {
+				t.Fatalf("expected status code 201, got %d", code)
+			}
+		}
+		return roomType, rooms
+	}
+	overbooked, overbookedRooms := addType(nil)
+	notOverbooked, _ := addType(nil)
+	invalid := 101
+	params := types.CreateRoomTypeParams{HotelID: hotel.ID.Hex(), Name: "Suite", Size: "Suite", Price: 300, MaxAdults: 2, Overbooking: &invalid}
+	if code := send("POST", "/admin/room-type", params, admin, nil).StatusCode; code != http.StatusBadRequest {
+		t.Fatalf("expected status code 400 overbooking more than all rooms, got %d", code)
+	}
+	update := "/admin/room-type/" + notOverbooked.ID.Hex()
+	if code := send("PUT", update, types.UpdateRoomTypeParams{Overbooking: &invalid}, admin, nil).StatusCode; code != http.StatusBadRequest {
+		t.Fatalf("expected status code 400 updating to overbook more than all rooms, got %d", code)
+	}
+	if code := send("PUT", update, types.UpdateRoomTypeParams{Overbooking: &strict}, admin, &notOverbooked).StatusCode; code != http.StatusOK {
+		t.Fatalf("expected status code 200, got %d", code)
+	}
+	if notOverbooked.Overbooking == nil || *notOverbooked.Overbooking != strict {
+		t.Fatalf("expected the type to no longer be overbooked, got %+v", notOverbooked)
+	}
+
+	stay := types.BookParams{FromDate: types.Date{Time: time.Now().AddDate(0, 0, 3)}, TillDate: types.Date{Time: time.Now().AddDate(0, 0, 5)}, NumPersons: 1}
+	book := func(target string) (types.Booking, int) {
+		var booking types.Booking
+		code := send("POST", target, stay, user, &booking).StatusCode
+		return booking, code
+	}
+	// half of two rooms makes one booking on top
+	var last types.Booking
+	for i := 0; i < 3; i++ {
+		booking, code := book(fmt.Sprintf("/api/room-type/%s/book", overbooked.ID.Hex()))
+		if code != http.StatusCreated {
+			t.Fatalf("expected status code 201 for booking %d, got %d", i+1, code)
+		}
+		last = booking
+	}
+	if _, code := book(fmt.Sprintf("/api/room-type/%s/book", overbooked.ID.Hex())); code != http.StatusConflict {
+		t.Fatalf("expected status code 409 beyond the allowance, got %d", code)
+	}
+	for i := 0; i < 2; i++ {
+		if _, code := book(fmt.Sprintf("/api/room-type/%s/book", notOverbooked.ID.Hex())); code != http.StatusCreated {
+			t.Fatalf("expected status code 201, got %d", code)
+		}
+	}
+	if _, code := book(fmt.Sprintf("/api/room-type/%s/book", notOverbooked.ID.Hex())); code != http.StatusConflict {
+		t.Fatalf("expected status code 409 for a type that isn't overbooked, got %d", code)
+	}
+
+	// the rooms without a type are overbooked together
+	target := func(room *types.Room) string { return fmt.Sprintf("/api/room/%s/book", room.ID.Hex()) }
+	if _, code := book(target(untyped[0])); code != http.StatusCreated {
+		t.Fatalf("expected status code 201, got %d", code)
+	}
+	if _, code := book(target(untyped[0])); code != http.StatusCreated {
+		t.Fatalf("expected status code 201 double booking within the allowance, got %d", code)
+	}
+	lastUntyped, code := book(target(untyped[1]))
+	if code != http.StatusCreated {
+		t.Fatalf("expected status code 201 within the allowance, got %d", code)
+	}
+	if _, code := book(target(untyped[1])); code != http.StatusConflict {
+		t.Fatalf("expected status code 409 beyond the allowance, got %d", code)
+	}
+	block := types.BlockRoomParams{FromDate: stay.FromDate, TillDate: stay.TillDate, Reason: "painting"}
+	if code := send("POST", fmt.Sprintf("/admin/room/%s/block", untyped[0].ID.Hex()), block, admin, nil).StatusCode; code != http.StatusConflict {
+		t.Fatalf("expected status code 409 blocking a booked room, got %d", code)
+	}
+	if code := send("DELETE", "/admin/room/"+overbookedRooms[0].ID.Hex(), nil, admin, nil).StatusCode; code != http.StatusConflict {
+		t.Fatalf("expected status code 409 deleting a room of an overbooked type, got %d", code)
+	}
+
+	var relocations []types.Relocation
+	send("GET", "/admin/relocation?hotelID="+hotel.ID.Hex(), nil, admin, &db.ResourceResponse{Data: &relocations})
+	if len(relocations) != 2 {
+		t.Fatalf("expected 2 relocations, got %+v", relocations)
+	}
+	relocated := map[primitive.ObjectID]bool{}
+	for _, r := range relocations {
+		relocated[r.Booking.ID] = r.HotelID == hotel.ID
+	}
+	if !relocated[last.ID] || !relocated[lastUntyped.ID] {
+		t.Fatalf("expected the latest bookings of the overbooked rooms to be relocated, got %+v", relocations)
+	}
+	send("GET", "/admin/relocation?limit=1&page=2&hotelID="+hotel.ID.Hex(), nil, admin, &db.ResourceResponse{Data: &relocations})
+	if len(relocations) != 1 {
+		t.Fatalf("expected a page of 1 relocation, got %+v", relocations)
+	}
+	past := fmt.Sprintf("/admin/relocation?hotelID=%s&from=%s&till=%s", hotel.ID.Hex(), time.Now().AddDate(0, 0, -5).Format(time.DateOnly), time.Now().AddDate(0, 0, 1).Format(time.DateOnly))
+	send("GET", past, nil, admin, &db.ResourceResponse{Data: &relocations})
+	if len(relocations) != 0 {
+		t.Fatalf("expected no relocations before the arrivals, got %+v", relocations)
+	}
+	if resp := send("GET", "/admin/relocation", nil, admin, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status code 400 without a hotel, got %d", resp.StatusCode)
+	}
+}
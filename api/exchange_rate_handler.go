@@ -0,0 +1,197 @@
+package api
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kmogilevskii/hotel-reservation/db"
+	"github.com/kmogilevskii/hotel-reservation/errors"
+	"github.com/kmogilevskii/hotel-reservation/pricing"
+	"github.com/kmogilevskii/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type ExchangeRateHandler struct {
+	store *db.Store
+}
+
+func NewExchangeRateHandler(store *db.Store) *ExchangeRateHandler {
+	return &ExchangeRateHandler{store: store}
+}
+
+// HandlePutExchangeRates uploads the exchange-rate table of a base currency,
+// replacing the one uploaded before.
+func (h *ExchangeRateHandler) HandlePutExchangeRates(c *fiber.Ctx) error {
+	var table types.ExchangeRates
+	if err := c.BodyParser(&table); err != nil {
+		return errors.ErrBadRequest()
+	}
+	table.Base = strings.ToUpper(c.Params("base"))
+	table.UpdatedAt = time.Now()
+	if err := table.Validate(c.Context()); err != nil {
+		return errors.NewError(http.StatusBadRequest, err.Error())
+	}
+	if err := h.store.ExchangeRate.PutExchangeRates(c.Context(), &table); err != nil {
+		return err
+	}
+	return c.JSON(table)
+}
+
+func (h *ExchangeRateHandler) HandleGetExchangeRates(c *fiber.Ctx) error {
+	tables, err := h.store.ExchangeRate.GetAllExchangeRates(c.Context())
+	if err != nil {
+		return err
+	}
+	resp := db.ResourceResponse{
+		Results: len(tables),
+		Data:    tables,
+	}
+	return c.JSON(resp)
+}
+
+// requestedCurrency returns the currency asked for with ?currency=, empty
+// when prices are wanted in the currency they are set in.
+func requestedCurrency(c *fiber.Ctx) (string, error) {
+	currency := strings.ToUpper(c.Query("currency"))
+	if currency == "" {
+		return "", nil
+	}
+	if len(currency) != 3 || strings.Trim(currency, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return "", errors.NewError(http.StatusBadRequest, fmt.Sprintf("invalid currency %s", currency))
+	}
+	return currency, nil
+}
+
+// exchangeRate looks up the rate from one currency to another in the table
+// of the source currency, falling back to the inverse rate in the table of
+// the target currency.
+func exchangeRate(ctx context.Context, store db.ExchangeRateStore, from, to string) (*types.ExchangeRate, error) {
+	table, err := store.GetExchangeRates(ctx, from)
+	switch {
+	case err == nil:
+		if rate, ok := table.Rates[to]; ok {
+			return &types.ExchangeRate{From: from, To: to, Rate: rate, AsOf: table.UpdatedAt}, nil
+		}
+	case !stderrors.Is(err, mongo.ErrNoDocuments):
+		return nil, err
+	}
+	table, err = store.GetExchangeRates(ctx, to)
+	switch {
+	case err == nil:
+		if rate, ok := table.Rates[from]; ok {
+			return &types.ExchangeRate{From: from, To: to, Rate: 1 / rate, AsOf: table.UpdatedAt}, nil
+		}
+	case !stderrors.Is(err, mongo.ErrNoDocuments):
+		return nil, err
+	}
+	return nil, errors.NewError(http.StatusBadRequest, fmt.Sprintf("no exchange rate from %s to %s", from, to))
+}
+
+// converter converts the prices of hotels, rooms and room types to the
+// currency asked for with ?currency= before they are shown. Without one it
+// leaves them alone. Rates and hotels are looked up once per request.
+type converter struct {
+	ctx    context.Context
+	store  *db.Store
+	to     string
+	rates  map[string]*types.ExchangeRate
+	hotels map[primitive.ObjectID]*types.Hotel
+}
+
+func newConverter(c *fiber.Ctx, store *db.Store) (*converter, error) {
+	to, err := requestedCurrency(c)
+	if err != nil {
+		return nil, err
+	}
+	return &converter{
+		ctx:    c.Context(),
+		store:  store,
+		to:     to,
+		rates:  map[string]*types.ExchangeRate{},
+		hotels: map[primitive.ObjectID]*types.Hotel{},
+	}, nil
+}
+
+// rate returns the rate from the currency to the requested one, nil if there
+// is nothing to convert.
+func (cv *converter) rate(from string) (*types.ExchangeRate, error) {
+	if cv.to == "" || cv.to == from {
+		return nil, nil
+	}
+	if rate, ok := cv.rates[from]; ok {
+		return rate, nil
+	}
+	rate, err := exchangeRate(cv.ctx, cv.store.ExchangeRate, from, cv.to)
+	if err != nil {
+		return nil, err
+	}
+	cv.rates[from] = rate
+	return rate, nil
+}
+
+func (cv *converter) currency(hotelID primitive.ObjectID) (string, error) {
+	hotel, ok := cv.hotels[hotelID]
+	if !ok {
+		var err error
+		if hotel, err = getHotel(cv.ctx, cv.store.Hotel, hotelID.Hex()); err != nil {
+			return "", err
+		}
+		cv.hotels[hotelID] = hotel
+	}
+	return pricing.Currency(hotel), nil
+}
+
+// hotel converts the hotel's fees.
+func (cv *converter) hotel(hotel *types.Hotel) error {
+	rate, err := cv.rate(pricing.Currency(hotel))
+	if err != nil || rate == nil {
+		return err
+	}
+	hotel.Taxes = pricing.ConvertTaxes(hotel.Taxes, *rate)
+	return nil
+}
+
+func (cv *converter) room(room *types.Room) error {
+	if cv.to == "" {
+		return nil
+	}
+	currency, err := cv.currency(room.HotelID)
+	if err != nil {
+		return err
+	}
+	room.Price, room.Rates, err = cv.roomRates(room.Price, pricing.Rates(room, currency))
+	return err
+}
+
+func (cv *converter) roomType(roomType *types.RoomType) error {
+	if cv.to == "" {
+		return nil
+	}
+	currency, err := cv.currency(roomType.HotelID)
+	if err != nil {
+		return err
+	}
+	roomType.Price, roomType.Rates, err = cv.roomRates(roomType.Price, pricing.TypeRates(roomType, currency))
+	return err
+}
+
+// roomRates converts a list price and the rates it is sold at. The converted
+// rates are always returned, so they tell the currency.
+func (cv *converter) roomRates(price float64, rates types.RoomRates) (float64, *types.RoomRates, error) {
+	rate, err := cv.rate(rates.Currency)
+	if err != nil {
+		return 0, nil, err
+	}
+	if rate == nil {
+		return price, &rates, nil
+	}
+	converted := pricing.ConvertRates(rates, *rate)
+	amount := pricing.Convert(pricing.ToMinorUnits(price, rate.From), *rate)
+	return pricing.FromMinorUnits(amount, rate.To), &converted, nil
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kmogilevskii/hotel-reservation/db"
+	"github.com/kmogilevskii/hotel-reservation/db/fixtures"
+	"github.com/kmogilevskii/hotel-reservation/errors"
+	"github.com/kmogilevskii/hotel-reservation/types"
+)
+
+func TestCurrencies(t *testing.T) {
+	tdb := setup(t)
+	var (
+		admin          = fixtures.AddUser(tdb.store.User, "admin", "admin", true)
+		user           = fixtures.AddUser(tdb.store.User, "foo", "bar", false)
+		hotel          = fixtures.AddHotel(tdb.store.Hotel, "Hilton", "New York", 5)
+		room           = fixtures.AddRoom(tdb.store.Room, hotel.ID, "Single", 100)
+		app            = fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
+		rateHandler    = NewExchangeRateHandler(tdb.store)
+		hotelHandler   = NewHotelHandler(tdb.store)
+		roomHandler    = NewRoomHandler(tdb.store, tdb.payments)
+		bookingHandler = NewBookingHandler(tdb.store, tdb.payments, tdb.notifier)
+		eur            = func(amount int64) types.Money { return types.Money{Amount: amount, Currency: "EUR"} }
+	)
+	app.Put("/admin/exchange-rates/:base", JWTAuthentication(tdb.store.User), AdminAuth, rateHandler.HandlePutExchangeRates)
+	app.Get("/hotel/:id/rooms", JWTAuthentication(tdb.store.User), hotelHandler.HandleGetRooms)
+	app.Post("/room/:id/book", JWTAuthentication(tdb.store.User), roomHandler.HandleBookRoom)
+	app.Patch("/booking/:id", JWTAuthentication(tdb.store.User), bookingHandler.HandleModifyBooking)
+
+	send := newSender(t, app)
+	upload := func(base string, rates map[string]float64, as *types.User) *http.Response {
+		return send("PUT", "/admin/exchange-rates/"+base, types.ExchangeRates{Rates: rates}, as, nil)
+	}
+	roomsIn := func(currency string) (*http.Response, []types.Room) {
+		var body struct {
+			db.ResourceResponse
+			Data []types.Room `json:"data"`
+		}
+		resp := send("GET", fmt.Sprintf("/hotel/%s/rooms?currency=%s", hotel.ID.Hex(), currency), nil, user, &body)
+		return resp, body.Data
+	}
+
+	if resp := upload("usd", map[string]float64{"EUR": 0.9}, user); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected status code 401 for a regular user, got %d", resp.StatusCode)
+	}
+	for _, bad := range []map[string]float64{nil, {"eur": 0.9}, {"EUR": 0}} {
+		if resp := upload("USD", bad, admin); resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected status code 400 for %v, got %d", bad, resp.StatusCode)
+		}
+	}
+	if resp := upload("usd", map[string]float64{"EUR": 0.9}, admin); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code 200, got %d", resp.StatusCode)
+	}
+
+	resp, rooms := roomsIn("eur")
+	if resp.StatusCode != http.StatusOK || len(rooms) != 1 {
+		t.Fatalf("expected the room, got %d %+v", resp.StatusCode, rooms)
+	}
+	if rooms[0].Price != 90 || rooms[0].Rates == nil || rooms[0].Rates.Currency != "EUR" || rooms[0].Rates.Weekday != 9000 {
+		t.Fatalf("expected 100.00 USD shown as 90.00 EUR, got %v %+v", rooms[0].Price, rooms[0].Rates)
+	}
+	if resp, _ := roomsIn("GBP"); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status code 400 without a GBP rate, got %d", resp.StatusCode)
+	}
+	if resp, _ := roomsIn("EURO"); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status code 400 for an invalid currency, got %d", resp.StatusCode)
+	}
+	// the GBP table is used the other way round
+	upload("GBP", map[string]float64{"USD": 1.25}, admin)
+	if _, rooms := roomsIn("GBP"); len(rooms) != 1 || rooms[0].Price != 80 {
+		t.Fatalf("expected 100.00 USD shown as 80.00 GBP, got %+v", rooms)
+	}
+
+	params := types.BookParams{FromDate: types.Date{Time: time.Now().AddDate(0, 0, 3)}, TillDate: types.Date{Time: time.Now().AddDate(0, 0, 5)}, NumPersons: 1}
+	var booking types.Booking
+	if resp := send("POST", fmt.Sprintf("/room/%s/book?currency=EUR", room.ID.Hex()), params, user, &booking); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status code 201, got %d", resp.StatusCode)
+	}
+	if booking.Currency != "EUR" || booking.Price.Total != eur(18000) || booking.Payment.Captured != eur(18000) {
+		t.Fatalf("expected 180.00 EUR to be charged, got %s %+v %+v", booking.Currency, booking.Price.Total, booking.Payment)
+	}
+	if r := booking.ExchangeRate; r == nil || r.From != "USD" || r.To != "EUR" || r.Rate != 0.9 {
+		t.Fatalf("expected the rate to be kept on the booking, got %+v", r)
+	}
+
+	// a longer stay is charged at the rate the booking was made at
+	upload("USD", map[string]float64{"EUR": 1}, admin)
+	modify := types.ModifyBookingParams{TillDate: types.Date{Time: booking.TillDate.AddDate(0, 0, 1)}}
+	if resp := send("PATCH", fmt.Sprintf("/booking/%s", booking.ID.Hex()), modify, user, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the booking to be modified, got %d", resp.StatusCode)
+	}
+	stored, _ := tdb.store.Booking.GetBookingByID(context.TODO(), booking.ID.Hex())
+	if stored.Price.Total != eur(27000) || stored.ExchangeRate.Rate != 0.9 {
+		t.Fatalf("expected 270.00 EUR at 0.9, got %+v %+v", stored.Price.Total, stored.ExchangeRate)
+	}
+
+	// without a currency guests pay in the hotel's
+	chf := fixtures.AddHotel(tdb.store.Hotel, "Baur au Lac", "Zurich", 5)
+	tdb.store.Hotel.UpdateHotel(context.TODO(), chf.ID.Hex(), types.UpdateHotelParams{Currency: "CHF"})
+	chfRoom := fixtures.AddRoom(tdb.store.Room, chf.ID, "Single", 300)
+	var local types.Booking
+	if resp := send("POST", fmt.Sprintf("/room/%s/book", chfRoom.ID.Hex()), params, user, &local); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status code 201, got %d", resp.StatusCode)
+	}
+	if local.Currency != "CHF" || local.Price.Total.Amount != 60000 || local.ExchangeRate != nil {
+		t.Fatalf("expected 600.00 CHF, got %s %+v %+v", local.Currency, local.Price.Total, local.ExchangeRate)
+	}
+}
@@ -1,12 +1,18 @@
 package api
 
 import (
+	"context"
+	stderrors "errors"
+	"fmt"
 	"net/http"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/kmogilevskii/hotel-reservation/db"
 	"github.com/kmogilevskii/hotel-reservation/errors"
+	"github.com/kmogilevskii/hotel-reservation/pricing"
+	"github.com/kmogilevskii/hotel-reservation/types"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type HotelHandler struct {
@@ -26,10 +32,19 @@ func (hh *HotelHandler) HandleGetHotels(c *fiber.Ctx) error {
 	filter := db.Map{
 		//"rating": params.Rating,
 	}
+	cv, err := newConverter(c, hh.store)
+	if err != nil {
+		return err
+	}
 	hotels, err := hh.store.Hotel.GetHotels(c.Context(), filter, &params.Pagination)
 	if err != nil {
 		return errors.ErrBadRequest()
 	}
+	for _, hotel := range hotels {
+		if err := cv.hotel(hotel); err != nil {
+			return err
+		}
+	}
 	resp := db.ResourceResponse{
 		Results: len(hotels),
 		Data:    hotels,
@@ -39,12 +54,19 @@ func (hh *HotelHandler) HandleGetHotels(c *fiber.Ctx) error {
 }
 
 func (hh *HotelHandler) HandleGetHotel(c *fiber.Ctx) error {
+	cv, err := newConverter(c, hh.store)
+	if err != nil {
+		return err
+	}
 	hotelID := c.Params("id")
-	hotels, err := hh.store.Hotel.GetHotelByID(c.Context(), hotelID)
+	hotel, err := hh.store.Hotel.GetHotelByID(c.Context(), hotelID)
 	if err != nil {
 		return errors.ErrInvalidID()
 	}
-	return c.JSON(hotels)
+	if err := cv.hotel(hotel); err != nil {
+		return err
+	}
+	return c.JSON(hotel)
 }
 
 func (hh *HotelHandler) HandleGetRooms(c *fiber.Ctx) error {
@@ -57,6 +79,10 @@ func (hh *HotelHandler) HandleGetRooms(c *fiber.Ctx) error {
 	if err != nil {
 		return errors.ErrInvalidID()
 	}
+	cv, err := newConverter(c, hh.store)
+	if err != nil {
+		return err
+	}
 	filter := db.Map{
 		"hotelID": oid,
 	}
@@ -68,6 +94,11 @@ func (hh *HotelHandler) HandleGetRooms(c *fiber.Ctx) error {
 	if err != nil {
 		return err
 	}
+	for _, room := range rooms {
+		if err := cv.room(room); err != nil {
+			return err
+		}
+	}
 
 	resp := db.ResourceResponse{
 		Results: len(rooms),
@@ -77,3 +108,122 @@ func (hh *HotelHandler) HandleGetRooms(c *fiber.Ctx) error {
 
 	return c.Status(http.StatusOK).JSON(resp)
 }
+
+// getHotel maps store lookup failures to API errors.
+func getHotel(ctx context.Context, store db.HotelStore, id string) (*types.Hotel, error) {
+	hotel, err := store.GetHotelByID(ctx, id)
+	if err != nil {
+		if stderrors.Is(err, mongo.ErrNoDocuments) {
+			return nil, errors.ErrResourceNotFound("hotel")
+		}
+		return nil, errors.ErrInvalidID()
+	}
+	return hotel, nil
+}
+
+func (hh *HotelHandler) HandlePostHotel(c *fiber.Ctx) error {
+	var params types.CreateHotelParams
+	if err := c.BodyParser(&params); err != nil {
+		return errors.ErrBadRequest()
+	}
+	if err := params.Validate(c.Context()); err != nil {
+		return errors.ErrBadRequest()
+	}
+	hotel := types.NewHotelFromParams(params)
+	if hotel.Taxes != nil {
+		if err := hotel.Taxes.Check(pricing.Currency(hotel)); err != nil {
+			return errors.NewError(http.StatusBadRequest, err.Error())
+		}
+	}
+	hotel, err := hh.store.Hotel.Insert(c.Context(), hotel)
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusCreated).JSON(hotel)
+}
+
+func (hh *HotelHandler) HandlePutHotel(c *fiber.Ctx) error {
+	var (
+		params  types.UpdateHotelParams
+		hotelID = c.Params("id")
+	)
+	if err := c.BodyParser(&params); err != nil {
+		return errors.ErrBadRequest()
+	}
+	if err := params.Validate(c.Context()); err != nil || len(params.ToBSON()) == 0 {
+		return errors.ErrBadRequest()
+	}
+	hotel, err := getHotel(c.Context(), hh.store.Hotel, hotelID)
+	if err != nil {
+		return err
+	}
+	// rooms, their bookings and the taxes are priced in the hotel's
+	// currency, changing it would silently reprice them
+	changed := params.Currency != "" && params.Currency != pricing.Currency(hotel)
+	if changed && (len(hotel.Rooms) > 0 || len(hotel.RoomTypes) > 0) {
+		return errors.NewError(http.StatusConflict, "the currency of a hotel with rooms can't be changed")
+	}
+	// the fees have to be in the currency the hotel ends up with
+	updated := *hotel
+	if changed {
+		updated.Currency = params.Currency
+	}
+	if params.Taxes != nil {
+		updated.Taxes = params.Taxes
+	}
+	if updated.Taxes != nil {
+		if err := updated.Taxes.Check(pricing.Currency(&updated)); err != nil {
+			return errors.NewError(http.StatusBadRequest, err.Error())
+		}
+	}
+	if err := hh.store.Hotel.UpdateHotel(c.Context(), hotelID, params); err != nil {
+		return err
+	}
+	hotel, err = hh.store.Hotel.GetHotelByID(c.Context(), hotelID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(hotel)
+}
+
+// HandleDeleteHotel deletes the hotel together with its rooms and room types.
+// It is refused while any of the rooms or room types has upcoming bookings.
+func (hh *HotelHandler) HandleDeleteHotel(c *fiber.Ctx) error {
+	hotelID := c.Params("id")
+	hotel, err := getHotel(c.Context(), hh.store.Hotel, hotelID)
+	if err != nil {
+		return err
+	}
+	for _, roomID := range hotel.Rooms {
+		upcoming, err := hasUpcomingBookings(c.Context(), hh.store.Booking, roomID)
+		if err != nil {
+			return err
+		}
+		if upcoming {
+			return errors.ErrHasUpcomingBookings("hotel")
+		}
+	}
+	for _, roomTypeID := range hotel.RoomTypes {
+		upcoming, err := hasUpcomingTypeBookings(c.Context(), hh.store.Booking, roomTypeID)
+		if err != nil {
+			return err
+		}
+		if upcoming {
+			return errors.ErrHasUpcomingBookings("hotel")
+		}
+	}
+	for _, roomID := range hotel.Rooms {
+		if err := hh.store.Room.DeleteRoom(c.Context(), roomID.Hex()); err != nil && !stderrors.Is(err, mongo.ErrNoDocuments) {
+			return err
+		}
+	}
+	for _, roomTypeID := range hotel.RoomTypes {
+		if err := hh.store.RoomType.DeleteRoomType(c.Context(), roomTypeID.Hex()); err != nil && !stderrors.Is(err, mongo.ErrNoDocuments) {
+			return err
+		}
+	}
+	if err := hh.store.Hotel.DeleteHotel(c.Context(), hotelID); err != nil {
+		return err
+	}
+	return c.JSON(map[string]string{"msg": fmt.Sprintf("deleted hotel with id %s", hotelID)})
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kmogilevskii/hotel-reservation/db/fixtures"
+	"github.com/kmogilevskii/hotel-reservation/errors"
+	"github.com/kmogilevskii/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestAdminHotelCRUD(t *testing.T) {
+	tdb := setup(t)
+	var (
+		admin        = fixtures.AddUser(tdb.store.User, "admin", "admin", true)
+		user         = fixtures.AddUser(tdb.store.User, "foo", "bar", false)
+		app          = fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
+		hotelHandler = NewHotelHandler(tdb.store)
+	)
+	group := app.Group("/", JWTAuthentication(tdb.store.User), AdminAuth)
+	group.Post("/hotel", hotelHandler.HandlePostHotel)
+	group.Put("/hotel/:id", hotelHandler.HandlePutHotel)
+	group.Delete("/hotel/:id", hotelHandler.HandleDeleteHotel)
+
+	send := newSender(t, app)
+
+	params := types.CreateHotelParams{Name: "Hilton", Location: "New York", Rating: 5}
+	if resp := send("POST", "/hotel", params, user, nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected status code 401 for a regular user, got %d", resp.StatusCode)
+	}
+	if resp := send("POST", "/hotel", types.CreateHotelParams{Name: "H"}, admin, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status code 400 for invalid params, got %d", resp.StatusCode)
+	}
+	resp := send("POST", "/hotel", params, admin, nil)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status code 201, got %d", resp.StatusCode)
+	}
+	var hotel types.Hotel
+	json.NewDecoder(resp.Body).Decode(&hotel)
+	if hotel.ID.IsZero() || hotel.Name != params.Name || hotel.Rating != 5 {
+		t.Fatalf("unexpected hotel %+v", hotel)
+	}
+
+	resp = send("PUT", fmt.Sprintf("/hotel/%s", hotel.ID.Hex()), types.UpdateHotelParams{Location: "Boston"}, admin, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code 200, got %d", resp.StatusCode)
+	}
+	json.NewDecoder(resp.Body).Decode(&hotel)
+	if hotel.Location != "Boston" || hotel.Name != "Hilton" {
+		t.Fatalf("unexpected hotel after update %+v", hotel)
+	}
+
+	for _, bad := range []types.UpdateHotelParams{{Timezone: "Mars/Olympus"}, {CheckInTime: "3pm"}} {
+		if resp := send("PUT", fmt.Sprintf("/hotel/%s", hotel.ID.Hex()), bad, admin, nil); resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected status code 400 for %+v, got %d", bad, resp.StatusCode)
+		}
+	}
+
+	if resp := send("PUT", fmt.Sprintf("/hotel/%s", hotel.ID.Hex()), types.UpdateHotelParams{Currency: "EUR"}, admin, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the currency of a hotel without rooms to change, got %d", resp.StatusCode)
+	}
+	room := fixtures.AddRoom(tdb.store.Room, hotel.ID, "Single", 99.99)
+	if resp := send("PUT", fmt.Sprintf("/hotel/%s", hotel.ID.Hex()), types.UpdateHotelParams{Currency: "CHF"}, admin, nil); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected status code 409 for a currency change of a hotel with rooms, got %d", resp.StatusCode)
+	}
+	if resp := send("PUT", fmt.Sprintf("/hotel/%s", hotel.ID.Hex()), types.UpdateHotelParams{Currency: "EUR", Rating: new(int)}, admin, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the same currency to be accepted, got %d", resp.StatusCode)
+	}
+	booking := fixtures.AddBooking(tdb.store.Booking, user.ID, room.ID, time.Now().AddDate(0, 0, 2), time.Now().AddDate(0, 0, 4), 1)
+	if resp := send("DELETE", fmt.Sprintf("/hotel/%s", hotel.ID.Hex()), nil, admin, nil); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected status code 409 while a room is booked, got %d", resp.StatusCode)
+	}
+	tdb.store.Booking.CancelBooking(context.TODO(), booking.ID.Hex(), types.Cancellation{}, nil)
+	roomType := &types.RoomType{ID: primitive.NewObjectID(), HotelID: hotel.ID, Name: "Double", Size: "Double", Price: 150}
+	if _, err := tdb.store.RoomType.InsertRoomType(context.TODO(), roomType); err != nil {
+		t.Fatal(err)
+	}
+	unassigned := &types.Booking{ID: primitive.NewObjectID(), RoomTypeID: roomType.ID, UserID: user.ID, FromDate: time.Now().AddDate(0, 0, 2), TillDate: time.Now().AddDate(0, 0, 4), NumPersons: 1, Status: types.StatusConfirmed}
+	if _, err := tdb.store.Booking.Insert(context.TODO(), unassigned); err != nil {
+		t.Fatal(err)
+	}
+	if resp := send("DELETE", fmt.Sprintf("/hotel/%s", hotel.ID.Hex()), nil, admin, nil); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected status code 409 while a room type is booked, got %d", resp.StatusCode)
+	}
+	tdb.store.Booking.CancelBooking(context.TODO(), unassigned.ID.Hex(), types.Cancellation{}, nil)
+	if resp := send("DELETE", fmt.Sprintf("/hotel/%s", hotel.ID.Hex()), nil, admin, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code 200, got %d", resp.StatusCode)
+	}
+	if _, err := tdb.store.Room.GetRoomByID(context.TODO(), room.ID.Hex()); err == nil {
+		t.Fatal("expected the hotel's rooms to be deleted")
+	}
+	if _, err := tdb.store.RoomType.GetRoomTypeByID(context.TODO(), roomType.ID.Hex()); err == nil {
+		t.Fatal("expected the hotel's room types to be deleted")
+	}
+	if resp := send("PUT", fmt.Sprintf("/hotel/%s", hotel.ID.Hex()), types.UpdateHotelParams{Location: "Boston"}, admin, nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected status code 404, got %d", resp.StatusCode)
+	}
+}
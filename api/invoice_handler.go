@@ -0,0 +1,86 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kmogilevskii/hotel-reservation/db"
+	"github.com/kmogilevskii/hotel-reservation/errors"
+	"github.com/kmogilevskii/hotel-reservation/invoices"
+	"github.com/kmogilevskii/hotel-reservation/types"
+)
+
+const mimeApplicationPDF = "application/pdf"
+
+// invoicedStatuses are the statuses of bookings that were sold, so they get
+// an invoice even when canceled.
+var invoicedStatuses = []types.BookingStatus{
+	types.StatusConfirmed,
+	types.StatusCheckedIn,
+	types.StatusCheckedOut,
+	types.StatusNoShow,
+	types.StatusCanceled,
+}
+
+type InvoiceHandler struct {
+	store *db.Store
+}
+
+func NewInvoiceHandler(store *db.Store) *InvoiceHandler {
+	return &InvoiceHandler{store: store}
+}
+
+// HandleGetInvoice returns the booking's invoice, numbered the first time it
+// is asked for. It is a PDF with ?format=pdf or when the client accepts
+// application/pdf, JSON otherwise.
+func (h *InvoiceHandler) HandleGetInvoice(c *fiber.Ctx) error {
+	id := c.Params("id")
+	booking, err := h.store.Booking.GetBookingByID(c.Context(), id)
+	if err != nil {
+		return err
+	}
+	user, ok := c.Context().Value("user").(*types.User)
+	if !ok {
+		return fmt.Errorf("authorization problems")
+	}
+	if booking.UserID != user.ID && !user.IsAdmin {
+		return c.Status(http.StatusUnauthorized).JSON(genericResp{
+			Type: "error",
+			Msg:  "unauthorized",
+		})
+	}
+	if booking.Price == nil || !slices.Contains(invoicedStatuses, booking.Status) {
+		return errors.NewError(http.StatusConflict, "booking cannot be invoiced")
+	}
+	terms, err := getSaleTerms(c.Context(), h.store, booking)
+	if err != nil {
+		return err
+	}
+	guest, err := h.store.User.GetUserByID(c.Context(), booking.UserID.Hex())
+	if err != nil {
+		return err
+	}
+	issued, err := h.store.Invoice.IssueInvoice(c.Context(), &types.IssuedInvoice{
+		BookingID: booking.ID,
+		HotelID:   terms.hotel.ID,
+		IssuedAt:  time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	invoice := invoices.New(issued, booking, terms.hotel, guest)
+	if c.Query("format") != "pdf" && c.Accepts(fiber.MIMEApplicationJSON, mimeApplicationPDF) != mimeApplicationPDF {
+		return c.JSON(invoice)
+	}
+	var buf bytes.Buffer
+	if err := invoices.WritePDF(&buf, invoice); err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderContentType, mimeApplicationPDF)
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf("inline; filename=%q", invoice.Number+".pdf"))
+	return c.Send(buf.Bytes())
+}
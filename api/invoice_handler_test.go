@@ -0,0 +1,101 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kmogilevskii/hotel-reservation/db/fixtures"
+	"github.com/kmogilevskii/hotel-reservation/errors"
+	"github.com/kmogilevskii/hotel-reservation/types"
+)
+
+func TestGetInvoice(t *testing.T) {
+	tdb := setup(t)
+	var (
+		user           = fixtures.AddUser(tdb.store.User, "foo", "bar", false)
+		other          = fixtures.AddUser(tdb.store.User, "baz", "qux", false)
+		hilton         = fixtures.AddHotel(tdb.store.Hotel, "Hilton", "New York", 5)
+		plaza          = fixtures.AddHotel(tdb.store.Hotel, "Plaza", "New York", 4)
+		hiltonRoom     = fixtures.AddRoom(tdb.store.Room, hilton.ID, "Single", 100)
+		plazaRoom      = fixtures.AddRoom(tdb.store.Room, plaza.ID, "Single", 120)
+		unpriced       = fixtures.AddBooking(tdb.store.Booking, user.ID, hiltonRoom.ID, time.Now().AddDate(0, 0, 20), time.Now().AddDate(0, 0, 22), 1)
+		day            = time.Now().AddDate(0, 0, 5)
+		app            = fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
+		roomHandler    = NewRoomHandler(tdb.store, tdb.payments)
+		invoiceHandler = NewInvoiceHandler(tdb.store)
+	)
+	app.Post("/room/:id/book", JWTAuthentication(tdb.store.User), roomHandler.HandleBookRoom)
+	app.Get("/booking/:id/invoice", JWTAuthentication(tdb.store.User), invoiceHandler.HandleGetInvoice)
+
+	send := newSender(t, app)
+	book := func(room *types.Room, from int) *types.Booking {
+		params := types.BookParams{FromDate: types.Date{Time: day.AddDate(0, 0, from)}, TillDate: types.Date{Time: day.AddDate(0, 0, from+2)}, NumPersons: 1}
+		var booking types.Booking
+		if resp := send("POST", fmt.Sprintf("/room/%s/book", room.ID.Hex()), params, user, &booking); resp.StatusCode != http.StatusCreated {
+			t.Fatalf("expected the room to be booked, got %d", resp.StatusCode)
+		}
+		return &booking
+	}
+	get := func(booking *types.Booking, as *types.User, accept string) *http.Response {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/booking/%s/invoice", booking.ID.Hex()), nil)
+		req.Header.Set("X-Api-Token", CreateTokenFromUser(as))
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	invoice := func(booking *types.Booking) types.Invoice {
+		resp := get(booking, user, "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status code 200, got %d", resp.StatusCode)
+		}
+		var invoice types.Invoice
+		json.NewDecoder(resp.Body).Decode(&invoice)
+		return invoice
+	}
+
+	first, second, elsewhere := book(hiltonRoom, 0), book(hiltonRoom, 2), book(plazaRoom, 0)
+	inv := invoice(first)
+	if inv.Number != "INV-000001" || inv.Hotel.Name != "Hilton" || inv.Guest.Name != "foo bar" {
+		t.Fatalf("unexpected invoice %+v", inv)
+	}
+	if len(inv.Charges) != 2 || inv.Total.Amount != 20000 || inv.Paid.Amount != 20000 || inv.Due.Amount != 0 {
+		t.Fatalf("expected two nights paid in full, got %+v", inv)
+	}
+	if len(inv.Payments) != 1 || inv.Payments[0].Kind != types.LinePayment {
+		t.Fatalf("expected the payment to be listed, got %+v", inv.Payments)
+	}
+	// numbers run per hotel and stick to their booking
+	if n := invoice(elsewhere).Number; n != "INV-000001" {
+		t.Fatalf("expected the first invoice of the other hotel, got %s", n)
+	}
+	if n := invoice(second).Number; n != "INV-000002" {
+		t.Fatalf("expected the second invoice of the hotel, got %s", n)
+	}
+	if n := invoice(first).Number; n != "INV-000001" {
+		t.Fatalf("expected the booking to keep its number, got %s", n)
+	}
+
+	resp := get(first, user, "application/pdf")
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/pdf" || !bytes.HasPrefix(body, []byte("%PDF-")) {
+		t.Fatalf("expected a PDF, got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if resp := get(first, other, ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected status code 401 for another guest, got %d", resp.StatusCode)
+	}
+	if resp := get(unpriced, user, ""); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected status code 409 for an unpriced booking, got %d", resp.StatusCode)
+	}
+}
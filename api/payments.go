@@ -0,0 +1,212 @@
+package api
+
+import (
+	"context"
+	stderrors "errors"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/kmogilevskii/hotel-reservation/db"
+	"github.com/kmogilevskii/hotel-reservation/errors"
+	"github.com/kmogilevskii/hotel-reservation/notify"
+	"github.com/kmogilevskii/hotel-reservation/payments"
+	"github.com/kmogilevskii/hotel-reservation/pricing"
+	"github.com/kmogilevskii/hotel-reservation/types"
+)
+
+// authorizeBooking reserves what is due when the booking is made with the
+// provider: the deposit when its rates are paid on a schedule, otherwise the
+// whole total. Bookings made before stays were priced have nothing to
+// charge.
+func authorizeBooking(ctx context.Context, provider payments.Provider, booking *types.Booking) error {
+	if booking.Price == nil {
+		return nil
+	}
+	due := booking.Price.Total
+	booking.Balance = pricing.Schedule(booking.PaymentSchedule, due, booking.FromDate, time.Now())
+	if booking.Balance != nil {
+		due = booking.Balance.Deposit
+	}
+	if due.Amount == 0 {
+		return nil
+	}
+	payment, err := payments.Authorize(ctx, provider, due, booking.ID.Hex())
+	if err != nil {
+		return paymentError(err)
+	}
+	booking.Payment = payment
+	return nil
+}
+
+func paymentError(err error) error {
+	if stderrors.Is(err, payments.ErrDeclined) {
+		return errors.ErrPaymentDeclined()
+	}
+	return err
+}
+
+// captureBooking collects the booking's authorized payment and stores it
+// along with the balance left to pay.
+func captureBooking(ctx context.Context, store db.BookingStore, provider payments.Provider, booking *types.Booking) error {
+	if booking.Payment == nil && booking.Balance == nil {
+		return nil
+	}
+	if booking.Payment != nil {
+		if err := payments.Capture(ctx, provider, booking.Payment); err != nil {
+			return err
+		}
+	}
+	return store.UpdatePayment(ctx, booking)
+}
+
+// voidBookings releases the authorizations of bookings that didn't go
+// through and returns err.
+func voidBookings(ctx context.Context, provider payments.Provider, bookings []*types.Booking, err error) error {
+	for _, booking := range bookings {
+		if booking.Payment == nil {
+			continue
+		}
+		if voidErr := payments.Void(ctx, provider, booking.Payment); voidErr != nil {
+			return voidErr
+		}
+	}
+	return err
+}
+
+// bookAndCharge stores the booking and charges its total. The payment is
+// authorized first, so nothing gets booked when it is declined, and only
+// captured once the room is booked.
+func bookAndCharge(ctx context.Context, store *db.Store, provider payments.Provider, booking *types.Booking) (*types.Booking, error) {
+	if err := authorizeBooking(ctx, provider, booking); err != nil {
+		return nil, err
+	}
+	inserted, err := bookRoom(ctx, store, booking)
+	if err != nil {
+		return nil, voidBookings(ctx, provider, []*types.Booking{booking}, err)
+	}
+	if err := captureBooking(ctx, store.Booking, provider, inserted); err != nil {
+		return nil, err
+	}
+	return inserted, nil
+}
+
+// oweRefund records on the booking's payment that the amount is to be paid
+// back, so it gets stored along with the change that made it owed. Nothing
+// is owed on payments that were only authorized, they are voided instead.
+func oweRefund(booking *types.Booking, amount types.Money, now time.Time) {
+	payment := booking.Payment
+	if payment == nil || payment.Status == types.PaymentAuthorized || amount.Amount <= 0 {
+		return
+	}
+	payment.PendingRefund = &types.PendingRefund{Amount: amount, Since: now}
+}
+
+// refundBooking pays back the refund owed on the booking through the
+// provider its payment went through, or voids the payment of a canceled
+// booking when nothing was collected yet. Bookings made before payments
+// were taken have nothing to refund.
+func refundBooking(ctx context.Context, store db.BookingStore, provider payments.Provider, booking *types.Booking) error {
+	payment := booking.Payment
+	if payment == nil {
+		return nil
+	}
+	switch {
+	case payment.Status == types.PaymentAuthorized:
+		// nothing was collected yet, so there is nothing to refund
+		if err := payments.Void(ctx, provider, payment); err != nil {
+			return err
+		}
+	case payment.PendingRefund != nil:
+		refunded := payment.Refunded.Amount
+		err := payments.Refund(ctx, provider, payment, payment.PendingRefund.Amount)
+		if err != nil {
+			// a refund split over several captures may have gone through in
+			// part, only the rest is still owed
+			payment.PendingRefund.Amount.Amount -= payment.Refunded.Amount - refunded
+			if updateErr := store.UpdatePayment(ctx, booking); updateErr != nil {
+				return updateErr
+			}
+			return err
+		}
+		payment.PendingRefund = nil
+	default:
+		return nil
+	}
+	return store.UpdatePayment(ctx, booking)
+}
+
+// RetryRefunds pays back the refunds owed since before the given time that
+// failed when they were owed. Refunds failing again are logged and left for
+// the next run. It returns how many were paid back.
+func RetryRefunds(ctx context.Context, store db.BookingStore, provider payments.Provider, before time.Time) (int, error) {
+	bookings, err := store.GetBookings(ctx, db.PendingRefundsFilter(before), &db.Pagination{Page: 1})
+	if err != nil {
+		return 0, err
+	}
+	refunded := 0
+	for _, booking := range bookings {
+		if err := refundBooking(ctx, store, provider, booking); err != nil {
+			log.Printf("refunding booking %s: %v", booking.ID.Hex(), err)
+			continue
+		}
+		refunded++
+	}
+	return refunded, nil
+}
+
+// payBalance charges what is left to pay on an active booking. Should the
+// booking change in the meantime, the charge is refunded.
+func payBalance(ctx context.Context, store db.BookingStore, provider payments.Provider, booking *types.Booking) error {
+	balance := booking.Balance
+	if balance == nil || balance.Status == types.BalancePaid || !slices.Contains(types.ActiveStatuses, booking.Status) {
+		return errors.ErrNothingDue()
+	}
+	payment, err := payments.Charge(ctx, provider, booking.Payment, balance.Amount, booking.ID.Hex())
+	if err != nil {
+		return paymentError(err)
+	}
+	now := time.Now()
+	if err := store.PayBalance(ctx, booking.ID.Hex(), payment, now); err != nil {
+		if refundErr := payments.Refund(ctx, provider, payment, balance.Amount); refundErr != nil {
+			return refundErr
+		}
+		return err
+	}
+	booking.Payment = payment
+	balance.Status = types.BalancePaid
+	balance.PaidAt = &now
+	return nil
+}
+
+// SettleOverdueBalances marks the balances that weren't paid by their due
+// date overdue, then cancels the bookings whose schedule cancels them once
+// the grace period is over. The cancellation policy applies as if the guest
+// canceled, with the penalty taken out of the deposit, and the freed dates
+// go to the waitlist. It returns how many
+// balances became overdue and how many bookings were canceled.
+func SettleOverdueBalances(ctx context.Context, store *db.Store, provider payments.Provider, notifier notify.Notifier, now time.Time) (int64, int, error) {
+	overdue, err := store.Booking.MarkBalancesOverdue(ctx, now)
+	if err != nil {
+		return 0, 0, err
+	}
+	bookings, err := store.Booking.GetBookings(ctx, db.UnpaidBalancesToCancelFilter(now), &db.Pagination{Page: 1})
+	if err != nil {
+		return overdue, 0, err
+	}
+	// the system cancels them, so no user is recorded
+	system := &types.User{}
+	canceled := 0
+	for _, booking := range bookings {
+		_, err := cancelBooking(ctx, store, provider, notifier, booking, system, now)
+		switch {
+		case err == nil:
+			canceled++
+		case stderrors.Is(err, errors.ErrStayStarted()), stderrors.Is(err, errors.ErrStatusChanged()):
+			// arrived or canceled by someone else, the front desk takes over
+		default:
+			return overdue, canceled, err
+		}
+	}
+	return overdue, canceled, nil
+}
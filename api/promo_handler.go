@@ -0,0 +1,159 @@
+package api
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kmogilevskii/hotel-reservation/db"
+	"github.com/kmogilevskii/hotel-reservation/errors"
+	"github.com/kmogilevskii/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type PromoCodeHandler struct {
+	store *db.Store
+}
+
+func NewPromoCodeHandler(store *db.Store) *PromoCodeHandler {
+	return &PromoCodeHandler{store: store}
+}
+
+func (h *PromoCodeHandler) HandlePostPromoCode(c *fiber.Ctx) error {
+	var params types.CreatePromoCodeParams
+	if err := c.BodyParser(&params); err != nil {
+		return errors.ErrBadRequest()
+	}
+	if err := params.Validate(c.Context()); err != nil {
+		return errors.NewError(http.StatusBadRequest, err.Error())
+	}
+	for _, id := range params.HotelIDs {
+		if _, err := getHotel(c.Context(), h.store.Hotel, id); err != nil {
+			return err
+		}
+	}
+	promo, err := h.store.PromoCode.InsertPromoCode(c.Context(), types.NewPromoCodeFromParams(params))
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return errors.NewError(http.StatusConflict, "promo code already exists")
+		}
+		return err
+	}
+	return c.Status(http.StatusCreated).JSON(promo)
+}
+
+func (h *PromoCodeHandler) HandleGetPromoCodes(c *fiber.Ctx) error {
+	var params db.Pagination
+	if err := c.QueryParser(&params); err != nil {
+		return errors.ErrBadRequest()
+	}
+	promos, err := h.store.PromoCode.GetPromoCodes(c.Context(), db.Map{}, &params)
+	if err != nil {
+		return err
+	}
+	resp := db.ResourceResponse{
+		Results: len(promos),
+		Data:    promos,
+		Page:    params.Page,
+	}
+	return c.JSON(resp)
+}
+
+// applyPromoCode checks the user can use the code for a booking in the hotel
+// and sets its discount on the booking, to be taken off when the booking
+// gets priced. The code is only redeemed once the booking gets stored by
+// bookRoom.
+func applyPromoCode(ctx context.Context, store *db.Store, booking *types.Booking, hotel *types.Hotel, code string, user *types.User) error {
+	promo, err := store.PromoCode.GetPromoCode(ctx, code)
+	if err != nil {
+		if stderrors.Is(err, mongo.ErrNoDocuments) {
+			return errors.NewError(http.StatusBadRequest, fmt.Sprintf("unknown promo code %s", types.NormalizePromoCode(code)))
+		}
+		return err
+	}
+	if err := promo.Check(hotel.ID, time.Now()); err != nil {
+		return errors.NewError(http.StatusBadRequest, err.Error())
+	}
+	if promo.FirstBookingOnly {
+		filter := db.Map{
+			"userID": user.ID.Hex(),
+			"status": db.Map{"$nin": []types.BookingStatus{types.StatusCanceled, types.StatusExpired}},
+		}
+		previous, err := store.Booking.GetBookings(ctx, filter, &db.Pagination{Page: 1, Limit: 1})
+		if err != nil {
+			return err
+		}
+		if len(previous) > 0 {
+			return errors.NewError(http.StatusBadRequest, fmt.Sprintf("promo code %s is only valid on a first booking", promo.Code))
+		}
+	}
+	booking.Discount = &types.Discount{Code: promo.Code, Rule: promo.DiscountRule, FirstBookingOnly: promo.FirstBookingOnly}
+	return nil
+}
+
+// bookRoom stores the booking, redeeming its promo code first. A first
+// booking code is claimed for the guest before that, so two bookings made at
+// once can't both get it. The use and the claim are given back when the room
+// can't be booked after all.
+func bookRoom(ctx context.Context, store *db.Store, booking *types.Booking) (*types.Booking, error) {
+	if booking.Discount == nil {
+		return store.Booking.BookRoom(ctx, booking, time.Now())
+	}
+	if err := claimFirstBooking(ctx, store, booking); err != nil {
+		return nil, err
+	}
+	if err := store.PromoCode.RedeemPromoCode(ctx, booking.Discount.Code); err != nil {
+		if releaseErr := releaseFirstBooking(ctx, store, booking); releaseErr != nil {
+			return nil, releaseErr
+		}
+		return nil, err
+	}
+	inserted, err := store.Booking.BookRoom(ctx, booking, time.Now())
+	if err != nil {
+		if releaseErr := releasePromoCode(ctx, store, booking); releaseErr != nil {
+			return nil, releaseErr
+		}
+		return nil, err
+	}
+	return inserted, nil
+}
+
+// releasePromoCode gives back the use of the promo code the booking
+// redeemed, once the booking doesn't go ahead after all.
+func releasePromoCode(ctx context.Context, store *db.Store, booking *types.Booking) error {
+	if booking.Discount == nil {
+		return nil
+	}
+	if err := store.PromoCode.ReleasePromoCode(ctx, booking.Discount.Code); err != nil {
+		return err
+	}
+	return releaseFirstBooking(ctx, store, booking)
+}
+
+func claimFirstBooking(ctx context.Context, store *db.Store, booking *types.Booking) error {
+	if !booking.Discount.FirstBookingOnly {
+		return nil
+	}
+	claim := &types.FirstBooking{
+		UserID:    booking.UserID,
+		Code:      booking.Discount.Code,
+		ClaimedAt: time.Now(),
+	}
+	if err := store.PromoCode.ClaimFirstBooking(ctx, claim); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return errors.NewError(http.StatusBadRequest, fmt.Sprintf("promo code %s is only valid on a first booking", booking.Discount.Code))
+		}
+		return err
+	}
+	return nil
+}
+
+func releaseFirstBooking(ctx context.Context, store *db.Store, booking *types.Booking) error {
+	if !booking.Discount.FirstBookingOnly {
+		return nil
+	}
+	return store.PromoCode.ReleaseFirstBooking(ctx, booking.UserID.Hex(), booking.Discount.Code)
+}
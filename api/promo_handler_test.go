@@ -0,0 +1,191 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kmogilevskii/hotel-reservation/db/fixtures"
+	"github.com/kmogilevskii/hotel-reservation/errors"
+	"github.com/kmogilevskii/hotel-reservation/types"
+)
+
+func TestPromoCodes(t *testing.T) {
+	tdb := setup(t)
+	var (
+		admin        = fixtures.AddUser(tdb.store.User, "admin", "admin", true)
+		user         = fixtures.AddUser(tdb.store.User, "foo", "bar", false)
+		newcomer     = fixtures.AddUser(tdb.store.User, "baz", "qux", false)
+		hotel        = fixtures.AddHotel(tdb.store.Hotel, "Hilton", "New York", 5)
+		other        = fixtures.AddHotel(tdb.store.Hotel, "Plaza", "New York", 4)
+		room         = fixtures.AddRoom(tdb.store.Room, hotel.ID, "Single", 100)
+		day          = time.Now().AddDate(0, 0, 1)
+		app          = fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
+		promoHandler = NewPromoCodeHandler(tdb.store)
+		roomHandler  = NewRoomHandler(tdb.store, tdb.payments)
+		bookHandler  = NewBookingHandler(tdb.store, tdb.payments, tdb.notifier)
+		nights       = 0
+	)
+	app.Post("/admin/promo", JWTAuthentication(tdb.store.User), AdminAuth, promoHandler.HandlePostPromoCode)
+	app.Post("/room/:id/book", JWTAuthentication(tdb.store.User), roomHandler.HandleBookRoom)
+	app.Patch("/booking/:id", JWTAuthentication(tdb.store.User), bookHandler.HandleModifyBooking)
+
+	send := newSender(t, app)
+	// every booking takes the next two nights, so they never overlap
+	book := func(code string, as *types.User, out any) *http.Response {
+		params := types.BookParams{
+			FromDate:   types.Date{Time: day.AddDate(0, 0, nights)},
+			TillDate:   types.Date{Time: day.AddDate(0, 0, nights+2)},
+			NumPersons: 1,
+			PromoCode:  code,
+		}
+		nights += 2
+		return send("POST", fmt.Sprintf("/room/%s/book", room.ID.Hex()), params, as, out)
+	}
+
+	promos := []types.CreatePromoCodeParams{
+		{Code: "AUTUMN15", Percent: 15},
+		{Code: "PLAZA50", Fixed: &types.Money{Amount: 5000, Currency: "USD"}, HotelIDs: []string{other.ID.Hex()}},
+		{Code: "WELCOME10", Percent: 10, FirstBookingOnly: true},
+		{Code: "SUMMER20", Percent: 20, ValidTill: time.Now().Add(-time.Hour)},
+		{Code: "ONCE", Fixed: &types.Money{Amount: 2000, Currency: "USD"}, MaxUses: 1},
+	}
+	for _, p := range promos {
+		if resp := send("POST", "/admin/promo", p, admin, nil); resp.StatusCode != http.StatusCreated {
+			t.Fatalf("expected status code 201 for %s, got %d", p.Code, resp.StatusCode)
+		}
+	}
+	if resp := send("POST", "/admin/promo", promos[0], admin, nil); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected status code 409 for an existing code, got %d", resp.StatusCode)
+	}
+	if resp := send("POST", "/admin/promo", types.CreatePromoCodeParams{Code: "BOTH", Percent: 10, Fixed: &types.Money{Amount: 1, Currency: "USD"}}, admin, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status code 400 for a percentage and a fixed amount, got %d", resp.StatusCode)
+	}
+
+	var booking types.Booking
+	if resp := book("autumn15", user, &booking); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status code 201, got %d", resp.StatusCode)
+	}
+	if booking.Discount == nil || booking.Discount.Amount.Amount != 3000 || booking.Price.Total.Amount != 17000 {
+		t.Fatalf("expected 30.00 off 200.00, got %+v %+v", booking.Discount, booking.Price.Total)
+	}
+	// a longer stay keeps the percentage off, it is moved out of the way of
+	// the bookings below
+	longer := types.ModifyBookingParams{FromDate: types.Date{Time: day.AddDate(0, 0, 30)}, TillDate: types.Date{Time: day.AddDate(0, 0, 33)}}
+	if resp := send("PATCH", fmt.Sprintf("/booking/%s", booking.ID.Hex()), longer, user, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the booking to be modified, got %d", resp.StatusCode)
+	}
+	stored, _ := tdb.store.Booking.GetBookingByID(context.TODO(), booking.ID.Hex())
+	if stored.Discount == nil || stored.Discount.Amount.Amount != 4500 || stored.Price.Total.Amount != 25500 {
+		t.Fatalf("expected 45.00 off 300.00 to be stored, got %+v %+v", stored.Discount, stored.Price.Total)
+	}
+
+	tests := []struct {
+		code   string
+		as     *types.User
+		status int
+		msg    string
+	}{
+		{"NOPE", user, http.StatusBadRequest, "unknown promo code NOPE"},
+		{"PLAZA50", user, http.StatusBadRequest, "promo code PLAZA50 is not valid for this hotel"},
+		{"SUMMER20", user, http.StatusBadRequest, "promo code SUMMER20 has expired"},
+		{"WELCOME10", user, http.StatusBadRequest, "promo code WELCOME10 is only valid on a first booking"},
+		{"WELCOME10", newcomer, http.StatusCreated, ""},
+		{"ONCE", user, http.StatusCreated, ""},
+		{"ONCE", newcomer, http.StatusConflict, "promo code has been used up"},
+	}
+	for _, tt := range tests {
+		var errResp errors.Error
+		resp := book(tt.code, tt.as, &errResp)
+		if resp.StatusCode != tt.status || (tt.msg != "" && errResp.Err != tt.msg) {
+			t.Fatalf("%s: expected %d %q, got %d %q", tt.code, tt.status, tt.msg, resp.StatusCode, errResp.Err)
+		}
+	}
+
+	// a first booking of the guest still in flight has claimed the code
+	// before it shows up among their bookings
+	fresh := fixtures.AddUser(tdb.store.User, "fresh", "guest", false)
+	claim := &types.FirstBooking{UserID: fresh.ID, Code: "WELCOME10", ClaimedAt: time.Now()}
+	if err := tdb.store.PromoCode.ClaimFirstBooking(context.TODO(), claim); err != nil {
+		t.Fatal(err)
+	}
+	var errResp errors.Error
+	if resp := book("WELCOME10", fresh, &errResp); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected the claimed code to be refused, got %d %q", resp.StatusCode, errResp.Err)
+	}
+	if err := tdb.store.PromoCode.ReleaseFirstBooking(context.TODO(), fresh.ID.Hex(), "WELCOME10"); err != nil {
+		t.Fatal(err)
+	}
+	if resp := book("WELCOME10", fresh, nil); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected a released claim to be usable, got %d", resp.StatusCode)
+	}
+	if promo, _ := tdb.store.PromoCode.GetPromoCode(context.TODO(), "WELCOME10"); promo.Uses != 2 {
+		t.Fatalf("expected the refused booking not to use the code, got %d uses", promo.Uses)
+	}
+}
+
+func TestPromoCodeReleased(t *testing.T) {
+	tdb := setup(t)
+	var (
+		admin          = fixtures.AddUser(tdb.store.User, "admin", "admin", true)
+		user           = fixtures.AddUser(tdb.store.User, "foo", "bar", false)
+		hotel          = fixtures.AddHotel(tdb.store.Hotel, "Hilton", "New York", 5)
+		room           = fixtures.AddRoom(tdb.store.Room, hotel.ID, "Single", 100)
+		day            = time.Now().AddDate(0, 0, 1)
+		app            = fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
+		promoHandler   = NewPromoCodeHandler(tdb.store)
+		roomHandler    = NewRoomHandler(tdb.store, tdb.payments)
+		bookingHandler = NewBookingHandler(tdb.store, tdb.payments, tdb.notifier)
+		nights         = 0
+	)
+	app.Post("/admin/promo", JWTAuthentication(tdb.store.User), AdminAuth, promoHandler.HandlePostPromoCode)
+	app.Post("/room/:id/:action", JWTAuthentication(tdb.store.User), func(c *fiber.Ctx) error {
+		if c.Params("action") == "hold" {
+			return roomHandler.HandleHoldRoom(c)
+		}
+		return roomHandler.HandleBookRoom(c)
+	})
+	app.Delete("/booking/:id", JWTAuthentication(tdb.store.User), bookingHandler.HandleCancelBooking)
+
+	send := newSender(t, app)
+	book := func(action string, out any) *http.Response {
+		params := types.BookParams{
+			FromDate:   types.Date{Time: day.AddDate(0, 0, nights)},
+			TillDate:   types.Date{Time: day.AddDate(0, 0, nights+2)},
+			NumPersons: 1,
+			PromoCode:  "ONCE",
+		}
+		nights += 2
+		return send("POST", fmt.Sprintf("/room/%s/%s", room.ID.Hex(), action), params, user, out)
+	}
+
+	once := types.CreatePromoCodeParams{Code: "ONCE", Fixed: &types.Money{Amount: 2000, Currency: "USD"}, MaxUses: 1}
+	if resp := send("POST", "/admin/promo", once, admin, nil); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status code 201, got %d", resp.StatusCode)
+	}
+
+	if resp := book("hold", nil); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected the hold to be created, got %d", resp.StatusCode)
+	}
+	if resp := book("book", nil); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected the code to be used up by the hold, got %d", resp.StatusCode)
+	}
+	n, err := ExpireHolds(context.TODO(), tdb.store, tdb.notifier, time.Now().Add(holdTTL+time.Minute))
+	if err != nil || n != 1 {
+		t.Fatalf("expected one hold to expire, got %d %v", n, err)
+	}
+
+	var booking types.Booking
+	if resp := book("book", &booking); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected the expired hold to give the use back, got %d", resp.StatusCode)
+	}
+	if resp := send("DELETE", fmt.Sprintf("/booking/%s", booking.ID.Hex()), nil, user, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the booking to be canceled, got %d", resp.StatusCode)
+	}
+	if resp := book("book", nil); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected the canceled booking to give the use back, got %d", resp.StatusCode)
+	}
+}
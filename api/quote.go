@@ -0,0 +1,134 @@
+package api
+
+import (
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/kmogilevskii/hotel-reservation/errors"
+	"github.com/kmogilevskii/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// quoteTTL is how long a quoted price is guaranteed.
+const quoteTTL = 15 * time.Minute
+
+// Quote is what a stay in a room would cost if booked now. Booking the same
+// stay with Token before ExpiresAt charges the quoted price.
+type Quote struct {
+	Token              string                    `json:"token"`
+	ExpiresAt          time.Time                 `json:"expiresAt"`
+	RoomID             primitive.ObjectID        `json:"roomID"`
+	FromDate           time.Time                 `json:"fromDate"`
+	TillDate           time.Time                 `json:"tillDate"`
+	NumPersons         int                       `json:"numPersons"`
+	NumChildren        int                       `json:"numChildren,omitempty"`
+	Price              *types.PriceBreakdown     `json:"price"`
+	Discount           *types.Discount           `json:"discount,omitempty"`
+	ExchangeRate       *types.ExchangeRate       `json:"exchangeRate,omitempty"`
+	CancellationPolicy *types.CancellationPolicy `json:"cancellationPolicy,omitempty"`
+	PaymentSchedule    *types.PaymentSchedule    `json:"paymentSchedule,omitempty"`
+}
+
+// quoteClaims carry the stay a quote is for and its price. The subject is
+// the guest the quote was made for.
+type quoteClaims struct {
+	jwt.StandardClaims
+	RoomID       string                `json:"roomID"`
+	FromDate     time.Time             `json:"fromDate"`
+	TillDate     time.Time             `json:"tillDate"`
+	NumPersons   int                   `json:"numPersons"`
+	NumChildren  int                   `json:"numChildren,omitempty"`
+	PromoCode    string                `json:"promoCode,omitempty"`
+	Price        *types.PriceBreakdown `json:"price"`
+	Discount     *types.Discount       `json:"discount,omitempty"`
+	ExchangeRate *types.ExchangeRate   `json:"exchangeRate,omitempty"`
+}
+
+// quoteSecret signs quotes. It differs from the secret of API tokens, so a
+// quote can't pass for one.
+func quoteSecret() []byte {
+	return []byte("quote:" + os.Getenv("JWT_SECRET"))
+}
+
+// newQuote quotes the priced booking, which isn't stored, for the user.
+func newQuote(booking *types.Booking, promoCode string, now time.Time) (*Quote, error) {
+	expiresAt := now.Add(quoteTTL)
+	claims := quoteClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject:   booking.UserID.Hex(),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: expiresAt.Unix(),
+		},
+		RoomID:       booking.RoomID.Hex(),
+		FromDate:     booking.FromDate,
+		TillDate:     booking.TillDate,
+		NumPersons:   booking.NumPersons,
+		NumChildren:  booking.NumChildren,
+		PromoCode:    types.NormalizePromoCode(promoCode),
+		Price:        booking.Price,
+		Discount:     booking.Discount,
+		ExchangeRate: booking.ExchangeRate,
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(quoteSecret())
+	if err != nil {
+		return nil, err
+	}
+	return &Quote{
+		Token:              token,
+		ExpiresAt:          time.Unix(claims.ExpiresAt, 0).UTC(),
+		RoomID:             booking.RoomID,
+		FromDate:           booking.FromDate,
+		TillDate:           booking.TillDate,
+		NumPersons:         booking.NumPersons,
+		NumChildren:        booking.NumChildren,
+		Price:              booking.Price,
+		Discount:           booking.Discount,
+		ExchangeRate:       booking.ExchangeRate,
+		CancellationPolicy: booking.CancellationPolicy,
+		PaymentSchedule:    booking.PaymentSchedule,
+	}, nil
+}
+
+func parseQuote(token string) (*quoteClaims, error) {
+	var claims quoteClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return quoteSecret(), nil
+	})
+	if err != nil {
+		if verr, ok := err.(*jwt.ValidationError); ok && verr.Errors&jwt.ValidationErrorExpired != 0 {
+			return nil, errors.NewError(http.StatusBadRequest, "quote has expired")
+		}
+		return nil, errors.NewError(http.StatusBadRequest, "invalid quote")
+	}
+	return &claims, nil
+}
+
+// applyQuote charges the booking the price quoted with the token, provided
+// the quote was made for the same guest, room and stay. The booking must be
+// priced already, so its dates are the hotel's check-in and check-out times
+// like the quote's.
+func applyQuote(booking *types.Booking, token, promoCode string) error {
+	claims, err := parseQuote(token)
+	if err != nil {
+		return err
+	}
+	if claims.Subject != booking.UserID.Hex() ||
+		claims.RoomID != booking.RoomID.Hex() ||
+		!claims.FromDate.Equal(booking.FromDate) ||
+		!claims.TillDate.Equal(booking.TillDate) ||
+		claims.NumPersons != booking.NumPersons ||
+		claims.NumChildren != booking.NumChildren ||
+		claims.PromoCode != types.NormalizePromoCode(promoCode) ||
+		claims.Price.Total.Currency != booking.Currency {
+		return errors.NewError(http.StatusBadRequest, "quote does not match the booking")
+	}
+	booking.Price = claims.Price
+	booking.Discount = claims.Discount
+	booking.ExchangeRate = claims.ExchangeRate
+	return nil
+}
@@ -0,0 +1,266 @@
+package api
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kmogilevskii/hotel-reservation/db"
+	"github.com/kmogilevskii/hotel-reservation/errors"
+	"github.com/kmogilevskii/hotel-reservation/notify"
+	"github.com/kmogilevskii/hotel-reservation/payments"
+	"github.com/kmogilevskii/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type reservationResp struct {
+	*types.Reservation
+	Rooms []*types.Booking `json:"rooms"`
+}
+
+type cancelReservationResp struct {
+	genericResp
+	Cancellations []types.Cancellation `json:"cancellations"`
+}
+
+type ReservationHandler struct {
+	store    *db.Store
+	payments payments.Provider
+	notifier notify.Notifier
+}
+
+func NewReservationHandler(store *db.Store, provider payments.Provider, notifier notify.Notifier) *ReservationHandler {
+	return &ReservationHandler{
+		store:    store,
+		payments: provider,
+		notifier: notifier,
+	}
+}
+
+// HandlePostReservation books several rooms for the same stay at once. Either
+// all rooms get booked or none of them.
+func (h *ReservationHandler) HandlePostReservation(c *fiber.Ctx) error {
+	var params types.GroupBookParams
+	if err := c.BodyParser(&params); err != nil {
+		return errors.ErrBadRequest()
+	}
+	if err := params.Validate(); err != nil {
+		return errors.NewError(http.StatusBadRequest, err.Error())
+	}
+	currency, err := requestedCurrency(c)
+	if err != nil {
+		return err
+	}
+	user, ok := c.Context().Value("user").(*types.User)
+	if !ok {
+		return fmt.Errorf("authorization problems")
+	}
+	bookings, err := h.groupBookings(c.Context(), params, user)
+	if err != nil {
+		return err
+	}
+
+	reservation := &types.Reservation{
+		ID:        primitive.NewObjectID(),
+		UserID:    user.ID,
+		FromDate:  params.FromDate.Time,
+		TillDate:  params.TillDate.Time,
+		CreatedAt: time.Now(),
+	}
+	for _, booking := range bookings {
+		booking.ReservationID = reservation.ID
+		terms, err := priceBooking(c.Context(), h.store, booking, currency)
+		if err != nil {
+			return err
+		}
+		booking.CancellationPolicy = terms.policy
+		booking.PaymentSchedule = terms.rates.PaymentSchedule
+		reservation.Bookings = append(reservation.Bookings, booking.ID)
+	}
+	reservation.FromDate, reservation.TillDate = bookings[0].FromDate, bookings[0].TillDate
+	if params.Count > 0 {
+		available, err := h.store.Booking.RoomTypeAvailability(c.Context(), bookings[0].RoomTypeID, reservation.FromDate, reservation.TillDate, time.Now())
+		if err != nil {
+			return err
+		}
+		if available < params.Count {
+			return errors.NewError(http.StatusConflict, fmt.Sprintf("only %d rooms of the type are available", available))
+		}
+	}
+	// the reservation goes in first so that its bookings never point at a
+	// reservation that isn't there, it is taken back out if they fail
+	if _, err := h.store.Reservation.Insert(c.Context(), reservation); err != nil {
+		return err
+	}
+	for i, booking := range bookings {
+		if err := authorizeBooking(c.Context(), h.payments, booking); err != nil {
+			return h.dropReservation(c.Context(), reservation, voidBookings(c.Context(), h.payments, bookings[:i], err))
+		}
+	}
+	if _, err := h.store.Booking.BookRooms(c.Context(), bookings, time.Now()); err != nil {
+		return h.dropReservation(c.Context(), reservation, voidBookings(c.Context(), h.payments, bookings, err))
+	}
+	for _, booking := range bookings {
+		if err := captureBooking(c.Context(), h.store.Booking, h.payments, booking); err != nil {
+			return err
+		}
+	}
+	return c.Status(http.StatusCreated).JSON(reservationResp{
+		Reservation: reservation,
+		Rooms:       bookings,
+	})
+}
+
+// dropReservation deletes the reservation of rooms that didn't get booked and
+// returns err.
+func (h *ReservationHandler) dropReservation(ctx context.Context, reservation *types.Reservation, err error) error {
+	if deleteErr := h.store.Reservation.DeleteReservation(ctx, reservation.ID); deleteErr != nil {
+		log.Printf("deleting reservation %s: %v", reservation.ID.Hex(), deleteErr)
+	}
+	return err
+}
+
+// groupBookings makes the bookings a group asks for: one per listed room, or
+// Count bookings of the room type the front desk assigns rooms to later on.
+// They are priced and checked against the inventory when booked.
+func (h *ReservationHandler) groupBookings(ctx context.Context, params types.GroupBookParams, user *types.User) ([]*types.Booking, error) {
+	if len(params.RoomIDs) > 0 {
+		bookings := make([]*types.Booking, len(params.RoomIDs))
+		for i, id := range params.RoomIDs {
+			room, err := getRoom(ctx, h.store.Room, id)
+			if err != nil {
+				return nil, err
+			}
+			bookings[i] = newStayBooking(params.Stay(), user, types.StatusConfirmed)
+			bookings[i].RoomID = room.ID
+			bookings[i].RoomTypeID = room.RoomTypeID
+		}
+		return bookings, nil
+	}
+
+	roomType, err := getRoomType(ctx, h.store.RoomType, params.RoomTypeID)
+	if err != nil {
+		return nil, err
+	}
+	bookings := make([]*types.Booking, params.Count)
+	for i := range bookings {
+		bookings[i] = newStayBooking(params.Stay(), user, types.StatusConfirmed)
+		bookings[i].RoomTypeID = roomType.ID
+	}
+	return bookings, nil
+}
+
+// getReservation loads the reservation and its bookings, making sure the user
+// is allowed to see them.
+func (h *ReservationHandler) getReservation(c *fiber.Ctx) (*reservationResp, *types.User, error) {
+	reservation, err := h.store.Reservation.GetReservationByID(c.Context(), c.Params("id"))
+	if err != nil {
+		if stderrors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil, errors.ErrResourceNotFound("reservation")
+		}
+		return nil, nil, errors.ErrInvalidID()
+	}
+	user, ok := c.Context().Value("user").(*types.User)
+	if !ok {
+		return nil, nil, fmt.Errorf("authorization problems")
+	}
+	if reservation.UserID != user.ID && !user.IsAdmin {
+		return nil, nil, errors.ErrUnauthorized()
+	}
+	bookings, err := h.store.Booking.GetBookings(c.Context(), db.Map{"reservationID": reservation.ID}, &db.Pagination{})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &reservationResp{Reservation: reservation, Rooms: bookings}, user, nil
+}
+
+func (h *ReservationHandler) HandleGetReservation(c *fiber.Ctx) error {
+	resp, _, err := h.getReservation(c)
+	if err != nil {
+		return err
+	}
+	return c.JSON(resp)
+}
+
+// HandleCancelReservation cancels every room of the reservation that isn't
+// canceled yet. Nothing gets canceled if the stay of one of them started.
+// Should canceling a room fail, the others still get canceled and canceling
+// the reservation again picks up the rooms left. Once all of them are
+// canceled it succeeds without canceling anything.
+func (h *ReservationHandler) HandleCancelReservation(c *fiber.Ctx) error {
+	resp, user, err := h.getReservation(c)
+	if err != nil {
+		return err
+	}
+	var (
+		now  = time.Now()
+		open []*types.Booking
+	)
+	for _, booking := range resp.Rooms {
+		if booking.Status == types.StatusCanceled {
+			continue
+		}
+		if err := checkOpen(booking, now); err != nil {
+			return err
+		}
+		open = append(open, booking)
+	}
+	var (
+		cancellations = make([]types.Cancellation, 0, len(open))
+		failed        int
+	)
+	for _, booking := range open {
+		cancellation, err := cancelBooking(c.Context(), h.store, h.payments, h.notifier, booking, user, now)
+		if err != nil {
+			// a room canceled by a concurrent request is canceled all the same
+			if !stderrors.Is(err, errors.ErrStatusChanged()) {
+				log.Printf("canceling booking %s of reservation %s: %v", booking.ID.Hex(), resp.ID.Hex(), err)
+				failed++
+			}
+			continue
+		}
+		cancellations = append(cancellations, cancellation)
+	}
+	if failed > 0 {
+		return errors.NewError(http.StatusInternalServerError, fmt.Sprintf("%d of %d rooms could not be canceled, cancel the reservation again to retry", failed, len(open)))
+	}
+	return c.JSON(cancelReservationResp{
+		genericResp: genericResp{
+			Type: "success",
+			Msg:  "reservation canceled",
+		},
+		Cancellations: cancellations,
+	})
+}
+
+// HandleCancelReservationRoom cancels a single room of the reservation. Rooms
+// booked by type are told by their booking's ID until they are assigned.
+func (h *ReservationHandler) HandleCancelReservationRoom(c *fiber.Ctx) error {
+	resp, user, err := h.getReservation(c)
+	if err != nil {
+		return err
+	}
+	roomID := c.Params("roomID")
+	for _, booking := range resp.Rooms {
+		if booking.RoomID.Hex() != roomID && booking.ID.Hex() != roomID {
+			continue
+		}
+		cancellation, err := cancelBooking(c.Context(), h.store, h.payments, h.notifier, booking, user, time.Now())
+		if err != nil {
+			return err
+		}
+		return c.JSON(cancelResp{
+			genericResp: genericResp{
+				Type: "success",
+				Msg:  "room canceled",
+			},
+			Cancellation: cancellation,
+		})
+	}
+	return errors.ErrResourceNotFound("room")
+}
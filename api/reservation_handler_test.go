@@ -0,0 +1,154 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kmogilevskii/hotel-reservation/db"
+	"github.com/kmogilevskii/hotel-reservation/db/fixtures"
+	"github.com/kmogilevskii/hotel-reservation/errors"
+	"github.com/kmogilevskii/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// insertedReservations remembers the reservations inserted.
+type insertedReservations struct {
+	db.ReservationStore
+	inserted []primitive.ObjectID
+}
+
+func (s *insertedReservations) Insert(ctx context.Context, reservation *types.Reservation) (*types.Reservation, error) {
+	s.inserted = append(s.inserted, reservation.ID)
+	return s.ReservationStore.Insert(ctx, reservation)
+}
+
+func TestGroupReservation(t *testing.T) {
+	tdb := setup(t)
+	var (
+		user               = fixtures.AddUser(tdb.store.User, "foo", "bar", false)
+		other              = fixtures.AddUser(tdb.store.User, "bar", "baz", false)
+		hotel              = fixtures.AddHotel(tdb.store.Hotel, "Hilton", "New York", 5)
+		single             = fixtures.AddRoom(tdb.store.Room, hotel.ID, "Single", 80)
+		double             = &types.RoomType{ID: primitive.NewObjectID(), HotelID: hotel.ID, Name: "Double", Size: "Double", Price: 120, Capacity: types.Capacity{MaxAdults: 2}}
+		doubles            = make([]*types.Room, 3)
+		from               = time.Now().AddDate(0, 0, 10)
+		till               = time.Now().AddDate(0, 0, 12)
+		app                = fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
+		reservationHandler = NewReservationHandler(tdb.store, tdb.payments, tdb.notifier)
+	)
+	reservations := &insertedReservations{ReservationStore: tdb.store.Reservation}
+	tdb.store.Reservation = reservations
+	group := app.Group("/", JWTAuthentication(tdb.store.User))
+	group.Post("/reservation", reservationHandler.HandlePostReservation)
+	group.Get("/reservation/:id", reservationHandler.HandleGetReservation)
+	group.Delete("/reservation/:id", reservationHandler.HandleCancelReservation)
+	group.Delete("/reservation/:id/room/:roomID", reservationHandler.HandleCancelReservationRoom)
+
+	send := newSender(t, app)
+
+	if _, err := tdb.store.RoomType.InsertRoomType(context.TODO(), double); err != nil {
+		t.Fatal(err)
+	}
+	for i := range doubles {
+		doubles[i] = &types.Room{ID: primitive.NewObjectID(), HotelID: hotel.ID, RoomTypeID: double.ID, Size: "Double", Price: 120}
+		if _, err := tdb.store.Room.InsertRoom(context.TODO(), doubles[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	taken := &types.Booking{ID: primitive.NewObjectID(), UserID: other.ID, RoomID: doubles[0].ID, RoomTypeID: double.ID, FromDate: from, TillDate: till, NumPersons: 1, Status: types.StatusConfirmed}
+	if _, err := tdb.store.Booking.Insert(context.TODO(), taken); err != nil {
+		t.Fatal(err)
+	}
+	params := types.GroupBookParams{
+		FromDate:   types.Date{Time: from},
+		TillDate:   types.Date{Time: till},
+		NumPersons: 2,
+		RoomIDs:    []string{single.ID.Hex(), doubles[0].ID.Hex()},
+	}
+	if resp := send("POST", "/reservation", params, user, nil); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected status code 409 with a taken room, got %d", resp.StatusCode)
+	}
+	if len(reservations.inserted) != 1 {
+		t.Fatalf("expected the group's reservation to go in first, got %d", len(reservations.inserted))
+	}
+	for _, id := range reservations.inserted {
+		if _, err := tdb.store.Reservation.GetReservationByID(context.TODO(), id.Hex()); !stderrors.Is(err, mongo.ErrNoDocuments) {
+			t.Fatalf("expected the reservation of the failed group to be deleted, got %v", err)
+		}
+	}
+	if ok, _ := tdb.store.Booking.IsRoomAvailable(context.TODO(), single.ID, from, till, time.Now()); !ok {
+		t.Fatal("expected the free room not to be booked when the group fails")
+	}
+	params = types.GroupBookParams{
+		FromDate:   types.Date{Time: from},
+		TillDate:   types.Date{Time: till},
+		NumPersons: 3,
+		RoomTypeID: double.ID.Hex(),
+		Count:      2,
+	}
+	if resp := send("POST", "/reservation", params, user, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status code 400 for more guests than the type sleeps, got %d", resp.StatusCode)
+	}
+	params.NumPersons, params.Count = 2, 3
+	if resp := send("POST", "/reservation", params, user, nil); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected status code 409 asking for more rooms than available, got %d", resp.StatusCode)
+	}
+	params.Count = 2
+	resp := send("POST", "/reservation", params, user, nil)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status code 201, got %d", resp.StatusCode)
+	}
+	var reservation reservationResp
+	json.NewDecoder(resp.Body).Decode(&reservation)
+	if len(reservation.Bookings) != 2 || len(reservation.Rooms) != 2 {
+		t.Fatalf("expected a reservation of 2 rooms, got %+v", reservation)
+	}
+	for _, booking := range reservation.Rooms {
+		if booking.ReservationID != reservation.ID || booking.RoomTypeID != double.ID || !booking.RoomID.IsZero() || booking.Price == nil || booking.Price.Total.Amount != 24000 {
+			t.Fatalf("expected an unassigned booking of the type at its price, got %+v", booking)
+		}
+	}
+	if available, _ := tdb.store.Booking.RoomTypeAvailability(context.TODO(), double.ID, reservation.FromDate, reservation.TillDate, time.Now()); available != 0 {
+		t.Fatalf("expected the group to take the type's last rooms, got %d left", available)
+	}
+
+	target := fmt.Sprintf("/reservation/%s", reservation.ID.Hex())
+	if resp := send("GET", target, nil, other, nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected status code 401 for someone else's reservation, got %d", resp.StatusCode)
+	}
+	if resp := send("DELETE", fmt.Sprintf("%s/room/%s", target, reservation.Rooms[0].ID.Hex()), nil, user, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code 200 canceling one room, got %d", resp.StatusCode)
+	}
+	resp = send("DELETE", target, nil, user, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code 200 canceling the reservation, got %d", resp.StatusCode)
+	}
+	var canceled cancelReservationResp
+	json.NewDecoder(resp.Body).Decode(&canceled)
+	if len(canceled.Cancellations) != 1 {
+		t.Fatalf("expected only the remaining room to be canceled, got %d cancellations", len(canceled.Cancellations))
+	}
+	resp = send("DELETE", target, nil, user, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code 200 canceling twice, got %d", resp.StatusCode)
+	}
+	json.NewDecoder(resp.Body).Decode(&canceled)
+	if len(canceled.Cancellations) != 0 {
+		t.Fatalf("expected nothing left to cancel, got %d cancellations", len(canceled.Cancellations))
+	}
+
+	resp = send("GET", target, nil, user, nil)
+	json.NewDecoder(resp.Body).Decode(&reservation)
+	for _, booking := range reservation.Rooms {
+		if booking.Status != types.StatusCanceled {
+			t.Fatalf("expected all rooms to be canceled, got %s", booking.Status)
+		}
+	}
+}
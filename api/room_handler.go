@@ -2,21 +2,31 @@ package api
 
 import (
 	"context"
+	stderrors "errors"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/kmogilevskii/hotel-reservation/db"
 	"github.com/kmogilevskii/hotel-reservation/errors"
+	"github.com/kmogilevskii/hotel-reservation/payments"
+	"github.com/kmogilevskii/hotel-reservation/pricing"
 	"github.com/kmogilevskii/hotel-reservation/types"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type RoomHandler struct {
-	store *db.Store
+	store    *db.Store
+	payments payments.Provider
 }
 
-func NewRoomHandler(store *db.Store) *RoomHandler {
-	return &RoomHandler{store: store}
+func NewRoomHandler(store *db.Store, provider payments.Provider) *RoomHandler {
+	return &RoomHandler{
+		store:    store,
+		payments: provider,
+	}
 }
 
 func (r *RoomHandler) HandleGetRooms(c *fiber.Ctx) error {
@@ -28,11 +38,28 @@ func (r *RoomHandler) HandleGetRooms(c *fiber.Ctx) error {
 	if params.Size != "" {
 		filter["size"] = params.Size
 	}
+	if params.Adults < 0 || params.Children < 0 {
+		return errors.ErrBadRequest()
+	}
+	cv, err := newConverter(c, r.store)
+	if err != nil {
+		return err
+	}
 
-	rooms, err := r.store.Room.GetRooms(c.Context(), filter, &params.Pagination)
+	var rooms []*types.Room
+	if params.Adults == 0 && params.Children == 0 {
+		rooms, err = r.store.Room.GetRooms(c.Context(), filter, &params.Pagination)
+	} else {
+		rooms, err = r.roomsForParty(c.Context(), filter, params)
+	}
 	if err != nil {
 		return err
 	}
+	for _, room := range rooms {
+		if err := cv.room(room); err != nil {
+			return err
+		}
+	}
 	resp := db.ResourceResponse{
 		Results: len(rooms),
 		Data:    rooms,
@@ -41,76 +68,441 @@ func (r *RoomHandler) HandleGetRooms(c *fiber.Ctx) error {
 	return c.Status(http.StatusOK).JSON(resp)
 }
 
+// holdTTL is how long a hold keeps its room while the guest checks out.
+const holdTTL = 10 * time.Minute
+
+// roomsForParty lists the rooms sleeping the party asked for. Occupancy
+// depends on extra beds, so the rooms are filtered and paginated here rather
+// than by the store.
+func (r *RoomHandler) roomsForParty(ctx context.Context, filter db.Map, params db.RoomQueryParams) ([]*types.Room, error) {
+	all, err := r.store.Room.GetRooms(ctx, filter, &db.Pagination{})
+	if err != nil {
+		return nil, err
+	}
+	adults := max(params.Adults, 1)
+	rooms := []*types.Room{}
+	for _, room := range all {
+		if room.CheckOccupancy(adults, params.Children) == nil {
+			rooms = append(rooms, room)
+		}
+	}
+	skip := int(max((params.Page-1)*params.Limit, 0))
+	if skip > len(rooms) {
+		skip = len(rooms)
+	}
+	rooms = rooms[skip:]
+	if params.Limit > 0 && int(params.Limit) < len(rooms) {
+		rooms = rooms[:params.Limit]
+	}
+	return rooms, nil
+}
+
 func (r *RoomHandler) HandleBookRoom(c *fiber.Ctx) error {
-	var params types.BookParams
-	roomID, err := primitive.ObjectIDFromHex(c.Params("id"))
-	if err := c.BodyParser(&params); err != nil {
+	booking, err := r.newBooking(c, types.StatusConfirmed)
+	if err != nil {
+		return err
+	}
+
+	// the availability check in newBooking only rejects obvious conflicts
+	// early, BookRoom repeats it atomically so concurrent requests can't
+	// double-book
+	insertedBooking, err := bookAndCharge(c.Context(), r.store, r.payments, booking)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(http.StatusCreated).JSON(insertedBooking)
+}
+
+// HandleHoldRoom reserves the room for a short time as a pending booking.
+// The hold has to be confirmed before it expires to become a booking.
+func (r *RoomHandler) HandleHoldRoom(c *fiber.Ctx) error {
+	booking, err := r.newBooking(c, types.StatusPending)
+	if err != nil {
 		return err
 	}
+	expiresAt := booking.StatusHistory[0].At.Add(holdTTL)
+	booking.HoldExpiresAt = &expiresAt
+
+	hold, err := bookRoom(c.Context(), r.store, booking)
 	if err != nil {
 		return err
 	}
-	if err := params.Validate(); err != nil {
+	return c.Status(http.StatusCreated).JSON(hold)
+}
+
+// HandleQuoteRoom prices a stay in the room like booking it would, without
+// booking it. The quote's token guarantees the price to a booking of the
+// same stay for a while.
+func (r *RoomHandler) HandleQuoteRoom(c *fiber.Ctx) error {
+	var params types.BookParams
+	if err := c.BodyParser(&params); err != nil {
 		return errors.ErrBadRequest()
 	}
-	ok, err := r.isRoomAvailableForBooking(c.Context(), c.Params("id"), params)
+	booking, err := r.priceStay(c, params, types.StatusConfirmed)
 	if err != nil {
 		return err
 	}
-	if !ok {
-		return errors.ErrAlreadyBooked()
+	quote, err := newQuote(booking, params.PromoCode, time.Now())
+	if err != nil {
+		return err
+	}
+	return c.JSON(quote)
+}
+
+// newBooking validates the booking request and prices the stay for the
+// current user, at the quoted price when the request carries a quote. The
+// booking it returns isn't stored yet.
+func (r *RoomHandler) newBooking(c *fiber.Ctx, status types.BookingStatus) (*types.Booking, error) {
+	var params types.BookParams
+	if err := c.BodyParser(&params); err != nil {
+		return nil, errors.ErrBadRequest()
+	}
+	booking, err := r.priceStay(c, params, status)
+	if err != nil {
+		return nil, err
+	}
+	if params.QuoteToken != "" {
+		if err := applyQuote(booking, params.QuoteToken, params.PromoCode); err != nil {
+			return nil, err
+		}
+	}
+	return booking, nil
+}
+
+// priceStay checks the stay can be booked in the room by the current user
+// and prices it.
+func (r *RoomHandler) priceStay(c *fiber.Ctx, params types.BookParams, status types.BookingStatus) (*types.Booking, error) {
+	roomID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return nil, err
+	}
+	room, err := getRoom(c.Context(), r.store.Room, roomID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	currency, err := requestedCurrency(c)
+	if err != nil {
+		return nil, err
 	}
 	user, ok := c.Context().Value("user").(*types.User)
 	if !ok {
-		return err
+		return nil, fmt.Errorf("authorization problems")
+	}
+	booking := newStayBooking(params, user, status)
+	booking.RoomID = room.ID
+	booking.RoomTypeID = room.RoomTypeID
+	terms, err := getSaleTerms(c.Context(), r.store, booking)
+	if err != nil {
+		return nil, err
+	}
+	if err := chargeIn(c.Context(), r.store, terms, booking, currency); err != nil {
+		return nil, err
+	}
+	if params.PromoCode != "" {
+		if err := applyPromoCode(c.Context(), r.store, booking, terms.hotel, params.PromoCode, user); err != nil {
+			return nil, err
+		}
+	}
+	if err := terms.price(booking); err != nil {
+		return nil, err
+	}
+	booking.CancellationPolicy = terms.policy
+	booking.PaymentSchedule = terms.rates.PaymentSchedule
+	ok, err = r.isRoomAvailableForBooking(c.Context(), c.Params("id"), booking.Stay())
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.ErrAlreadyBooked()
+	}
+	return booking, nil
+}
+
+// newStayBooking starts a booking of the stay for the user. It still needs a
+// room or room type and a price.
+func newStayBooking(params types.BookParams, user *types.User, status types.BookingStatus) *types.Booking {
+	return &types.Booking{
+		ID:          primitive.NewObjectID(),
+		UserID:      user.ID,
+		FromDate:    params.FromDate.Time,
+		TillDate:    params.TillDate.Time,
+		NumPersons:  params.NumPersons,
+		NumChildren: params.NumChildren,
+		Status:      status,
+		StatusHistory: []types.StatusChange{{
+			Status: status,
+			By:     user.ID,
+			At:     time.Now(),
+		}},
+	}
+}
+
+// saleTerms are what a stay is sold under: the booking's room type if it
+// has one, otherwise its room.
+type saleTerms struct {
+	hotel        *types.Hotel
+	rates        types.RoomRates
+	capacity     types.Capacity
+	policy       *types.CancellationPolicy
+	restrictions []types.StayRestriction
+}
+
+func getSaleTerms(ctx context.Context, store *db.Store, booking *types.Booking) (*saleTerms, error) {
+	var (
+		terms    saleTerms
+		hotelID  primitive.ObjectID
+		roomType *types.RoomType
+		room     *types.Room
+		err      error
+	)
+	if !booking.RoomTypeID.IsZero() {
+		roomType, err = getRoomType(ctx, store.RoomType, booking.RoomTypeID.Hex())
+		if err != nil {
+			return nil, err
+		}
+		hotelID = roomType.HotelID
+	}
+	if !booking.RoomID.IsZero() {
+		room, err = getRoom(ctx, store.Room, booking.RoomID.Hex())
+		if err != nil {
+			return nil, err
+		}
+		if roomType == nil {
+			hotelID = room.HotelID
+		}
 	}
+	hotel, err := getHotel(ctx, store.Hotel, hotelID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	terms.hotel = hotel
+	if roomType != nil {
+		terms.rates, terms.capacity = pricing.TypeRates(roomType, pricing.Currency(hotel)), roomType.Capacity
+	} else {
+		terms.rates, terms.capacity = pricing.Rates(room, pricing.Currency(hotel)), room.Capacity
+	}
+	terms.policy = pricing.CancellationPolicy(hotel, terms.rates)
+	terms.restrictions = stayRestrictions(hotel, roomType, room)
+	return &terms, nil
+}
 
-	booking := types.Booking{
-		ID:         primitive.NewObjectID(),
-		RoomID:     roomID,
-		UserID:     user.ID,
-		FromDate:   params.FromDate,
-		TillDate:   params.TillDate,
-		NumPersons: params.NumPersons,
-		Canceled:   false,
+// stayRestrictions collects the restrictions of the hotel, the room type and
+// the room a stay is sold under. The room type and the room may be nil.
+func stayRestrictions(hotel *types.Hotel, roomType *types.RoomType, room *types.Room) []types.StayRestriction {
+	restrictions := append([]types.StayRestriction{}, hotel.Restrictions...)
+	if roomType != nil {
+		restrictions = append(restrictions, roomType.Restrictions...)
+	}
+	if room != nil {
+		restrictions = append(restrictions, room.Restrictions...)
 	}
+	return restrictions
+}
 
-	insertedBooking, err := r.store.Booking.Insert(c.Context(), &booking)
+// priceBooking checks the booking's stay against what its room or room type
+// sleeps and prices it in the currency, the hotel's if it is empty.
+func priceBooking(ctx context.Context, store *db.Store, booking *types.Booking, currency string) (*saleTerms, error) {
+	terms, err := getSaleTerms(ctx, store, booking)
+	if err != nil {
+		return nil, err
+	}
+	if err := chargeIn(ctx, store, terms, booking, currency); err != nil {
+		return nil, err
+	}
+	if err := terms.price(booking); err != nil {
+		return nil, err
+	}
+	return terms, nil
+}
+
+// chargeIn has the booking charged in the currency instead of the currency
+// it is sold in, at today's exchange rate.
+func chargeIn(ctx context.Context, store *db.Store, terms *saleTerms, booking *types.Booking, currency string) error {
+	if currency == "" || currency == terms.rates.Currency {
+		return nil
+	}
+	rate, err := exchangeRate(ctx, store.ExchangeRate, terms.rates.Currency, currency)
 	if err != nil {
 		return err
 	}
+	booking.ExchangeRate = rate
+	return nil
+}
 
-	return c.Status(http.StatusCreated).JSON(insertedBooking)
+// price moves the booking's stay dates to the hotel's check-in and check-out
+// times, so nights are counted in the hotel's timezone, checks the stay is
+// allowed and prices it. The booking's discount comes off before the hotel's
+// taxes and fees are added. Bookings charged in another currency are priced
+// at converted rates, using the exchange rate they were booked at.
+func (t *saleTerms) price(booking *types.Booking) error {
+	stay := booking.Stay().LocalizeFor(t.hotel)
+	if err := stay.ValidateFor(t.capacity); err != nil {
+		return errors.NewError(http.StatusBadRequest, err.Error())
+	}
+	if err := types.CheckRestrictions(t.restrictions, stay); err != nil {
+		return errors.NewError(http.StatusBadRequest, err.Error())
+	}
+	rates, taxes := t.rates, t.hotel.Taxes
+	if rate := booking.ExchangeRate; rate != nil {
+		rates, taxes = pricing.ConvertRates(rates, *rate), pricing.ConvertTaxes(taxes, *rate)
+	}
+	price, err := pricing.ComputeRates(rates, stay.FromDate.Time, stay.TillDate.Time, stay.NumPersons)
+	if err != nil {
+		return errors.NewError(http.StatusBadRequest, err.Error())
+	}
+	if booking.Discount != nil {
+		// a changed stay keeps the discount it was booked with
+		rule := booking.Discount.Rule
+		if booking.ExchangeRate != nil {
+			rule = pricing.ConvertDiscountRule(rule, *booking.ExchangeRate)
+		}
+		discount, err := pricing.ApplyDiscount(price, booking.Discount.Code, rule)
+		if err != nil {
+			return errors.NewError(http.StatusBadRequest, err.Error())
+		}
+		discount.Rule = booking.Discount.Rule
+		discount.FirstBookingOnly = booking.Discount.FirstBookingOnly
+		booking.Discount = discount
+	}
+	if err := pricing.ApplyTaxes(price, taxes, stay); err != nil {
+		return errors.NewError(http.StatusBadRequest, err.Error())
+	}
+	booking.FromDate, booking.TillDate = stay.FromDate.Time, stay.TillDate.Time
+	booking.Price = price
+	booking.Currency = price.Total.Currency
+	return nil
 }
 
 func (r *RoomHandler) isRoomAvailableForBooking(ctx context.Context, roomID string, params types.BookParams) (bool, error) {
-	filter := db.Map{
-		"roomID": roomID,
+	oid, err := primitive.ObjectIDFromHex(roomID)
+	if err != nil {
+		return false, err
 	}
+	return r.store.Booking.IsRoomAvailable(ctx, oid, params.FromDate.Time, params.TillDate.Time, time.Now())
+}
+
+// endOfTime is used as the open end when looking for any booking after now.
+var endOfTime = time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC)
+
+func hasUpcomingBookings(ctx context.Context, store db.BookingStore, roomID primitive.ObjectID) (bool, error) {
+	now := time.Now()
+	return hasBookings(ctx, store, db.OverlappingBookingsFilter(roomID, now, endOfTime, now))
+}
+
+// hasUpcomingTypeBookings reports whether any stay booked as the room type,
+// assigned to a room or not, is yet to end.
+func hasUpcomingTypeBookings(ctx context.Context, store db.BookingStore, roomTypeID primitive.ObjectID) (bool, error) {
+	now := time.Now()
+	return hasBookings(ctx, store, db.OverlappingTypeBookingsFilter(roomTypeID, now, endOfTime, now))
+}
 
-	bookings, err := r.store.Booking.GetBookings(ctx, filter, &db.Pagination{Page: 1, Limit: 100})
+func hasBookings(ctx context.Context, store db.BookingStore, filter db.Map) (bool, error) {
+	bookings, err := store.GetBookings(ctx, filter, &db.Pagination{Page: 1, Limit: 1})
 	if err != nil {
 		return false, err
 	}
-	ok := true
-	for _, booking := range bookings {
-		if params.FromDate.Before(booking.FromDate) && params.TillDate.After(booking.FromDate) {
-			ok = false
-			break
-		}
-		if params.FromDate.Before(booking.TillDate) && params.TillDate.After(booking.TillDate) {
-			ok = false
-			break
+	return len(bookings) > 0, nil
+}
+
+// getRoom maps store lookup failures to API errors.
+func getRoom(ctx context.Context, store db.RoomStore, id string) (*types.Room, error) {
+	room, err := store.GetRoomByID(ctx, id)
+	if err != nil {
+		if stderrors.Is(err, mongo.ErrNoDocuments) {
+			return nil, errors.ErrResourceNotFound("room")
 		}
-		if params.FromDate.After(booking.FromDate) && params.TillDate.Before(booking.TillDate) {
-			ok = false
-			break
+		return nil, err
+	}
+	return room, nil
+}
+
+func (r *RoomHandler) HandlePostRoom(c *fiber.Ctx) error {
+	var params types.CreateRoomParams
+	if err := c.BodyParser(&params); err != nil {
+		return errors.ErrBadRequest()
+	}
+	if err := params.Validate(c.Context()); err != nil {
+		return errors.ErrBadRequest()
+	}
+	hotel, err := getHotel(c.Context(), r.store.Hotel, params.HotelID)
+	if err != nil {
+		return err
+	}
+	room := types.NewRoomFromParams(hotel.ID, params)
+	if params.RoomTypeID != "" {
+		roomType, err := getRoomType(c.Context(), r.store.RoomType, params.RoomTypeID)
+		if err != nil {
+			return err
 		}
-		if params.FromDate.Equal(booking.FromDate) && params.TillDate.Equal(booking.TillDate) {
-			ok = false
-			break
+		if roomType.HotelID != hotel.ID {
+			return errors.NewError(http.StatusBadRequest, "room type belongs to another hotel")
 		}
+		room.RoomTypeID = roomType.ID
 	}
+	room, err = r.store.Room.InsertRoom(c.Context(), room)
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusCreated).JSON(room)
+}
 
-	return ok, nil
+func (r *RoomHandler) HandlePutRoom(c *fiber.Ctx) error {
+	var (
+		params types.UpdateRoomParams
+		roomID = c.Params("id")
+	)
+	if err := c.BodyParser(&params); err != nil {
+		return errors.ErrBadRequest()
+	}
+	if err := params.Validate(c.Context()); err != nil || len(params.ToBSON()) == 0 {
+		return errors.ErrBadRequest()
+	}
+	if _, err := getRoom(c.Context(), r.store.Room, roomID); err != nil {
+		return err
+	}
+	if err := r.store.Room.UpdateRoom(c.Context(), roomID, params); err != nil {
+		return err
+	}
+	room, err := r.store.Room.GetRoomByID(c.Context(), roomID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(room)
+}
+
+func (r *RoomHandler) HandleDeleteRoom(c *fiber.Ctx) error {
+	roomID := c.Params("id")
+	room, err := getRoom(c.Context(), r.store.Room, roomID)
+	if err != nil {
+		return err
+	}
+	upcoming, err := hasUpcomingBookings(c.Context(), r.store.Booking, room.ID)
+	if err != nil {
+		return err
+	}
+	if upcoming {
+		return errors.ErrHasUpcomingBookings("room")
+	}
+	// the rooms sold with it must not end up with more bookings than
+	// rooms, even if they may be overbooked
+	pool, err := inventory(r.store).RoomPool(c.Context(), room)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	free, err := pool.Free(c.Context(), findBookings(r.store.Booking), now, endOfTime, now, primitive.NilObjectID)
+	if err != nil {
+		return err
+	}
+	if free-pool.Extra < 1 {
+		if room.RoomTypeID.IsZero() {
+			return errors.ErrHasUpcomingBookings("hotel")
+		}
+		return errors.ErrHasUpcomingBookings("room type")
+	}
+	if err := r.store.Room.DeleteRoom(c.Context(), roomID); err != nil {
+		return err
+	}
+	return c.JSON(map[string]string{"msg": fmt.Sprintf("deleted room with id %s", roomID)})
 }
@@ -2,36 +2,40 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/kmogilevskii/hotel-reservation/db"
 	"github.com/kmogilevskii/hotel-reservation/db/fixtures"
 	"github.com/kmogilevskii/hotel-reservation/errors"
 	"github.com/kmogilevskii/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func TestHandleBookRoom(t *testing.T) {
 	tdb := setup(t)
-	defer tdb.teardown(t)
 	var (
 		user        = fixtures.AddUser(tdb.store.User, "foo", "bar", false)
 		hotel       = fixtures.AddHotel(tdb.store.Hotel, "Hilton", "New York", 5)
 		room        = fixtures.AddRoom(tdb.store.Room, hotel.ID, "Single", 99.99)
 		booking     = fixtures.AddBooking(tdb.store.Booking, user.ID, room.ID, time.Now().AddDate(0, 0, 2), time.Now().AddDate(0, 0, 8), 2)
 		app         = fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
-		roomHandler = NewRoomHandler(tdb.store)
+		roomHandler = NewRoomHandler(tdb.store, tdb.payments)
 	)
 	book := app.Group("/", JWTAuthentication(tdb.store.User))
 	book.Post("/room/:id/book", roomHandler.HandleBookRoom)
 
 	params := types.BookParams{
-		FromDate:   time.Now().AddDate(0, 0, 9),
-		TillDate:   time.Now().AddDate(0, 0, 10),
+		FromDate:   types.Date{Time: time.Now().AddDate(0, 0, 9)},
+		TillDate:   types.Date{Time: time.Now().AddDate(0, 0, 10)},
 		NumPersons: 2,
 	}
 	b, _ := json.Marshal(params)
@@ -46,18 +50,23 @@ func TestHandleBookRoom(t *testing.T) {
 	var respBooking *types.Booking
 	json.NewDecoder(resp.Body).Decode(&respBooking)
 
-	if !params.FromDate.Equal(respBooking.FromDate) || !params.TillDate.Equal(respBooking.TillDate) {
-		t.Fatalf("booking dates do not match; expected: %s-%s, got %s-%s", params.FromDate, params.TillDate, respBooking.FromDate, respBooking.TillDate)
+	checkIn, checkOut := hotel.CheckInAt(params.FromDate.Time), hotel.CheckOutAt(params.TillDate.Time)
+	if !checkIn.Equal(respBooking.FromDate) || !checkOut.Equal(respBooking.TillDate) {
+		t.Fatalf("booking dates do not match; expected: %s-%s, got %s-%s", checkIn, checkOut, respBooking.FromDate, respBooking.TillDate)
 	}
 
 	if booking.TillDate.After(respBooking.FromDate) && booking.TillDate.Before(respBooking.TillDate) {
 		t.Fatalf("bookings overlap")
 	}
 
+	if respBooking.Price == nil || respBooking.Price.Total.Amount != 9999 || len(respBooking.Price.Nights) != 1 {
+		t.Fatalf("expected one night for 99.99 to be stored on the booking, got %+v", respBooking.Price)
+	}
+
 	// testing overlapping dates
 	params = types.BookParams{
-		FromDate:   time.Now().AddDate(0, 0, 1),
-		TillDate:   time.Now().AddDate(0, 0, 4),
+		FromDate:   types.Date{Time: time.Now().AddDate(0, 0, 1)},
+		TillDate:   types.Date{Time: time.Now().AddDate(0, 0, 4)},
 		NumPersons: 2,
 	}
 	b, _ = json.Marshal(params)
@@ -66,7 +75,532 @@ func TestHandleBookRoom(t *testing.T) {
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Api-Token", CreateTokenFromUser(user))
 	resp, _ = app.Test(req)
-	if resp.StatusCode != http.StatusBadRequest {
-		t.Fatalf("expected status code 400, got %d", resp.StatusCode)
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected status code 409, got %d", resp.StatusCode)
+	}
+
+	req = httptest.NewRequest("POST", fmt.Sprintf("/room/%s/book", room.ID.Hex()), bytes.NewReader([]byte(`{"fromDate":`)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Api-Token", CreateTokenFromUser(user))
+	resp, _ = app.Test(req)
+	var errResp errors.Error
+	json.NewDecoder(resp.Body).Decode(&errResp)
+	if resp.StatusCode != http.StatusBadRequest || errResp.Err != errors.ErrBadRequest().Err {
+		t.Fatalf("expected status code 400 for a malformed body, got %d %q", resp.StatusCode, errResp.Err)
+	}
+}
+
+func TestBookRoomHotelLocalDates(t *testing.T) {
+	tdb := setup(t)
+	var (
+		user        = fixtures.AddUser(tdb.store.User, "foo", "bar", false)
+		hotel       = fixtures.AddHotel(tdb.store.Hotel, "Hilton", "Kiritimati", 5)
+		room        = fixtures.AddRoom(tdb.store.Room, hotel.ID, "Single", 100)
+		app         = fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
+		roomHandler = NewRoomHandler(tdb.store, tdb.payments)
+	)
+	// UTC+14, so the hotel's today is ahead of UTC most of the day
+	local := types.UpdateHotelParams{Timezone: "Pacific/Kiritimati", CheckInTime: "14:00", CheckOutTime: "10:00"}
+	if err := tdb.store.Hotel.UpdateHotel(context.TODO(), hotel.ID.Hex(), local); err != nil {
+		t.Fatal(err)
+	}
+	app.Post("/room/:id/book", JWTAuthentication(tdb.store.User), roomHandler.HandleBookRoom)
+
+	book := func(from, till time.Time) (*http.Response, *types.Booking) {
+		body := fmt.Sprintf(`{"fromDate":%q,"tillDate":%q,"numPersons":1}`, from.Format(time.DateOnly), till.Format(time.DateOnly))
+		req := httptest.NewRequest("POST", fmt.Sprintf("/room/%s/book", room.ID.Hex()), strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Api-Token", CreateTokenFromUser(user))
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var booking types.Booking
+		json.NewDecoder(resp.Body).Decode(&booking)
+		return resp, &booking
+	}
+
+	loc, _ := time.LoadLocation("Pacific/Kiritimati")
+	today := time.Now().In(loc)
+	resp, booking := book(today, today.AddDate(0, 0, 2))
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected the hotel's today to be bookable, got %d", resp.StatusCode)
+	}
+	y, m, d := today.Date()
+	checkIn := time.Date(y, m, d, 14, 0, 0, 0, loc)
+	checkOut := time.Date(y, m, d+2, 10, 0, 0, 0, loc)
+	if !booking.FromDate.Equal(checkIn) || !booking.TillDate.Equal(checkOut) {
+		t.Fatalf("expected a stay from %s till %s, got %s-%s", checkIn, checkOut, booking.FromDate, booking.TillDate)
+	}
+	if len(booking.Price.Nights) != 2 || booking.Price.Total.Amount != 20000 {
+		t.Fatalf("expected 2 local nights for 200.00, got %+v", booking.Price)
+	}
+
+	// the next guest arrives on the day the first one leaves
+	if resp, _ := book(today.AddDate(0, 0, 2), today.AddDate(0, 0, 3)); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected same-day turnover to be bookable, got %d", resp.StatusCode)
+	}
+	if resp, _ := book(today.AddDate(0, 0, 1), today.AddDate(0, 0, 2)); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected an overlapping stay to be rejected, got %d", resp.StatusCode)
+	}
+	if resp, _ := book(today.AddDate(0, 0, -1), today.AddDate(0, 0, 1)); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected the hotel's yesterday to be in the past, got %d", resp.StatusCode)
+	}
+}
+
+func TestStayRestrictions(t *testing.T) {
+	tdb := setup(t)
+	var (
+		user         = fixtures.AddUser(tdb.store.User, "foo", "bar", false)
+		hotel        = fixtures.AddHotel(tdb.store.Hotel, "Hilton", "New York", 5)
+		room         = fixtures.AddRoom(tdb.store.Room, hotel.ID, "Single", 100)
+		app          = fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
+		roomHandler  = NewRoomHandler(tdb.store, tdb.payments)
+		availHandler = NewAvailabilityHandler(tdb.store)
+		date         = func(s string) time.Time {
+			d, _ := time.Parse(time.DateOnly, s)
+			return d
+		}
+	)
+	hotelRules := types.UpdateHotelParams{Restrictions: []types.StayRestriction{
+		{Name: "New Year", From: types.Date{Time: date("2030-12-28")}, Till: types.Date{Time: date("2031-01-02")}, MinNights: 3},
+		{From: types.Date{Time: date("2030-01-01")}, Till: types.Date{Time: date("2032-01-01")}, Weekdays: []time.Weekday{time.Saturday}, ClosedToArrival: true},
+		{From: types.Date{Time: date("2030-01-01")}, Till: types.Date{Time: date("2032-01-01")}, MaxNights: 14},
+	}}
+	if err := tdb.store.Hotel.UpdateHotel(context.TODO(), hotel.ID.Hex(), hotelRules); err != nil {
+		t.Fatal(err)
+	}
+	roomRules := types.UpdateRoomParams{Restrictions: []types.StayRestriction{
+		{Name: "renovation", From: types.Date{Time: date("2030-12-24")}, Till: types.Date{Time: date("2030-12-27")}, Blackout: true},
+	}}
+	if err := tdb.store.Room.UpdateRoom(context.TODO(), room.ID.Hex(), roomRules); err != nil {
+		t.Fatal(err)
+	}
+	group := app.Group("/", JWTAuthentication(tdb.store.User))
+	group.Post("/room/:id/book", roomHandler.HandleBookRoom)
+	group.Get("/availability", availHandler.HandleGetAvailability)
+
+	book := func(from, till string) (*http.Response, errors.Error) {
+		body := fmt.Sprintf(`{"fromDate":%q,"tillDate":%q,"numPersons":1}`, from, till)
+		req := httptest.NewRequest("POST", fmt.Sprintf("/room/%s/book", room.ID.Hex()), strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Api-Token", CreateTokenFromUser(user))
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var errResp errors.Error
+		json.NewDecoder(resp.Body).Decode(&errResp)
+		return resp, errResp
+	}
+
+	tests := []struct {
+		name, from, till, msg string
+	}{
+		{"min stay", "2030-12-29", "2030-12-31", "stays arriving on 2030-12-29 must be at least 3 nights (New Year)"},
+		{"max stay", "2030-03-04", "2030-03-20", "stays arriving on 2030-03-04 can be at most 14 nights"},
+		{"closed to arrival", "2030-12-21", "2030-12-23", "no arrivals on Saturday 2030-12-21"},
+		{"blackout", "2030-12-23", "2030-12-25", "closed on the night of 2030-12-24 (renovation)"},
+		{"several rules", "2030-12-28", "2030-12-29", "stays arriving on 2030-12-28 must be at least 3 nights (New Year); no arrivals on Saturday 2030-12-28"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, errResp := book(tt.from, tt.till)
+			if resp.StatusCode != http.StatusBadRequest || errResp.Err != tt.msg {
+				t.Fatalf("expected %q, got %d %q", tt.msg, resp.StatusCode, errResp.Err)
+			}
+		})
+	}
+	if resp, errResp := book("2030-12-29", "2031-01-01"); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected a stay meeting every rule to be booked, got %d %q", resp.StatusCode, errResp.Err)
+	}
+
+	// the blacked out room isn't offered, the hotel has no other
+	req := httptest.NewRequest("GET", "/availability?from=2030-12-23&till=2030-12-25", nil)
+	req.Header.Set("X-Api-Token", CreateTokenFromUser(user))
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body db.ResourceResponse
+	json.NewDecoder(resp.Body).Decode(&body)
+	if resp.StatusCode != http.StatusOK || body.Results != 0 {
+		t.Fatalf("expected no availability during the blackout, got %d %+v", resp.StatusCode, body)
+	}
+}
+
+func TestHotelTaxes(t *testing.T) {
+	tdb := setup(t)
+	var (
+		admin        = fixtures.AddUser(tdb.store.User, "admin", "admin", true)
+		user         = fixtures.AddUser(tdb.store.User, "foo", "bar", false)
+		hotel        = fixtures.AddHotel(tdb.store.Hotel, "Hilton", "New York", 5)
+		room         = fixtures.AddRoom(tdb.store.Room, hotel.ID, "Double", 100)
+		app          = fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
+		hotelHandler = NewHotelHandler(tdb.store)
+		roomHandler  = NewRoomHandler(tdb.store, tdb.payments)
+		availHandler = NewAvailabilityHandler(tdb.store)
+		usd          = func(amount int64) types.Money { return types.Money{Amount: amount, Currency: "USD"} }
+	)
+	tdb.store.PromoCode.InsertPromoCode(context.TODO(), types.NewPromoCodeFromParams(types.CreatePromoCodeParams{Code: "SPRING10", Percent: 10}))
+	app.Put("/hotel/:id", JWTAuthentication(tdb.store.User), AdminAuth, hotelHandler.HandlePutHotel)
+	app.Post("/room/:id/book", JWTAuthentication(tdb.store.User), roomHandler.HandleBookRoom)
+	app.Get("/availability", JWTAuthentication(tdb.store.User), availHandler.HandleGetAvailability)
+
+	send := newSender(t, app)
+
+	for _, tt := range []struct {
+		name string
+		fee  types.Fee
+		msg  string
+	}{
+		{"unknown basis", types.Fee{Name: "City tax", Amount: usd(300), Basis: "per-week"}, errors.ErrBadRequest().Err},
+		{"negative fee", types.Fee{Name: "Discount", Amount: usd(-300), Basis: types.PerStay}, "fee Discount can't be negative"},
+		{"other currency", types.Fee{Name: "City tax", Amount: types.Money{Amount: 300, Currency: "EUR"}, Basis: types.PerStay}, "fee City tax has to be charged in USD, the hotel's currency"},
+	} {
+		var errResp errors.Error
+		bad := types.UpdateHotelParams{Taxes: &types.TaxRules{Fees: []types.Fee{tt.fee}}}
+		if resp := send("PUT", fmt.Sprintf("/hotel/%s", hotel.ID.Hex()), bad, admin, &errResp); resp.StatusCode != http.StatusBadRequest || errResp.Err != tt.msg {
+			t.Fatalf("%s: expected status code 400 %q, got %d %q", tt.name, tt.msg, resp.StatusCode, errResp.Err)
+		}
+	}
+	taxes := types.UpdateHotelParams{Taxes: &types.TaxRules{
+		VAT:  &types.VAT{Percent: 10},
+		Fees: []types.Fee{{Name: "City tax", Amount: usd(300), Basis: types.PerPersonPerNight}},
+	}}
+	if resp := send("PUT", fmt.Sprintf("/hotel/%s", hotel.ID.Hex()), taxes, admin, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code 200, got %d", resp.StatusCode)
+	}
+
+	// 200.00 less 10% off, 2 guests x 2 nights x 3.00 city tax and 10% VAT
+	// on 180.00
+	want := []types.PriceCharge{
+		{Kind: types.ChargeFee, Name: "City tax", Basis: types.PerPersonPerNight, Quantity: 4, UnitAmount: 300, Amount: 1200},
+		{Kind: types.ChargeVAT, Name: "VAT", Percent: 10, Base: 18000, Amount: 1800},
+	}
+	params := types.BookParams{FromDate: types.Date{Time: time.Now().AddDate(0, 0, 3)}, TillDate: types.Date{Time: time.Now().AddDate(0, 0, 5)}, NumPersons: 2, PromoCode: "SPRING10"}
+	var booking types.Booking
+	if resp := send("POST", fmt.Sprintf("/room/%s/book", room.ID.Hex()), params, user, &booking); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status code 201, got %d", resp.StatusCode)
+	}
+	if !reflect.DeepEqual(booking.Price.Charges, want) || booking.Price.Total != usd(21000) {
+		t.Fatalf("expected the taxes to be itemized on 210.00, got %+v %+v", booking.Price.Charges, booking.Price.Total)
+	}
+	if booking.Payment == nil || booking.Payment.Captured != usd(21000) {
+		t.Fatalf("expected 210.00 to be charged, got %+v", booking.Payment)
+	}
+
+	// quotes carry the taxes too
+	var body availabilityResp
+	from, till := params.FromDate.AddDate(0, 0, 7), params.TillDate.AddDate(0, 0, 7)
+	send("GET", fmt.Sprintf("/availability?from=%s&till=%s&guests=2", from.Format(time.DateOnly), till.Format(time.DateOnly)), nil, user, &body)
+	if body.Results != 1 || len(body.Data[0].Rooms) != 1 {
+		t.Fatalf("expected the room to be offered, got %+v", body.Data)
+	}
+	if price := body.Data[0].Rooms[0].Price; len(price.Charges) != 2 || price.Total != usd(23200) {
+		t.Fatalf("expected 200.00 plus 12.00 city tax and 20.00 VAT, got %+v", price)
+	}
+}
+
+func TestAdminRoomCRUD(t *testing.T) {
+	tdb := setup(t)
+	var (
+		admin       = fixtures.AddUser(tdb.store.User, "admin", "admin", true)
+		user        = fixtures.AddUser(tdb.store.User, "foo", "bar", false)
+		hotel       = fixtures.AddHotel(tdb.store.Hotel, "Hilton", "New York", 5)
+		app         = fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
+		roomHandler = NewRoomHandler(tdb.store, tdb.payments)
+	)
+	group := app.Group("/", JWTAuthentication(tdb.store.User), AdminAuth)
+	group.Post("/room", roomHandler.HandlePostRoom)
+	group.Put("/room/:id", roomHandler.HandlePutRoom)
+	group.Delete("/room/:id", roomHandler.HandleDeleteRoom)
+
+	send := newSender(t, app)
+
+	params := types.CreateRoomParams{HotelID: hotel.ID.Hex(), Size: "Double", Price: 120}
+	resp := send("POST", "/room", params, admin, nil)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status code 201, got %d", resp.StatusCode)
+	}
+	var room types.Room
+	json.NewDecoder(resp.Body).Decode(&room)
+	if room.HotelID != hotel.ID || room.Price != 120 {
+		t.Fatalf("unexpected room %+v", room)
+	}
+	updated, _ := tdb.store.Hotel.GetHotelByID(context.TODO(), hotel.ID.Hex())
+	if len(updated.Rooms) != 1 || updated.Rooms[0] != room.ID {
+		t.Fatalf("expected the room to be added to the hotel, got %v", updated.Rooms)
+	}
+	if resp := send("POST", "/room", types.CreateRoomParams{HotelID: hotel.ID.Hex(), Size: "Double"}, admin, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status code 400 without a price, got %d", resp.StatusCode)
+	}
+	atArrival := &types.RoomRates{Weekday: 12000, PaymentSchedule: &types.PaymentSchedule{AutoCancel: true, GraceHours: 1}}
+	if resp := send("POST", "/room", types.CreateRoomParams{HotelID: hotel.ID.Hex(), Size: "Double", Price: 120, Rates: atArrival}, admin, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status code 400 canceling unpaid balances after arrival, got %d", resp.StatusCode)
+	}
+
+	seaside := true
+	resp = send("PUT", fmt.Sprintf("/room/%s", room.ID.Hex()), types.UpdateRoomParams{Seaside: &seaside, Price: 140}, admin, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code 200, got %d", resp.StatusCode)
+	}
+	json.NewDecoder(resp.Body).Decode(&room)
+	if !room.Seaside || room.Price != 140 || room.Size != "Double" {
+		t.Fatalf("unexpected room after update %+v", room)
+	}
+
+	fixtures.AddBooking(tdb.store.Booking, user.ID, room.ID, time.Now().AddDate(0, 0, -1), time.Now().AddDate(0, 0, 1), 1)
+	if resp := send("DELETE", fmt.Sprintf("/room/%s", room.ID.Hex()), nil, admin, nil); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected status code 409 during an ongoing stay, got %d", resp.StatusCode)
+	}
+
+	past := fixtures.AddRoom(tdb.store.Room, hotel.ID, "Single", 80)
+	fixtures.AddBooking(tdb.store.Booking, user.ID, past.ID, time.Now().AddDate(0, 0, -5), time.Now().AddDate(0, 0, -2), 1)
+	if resp := send("DELETE", fmt.Sprintf("/room/%s", past.ID.Hex()), nil, admin, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code 200 with only past bookings, got %d", resp.StatusCode)
+	}
+	updated, _ = tdb.store.Hotel.GetHotelByID(context.TODO(), hotel.ID.Hex())
+	if len(updated.Rooms) != 1 || updated.Rooms[0] != room.ID {
+		t.Fatalf("expected the deleted room to be pulled from the hotel, got %v", updated.Rooms)
+	}
+	if resp := send("DELETE", fmt.Sprintf("/room/%s", past.ID.Hex()), nil, admin, nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected status code 404, got %d", resp.StatusCode)
+	}
+}
+
+func TestHoldRoom(t *testing.T) {
+	tdb := setup(t)
+	var (
+		user           = fixtures.AddUser(tdb.store.User, "foo", "bar", false)
+		other          = fixtures.AddUser(tdb.store.User, "bar", "baz", false)
+		hotel          = fixtures.AddHotel(tdb.store.Hotel, "Hilton", "New York", 5)
+		room           = fixtures.AddRoom(tdb.store.Room, hotel.ID, "Single", 99.99)
+		app            = fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
+		roomHandler    = NewRoomHandler(tdb.store, tdb.payments)
+		bookingHandler = NewBookingHandler(tdb.store, tdb.payments, tdb.notifier)
+	)
+	group := app.Group("/", JWTAuthentication(tdb.store.User))
+	group.Post("/room/:id/book", roomHandler.HandleBookRoom)
+	group.Post("/room/:id/hold", roomHandler.HandleHoldRoom)
+	group.Post("/booking/:id/confirm", bookingHandler.HandleConfirmHold)
+
+	post := func(target string, as *types.User, body any) (*http.Response, *types.Booking) {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest("POST", target, bytes.NewReader(b))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Api-Token", CreateTokenFromUser(as))
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var booking types.Booking
+		json.NewDecoder(resp.Body).Decode(&booking)
+		return resp, &booking
+	}
+
+	params := types.BookParams{
+		FromDate:   types.Date{Time: time.Now().AddDate(0, 0, 1)},
+		TillDate:   types.Date{Time: time.Now().AddDate(0, 0, 3)},
+		NumPersons: 1,
+	}
+	resp, hold := post(fmt.Sprintf("/room/%s/hold", room.ID.Hex()), user, params)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status code 201, got %d", resp.StatusCode)
+	}
+	if hold.Status != types.StatusPending || hold.HoldExpiresAt == nil || hold.Price == nil {
+		t.Fatalf("expected a priced pending hold with an expiry, got %+v", hold)
+	}
+	if resp, _ := post(fmt.Sprintf("/room/%s/book", room.ID.Hex()), other, params); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected the hold to block the room, got %d", resp.StatusCode)
+	}
+	if resp, _ := post(fmt.Sprintf("/booking/%s/confirm", hold.ID.Hex()), other, nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected status code 401 confirming someone else's hold, got %d", resp.StatusCode)
+	}
+	resp, booking := post(fmt.Sprintf("/booking/%s/confirm", hold.ID.Hex()), user, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code 200, got %d", resp.StatusCode)
+	}
+	if booking.Status != types.StatusConfirmed || booking.HoldExpiresAt != nil {
+		t.Fatalf("expected the hold to become a booking, got %+v", booking)
+	}
+	if resp, _ := post(fmt.Sprintf("/booking/%s/confirm", hold.ID.Hex()), user, nil); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected status code 409 confirming twice, got %d", resp.StatusCode)
+	}
+
+	expiresAt := time.Now().Add(-time.Minute)
+	expired, _ := tdb.store.Booking.Insert(context.TODO(), &types.Booking{
+		ID:            primitive.NewObjectID(),
+		RoomID:        room.ID,
+		UserID:        user.ID,
+		FromDate:      time.Now().AddDate(0, 0, 5),
+		TillDate:      time.Now().AddDate(0, 0, 6),
+		NumPersons:    1,
+		Status:        types.StatusPending,
+		HoldExpiresAt: &expiresAt,
+	})
+	if resp, _ := post(fmt.Sprintf("/booking/%s/confirm", expired.ID.Hex()), user, nil); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected status code 409 confirming an expired hold, got %d", resp.StatusCode)
+	}
+}
+
+func TestRoomOccupancy(t *testing.T) {
+	tdb := setup(t)
+	var (
+		user        = fixtures.AddUser(tdb.store.User, "foo", "bar", false)
+		hotel       = fixtures.AddHotel(tdb.store.Hotel, "Hilton", "New York", 5)
+		single      = fixtures.AddRoom(tdb.store.Room, hotel.ID, "Single", 80)
+		app         = fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
+		roomHandler = NewRoomHandler(tdb.store, tdb.payments)
+	)
+	single.MaxAdults = 1
+	tdb.store.Room.UpdateRoom(context.TODO(), single.ID.Hex(), types.UpdateRoomParams{MaxAdults: &single.MaxAdults})
+	family, _ := tdb.store.Room.InsertRoom(context.TODO(), types.NewRoomFromParams(hotel.ID, types.CreateRoomParams{
+		Size:        "Family",
+		Price:       150,
+		MaxAdults:   2,
+		MaxChildren: 1,
+		ExtraBeds:   1,
+	}))
+	group := app.Group("/", JWTAuthentication(tdb.store.User))
+	group.Post("/room/:id/book", roomHandler.HandleBookRoom)
+	group.Get("/room", roomHandler.HandleGetRooms)
+
+	day := 0
+	book := func(room *types.Room, persons, children int) int {
+		day += 2
+		params := types.BookParams{
+			FromDate:    types.Date{Time: time.Now().AddDate(0, 0, day)},
+			TillDate:    types.Date{Time: time.Now().AddDate(0, 0, day+1)},
+			NumPersons:  persons,
+			NumChildren: children,
+		}
+		b, _ := json.Marshal(params)
+		req := httptest.NewRequest("POST", fmt.Sprintf("/room/%s/book", room.ID.Hex()), bytes.NewReader(b))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Api-Token", CreateTokenFromUser(user))
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	tests := []struct {
+		name              string
+		room              *types.Room
+		persons, children int
+		want              int
+	}{
+		{"12 people in a single", single, 12, 0, http.StatusBadRequest},
+		{"one adult in a single", single, 1, 0, http.StatusCreated},
+		{"only children", family, 2, 2, http.StatusBadRequest},
+		{"regular beds", family, 3, 1, http.StatusCreated},
+		{"third adult on the extra bed", family, 4, 1, http.StatusCreated},
+		{"second child on the extra bed", family, 4, 2, http.StatusCreated},
+		{"more than the extra beds", family, 5, 2, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if got := book(tt.room, tt.persons, tt.children); got != tt.want {
+			t.Errorf("%s: expected status code %d, got %d", tt.name, tt.want, got)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/room?adults=2&children=1", nil)
+	req.Header.Set("X-Api-Token", CreateTokenFromUser(user))
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rooms []*types.Room
+	json.NewDecoder(resp.Body).Decode(&db.ResourceResponse{Data: &rooms})
+	if len(rooms) != 1 || rooms[0].ID != family.ID {
+		t.Fatalf("expected only the family room to sleep 2 adults and a child, got %d rooms", len(rooms))
+	}
+}
+
+func TestQuoteRoom(t *testing.T) {
+	tdb := setup(t)
+	var (
+		user        = fixtures.AddUser(tdb.store.User, "foo", "bar", false)
+		other       = fixtures.AddUser(tdb.store.User, "baz", "qux", false)
+		hotel       = fixtures.AddHotel(tdb.store.Hotel, "Hilton", "New York", 5)
+		room        = fixtures.AddRoom(tdb.store.Room, hotel.ID, "Single", 100)
+		app         = fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
+		roomHandler = NewRoomHandler(tdb.store, tdb.payments)
+		stay        = types.BookParams{FromDate: types.Date{Time: time.Now().AddDate(0, 0, 3)}, TillDate: types.Date{Time: time.Now().AddDate(0, 0, 5)}, NumPersons: 1}
+	)
+	group := app.Group("/", JWTAuthentication(tdb.store.User))
+	group.Post("/room/:id/quote", roomHandler.HandleQuoteRoom)
+	group.Post("/room/:id/book", roomHandler.HandleBookRoom)
+
+	send := newSender(t, app)
+
+	var quote Quote
+	if resp := send("POST", fmt.Sprintf("/room/%s/%s", room.ID.Hex(), "quote"), stay, user, &quote); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code 200, got %d", resp.StatusCode)
+	}
+	if quote.Token == "" || quote.Price.Total.Amount != 20000 || !quote.FromDate.Equal(hotel.CheckInAt(stay.FromDate.Time)) {
+		t.Fatalf("expected 2 nights quoted at 200.00, got %+v", quote)
+	}
+	if time.Until(quote.ExpiresAt) > quoteTTL || time.Until(quote.ExpiresAt) < quoteTTL-time.Minute {
+		t.Fatalf("expected the quote to expire in %s, got %s", quoteTTL, quote.ExpiresAt)
+	}
+	if ok, _ := tdb.store.Booking.IsRoomAvailable(context.TODO(), room.ID, quote.FromDate, quote.TillDate, time.Now()); !ok {
+		t.Fatal("expected a quote to leave the room available")
+	}
+	past := types.BookParams{FromDate: types.Date{Time: time.Now().AddDate(0, 0, -2)}, TillDate: types.Date{Time: time.Now()}, NumPersons: 1}
+	if resp := send("POST", fmt.Sprintf("/room/%s/%s", room.ID.Hex(), "quote"), past, user, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status code 400 for a stay in the past, got %d", resp.StatusCode)
+	}
+
+	// the quoted price holds after the room got more expensive
+	if err := tdb.store.Room.UpdateRoom(context.TODO(), room.ID.Hex(), types.UpdateRoomParams{Price: 150}); err != nil {
+		t.Fatal(err)
+	}
+	expired, err := newQuote(&types.Booking{UserID: user.ID, RoomID: room.ID, FromDate: quote.FromDate, TillDate: quote.TillDate, NumPersons: 1, Price: quote.Price}, "", time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	longer := stay
+	longer.TillDate = types.Date{Time: stay.TillDate.AddDate(0, 0, 1)}
+	tests := []struct {
+		name   string
+		params types.BookParams
+		token  string
+		as     *types.User
+		msg    string
+	}{
+		{"another stay", longer, quote.Token, user, "quote does not match the booking"},
+		{"another guest", stay, quote.Token, other, "quote does not match the booking"},
+		{"expired", stay, expired.Token, user, "quote has expired"},
+		{"forged", stay, CreateTokenFromUser(user), user, "invalid quote"},
+	}
+	for _, tt := range tests {
+		tt.params.QuoteToken = tt.token
+		var errResp errors.Error
+		if resp := send("POST", fmt.Sprintf("/room/%s/%s", room.ID.Hex(), "book"), tt.params, tt.as, &errResp); resp.StatusCode != http.StatusBadRequest || errResp.Err != tt.msg {
+			t.Fatalf("%s: expected %q, got %d %q", tt.name, tt.msg, resp.StatusCode, errResp.Err)
+		}
+	}
+
+	stay.QuoteToken = quote.Token
+	var booking types.Booking
+	if resp := send("POST", fmt.Sprintf("/room/%s/%s", room.ID.Hex(), "book"), stay, user, &booking); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status code 201, got %d", resp.StatusCode)
+	}
+	if booking.Price.Total.Amount != 20000 || booking.Payment.Captured.Amount != 20000 {
+		t.Fatalf("expected the quoted 200.00 to be charged, got %+v %+v", booking.Price.Total, booking.Payment)
+	}
+	// a quote doesn't hold the room
+	if resp := send("POST", fmt.Sprintf("/room/%s/%s", room.ID.Hex(), "book"), stay, user, nil); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected status code 409 for a booked stay, got %d", resp.StatusCode)
+	}
+
+	// quotes can't be used to authenticate
+	req := httptest.NewRequest("POST", fmt.Sprintf("/room/%s/quote", room.ID.Hex()), nil)
+	req.Header.Set("X-Api-Token", quote.Token)
+	if resp, err := app.Test(req); err != nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected status code 401 for a quote token, got %v %v", resp, err)
 	}
 }
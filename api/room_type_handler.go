@@ -0,0 +1,198 @@
+package api
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kmogilevskii/hotel-reservation/db"
+	"github.com/kmogilevskii/hotel-reservation/errors"
+	"github.com/kmogilevskii/hotel-reservation/payments"
+	"github.com/kmogilevskii/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type RoomTypeAvailability struct {
+	*types.RoomType
+	// Available is how many rooms of the type are free on every night of
+	// the requested stay, nil when no stay was given.
+	Available *int `json:"available,omitempty"`
+}
+
+type RoomTypeHandler struct {
+	store    *db.Store
+	payments payments.Provider
+}
+
+func NewRoomTypeHandler(store *db.Store, provider payments.Provider) *RoomTypeHandler {
+	return &RoomTypeHandler{
+		store:    store,
+		payments: provider,
+	}
+}
+
+// getRoomType maps store lookup failures to API errors.
+func getRoomType(ctx context.Context, store db.RoomTypeStore, id string) (*types.RoomType, error) {
+	roomType, err := store.GetRoomTypeByID(ctx, id)
+	if err != nil {
+		if stderrors.Is(err, mongo.ErrNoDocuments) {
+			return nil, errors.ErrResourceNotFound("room type")
+		}
+		return nil, err
+	}
+	return roomType, nil
+}
+
+func (h *RoomTypeHandler) HandlePostRoomType(c *fiber.Ctx) error {
+	var params types.CreateRoomTypeParams
+	if err := c.BodyParser(&params); err != nil {
+		return errors.ErrBadRequest()
+	}
+	if err := params.Validate(c.Context()); err != nil {
+		return errors.ErrBadRequest()
+	}
+	hotel, err := getHotel(c.Context(), h.store.Hotel, params.HotelID)
+	if err != nil {
+		return err
+	}
+	roomType, err := h.store.RoomType.InsertRoomType(c.Context(), types.NewRoomTypeFromParams(hotel.ID, params))
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusCreated).JSON(roomType)
+}
+
+func (h *RoomTypeHandler) HandlePutRoomType(c *fiber.Ctx) error {
+	var (
+		params     types.UpdateRoomTypeParams
+		roomTypeID = c.Params("id")
+	)
+	if err := c.BodyParser(&params); err != nil {
+		return errors.ErrBadRequest()
+	}
+	if err := params.Validate(c.Context()); err != nil || len(params.ToBSON()) == 0 {
+		return errors.ErrBadRequest()
+	}
+	if _, err := getRoomType(c.Context(), h.store.RoomType, roomTypeID); err != nil {
+		return err
+	}
+	if err := h.store.RoomType.UpdateRoomType(c.Context(), roomTypeID, params); err != nil {
+		return err
+	}
+	roomType, err := h.store.RoomType.GetRoomTypeByID(c.Context(), roomTypeID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(roomType)
+}
+
+// HandleGetRoomTypes lists the room types of a hotel. Given a stay, it also
+// counts how many rooms of each type are available.
+func (h *RoomTypeHandler) HandleGetRoomTypes(c *fiber.Ctx) error {
+	var params db.RoomTypeQueryParams
+	if err := c.QueryParser(&params); err != nil {
+		return errors.ErrBadRequest()
+	}
+	hotel, err := getHotel(c.Context(), h.store.Hotel, c.Params("id"))
+	if err != nil {
+		return err
+	}
+	cv, err := newConverter(c, h.store)
+	if err != nil {
+		return err
+	}
+	var stay *types.BookParams
+	if params.From != "" || params.Till != "" {
+		from, err := parseDate(params.From)
+		if err != nil {
+			return errors.NewError(http.StatusBadRequest, "invalid from date")
+		}
+		till, err := parseDate(params.Till)
+		if err != nil {
+			return errors.NewError(http.StatusBadRequest, "invalid till date")
+		}
+		localized := types.BookParams{FromDate: types.Date{Time: from}, TillDate: types.Date{Time: till}, NumPersons: 1}.LocalizeFor(hotel)
+		stay = &localized
+		if err := stay.Validate(); err != nil {
+			return errors.NewError(http.StatusBadRequest, err.Error())
+		}
+	}
+
+	roomTypes, err := h.store.RoomType.GetRoomTypes(c.Context(), db.Map{"hotelID": hotel.ID}, &params.Pagination)
+	if err != nil {
+		return err
+	}
+	results := make([]RoomTypeAvailability, len(roomTypes))
+	for i, roomType := range roomTypes {
+		if err := cv.roomType(roomType); err != nil {
+			return err
+		}
+		results[i].RoomType = roomType
+		if stay == nil {
+			continue
+		}
+		if types.CheckRestrictions(stayRestrictions(hotel, roomType, nil), *stay) != nil {
+			results[i].Available = new(int)
+			continue
+		}
+		available, err := h.store.Booking.RoomTypeAvailability(c.Context(), roomType.ID, stay.FromDate.Time, stay.TillDate.Time, time.Now())
+		if err != nil {
+			return err
+		}
+		results[i].Available = &available
+	}
+	resp := db.ResourceResponse{
+		Results: len(results),
+		Data:    results,
+		Page:    params.Page,
+	}
+	return c.JSON(resp)
+}
+
+// HandleBookRoomType books a room of the type without picking which one. The
+// front desk assigns the room later on.
+func (h *RoomTypeHandler) HandleBookRoomType(c *fiber.Ctx) error {
+	var params types.BookParams
+	if err := c.BodyParser(&params); err != nil {
+		return errors.ErrBadRequest()
+	}
+	roomType, err := getRoomType(c.Context(), h.store.RoomType, c.Params("id"))
+	if err != nil {
+		return err
+	}
+	currency, err := requestedCurrency(c)
+	if err != nil {
+		return err
+	}
+	user, ok := c.Context().Value("user").(*types.User)
+	if !ok {
+		return fmt.Errorf("authorization problems")
+	}
+	booking := newStayBooking(params, user, types.StatusConfirmed)
+	booking.RoomTypeID = roomType.ID
+	terms, err := getSaleTerms(c.Context(), h.store, booking)
+	if err != nil {
+		return err
+	}
+	if err := chargeIn(c.Context(), h.store, terms, booking, currency); err != nil {
+		return err
+	}
+	if params.PromoCode != "" {
+		if err := applyPromoCode(c.Context(), h.store, booking, terms.hotel, params.PromoCode, user); err != nil {
+			return err
+		}
+	}
+	if err := terms.price(booking); err != nil {
+		return err
+	}
+	booking.CancellationPolicy = terms.policy
+	booking.PaymentSchedule = terms.rates.PaymentSchedule
+	inserted, err := bookAndCharge(c.Context(), h.store, h.payments, booking)
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusCreated).JSON(inserted)
+}
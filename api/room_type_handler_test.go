@@ -0,0 +1,106 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kmogilevskii/hotel-reservation/db"
+	"github.com/kmogilevskii/hotel-reservation/db/fixtures"
+	"github.com/kmogilevskii/hotel-reservation/errors"
+	"github.com/kmogilevskii/hotel-reservation/types"
+)
+
+func TestBookByRoomType(t *testing.T) {
+	tdb := setup(t)
+	var (
+		admin           = fixtures.AddUser(tdb.store.User, "admin", "admin", true)
+		user            = fixtures.AddUser(tdb.store.User, "foo", "bar", false)
+		hotel           = fixtures.AddHotel(tdb.store.Hotel, "Hilton", "New York", 5)
+		app             = fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
+		roomHandler     = NewRoomHandler(tdb.store, tdb.payments)
+		roomTypeHandler = NewRoomTypeHandler(tdb.store, tdb.payments)
+		bookingHandler  = NewBookingHandler(tdb.store, tdb.payments, tdb.notifier)
+	)
+	apiv1 := app.Group("/api", JWTAuthentication(tdb.store.User))
+	apiv1.Get("/hotel/:id/room-types", roomTypeHandler.HandleGetRoomTypes)
+	apiv1.Post("/room/:id/book", roomHandler.HandleBookRoom)
+	apiv1.Post("/room-type/:id/book", roomTypeHandler.HandleBookRoomType)
+	adminGroup := app.Group("/admin", JWTAuthentication(tdb.store.User), AdminAuth)
+	adminGroup.Post("/room", roomHandler.HandlePostRoom)
+	adminGroup.Post("/room-type", roomTypeHandler.HandlePostRoomType)
+	adminGroup.Post("/booking/:id/assign", bookingHandler.HandleAssignRoom)
+	adminGroup.Post("/booking/:id/check-in", bookingHandler.HandleUpdateStatus(types.StatusCheckedIn))
+
+	send := newSender(t, app)
+
+	var roomType types.RoomType
+	params := types.CreateRoomTypeParams{HotelID: hotel.ID.Hex(), Name: "Double, seaside", Size: "Double", Seaside: true, Price: 150, MaxAdults: 2}
+	if code := send("POST", "/admin/room-type", params, admin, &roomType).StatusCode; code != http.StatusCreated {
+		t.Fatalf("expected status code 201, got %d", code)
+	}
+	rooms := make([]types.Room, 2)
+	for i := range rooms {
+		params := types.CreateRoomParams{HotelID: hotel.ID.Hex(), RoomTypeID: roomType.ID.Hex(), Size: "Double", Seaside: true, Price: 150}
+		if code := send("POST", "/admin/room", params, admin, &rooms[i]).StatusCode; code != http.StatusCreated {
+			t.Fatalf("expected status code 201, got %d", code)
+		}
+	}
+
+	var (
+		from = time.Now().AddDate(0, 0, 3)
+		till = time.Now().AddDate(0, 0, 5)
+		stay = types.BookParams{FromDate: types.Date{Time: from}, TillDate: types.Date{Time: till}, NumPersons: 2}
+	)
+	var listed []RoomTypeAvailability
+	target := fmt.Sprintf("/api/hotel/%s/room-types?from=%s&till=%s", hotel.ID.Hex(), from.Format(time.DateOnly), till.Format(time.DateOnly))
+	send("GET", target, nil, user, &db.ResourceResponse{Data: &listed})
+	if len(listed) != 1 || listed[0].Available == nil || *listed[0].Available != 2 {
+		t.Fatalf("expected 2 rooms of the type to be available, got %+v", listed)
+	}
+
+	if code := send("POST", fmt.Sprintf("/api/room-type/%s/book", roomType.ID.Hex()), types.BookParams{FromDate: types.Date{Time: from}, TillDate: types.Date{Time: till}, NumPersons: 3}, user, nil).StatusCode; code != http.StatusBadRequest {
+		t.Fatalf("expected status code 400 for more guests than the type sleeps, got %d", code)
+	}
+	bookings := make([]types.Booking, 2)
+	for i := range bookings {
+		if code := send("POST", fmt.Sprintf("/api/room-type/%s/book", roomType.ID.Hex()), stay, user, &bookings[i]).StatusCode; code != http.StatusCreated {
+			t.Fatalf("expected status code 201, got %d", code)
+		}
+		if !bookings[i].RoomID.IsZero() || bookings[i].Price == nil || bookings[i].Price.Total.Amount != 30000 {
+			t.Fatalf("expected an unassigned booking priced by the type, got %+v", bookings[i])
+		}
+	}
+	if code := send("POST", fmt.Sprintf("/api/room-type/%s/book", roomType.ID.Hex()), stay, user, nil).StatusCode; code != http.StatusConflict {
+		t.Fatalf("expected status code 409 once the type is sold out, got %d", code)
+	}
+	if code := send("POST", fmt.Sprintf("/api/room/%s/book", rooms[0].ID.Hex()), stay, user, nil).StatusCode; code != http.StatusConflict {
+		t.Fatalf("expected status code 409 booking a room of a sold out type directly, got %d", code)
+	}
+
+	checkIn := fmt.Sprintf("/admin/booking/%s/check-in", bookings[0].ID.Hex())
+	if code := send("POST", checkIn, nil, admin, nil).StatusCode; code != http.StatusConflict {
+		t.Fatalf("expected status code 409 checking in without a room, got %d", code)
+	}
+	assign := func(booking types.Booking, room types.Room) int {
+		return send("POST", fmt.Sprintf("/admin/booking/%s/assign", booking.ID.Hex()), types.AssignRoomParams{RoomID: room.ID.Hex()}, admin, nil).StatusCode
+	}
+	if code := assign(bookings[0], rooms[0]); code != http.StatusOK {
+		t.Fatalf("expected status code 200, got %d", code)
+	}
+	if code := assign(bookings[1], rooms[0]); code != http.StatusConflict {
+		t.Fatalf("expected status code 409 assigning an occupied room, got %d", code)
+	}
+	if code := assign(bookings[1], rooms[1]); code != http.StatusOK {
+		t.Fatalf("expected status code 200, got %d", code)
+	}
+	if code := assign(bookings[0], rooms[1]); code != http.StatusConflict {
+		t.Fatalf("expected status code 409 reassigning into an occupied room, got %d", code)
+	}
+	other := fixtures.AddRoom(tdb.store.Room, hotel.ID, "Double", 150)
+	if code := assign(bookings[0], *other); code != http.StatusBadRequest {
+		t.Fatalf("expected status code 400 assigning a room of another type, got %d", code)
+	}
+}
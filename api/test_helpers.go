@@ -1,52 +1,69 @@
 package api
 
 import (
-	"context"
-	"log"
-	"os"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
-	"github.com/joho/godotenv"
+	"github.com/gofiber/fiber/v2"
 	"github.com/kmogilevskii/hotel-reservation/db"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"github.com/kmogilevskii/hotel-reservation/db/memory"
+	"github.com/kmogilevskii/hotel-reservation/notify"
+	"github.com/kmogilevskii/hotel-reservation/payments"
+	"github.com/kmogilevskii/hotel-reservation/types"
 )
 
 type testdb struct {
-	store  *db.Store
-	client *mongo.Client
-}
-
-func (tdb *testdb) teardown(t *testing.T) {
-	DBNAME := os.Getenv(db.MONGO_DBNAME_ENV_VARIABLE_NAME)
-	if err := tdb.client.Database(DBNAME).Drop(context.TODO()); err != nil {
-		t.Fatal(err)
-	}
+	store    *db.Store
+	payments *payments.Fake
+	notifier *notify.Fake
 }
 
 func setup(t *testing.T) *testdb {
-	if err := godotenv.Load("../.env"); err != nil {
-		log.Fatal("Error loading .env file")
-	}
-	MONGO_URI := os.Getenv("MONGO_URI")
-	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(MONGO_URI))
+	// tokens only have to round trip within the test
+	t.Setenv("JWT_SECRET", "test-secret")
 
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	hotelStore := db.NewMongoHotelStore(client)
-	roomStore := db.NewMongoRoomStore(client, hotelStore)
-	userStore := db.NewMongoUserStore(client)
-	bookingStore := db.NewMongoBookingStore(client)
+	hotelStore := memory.NewHotelStore()
+	roomStore := memory.NewRoomStore(hotelStore)
+	userStore := memory.NewUserStore()
+	roomTypeStore := memory.NewRoomTypeStore(hotelStore)
+	bookingStore := memory.NewBookingStore(roomStore, roomTypeStore, hotelStore)
 
 	return &testdb{
 		store: &db.Store{
-			User:    userStore,
-			Hotel:   hotelStore,
-			Room:    roomStore,
-			Booking: bookingStore,
+			User:         userStore,
+			Hotel:        hotelStore,
+			Room:         roomStore,
+			RoomType:     roomTypeStore,
+			Booking:      bookingStore,
+			Reservation:  memory.NewReservationStore(),
+			PromoCode:    memory.NewPromoCodeStore(),
+			Invoice:      memory.NewInvoiceStore(),
+			ExchangeRate: memory.NewExchangeRateStore(),
+			Waitlist:     memory.NewWaitlistStore(),
 		},
-		client: client,
+		payments: payments.NewFake(),
+		notifier: notify.NewFake(),
+	}
+}
+
+// newSender returns a function sending the body as JSON to the app on behalf
+// of the user. It decodes the response into out unless out is nil.
+func newSender(t *testing.T, app *fiber.App) func(method, target string, body any, as *types.User, out any) *http.Response {
+	return func(method, target string, body any, as *types.User, out any) *http.Response {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, target, bytes.NewReader(b))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Api-Token", CreateTokenFromUser(as))
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != nil {
+			json.NewDecoder(resp.Body).Decode(out)
+		}
+		return resp
 	}
 }
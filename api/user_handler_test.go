@@ -17,7 +17,6 @@ var config = fiber.Config{
 
 func TestPostUser(t *testing.T) {
 	tdb := setup(t)
-	defer tdb.teardown(t)
 
 	app := fiber.New(config)
 	userHandler := NewUserHandler(tdb.store.User)
@@ -0,0 +1,327 @@
+package api
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kmogilevskii/hotel-reservation/db"
+	"github.com/kmogilevskii/hotel-reservation/errors"
+	"github.com/kmogilevskii/hotel-reservation/notify"
+	"github.com/kmogilevskii/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// waitlistHoldTTL is how long a guest offered a hold from the waitlist has
+// to confirm it. Unlike holdTTL it has to leave time for the guest to learn
+// about the hold.
+const waitlistHoldTTL = 24 * time.Hour
+
+// WaitlistHandler manages guests waiting for sold-out stays. Whatever frees
+// dates offers them to the guests, see releaseStay.
+type WaitlistHandler struct {
+	store *db.Store
+}
+
+func NewWaitlistHandler(store *db.Store) *WaitlistHandler {
+	return &WaitlistHandler{store: store}
+}
+
+// HandleJoinRoomWaitlist puts the current user on the waitlist for a stay
+// the room is booked for.
+func (h *WaitlistHandler) HandleJoinRoomWaitlist(c *fiber.Ctx) error {
+	var params types.BookParams
+	if err := c.BodyParser(&params); err != nil {
+		return errors.ErrBadRequest()
+	}
+	room, err := getRoom(c.Context(), h.store.Room, c.Params("id"))
+	if err != nil {
+		return err
+	}
+	return h.join(c, params, &types.WaitlistEntry{RoomID: room.ID, RoomTypeID: room.RoomTypeID})
+}
+
+// HandleJoinRoomTypeWaitlist puts the current user on the waitlist for a
+// stay the room type is sold out for.
+func (h *WaitlistHandler) HandleJoinRoomTypeWaitlist(c *fiber.Ctx) error {
+	var params types.BookParams
+	if err := c.BodyParser(&params); err != nil {
+		return errors.ErrBadRequest()
+	}
+	roomType, err := getRoomType(c.Context(), h.store.RoomType, c.Params("id"))
+	if err != nil {
+		return err
+	}
+	return h.join(c, params, &types.WaitlistEntry{RoomTypeID: roomType.ID})
+}
+
+// join adds the entry for the stay if it could be booked but for the dates
+// being taken.
+func (h *WaitlistHandler) join(c *fiber.Ctx, params types.BookParams, entry *types.WaitlistEntry) error {
+	user, ok := c.Context().Value("user").(*types.User)
+	if !ok {
+		return fmt.Errorf("authorization problems")
+	}
+	booking := newStayBooking(params, user, types.StatusPending)
+	booking.RoomID, booking.RoomTypeID = entry.RoomID, entry.RoomTypeID
+	terms, err := getSaleTerms(c.Context(), h.store, booking)
+	if err != nil {
+		return err
+	}
+	if err := terms.price(booking); err != nil {
+		return err
+	}
+	free, err := isStayFree(c.Context(), h.store.Booking, booking, time.Now())
+	if err != nil {
+		return err
+	}
+	if free {
+		return errors.NewError(http.StatusConflict, "stay is available, book it instead")
+	}
+	filter := waitlistFor(entry)
+	filter["userID"] = user.ID
+	filter["status"] = types.WaitlistWaiting
+	filter["fromDate"] = booking.FromDate
+	filter["tillDate"] = booking.TillDate
+	waiting, err := h.store.Waitlist.GetWaitlist(c.Context(), filter)
+	if err != nil {
+		return err
+	}
+	if len(waiting) > 0 {
+		return errors.NewError(http.StatusConflict, "already waiting for this stay")
+	}
+	entry.ID = primitive.NewObjectID()
+	entry.UserID = user.ID
+	entry.HotelID = terms.hotel.ID
+	entry.FromDate, entry.TillDate = booking.FromDate, booking.TillDate
+	entry.NumPersons, entry.NumChildren = booking.NumPersons, booking.NumChildren
+	entry.Status = types.WaitlistWaiting
+	entry.CreatedAt = time.Now()
+	inserted, err := h.store.Waitlist.InsertWaitlistEntry(c.Context(), entry)
+	if err != nil {
+		return err
+	}
+	return c.Status(http.StatusCreated).JSON(inserted)
+}
+
+// waitlistFor matches the entries waiting for the same room as the entry,
+// or for the same room type when it doesn't ask for a room.
+func waitlistFor(entry *types.WaitlistEntry) db.Map {
+	if !entry.RoomID.IsZero() {
+		return db.Map{"roomID": entry.RoomID}
+	}
+	return db.Map{"roomID": db.Map{"$exists": false}, "roomTypeID": entry.RoomTypeID}
+}
+
+// isStayFree reports whether the booking's room, or a room of its room type
+// when no room was picked, is free for the whole stay.
+func isStayFree(ctx context.Context, store db.BookingStore, booking *types.Booking, now time.Time) (bool, error) {
+	if !booking.RoomID.IsZero() {
+		return store.IsRoomAvailable(ctx, booking.RoomID, booking.FromDate, booking.TillDate, now)
+	}
+	available, err := store.RoomTypeAvailability(ctx, booking.RoomTypeID, booking.FromDate, booking.TillDate, now)
+	return available > 0, err
+}
+
+// HandleGetWaitlist lists the current user's waitlist entries.
+func (h *WaitlistHandler) HandleGetWaitlist(c *fiber.Ctx) error {
+	user, ok := c.Context().Value("user").(*types.User)
+	if !ok {
+		return fmt.Errorf("authorization problems")
+	}
+	entries, err := h.store.Waitlist.GetWaitlist(c.Context(), db.Map{"userID": user.ID})
+	if err != nil {
+		return err
+	}
+	resp := db.ResourceResponse{
+		Results: len(entries),
+		Data:    entries,
+	}
+	return c.JSON(resp)
+}
+
+// HandleLeaveWaitlist takes an entry that is still waiting off the waitlist.
+func (h *WaitlistHandler) HandleLeaveWaitlist(c *fiber.Ctx) error {
+	id := c.Params("id")
+	entry, err := h.store.Waitlist.GetWaitlistEntryByID(c.Context(), id)
+	if err != nil {
+		if stderrors.Is(err, mongo.ErrNoDocuments) {
+			return errors.ErrResourceNotFound("waitlist entry")
+		}
+		return err
+	}
+	user, ok := c.Context().Value("user").(*types.User)
+	if !ok {
+		return fmt.Errorf("authorization problems")
+	}
+	if entry.UserID != user.ID && !user.IsAdmin {
+		return c.Status(http.StatusUnauthorized).JSON(genericResp{
+			Type: "error",
+			Msg:  "unauthorized",
+		})
+	}
+	if err := h.store.Waitlist.LeaveWaitlist(c.Context(), id); err != nil {
+		return err
+	}
+	return c.JSON(genericResp{
+		Type: "success",
+		Msg:  "left the waitlist",
+	})
+}
+
+// HandleGetWaitlistDepth counts the guests waiting for upcoming stays per
+// hotel, the hotels with the most guests waiting first.
+func (h *WaitlistHandler) HandleGetWaitlistDepth(c *fiber.Ctx) error {
+	depths, err := h.store.Waitlist.WaitlistDepth(c.Context(), time.Now())
+	if err != nil {
+		return err
+	}
+	resp := db.ResourceResponse{
+		Results: len(depths),
+		Data:    depths,
+	}
+	return c.JSON(resp)
+}
+
+// releaseStay offers the dates the booking no longer takes up, be it
+// canceled, expired, moved or a removed block, to the waitlist. The dates
+// are free either way, so a guest on the waitlist missing out on them
+// mustn't fail whatever freed them and is only logged.
+func releaseStay(ctx context.Context, store *db.Store, notifier notify.Notifier, freed *types.Booking, now time.Time) {
+	if _, err := offerWaitlistHold(ctx, store, notifier, freed, now); err != nil {
+		log.Printf("offering the dates of booking %s to the waitlist: %v", freed.ID.Hex(), err)
+	}
+}
+
+// offerWaitlistHold gives the dates the canceled booking freed to the guest
+// waiting longest for a stay that now fits, as a hold they are notified
+// about. Entries whose stay still can't be booked keep waiting. Expired holds
+// free their dates the same way. It returns the hold, nil if nobody got one.
+func offerWaitlistHold(ctx context.Context, store *db.Store, notifier notify.Notifier, canceled *types.Booking, now time.Time) (*types.Booking, error) {
+	entries, err := store.Waitlist.GetWaitlist(ctx, db.FreedStayFilter(canceled, now))
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		user, err := store.User.GetUserByID(ctx, entry.UserID.Hex())
+		if err != nil {
+			return nil, err
+		}
+		hold, err := holdWaitlistedStay(ctx, store, entry, user, now)
+		// the stay is still taken, or can't be booked for another reason
+		var apiErr errors.Error
+		if stderrors.As(err, &apiErr) && (apiErr.Code == http.StatusBadRequest || apiErr.Code == http.StatusConflict) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := store.Waitlist.OfferWaitlistHold(ctx, entry.ID.Hex(), hold.ID, now); err != nil {
+			// the guest left or got a hold from another cancellation
+			// in the meantime
+			change := types.StatusChange{Status: types.StatusCanceled, At: now}
+			if releaseErr := store.Booking.UpdateBookingStatus(ctx, hold.ID.Hex(), types.StatusPending, change); releaseErr != nil {
+				return nil, releaseErr
+			}
+			if stderrors.Is(err, errors.ErrNotWaiting()) {
+				continue
+			}
+			return nil, err
+		}
+		return hold, notifyWaitlistHold(ctx, store, notifier, entry, user, hold, now)
+	}
+	return nil, nil
+}
+
+// notifyWaitlistHold tells the guest about the hold their entry was offered.
+// Should that fail, the hold stays theirs and RetryWaitlistNotifications
+// tries again.
+func notifyWaitlistHold(ctx context.Context, store *db.Store, notifier notify.Notifier, entry *types.WaitlistEntry, user *types.User, hold *types.Booking, now time.Time) error {
+	// the hold's dates are in the hotel's timezone
+	zone := hold.FromDate.Location()
+	err := notifier.Notify(ctx, notify.Message{
+		UserID:    user.ID,
+		Email:     user.Email,
+		BookingID: hold.ID,
+		Subject:   "Your waitlisted stay is available",
+		Body: fmt.Sprintf("We are holding your stay from %s to %s until %s. Confirm the hold to book it.",
+			hold.FromDate.Format(time.DateOnly), hold.TillDate.Format(time.DateOnly),
+			hold.HoldExpiresAt.In(zone).Format(time.DateTime)),
+	})
+	if err != nil {
+		return err
+	}
+	return store.Waitlist.MarkWaitlistNotified(ctx, entry.ID.Hex(), now)
+}
+
+// RetryWaitlistNotifications tells the guests offered a hold before the
+// given time about it, where that failed when it was offered. Holds that
+// are no longer pending are left alone, guests failing to be notified again
+// are logged and left for the next run. It returns how many were notified.
+func RetryWaitlistNotifications(ctx context.Context, store *db.Store, notifier notify.Notifier, before, now time.Time) (int, error) {
+	entries, err := store.Waitlist.GetWaitlist(ctx, db.UnnotifiedOffersFilter(before))
+	if err != nil {
+		return 0, err
+	}
+	notified := 0
+	for _, entry := range entries {
+		hold, err := store.Booking.GetBookingByID(ctx, entry.HoldID.Hex())
+		if err != nil {
+			return notified, err
+		}
+		if hold.Status != types.StatusPending || !now.Before(*hold.HoldExpiresAt) {
+			continue
+		}
+		user, err := store.User.GetUserByID(ctx, entry.UserID.Hex())
+		if err != nil {
+			return notified, err
+		}
+		// the hold's dates come back in UTC from the store
+		hotel, err := store.Hotel.GetHotelByID(ctx, entry.HotelID.Hex())
+		if err != nil {
+			return notified, err
+		}
+		zone := hotel.Zone()
+		hold.FromDate, hold.TillDate = hold.FromDate.In(zone), hold.TillDate.In(zone)
+		if err := notifyWaitlistHold(ctx, store, notifier, entry, user, hold, now); err != nil {
+			log.Printf("notifying the guest of waitlist entry %s: %v", entry.ID.Hex(), err)
+			continue
+		}
+		notified++
+	}
+	return notified, nil
+}
+
+// holdWaitlistedStay books the entry's stay as a pending hold, priced like a
+// booking made now. The hold has to be confirmed before it expires, at the
+// latest by check-in.
+func holdWaitlistedStay(ctx context.Context, store *db.Store, entry *types.WaitlistEntry, user *types.User, now time.Time) (*types.Booking, error) {
+	hold := newStayBooking(entry.Stay(), user, types.StatusPending)
+	// the system holds the stay, not the guest
+	hold.StatusHistory[0].By = primitive.NilObjectID
+	hold.StatusHistory[0].At = now
+	hold.RoomID, hold.RoomTypeID = entry.RoomID, entry.RoomTypeID
+	terms, err := getSaleTerms(ctx, store, hold)
+	if err != nil {
+		return nil, err
+	}
+	// stays are priced by the hotel's calendar dates
+	zone := terms.hotel.Zone()
+	hold.FromDate, hold.TillDate = hold.FromDate.In(zone), hold.TillDate.In(zone)
+	if err := terms.price(hold); err != nil {
+		return nil, err
+	}
+	hold.CancellationPolicy = terms.policy
+	hold.PaymentSchedule = terms.rates.PaymentSchedule
+	expiresAt := now.Add(waitlistHoldTTL)
+	if hold.FromDate.Before(expiresAt) {
+		expiresAt = hold.FromDate
+	}
+	hold.HoldExpiresAt = &expiresAt
+	return store.Booking.BookRoom(ctx, hold, now)
+}
@@ -0,0 +1,328 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kmogilevskii/hotel-reservation/db"
+	"github.com/kmogilevskii/hotel-reservation/db/fixtures"
+	"github.com/kmogilevskii/hotel-reservation/errors"
+	"github.com/kmogilevskii/hotel-reservation/types"
+)
+
+func TestWaitlist(t *testing.T) {
+	tdb := setup(t)
+	var (
+		admin           = fixtures.AddUser(tdb.store.User, "admin", "admin", true)
+		alice           = fixtures.AddUser(tdb.store.User, "alice", "a", false)
+		bob             = fixtures.AddUser(tdb.store.User, "bob", "b", false)
+		carol           = fixtures.AddUser(tdb.store.User, "carol", "c", false)
+		eve             = fixtures.AddUser(tdb.store.User, "eve", "e", false)
+		hotel           = fixtures.AddHotel(tdb.store.Hotel, "Hilton", "New York", 5)
+		room            = fixtures.AddRoom(tdb.store.Room, hotel.ID, "Single", 100)
+		app             = fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
+		roomHandler     = NewRoomHandler(tdb.store, tdb.payments)
+		roomTypeHandler = NewRoomTypeHandler(tdb.store, tdb.payments)
+		bookingHandler  = NewBookingHandler(tdb.store, tdb.payments, tdb.notifier)
+		waitlistHandler = NewWaitlistHandler(tdb.store)
+	)
+	apiv1 := app.Group("/api", JWTAuthentication(tdb.store.User))
+	apiv1.Post("/room/:id/book", roomHandler.HandleBookRoom)
+	apiv1.Post("/room-type/:id/book", roomTypeHandler.HandleBookRoomType)
+	apiv1.Post("/room/:id/waitlist", waitlistHandler.HandleJoinRoomWaitlist)
+	apiv1.Post("/room-type/:id/waitlist", waitlistHandler.HandleJoinRoomTypeWaitlist)
+	apiv1.Get("/waitlist", waitlistHandler.HandleGetWaitlist)
+	apiv1.Delete("/waitlist/:id", waitlistHandler.HandleLeaveWaitlist)
+	apiv1.Get("/booking/:id", bookingHandler.HandleGetBooking)
+	apiv1.Delete("/booking/:id", bookingHandler.HandleCancelBooking)
+	apiv1.Post("/booking/:id/confirm", bookingHandler.HandleConfirmHold)
+	adminGroup := app.Group("/admin", JWTAuthentication(tdb.store.User), AdminAuth)
+	adminGroup.Post("/room", roomHandler.HandlePostRoom)
+	adminGroup.Post("/room-type", roomTypeHandler.HandlePostRoomType)
+	adminGroup.Get("/waitlist", waitlistHandler.HandleGetWaitlistDepth)
+
+	send := newSender(t, app)
+	stay := func(from, till int) types.BookParams {
+		return types.BookParams{FromDate: types.Date{Time: time.Now().AddDate(0, 0, from)}, TillDate: types.Date{Time: time.Now().AddDate(0, 0, till)}, NumPersons: 1}
+	}
+	depth := func() []types.WaitlistDepth {
+		var depths []types.WaitlistDepth
+		if code := send("GET", "/admin/waitlist", nil, admin, &db.ResourceResponse{Data: &depths}).StatusCode; code != http.StatusOK {
+			t.Fatalf("expected status code 200, got %d", code)
+		}
+		return depths
+	}
+	bookRoom := fmt.Sprintf("/api/room/%s/book", room.ID.Hex())
+	roomWaitlist := fmt.Sprintf("/api/room/%s/waitlist", room.ID.Hex())
+
+	var booked types.Booking
+	if code := send("POST", bookRoom, stay(3, 5), alice, &booked).StatusCode; code != http.StatusCreated {
+		t.Fatalf("expected status code 201, got %d", code)
+	}
+	if code := send("POST", bookRoom, stay(5, 6), eve, nil).StatusCode; code != http.StatusCreated {
+		t.Fatalf("expected status code 201, got %d", code)
+	}
+
+	// bob waits longest, but the room stays booked on his last night
+	var bobs, carols types.WaitlistEntry
+	if code := send("POST", roomWaitlist, stay(3, 6), bob, &bobs).StatusCode; code != http.StatusCreated {
+		t.Fatalf("expected status code 201, got %d", code)
+	}
+	if bobs.Status != types.WaitlistWaiting || bobs.HotelID != hotel.ID || !bobs.FromDate.Equal(hotel.CheckInAt(stay(3, 6).FromDate.Time)) {
+		t.Fatalf("expected bob to wait for the stay at the hotel, got %+v", bobs)
+	}
+	if code := send("POST", roomWaitlist, stay(4, 5), carol, &carols).StatusCode; code != http.StatusCreated {
+		t.Fatalf("expected status code 201, got %d", code)
+	}
+	tests := []struct {
+		name   string
+		params types.BookParams
+		code   int
+		msg    string
+	}{
+		{"twice", stay(3, 6), http.StatusConflict, "already waiting for this stay"},
+		{"free dates", stay(10, 12), http.StatusConflict, "stay is available, book it instead"},
+		{"past", stay(-3, -1), http.StatusBadRequest, "cannot book in the past"},
+	}
+	for _, tt := range tests {
+		var errResp errors.Error
+		if code := send("POST", roomWaitlist, tt.params, bob, &errResp).StatusCode; code != tt.code || errResp.Err != tt.msg {
+			t.Fatalf("%s: expected %d %q, got %d %q", tt.name, tt.code, tt.msg, code, errResp.Err)
+		}
+	}
+	if depths := depth(); len(depths) != 1 || depths[0].HotelID != hotel.ID || depths[0].Waiting != 2 {
+		t.Fatalf("expected 2 guests waiting at the hotel, got %+v", depths)
+	}
+
+	if code := send("DELETE", "/api/booking/"+booked.ID.Hex(), nil, alice, nil).StatusCode; code != http.StatusOK {
+		t.Fatalf("expected status code 200, got %d", code)
+	}
+	sent := tdb.notifier.Sent()
+	if len(sent) != 1 || sent[0].UserID != carol.ID || sent[0].Email != carol.Email {
+		t.Fatalf("expected carol to be notified, got %+v", sent)
+	}
+	var listed []types.WaitlistEntry
+	send("GET", "/api/waitlist", nil, carol, &db.ResourceResponse{Data: &listed})
+	if len(listed) != 1 || listed[0].Status != types.WaitlistOffered || listed[0].HoldID != sent[0].BookingID {
+		t.Fatalf("expected carol's entry to be offered the hold, got %+v", listed)
+	}
+	var hold types.Booking
+	send("GET", "/api/booking/"+sent[0].BookingID.Hex(), nil, carol, &hold)
+	if hold.Status != types.StatusPending || hold.RoomID != room.ID || hold.UserID != carol.ID || hold.Price.Total.Amount != 10000 {
+		t.Fatalf("expected carol to hold a night in the room, got %+v", hold)
+	}
+	if hold.HoldExpiresAt == nil || time.Until(*hold.HoldExpiresAt) > waitlistHoldTTL {
+		t.Fatalf("expected the hold to expire within %s, got %v", waitlistHoldTTL, hold.HoldExpiresAt)
+	}
+	if code := send("POST", bookRoom, stay(4, 5), eve, nil).StatusCode; code != http.StatusConflict {
+		t.Fatalf("expected the held night not to be bookable, got %d", code)
+	}
+	if code := send("POST", "/api/booking/"+hold.ID.Hex()+"/confirm", nil, carol, &hold).StatusCode; code != http.StatusOK || hold.Status != types.StatusConfirmed {
+		t.Fatalf("expected carol to confirm the hold, got %d %s", code, hold.Status)
+	}
+	if depths := depth(); len(depths) != 1 || depths[0].Waiting != 1 {
+		t.Fatalf("expected bob to still be waiting, got %+v", depths)
+	}
+
+	if code := send("DELETE", "/api/waitlist/"+bobs.ID.Hex(), nil, carol, nil).StatusCode; code != http.StatusUnauthorized {
+		t.Fatalf("expected status code 401, got %d", code)
+	}
+	if code := send("DELETE", "/api/waitlist/"+bobs.ID.Hex(), nil, bob, nil).StatusCode; code != http.StatusOK {
+		t.Fatalf("expected status code 200, got %d", code)
+	}
+	var errResp errors.Error
+	if code := send("DELETE", "/api/waitlist/"+bobs.ID.Hex(), nil, bob, &errResp).StatusCode; code != http.StatusConflict || errResp.Err != errors.ErrNotWaiting().Err {
+		t.Fatalf("expected status code 409, got %d %q", code, errResp.Err)
+	}
+	if depths := depth(); len(depths) != 0 {
+		t.Fatalf("expected nobody to be waiting, got %+v", depths)
+	}
+
+	// a room of the type freeing up goes to those waiting for the type
+	var roomType types.RoomType
+	typeParams := types.CreateRoomTypeParams{HotelID: hotel.ID.Hex(), Name: "Double", Size: "Double", Price: 150, MaxAdults: 2}
+	if code := send("POST", "/admin/room-type", typeParams, admin, &roomType).StatusCode; code != http.StatusCreated {
+		t.Fatalf("expected status code 201, got %d", code)
+	}
+	var typed types.Room
+	roomParams := types.CreateRoomParams{HotelID: hotel.ID.Hex(), RoomTypeID: roomType.ID.Hex(), Size: "Double", Price: 150}
+	if code := send("POST", "/admin/room", roomParams, admin, &typed).StatusCode; code != http.StatusCreated {
+		t.Fatalf("expected status code 201, got %d", code)
+	}
+	if code := send("POST", fmt.Sprintf("/api/room/%s/book", typed.ID.Hex()), stay(3, 5), alice, &booked).StatusCode; code != http.StatusCreated {
+		t.Fatalf("expected status code 201, got %d", code)
+	}
+	var typeEntry types.WaitlistEntry
+	if code := send("POST", fmt.Sprintf("/api/room-type/%s/waitlist", roomType.ID.Hex()), stay(3, 5), bob, &typeEntry).StatusCode; code != http.StatusCreated {
+		t.Fatalf("expected status code 201, got %d", code)
+	}
+	if !typeEntry.RoomID.IsZero() || typeEntry.RoomTypeID != roomType.ID {
+		t.Fatalf("expected bob to wait for any room of the type, got %+v", typeEntry)
+	}
+	if code := send("DELETE", "/api/booking/"+booked.ID.Hex(), nil, alice, nil).StatusCode; code != http.StatusOK {
+		t.Fatalf("expected status code 200, got %d", code)
+	}
+	sent = tdb.notifier.Sent()
+	if len(sent) != 2 || sent[1].UserID != bob.ID {
+		t.Fatalf("expected bob to be notified, got %+v", sent)
+	}
+	send("GET", "/api/booking/"+sent[1].BookingID.Hex(), nil, bob, &hold)
+	if hold.Status != types.StatusPending || hold.RoomTypeID != roomType.ID || !hold.RoomID.IsZero() {
+		t.Fatalf("expected bob to hold a room of the type, got %+v", hold)
+	}
+}
+
+func TestWaitlistHoldLapses(t *testing.T) {
+	tdb := setup(t)
+	var (
+		alice          = fixtures.AddUser(tdb.store.User, "alice", "a", false)
+		bob            = fixtures.AddUser(tdb.store.User, "bob", "b", false)
+		carol          = fixtures.AddUser(tdb.store.User, "carol", "c", false)
+		hotel          = fixtures.AddHotel(tdb.store.Hotel, "Hilton", "New York", 5)
+		room           = fixtures.AddRoom(tdb.store.Room, hotel.ID, "Single", 100)
+		app            = fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
+		roomHandler    = NewRoomHandler(tdb.store, tdb.payments)
+		bookingHandler = NewBookingHandler(tdb.store, tdb.payments, tdb.notifier)
+		waitlist       = NewWaitlistHandler(tdb.store)
+		ctx            = context.TODO()
+		params         = types.BookParams{FromDate: types.Date{Time: time.Now().AddDate(0, 0, 3)}, TillDate: types.Date{Time: time.Now().AddDate(0, 0, 5)}, NumPersons: 1}
+	)
+	apiv1 := app.Group("/api", JWTAuthentication(tdb.store.User))
+	apiv1.Post("/room/:id/book", roomHandler.HandleBookRoom)
+	apiv1.Post("/room/:id/waitlist", waitlist.HandleJoinRoomWaitlist)
+	apiv1.Delete("/booking/:id", bookingHandler.HandleCancelBooking)
+
+	send := newSender(t, app)
+	var booked types.Booking
+	if code := send("POST", fmt.Sprintf("/api/room/%s/book", room.ID.Hex()), params, alice, &booked).StatusCode; code != http.StatusCreated {
+		t.Fatalf("expected status code 201, got %d", code)
+	}
+	var bobs, carols types.WaitlistEntry
+	send("POST", fmt.Sprintf("/api/room/%s/waitlist", room.ID.Hex()), params, bob, &bobs)
+	send("POST", fmt.Sprintf("/api/room/%s/waitlist", room.ID.Hex()), params, carol, &carols)
+
+	// bob keeps the hold while he can't be told about it
+	tdb.notifier.Fail = true
+	if code := send("DELETE", "/api/booking/"+booked.ID.Hex(), nil, alice, nil).StatusCode; code != http.StatusOK {
+		t.Fatalf("expected status code 200, got %d", code)
+	}
+	entry, _ := tdb.store.Waitlist.GetWaitlistEntryByID(ctx, bobs.ID.Hex())
+	if entry.Status != types.WaitlistOffered || entry.NotifiedAt != nil {
+		t.Fatalf("expected bob to be offered the hold without being notified, got %+v", entry)
+	}
+	later := time.Now().Add(time.Minute)
+	if n, err := RetryWaitlistNotifications(ctx, tdb.store, tdb.notifier, later, later); err != nil || n != 0 {
+		t.Fatalf("expected the retry to fail again, got %d %v", n, err)
+	}
+	tdb.notifier.Fail = false
+	if n, err := RetryWaitlistNotifications(ctx, tdb.store, tdb.notifier, later, later); err != nil || n != 1 {
+		t.Fatalf("expected bob to be notified, got %d %v", n, err)
+	}
+	if n, _ := RetryWaitlistNotifications(ctx, tdb.store, tdb.notifier, later, later); n != 0 {
+		t.Fatalf("expected bob to be notified once, got %d more", n)
+	}
+	sent := tdb.notifier.Sent()
+	if len(sent) != 1 || sent[0].UserID != bob.ID || sent[0].BookingID != entry.HoldID {
+		t.Fatalf("expected bob to be told about his hold, got %+v", sent)
+	}
+
+	// bob lets the hold expire, carol is next
+	expired := time.Now().Add(waitlistHoldTTL + time.Hour)
+	if n, err := ExpireHolds(ctx, tdb.store, tdb.notifier, expired); err != nil || n != 1 {
+		t.Fatalf("expected bob's hold to expire, got %d %v", n, err)
+	}
+	if entry, _ := tdb.store.Waitlist.GetWaitlistEntryByID(ctx, bobs.ID.Hex()); entry.Status != types.WaitlistLapsed {
+		t.Fatalf("expected bob's entry to lapse, got %s", entry.Status)
+	}
+	entry, _ = tdb.store.Waitlist.GetWaitlistEntryByID(ctx, carols.ID.Hex())
+	sent = tdb.notifier.Sent()
+	if entry.Status != types.WaitlistOffered || len(sent) != 2 || sent[1].UserID != carol.ID || sent[1].BookingID != entry.HoldID {
+		t.Fatalf("expected carol to be offered the dates, got %+v %+v", entry, sent)
+	}
+}
+
+func TestWaitlistGetsReleasedDates(t *testing.T) {
+	tdb := setup(t)
+	var (
+		admin              = fixtures.AddUser(tdb.store.User, "admin", "admin", true)
+		alice              = fixtures.AddUser(tdb.store.User, "alice", "a", false)
+		bob                = fixtures.AddUser(tdb.store.User, "bob", "b", false)
+		hotel              = fixtures.AddHotel(tdb.store.Hotel, "Hilton", "New York", 5)
+		moved              = fixtures.AddRoom(tdb.store.Room, hotel.ID, "Single", 100)
+		blocked            = fixtures.AddRoom(tdb.store.Room, hotel.ID, "Single", 100)
+		reserved           = fixtures.AddRoom(tdb.store.Room, hotel.ID, "Single", 100)
+		app                = fiber.New(fiber.Config{ErrorHandler: errors.ErrorHandler})
+		roomHandler        = NewRoomHandler(tdb.store, tdb.payments)
+		bookingHandler     = NewBookingHandler(tdb.store, tdb.payments, tdb.notifier)
+		reservationHandler = NewReservationHandler(tdb.store, tdb.payments, tdb.notifier)
+		blockHandler       = NewBlockHandler(tdb.store, tdb.notifier)
+		waitlist           = NewWaitlistHandler(tdb.store)
+	)
+	apiv1 := app.Group("/api", JWTAuthentication(tdb.store.User))
+	apiv1.Post("/room/:id/book", roomHandler.HandleBookRoom)
+	apiv1.Post("/room/:id/waitlist", waitlist.HandleJoinRoomWaitlist)
+	apiv1.Patch("/booking/:id", bookingHandler.HandleModifyBooking)
+	apiv1.Post("/reservation", reservationHandler.HandlePostReservation)
+	apiv1.Delete("/reservation/:id", reservationHandler.HandleCancelReservation)
+	adminGroup := app.Group("/admin", JWTAuthentication(tdb.store.User), AdminAuth)
+	adminGroup.Post("/room/:id/block", blockHandler.HandlePostBlock)
+	adminGroup.Delete("/block/:id", blockHandler.HandleDeleteBlock)
+
+	send := newSender(t, app)
+	date := func(days int) types.Date { return types.Date{Time: time.Now().AddDate(0, 0, days)} }
+	stay := types.BookParams{FromDate: date(3), TillDate: date(5), NumPersons: 1}
+	wait := func(room *types.Room, params types.BookParams) {
+		if code := send("POST", fmt.Sprintf("/api/room/%s/waitlist", room.ID.Hex()), params, bob, nil).StatusCode; code != http.StatusCreated {
+			t.Fatalf("expected status code 201, got %d", code)
+		}
+	}
+	offered := func(room *types.Room) {
+		t.Helper()
+		sent := tdb.notifier.Sent()
+		if len(sent) == 0 || sent[len(sent)-1].UserID != bob.ID {
+			t.Fatalf("expected bob to be offered a hold, got %+v", sent)
+		}
+		hold, err := tdb.store.Booking.GetBookingByID(context.TODO(), sent[len(sent)-1].BookingID.Hex())
+		if err != nil || hold.RoomID != room.ID || hold.Status != types.StatusPending {
+			t.Fatalf("expected bob to hold the room, got %+v %v", hold, err)
+		}
+	}
+
+	// the nights a modified booking no longer covers
+	var booking types.Booking
+	if code := send("POST", fmt.Sprintf("/api/room/%s/book", moved.ID.Hex()), stay, alice, &booking).StatusCode; code != http.StatusCreated {
+		t.Fatalf("expected status code 201, got %d", code)
+	}
+	wait(moved, types.BookParams{FromDate: date(3), TillDate: date(4), NumPersons: 1})
+	if code := send("PATCH", "/api/booking/"+booking.ID.Hex(), types.ModifyBookingParams{FromDate: date(4), TillDate: date(6)}, alice, nil).StatusCode; code != http.StatusOK {
+		t.Fatalf("expected status code 200, got %d", code)
+	}
+	offered(moved)
+
+	// a removed block
+	var block types.Booking
+	if code := send("POST", fmt.Sprintf("/admin/room/%s/block", blocked.ID.Hex()), types.BlockRoomParams{FromDate: stay.FromDate, TillDate: stay.TillDate, Reason: "painting"}, admin, &block).StatusCode; code != http.StatusCreated {
+		t.Fatalf("expected status code 201, got %d", code)
+	}
+	wait(blocked, stay)
+	if code := send("DELETE", "/admin/block/"+block.ID.Hex(), nil, admin, nil).StatusCode; code != http.StatusOK {
+		t.Fatalf("expected status code 200, got %d", code)
+	}
+	offered(blocked)
+
+	// a canceled reservation
+	var reservation reservationResp
+	group := types.GroupBookParams{FromDate: stay.FromDate, TillDate: stay.TillDate, NumPersons: 1, RoomIDs: []string{reserved.ID.Hex()}}
+	if code := send("POST", "/api/reservation", group, alice, &reservation).StatusCode; code != http.StatusCreated {
+		t.Fatalf("expected status code 201, got %d", code)
+	}
+	wait(reserved, stay)
+	if code := send("DELETE", "/api/reservation/"+reservation.ID.Hex(), nil, alice, nil).StatusCode; code != http.StatusOK {
+		t.Fatalf("expected status code 200, got %d", code)
+	}
+	offered(reserved)
+}
@@ -1,8 +1,11 @@
 package db
 
 import (
+	"bytes"
 	"context"
 	"os"
+	"slices"
+	"time"
 
 	"github.com/kmogilevskii/hotel-reservation/errors"
 	"github.com/kmogilevskii/hotel-reservation/types"
@@ -15,20 +18,197 @@ import (
 type BookingStore interface {
 	Insert(context.Context, *types.Booking) (*types.Booking, error)
 	GetBookings(context.Context, Map, *Pagination) ([]*types.Booking, error)
+	// GetBalances returns a page of the bookings matching the filter ordered
+	// by when their balance is due, earliest first.
+	GetBalances(context.Context, Map, *Pagination) ([]*types.Booking, error)
 	GetBookingByID(context.Context, string) (*types.Booking, error)
-	UpdateBooking(context.Context, string) error
+	// CancelBooking moves an open booking to the canceled status and records
+	// the cancellation on it, along with its payment when there is one so a
+	// refund owed is stored with the cancellation. It returns
+	// errors.ErrStatusChanged when there is no open booking with the given id.
+	CancelBooking(ctx context.Context, id string, cancellation types.Cancellation, payment *types.Payment) error
+	// UpdateBookingStatus moves a booking from one status to another and
+	// appends the change to its history. It returns errors.ErrStatusChanged
+	// when the booking is no longer in the from status.
+	UpdateBookingStatus(ctx context.Context, id string, from types.BookingStatus, change types.StatusChange) error
+	// BookRoom inserts the booking only if its room is free for the whole
+	// stay, otherwise it returns errors.ErrAlreadyBooked. Bookings of a room
+	// type also need a room of the type left every night, otherwise it
+	// returns errors.ErrSoldOut. The checks and the insert happen atomically
+	// with respect to other bookings of the same room or room type. Holds
+	// that expired by now no longer count.
+	BookRoom(ctx context.Context, booking *types.Booking, now time.Time) (*types.Booking, error)
+	// BookRooms inserts all the bookings or, if any of them conflicts, none
+	// of them and returns the error BookRoom would.
+	BookRooms(ctx context.Context, bookings []*types.Booking, now time.Time) ([]*types.Booking, error)
+	// ModifyBooking writes the new dates, guest count, price, discount,
+	// payment and balance of an open booking if its room or room type is
+	// free for the new stay, ignoring the booking itself. It returns the
+	// same errors as BookRoom on conflicts.
+	ModifyBooking(ctx context.Context, booking *types.Booking, now time.Time) error
+	// AssignRoom puts the booking into the given room, or moves it there
+	// from the room it was assigned before. It returns
+	// errors.ErrAlreadyBooked when the room is taken during the stay.
+	AssignRoom(ctx context.Context, id string, roomID primitive.ObjectID, now time.Time) error
+	// RoomTypeAvailability returns how many rooms of the type are free on
+	// every night of the stay.
+	RoomTypeAvailability(ctx context.Context, roomTypeID primitive.ObjectID, from, till, now time.Time) (int, error)
+	// ConfirmHold turns a pending hold into a confirmed booking. It returns
+	// errors.ErrHoldExpired when there is no pending hold with the given id
+	// that is still valid at the time of the change.
+	ConfirmHold(ctx context.Context, id string, change types.StatusChange) error
+	// ExpireHolds moves all pending holds that expired by now to the expired
+	// status and returns them.
+	ExpireHolds(ctx context.Context, now time.Time) ([]*types.Booking, error)
+	// UpdatePayment records the state of the booking's payment and of its
+	// balance.
+	UpdatePayment(ctx context.Context, booking *types.Booking) error
+	// PayBalance records the payment that settled the booking's balance. It
+	// returns errors.ErrStatusChanged when the booking has no unpaid balance
+	// or is no longer active.
+	PayBalance(ctx context.Context, id string, payment *types.Payment, at time.Time) error
+	// MarkBalancesOverdue moves the balances that were due by now and are
+	// still unpaid to the overdue status and returns how many there were.
+	MarkBalancesOverdue(ctx context.Context, now time.Time) (int64, error)
+	IsRoomAvailable(ctx context.Context, roomID primitive.ObjectID, from, till, now time.Time) (bool, error)
+}
+
+// OverlappingBookingsFilter matches the bookings of a room that occupy it
+// during the stay from..till. Stays are half-open intervals [from, till),
+// so a stay ending on the day another one starts doesn't conflict with it.
+// Holds stop occupying the room as soon as they expired by now, even before
+// ExpireHolds gets to them.
+func OverlappingBookingsFilter(roomID primitive.ObjectID, from, till, now time.Time) Map {
+	return overlapping("roomID", roomID, from, till, now)
+}
+
+// OverlappingTypeBookingsFilter matches the bookings of the room type
+// overlapping from..till, assigned to a room or not.
+func OverlappingTypeBookingsFilter(roomTypeID primitive.ObjectID, from, till, now time.Time) Map {
+	return overlapping("roomTypeID", roomTypeID, from, till, now)
+}
+
+func overlapping(key string, id any, from, till, now time.Time) Map {
+	return Map{
+		key:        id,
+		"fromDate": Map{"$lt": till},
+		"tillDate": Map{"$gt": from},
+		"status":   Map{"$nin": types.ReleasedStatuses},
+		"$or": []Map{
+			{"holdExpiresAt": Map{"$exists": false}},
+			{"holdExpiresAt": Map{"$gt": now}},
+		},
+	}
+}
+
+// PeakOccupancy returns the largest number of the bookings staying at the
+// same time during from..till.
+func PeakOccupancy(bookings []*types.Booking, from, till time.Time) int {
+	type event struct {
+		at    time.Time
+		delta int
+	}
+	var events []event
+	for _, b := range bookings {
+		start, end := b.FromDate, b.TillDate
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(till) {
+			end = till
+		}
+		if !start.Before(end) {
+			continue
+		}
+		events = append(events, event{start, 1}, event{end, -1})
+	}
+	// stays are half-open, so a departure frees the room for an arrival at
+	// the same time
+	slices.SortFunc(events, func(a, b event) int {
+		if c := a.at.Compare(b.at); c != 0 {
+			return c
+		}
+		return a.delta - b.delta
+	})
+	var current, peak int
+	for _, e := range events {
+		current += e.delta
+		peak = max(peak, current)
+	}
+	return peak
+}
+
+// ExpiredHoldsFilter matches the pending holds that expired by now.
+func ExpiredHoldsFilter(now time.Time) Map {
+	return Map{
+		"status":        types.StatusPending,
+		"holdExpiresAt": Map{"$lte": now},
+	}
+}
+
+// PendingRefundsFilter matches the bookings owing the guest a refund since
+// before the given time.
+func PendingRefundsFilter(before time.Time) Map {
+	return Map{"payment.pendingRefund.since": Map{"$lt": before}}
+}
+
+// OverdueBalancesFilter matches the bookings whose balance was due by now
+// but hasn't been paid.
+func OverdueBalancesFilter(now time.Time) Map {
+	return Map{
+		"status":         Map{"$in": types.ActiveStatuses},
+		"balance.status": types.BalanceDue,
+		"balance.dueAt":  Map{"$lte": now},
+	}
+}
+
+// UnpaidBalancesToCancelFilter matches the open bookings whose balance is
+// overdue and that are to be canceled for it by now.
+func UnpaidBalancesToCancelFilter(now time.Time) Map {
+	return Map{
+		"status":           Map{"$in": types.OpenStatuses},
+		"balance.status":   types.BalanceOverdue,
+		"balance.cancelAt": Map{"$lte": now},
+	}
+}
+
+// PayBalanceFilter matches the booking with the id if it is active and its
+// balance still unpaid.
+func PayBalanceFilter(oid primitive.ObjectID) Map {
+	return Map{
+		"_id":            oid,
+		"status":         Map{"$in": types.ActiveStatuses},
+		"balance.status": Map{"$in": types.UnpaidBalanceStatuses},
+	}
+}
+
+// PayBalanceUpdate marks the balance of a booking paid with the payment.
+func PayBalanceUpdate(payment *types.Payment, at time.Time) Map {
+	return Map{"$set": Map{
+		"payment":        payment,
+		"balance.status": types.BalancePaid,
+		"balance.paidAt": at,
+	}}
 }
 
 type MongoBookingStore struct {
-	client *mongo.Client
-	coll   *mongo.Collection
+	client    *mongo.Client
+	coll      *mongo.Collection
+	locks     *mongo.Collection
+	inventory Inventory
 }
 
-func NewMongoBookingStore(client *mongo.Client) *MongoBookingStore {
+func NewMongoBookingStore(client *mongo.Client, roomStore RoomStore, roomTypeStore RoomTypeStore, hotelStore HotelStore) *MongoBookingStore {
 	DBNAME := os.Getenv(MONGO_DBNAME_ENV_VARIABLE_NAME)
 	return &MongoBookingStore{
 		client: client,
 		coll:   client.Database(DBNAME).Collection("bookings"),
+		locks:  client.Database(DBNAME).Collection("room_locks"),
+		inventory: Inventory{
+			Rooms:     roomStore,
+			RoomTypes: roomTypeStore,
+			Hotels:    hotelStore,
+		},
 	}
 }
 
@@ -65,6 +245,21 @@ func (m *MongoBookingStore) GetBookings(ctx context.Context, filter Map, pag *Pa
 	return bookings, nil
 }
 
+func (m *MongoBookingStore) GetBalances(ctx context.Context, filter Map, pag *Pagination) ([]*types.Booking, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "balance.dueAt", Value: 1}, {Key: "_id", Value: 1}})
+	opts.SetSkip(int64(pag.Page-1) * pag.Limit)
+	opts.SetLimit(int64(pag.Limit))
+	cursor, err := m.coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	bookings := []*types.Booking{}
+	if err := cursor.All(ctx, &bookings); err != nil {
+		return nil, err
+	}
+	return bookings, nil
+}
+
 func (m *MongoBookingStore) GetBookingByID(ctx context.Context, id string) (*types.Booking, error) {
 	oid, err := primitive.ObjectIDFromHex(id)
 
@@ -79,16 +274,318 @@ func (m *MongoBookingStore) GetBookingByID(ctx context.Context, id string) (*typ
 	return &booking, nil
 }
 
-func (m *MongoBookingStore) UpdateBooking(ctx context.Context, id string) error {
+func (m *MongoBookingStore) CancelBooking(ctx context.Context, id string, cancellation types.Cancellation, payment *types.Payment) error {
 	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errors.ErrInvalidID()
+	}
+	change := types.StatusChange{
+		Status: types.StatusCanceled,
+		By:     cancellation.CanceledBy,
+		At:     cancellation.CanceledAt,
+	}
+	filter := bson.M{"_id": oid, "status": bson.M{"$in": types.OpenStatuses}}
+	set := bson.M{"status": types.StatusCanceled, "cancellation": cancellation}
+	if payment != nil {
+		set["payment"] = payment
+	}
+	update := bson.M{
+		"$set":  set,
+		"$push": bson.M{"statusHistory": change},
+	}
+	res, err := m.coll.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return errors.ErrStatusChanged()
+	}
+	return nil
+}
 
+func (m *MongoBookingStore) UpdateBookingStatus(ctx context.Context, id string, from types.BookingStatus, change types.StatusChange) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errors.ErrInvalidID()
+	}
+	filter := bson.M{"_id": oid, "status": from}
+	update := bson.M{
+		"$set":  bson.M{"status": change.Status},
+		"$push": bson.M{"statusHistory": change},
+	}
+	res, err := m.coll.UpdateOne(ctx, filter, update)
 	if err != nil {
 		return err
 	}
+	if res.MatchedCount == 0 {
+		return errors.ErrStatusChanged()
+	}
+	return nil
+}
 
-	filter := bson.M{"_id": oid}
-	update := bson.M{"$set": bson.M{"canceled": true}}
-	_, err = m.coll.UpdateOne(ctx, filter, update)
-	// _, err = m.coll.UpdateByID(ctx, oid, update)
+func (m *MongoBookingStore) UpdatePayment(ctx context.Context, booking *types.Booking) error {
+	update := bson.M{"$set": bson.M{"payment": booking.Payment, "balance": booking.Balance}}
+	res, err := m.coll.UpdateOne(ctx, bson.M{"_id": booking.ID}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (m *MongoBookingStore) PayBalance(ctx context.Context, id string, payment *types.Payment, at time.Time) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errors.ErrInvalidID()
+	}
+	res, err := m.coll.UpdateOne(ctx, PayBalanceFilter(oid), PayBalanceUpdate(payment, at))
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return errors.ErrStatusChanged()
+	}
+	return nil
+}
+
+func (m *MongoBookingStore) MarkBalancesOverdue(ctx context.Context, now time.Time) (int64, error) {
+	update := bson.M{"$set": bson.M{"balance.status": types.BalanceOverdue}}
+	res, err := m.coll.UpdateMany(ctx, OverdueBalancesFilter(now), update)
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+func (m *MongoBookingStore) ConfirmHold(ctx context.Context, id string, change types.StatusChange) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errors.ErrInvalidID()
+	}
+	filter := bson.M{"_id": oid, "status": types.StatusPending, "holdExpiresAt": bson.M{"$gt": change.At}}
+	update := bson.M{
+		"$set":   bson.M{"status": change.Status},
+		"$unset": bson.M{"holdExpiresAt": ""},
+		"$push":  bson.M{"statusHistory": change},
+	}
+	res, err := m.coll.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return errors.ErrHoldExpired()
+	}
+	return nil
+}
+
+func (m *MongoBookingStore) ExpireHolds(ctx context.Context, now time.Time) ([]*types.Booking, error) {
+	change := types.StatusChange{Status: types.StatusExpired, At: now}
+	update := bson.M{
+		"$set":  bson.M{"status": types.StatusExpired},
+		"$push": bson.M{"statusHistory": change},
+	}
+	holds, err := m.find(ctx, ExpiredHoldsFilter(now))
+	if err != nil {
+		return nil, err
+	}
+	// each hold is expired on its own, so only the ones nobody confirmed or
+	// canceled in the meantime are returned
+	expired := []*types.Booking{}
+	for _, hold := range holds {
+		filter := ExpiredHoldsFilter(now)
+		filter["_id"] = hold.ID
+		res, err := m.coll.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return expired, err
+		}
+		if res.MatchedCount == 0 {
+			continue
+		}
+		hold.Status = types.StatusExpired
+		hold.StatusHistory = append(hold.StatusHistory, change)
+		expired = append(expired, hold)
+	}
+	return expired, nil
+}
+
+// MigrateStatus gives the bookings written before bookings had a status
+// one, derived from the canceled flag they were stored with.
+func (m *MongoBookingStore) MigrateStatus(ctx context.Context) error {
+	filter := bson.M{"status": bson.M{"$exists": false}, "canceled": true}
+	update := bson.M{"$set": bson.M{"status": types.StatusCanceled}, "$unset": bson.M{"canceled": ""}}
+	if _, err := m.coll.UpdateMany(ctx, filter, update); err != nil {
+		return err
+	}
+	filter = bson.M{"status": bson.M{"$exists": false}}
+	update = bson.M{"$set": bson.M{"status": types.StatusConfirmed}, "$unset": bson.M{"canceled": ""}}
+	_, err := m.coll.UpdateMany(ctx, filter, update)
 	return err
 }
+
+func (m *MongoBookingStore) BookRoom(ctx context.Context, booking *types.Booking, now time.Time) (*types.Booking, error) {
+	key, err := m.inventory.LockKey(ctx, booking)
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := m.lockRoom(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if err := m.checkConflicts(ctx, booking, now); err != nil {
+		return nil, err
+	}
+	return m.Insert(ctx, booking)
+}
+
+func (m *MongoBookingStore) BookRooms(ctx context.Context, bookings []*types.Booking, now time.Time) ([]*types.Booking, error) {
+	// lock in a fixed order so that two groups sharing rooms can't each wait
+	// for a lock the other one holds
+	var keys []primitive.ObjectID
+	for _, booking := range bookings {
+		key, err := m.inventory.LockKey(ctx, booking)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b primitive.ObjectID) int {
+		return bytes.Compare(a[:], b[:])
+	})
+	for _, key := range slices.Compact(keys) {
+		unlock, err := m.lockRoom(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+
+	// the bookings go in one by one so that each one is checked against the
+	// ones of the group before it, the transaction takes them all back out
+	// if one fails. Transactions need MongoDB to run as a replica set.
+	session, err := m.client.StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.EndSession(ctx)
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (any, error) {
+		for _, booking := range bookings {
+			if err := m.checkConflicts(sc, booking, now); err != nil {
+				return nil, err
+			}
+			if _, err := m.Insert(sc, booking); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return bookings, nil
+}
+
+// checkConflicts makes sure the booking fits its room and room type, see
+// CheckConflicts.
+func (m *MongoBookingStore) checkConflicts(ctx context.Context, booking *types.Booking, now time.Time) error {
+	return CheckConflicts(ctx, m.inventory, m.find, booking, now)
+}
+
+func (m *MongoBookingStore) find(ctx context.Context, filter Map) ([]*types.Booking, error) {
+	cursor, err := m.coll.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var bookings []*types.Booking
+	if err := cursor.All(ctx, &bookings); err != nil {
+		return nil, err
+	}
+	return bookings, nil
+}
+
+func (m *MongoBookingStore) RoomTypeAvailability(ctx context.Context, roomTypeID primitive.ObjectID, from, till, now time.Time) (int, error) {
+	return TypeAvailability(ctx, m.inventory, m.find, roomTypeID, from, till, now)
+}
+
+func (m *MongoBookingStore) IsRoomAvailable(ctx context.Context, roomID primitive.ObjectID, from, till, now time.Time) (bool, error) {
+	return RoomAvailable(ctx, m.inventory, m.find, roomID, from, till, now)
+}
+
+func (m *MongoBookingStore) ModifyBooking(ctx context.Context, booking *types.Booking, now time.Time) error {
+	key, err := m.inventory.LockKey(ctx, booking)
+	if err != nil {
+		return err
+	}
+	unlock, err := m.lockRoom(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := m.checkConflicts(ctx, booking, now); err != nil {
+		return err
+	}
+	res, err := m.coll.UpdateOne(ctx, bson.M{"_id": booking.ID, "status": bson.M{"$in": types.OpenStatuses}}, bson.M{"$set": bson.M{
+		"fromDate":    booking.FromDate,
+		"tillDate":    booking.TillDate,
+		"numPersons":  booking.NumPersons,
+		"numChildren": booking.NumChildren,
+		"price":       booking.Price,
+		"discount":    booking.Discount,
+		"payment":     booking.Payment,
+		"balance":     booking.Balance,
+	}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return errors.ErrStatusChanged()
+	}
+	return nil
+}
+
+func (m *MongoBookingStore) AssignRoom(ctx context.Context, id string, roomID primitive.ObjectID, now time.Time) error {
+	booking, err := m.GetBookingByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	key, err := m.inventory.LockKey(ctx, booking)
+	if err != nil {
+		return err
+	}
+	unlock, err := m.lockRoom(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// moving within the type doesn't change how many of its rooms are taken,
+	// only the room itself needs to be free, overbooked or not
+	moved := *booking
+	moved.RoomID = roomID
+	taken, err := RoomTaken(ctx, m.find, &moved, now)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return errors.ErrAlreadyBooked()
+	}
+	filter := bson.M{"_id": booking.ID, "status": bson.M{"$in": types.ActiveStatuses}}
+	res, err := m.coll.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"roomID": roomID}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return errors.ErrStatusChanged()
+	}
+	return nil
+}
+
+// lockRoom serializes bookings of a room, or of all rooms of a type, through
+// a lock document keyed by the room or room type id.
+func (m *MongoBookingStore) lockRoom(ctx context.Context, key primitive.ObjectID) (func(), error) {
+	return lock(ctx, m.locks, key)
+}
@@ -3,10 +3,16 @@ package db
 var MONGO_DBNAME_ENV_VARIABLE_NAME = "MONGO_DBNAME"
 
 type Store struct {
-	User    UserStore
-	Hotel   HotelStore
-	Room    RoomStore
-	Booking BookingStore
+	User         UserStore
+	Hotel        HotelStore
+	Room         RoomStore
+	RoomType     RoomTypeStore
+	Booking      BookingStore
+	Reservation  ReservationStore
+	PromoCode    PromoCodeStore
+	Invoice      InvoiceStore
+	ExchangeRate ExchangeRateStore
+	Waitlist     WaitlistStore
 }
 
 type Pagination struct {
@@ -22,6 +28,27 @@ type HotelQueryParams struct {
 type RoomQueryParams struct {
 	Pagination
 	Size string
+	// Adults and Children only list the rooms able to sleep such a party.
+	Adults   int
+	Children int
+}
+
+type RoomTypeQueryParams struct {
+	Pagination
+	// From and Till ask for the number of rooms available for the stay.
+	From string
+	Till string
+}
+
+type AvailabilityQueryParams struct {
+	Pagination
+	From      string
+	Till      string
+	Guests    int
+	Children  int
+	Location  string
+	MinRating int
+	MaxPrice  float64
 }
 
 type UserQueryParams struct {
@@ -34,6 +61,27 @@ type BookingQueryParams struct {
 	UserID string
 }
 
+type BlockQueryParams struct {
+	Pagination
+	RoomID  string
+	HotelID string
+}
+
+type BalanceQueryParams struct {
+	Pagination
+	HotelID string
+	// Status is due for upcoming balances or overdue, both when empty.
+	Status string
+}
+
+type RelocationQueryParams struct {
+	Pagination
+	HotelID string
+	// From and Till limit the report to the arrivals in between.
+	From string
+	Till string
+}
+
 type ResourceResponse struct {
 	Results int   `json:"results"`
 	Data    any   `json:"data"`
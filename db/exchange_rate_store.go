@@ -0,0 +1,59 @@
+package db
+
+import (
+	"context"
+	"os"
+
+	"github.com/kmogilevskii/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type ExchangeRateStore interface {
+	// PutExchangeRates stores the table, replacing the table of its base
+	// currency if there is one.
+	PutExchangeRates(context.Context, *types.ExchangeRates) error
+	// GetExchangeRates returns the table of the base currency, or
+	// mongo.ErrNoDocuments if none was uploaded.
+	GetExchangeRates(context.Context, string) (*types.ExchangeRates, error)
+	GetAllExchangeRates(context.Context) ([]*types.ExchangeRates, error)
+}
+
+type MongoExchangeRateStore struct {
+	client *mongo.Client
+	coll   *mongo.Collection
+}
+
+func NewMongoExchangeRateStore(client *mongo.Client) *MongoExchangeRateStore {
+	DBNAME := os.Getenv(MONGO_DBNAME_ENV_VARIABLE_NAME)
+	return &MongoExchangeRateStore{
+		client: client,
+		coll:   client.Database(DBNAME).Collection("exchange_rates"),
+	}
+}
+
+func (m *MongoExchangeRateStore) PutExchangeRates(ctx context.Context, rates *types.ExchangeRates) error {
+	_, err := m.coll.ReplaceOne(ctx, bson.M{"_id": rates.Base}, rates, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (m *MongoExchangeRateStore) GetExchangeRates(ctx context.Context, base string) (*types.ExchangeRates, error) {
+	var rates types.ExchangeRates
+	if err := m.coll.FindOne(ctx, bson.M{"_id": base}).Decode(&rates); err != nil {
+		return nil, err
+	}
+	return &rates, nil
+}
+
+func (m *MongoExchangeRateStore) GetAllExchangeRates(ctx context.Context) ([]*types.ExchangeRates, error) {
+	resp, err := m.coll.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	tables := []*types.ExchangeRates{}
+	if err := resp.All(ctx, &tables); err != nil {
+		return nil, err
+	}
+	return tables, nil
+}
@@ -71,7 +71,7 @@ func AddBooking(s db.BookingStore, userID, roomID primitive.ObjectID, fromDate,
 		FromDate:   fromDate,
 		TillDate:   tillDate,
 		NumPersons: numPersons,
-		Canceled:   false,
+		Status:     types.StatusConfirmed,
 	}
 
 	insertedBooking, err := s.Insert(context.TODO(), &booking)
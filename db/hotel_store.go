@@ -4,6 +4,7 @@ import (
 	"context"
 	"os"
 
+	"github.com/kmogilevskii/hotel-reservation/errors"
 	"github.com/kmogilevskii/hotel-reservation/types"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -16,6 +17,8 @@ type HotelStore interface {
 	Update(context.Context, Map, Map) error
 	GetHotels(context.Context, Map, *Pagination) ([]*types.Hotel, error)
 	GetHotelByID(context.Context, string) (*types.Hotel, error)
+	UpdateHotel(context.Context, string, types.UpdateHotelParams) error
+	DeleteHotel(context.Context, string) error
 }
 
 type MongoHotelStore struct {
@@ -75,3 +78,21 @@ func (m *MongoHotelStore) GetHotelByID(ctx context.Context, id string) (*types.H
 
 	return &hotel, nil
 }
+
+func (m *MongoHotelStore) UpdateHotel(ctx context.Context, id string, params types.UpdateHotelParams) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errors.ErrInvalidID()
+	}
+	_, err = m.coll.UpdateOne(ctx, bson.M{"_id": oid}, bson.M{"$set": params.ToBSON()})
+	return err
+}
+
+func (m *MongoHotelStore) DeleteHotel(ctx context.Context, id string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errors.ErrInvalidID()
+	}
+	_, err = m.coll.DeleteOne(ctx, bson.M{"_id": oid})
+	return err
+}
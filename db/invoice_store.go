@@ -0,0 +1,93 @@
+package db
+
+import (
+	"context"
+	"os"
+
+	"github.com/kmogilevskii/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type InvoiceStore interface {
+	// IssueInvoice gives the booking the next invoice number of its hotel,
+	// unless it was invoiced before. Either way it returns the invoice the
+	// booking is issued under, so a booking never gets two numbers.
+	IssueInvoice(context.Context, *types.IssuedInvoice) (*types.IssuedInvoice, error)
+}
+
+type MongoInvoiceStore struct {
+	client *mongo.Client
+	coll   *mongo.Collection
+	locks  *mongo.Collection
+}
+
+func NewMongoInvoiceStore(client *mongo.Client) *MongoInvoiceStore {
+	DBNAME := os.Getenv(MONGO_DBNAME_ENV_VARIABLE_NAME)
+	return &MongoInvoiceStore{
+		client: client,
+		coll:   client.Database(DBNAME).Collection("invoices"),
+		locks:  client.Database(DBNAME).Collection("invoice_locks"),
+	}
+}
+
+// issueAttempts is how many numbers IssueInvoice tries before giving up.
+const issueAttempts = 3
+
+// CreateIndexes makes invoice numbers unique per hotel, so no two invoices
+// share a number even when the hotel's lock expired under a slow request.
+func (m *MongoInvoiceStore) CreateIndexes(ctx context.Context) error {
+	_, err := m.coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "hotelID", Value: 1}, {Key: "number", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// IssueInvoice numbers the hotel's invoices one at a time under a lock on
+// the hotel. The next number follows the last one stored, so a request that
+// fails before storing its invoice doesn't use a number up, and a lock left
+// behind by a crashed request expires. Should another request store the
+// number first anyway, the unique index refuses the invoice and the next
+// number is tried.
+func (m *MongoInvoiceStore) IssueInvoice(ctx context.Context, invoice *types.IssuedInvoice) (*types.IssuedInvoice, error) {
+	if issued, err := m.getInvoice(ctx, invoice); err != mongo.ErrNoDocuments {
+		return issued, err
+	}
+	unlock, err := lock(ctx, m.locks, invoice.HotelID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	for attempt := 1; ; attempt++ {
+		// a concurrent request may have invoiced the booking before we got
+		// the lock
+		if issued, err := m.getInvoice(ctx, invoice); err != mongo.ErrNoDocuments {
+			return issued, err
+		}
+		var last types.IssuedInvoice
+		opts := options.FindOne().SetSort(bson.D{{Key: "number", Value: -1}})
+		err = m.coll.FindOne(ctx, bson.M{"hotelID": invoice.HotelID}, opts).Decode(&last)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+		issued := *invoice
+		issued.Number = last.Number + 1
+		_, err = m.coll.InsertOne(ctx, issued)
+		if err == nil {
+			return &issued, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) || attempt == issueAttempts {
+			return nil, err
+		}
+	}
+}
+
+func (m *MongoInvoiceStore) getInvoice(ctx context.Context, invoice *types.IssuedInvoice) (*types.IssuedInvoice, error) {
+	var issued types.IssuedInvoice
+	if err := m.coll.FindOne(ctx, bson.M{"_id": invoice.BookingID}).Decode(&issued); err != nil {
+		return nil, err
+	}
+	return &issued, nil
+}
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	lockTTL        = 10 * time.Second
+	lockRetryDelay = 10 * time.Millisecond
+)
+
+type lockDoc struct {
+	Key       primitive.ObjectID `bson:"_id"`
+	Owner     primitive.ObjectID `bson:"owner"`
+	ExpiresAt time.Time          `bson:"expiresAt"`
+}
+
+// lock waits until it holds the lock document keyed by key in locks and
+// returns the func releasing it. Locks left behind by a crashed process are
+// taken over once they expire.
+func lock(ctx context.Context, locks *mongo.Collection, key primitive.ObjectID) (func(), error) {
+	owner := primitive.NewObjectID()
+	for {
+		now := time.Now()
+		doc := lockDoc{Key: key, Owner: owner, ExpiresAt: now.Add(lockTTL)}
+		_, err := locks.InsertOne(ctx, doc)
+		if err == nil {
+			break
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, err
+		}
+		filter := bson.M{"_id": key, "expiresAt": bson.M{"$lt": now}}
+		update := bson.M{"$set": bson.M{"owner": owner, "expiresAt": doc.ExpiresAt}}
+		res, err := locks.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return nil, err
+		}
+		if res.ModifiedCount == 1 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockRetryDelay):
+		}
+	}
+	return func() {
+		locks.DeleteOne(context.Background(), bson.M{"_id": key, "owner": owner})
+	}, nil
+}
@@ -0,0 +1,354 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/kmogilevskii/hotel-reservation/db"
+	"github.com/kmogilevskii/hotel-reservation/errors"
+	"github.com/kmogilevskii/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type BookingStore struct {
+	coll      *collection
+	inventory db.Inventory
+}
+
+func NewBookingStore(roomStore db.RoomStore, roomTypeStore db.RoomTypeStore, hotelStore db.HotelStore) *BookingStore {
+	return &BookingStore{
+		coll: newCollection(),
+		inventory: db.Inventory{
+			Rooms:     roomStore,
+			RoomTypes: roomTypeStore,
+			Hotels:    hotelStore,
+		},
+	}
+}
+
+func (s *BookingStore) Insert(ctx context.Context, booking *types.Booking) (*types.Booking, error) {
+	if err := s.coll.insert(booking); err != nil {
+		return nil, err
+	}
+	return booking, nil
+}
+
+func (s *BookingStore) GetBookings(ctx context.Context, filter db.Map, pag *db.Pagination) ([]*types.Booking, error) {
+	if filter["userID"] != nil {
+		oid, err := primitive.ObjectIDFromHex(filter["userID"].(string))
+		if err != nil {
+			return nil, errors.ErrInvalidID()
+		}
+		filter["userID"] = oid
+	}
+	return find[types.Booking](s.coll, filter, pag)
+}
+
+func (s *BookingStore) GetBalances(ctx context.Context, filter db.Map, pag *db.Pagination) ([]*types.Booking, error) {
+	skip := (pag.Page - 1) * pag.Limit
+	if skip < 0 {
+		return nil, fmt.Errorf("skip value must be non-negative, but received: %d", skip)
+	}
+	bookings, err := find[types.Booking](s.coll, filter, &db.Pagination{Page: 1})
+	if err != nil {
+		return nil, err
+	}
+	// bookings are kept in insertion order, which breaks ties like _id does
+	slices.SortStableFunc(bookings, func(a, b *types.Booking) int {
+		return a.Balance.DueAt.Compare(b.Balance.DueAt)
+	})
+	if skip >= int64(len(bookings)) {
+		return []*types.Booking{}, nil
+	}
+	bookings = bookings[skip:]
+	if pag.Limit > 0 && pag.Limit < int64(len(bookings)) {
+		bookings = bookings[:pag.Limit]
+	}
+	return bookings, nil
+}
+
+func (s *BookingStore) GetBookingByID(ctx context.Context, id string) (*types.Booking, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	var booking types.Booking
+	if err := s.coll.findOne(db.Map{"_id": oid}, &booking); err != nil {
+		return nil, err
+	}
+	return &booking, nil
+}
+
+func (s *BookingStore) CancelBooking(ctx context.Context, id string, cancellation types.Cancellation, payment *types.Payment) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errors.ErrInvalidID()
+	}
+	change := types.StatusChange{
+		Status: types.StatusCanceled,
+		By:     cancellation.CanceledBy,
+		At:     cancellation.CanceledAt,
+	}
+	filter := db.Map{"_id": oid, "status": db.Map{"$in": types.OpenStatuses}}
+	set := db.Map{"status": types.StatusCanceled, "cancellation": cancellation}
+	if payment != nil {
+		set["payment"] = payment
+	}
+	update := db.Map{
+		"$set":  set,
+		"$push": db.Map{"statusHistory": change},
+	}
+	ok, err := s.coll.update(filter, update)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.ErrStatusChanged()
+	}
+	return nil
+}
+
+func (s *BookingStore) UpdateBookingStatus(ctx context.Context, id string, from types.BookingStatus, change types.StatusChange) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errors.ErrInvalidID()
+	}
+	filter := db.Map{"_id": oid, "status": from}
+	update := db.Map{
+		"$set":  db.Map{"status": change.Status},
+		"$push": db.Map{"statusHistory": change},
+	}
+	ok, err := s.coll.update(filter, update)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.ErrStatusChanged()
+	}
+	return nil
+}
+
+func (s *BookingStore) UpdatePayment(ctx context.Context, booking *types.Booking) error {
+	ok, err := s.coll.update(db.Map{"_id": booking.ID}, db.Map{"$set": db.Map{"payment": booking.Payment, "balance": booking.Balance}})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (s *BookingStore) PayBalance(ctx context.Context, id string, payment *types.Payment, at time.Time) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errors.ErrInvalidID()
+	}
+	ok, err := s.coll.update(db.PayBalanceFilter(oid), db.PayBalanceUpdate(payment, at))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.ErrStatusChanged()
+	}
+	return nil
+}
+
+func (s *BookingStore) MarkBalancesOverdue(ctx context.Context, now time.Time) (int64, error) {
+	update := db.Map{"$set": db.Map{"balance.status": types.BalanceOverdue}}
+	return s.coll.updateMany(db.OverdueBalancesFilter(now), update)
+}
+
+func (s *BookingStore) ConfirmHold(ctx context.Context, id string, change types.StatusChange) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errors.ErrInvalidID()
+	}
+	filter := db.Map{"_id": oid, "status": types.StatusPending, "holdExpiresAt": db.Map{"$gt": change.At}}
+	update := db.Map{
+		"$set":   db.Map{"status": change.Status},
+		"$unset": db.Map{"holdExpiresAt": ""},
+		"$push":  db.Map{"statusHistory": change},
+	}
+	ok, err := s.coll.update(filter, update)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.ErrHoldExpired()
+	}
+	return nil
+}
+
+func (s *BookingStore) ExpireHolds(ctx context.Context, now time.Time) ([]*types.Booking, error) {
+	change := types.StatusChange{Status: types.StatusExpired, At: now}
+	update, err := toDoc(db.Map{
+		"$set":  db.Map{"status": types.StatusExpired},
+		"$push": db.Map{"statusHistory": change},
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.coll.mu.Lock()
+	defer s.coll.mu.Unlock()
+	docs, err := s.coll.filterLocked(db.ExpiredHoldsFilter(now))
+	if err != nil {
+		return nil, err
+	}
+	expired := make([]*types.Booking, len(docs))
+	for i, doc := range docs {
+		if err := updateLocked(doc, update); err != nil {
+			return nil, err
+		}
+		expired[i] = new(types.Booking)
+		if err := fromDoc(doc, expired[i]); err != nil {
+			return nil, err
+		}
+	}
+	return expired, nil
+}
+
+func (s *BookingStore) BookRoom(ctx context.Context, booking *types.Booking, now time.Time) (*types.Booking, error) {
+	doc, err := toDoc(booking)
+	if err != nil {
+		return nil, err
+	}
+	s.coll.mu.Lock()
+	defer s.coll.mu.Unlock()
+	if err := s.checkConflictsLocked(ctx, booking, now); err != nil {
+		return nil, err
+	}
+	if err := s.coll.insertLocked(doc); err != nil {
+		return nil, err
+	}
+	return booking, nil
+}
+
+func (s *BookingStore) BookRooms(ctx context.Context, bookings []*types.Booking, now time.Time) ([]*types.Booking, error) {
+	docs := make([]bson.M, len(bookings))
+	for i, booking := range bookings {
+		doc, err := toDoc(booking)
+		if err != nil {
+			return nil, err
+		}
+		docs[i] = doc
+	}
+	s.coll.mu.Lock()
+	defer s.coll.mu.Unlock()
+	// each booking is checked against the ones of the group inserted before
+	// it, the whole group is taken back out if one fails
+	n := len(s.coll.docs)
+	for i, booking := range bookings {
+		err := s.checkConflictsLocked(ctx, booking, now)
+		if err == nil {
+			err = s.coll.insertLocked(docs[i])
+		}
+		if err != nil {
+			s.coll.docs = s.coll.docs[:n]
+			return nil, err
+		}
+	}
+	return bookings, nil
+}
+
+// checkConflictsLocked makes sure the booking fits its room and room type,
+// see db.CheckConflicts.
+func (s *BookingStore) checkConflictsLocked(ctx context.Context, booking *types.Booking, now time.Time) error {
+	return db.CheckConflicts(ctx, s.inventory, s.findLocked, booking, now)
+}
+
+// findLocked decodes the bookings matching the filter. The caller holds the
+// collection's lock.
+func (s *BookingStore) findLocked(ctx context.Context, filter db.Map) ([]*types.Booking, error) {
+	docs, err := s.coll.filterLocked(filter)
+	if err != nil {
+		return nil, err
+	}
+	bookings := make([]*types.Booking, len(docs))
+	for i, doc := range docs {
+		bookings[i] = new(types.Booking)
+		if err := fromDoc(doc, bookings[i]); err != nil {
+			return nil, err
+		}
+	}
+	return bookings, nil
+}
+
+func (s *BookingStore) RoomTypeAvailability(ctx context.Context, roomTypeID primitive.ObjectID, from, till, now time.Time) (int, error) {
+	s.coll.mu.RLock()
+	defer s.coll.mu.RUnlock()
+	return db.TypeAvailability(ctx, s.inventory, s.findLocked, roomTypeID, from, till, now)
+}
+
+func (s *BookingStore) ModifyBooking(ctx context.Context, booking *types.Booking, now time.Time) error {
+	update, err := toDoc(db.Map{"$set": db.Map{
+		"fromDate":    booking.FromDate,
+		"tillDate":    booking.TillDate,
+		"numPersons":  booking.NumPersons,
+		"numChildren": booking.NumChildren,
+		"price":       booking.Price,
+		"discount":    booking.Discount,
+		"payment":     booking.Payment,
+		"balance":     booking.Balance,
+	}})
+	if err != nil {
+		return err
+	}
+	s.coll.mu.Lock()
+	defer s.coll.mu.Unlock()
+	if err := s.checkConflictsLocked(ctx, booking, now); err != nil {
+		return err
+	}
+	docs, err := s.coll.filterLocked(db.Map{"_id": booking.ID, "status": db.Map{"$in": types.OpenStatuses}})
+	if err != nil {
+		return err
+	}
+	if len(docs) == 0 {
+		return errors.ErrStatusChanged()
+	}
+	return updateLocked(docs[0], update)
+}
+
+func (s *BookingStore) AssignRoom(ctx context.Context, id string, roomID primitive.ObjectID, now time.Time) error {
+	booking, err := s.GetBookingByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	update, err := toDoc(db.Map{"$set": db.Map{"roomID": roomID}})
+	if err != nil {
+		return err
+	}
+	s.coll.mu.Lock()
+	defer s.coll.mu.Unlock()
+	// moving within the type doesn't change how many of its rooms are taken,
+	// only the room itself needs to be free, overbooked or not
+	moved := *booking
+	moved.RoomID = roomID
+	taken, err := db.RoomTaken(ctx, s.findLocked, &moved, now)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return errors.ErrAlreadyBooked()
+	}
+	docs, err := s.coll.filterLocked(db.Map{"_id": booking.ID, "status": db.Map{"$in": types.ActiveStatuses}})
+	if err != nil {
+		return err
+	}
+	if len(docs) == 0 {
+		return errors.ErrStatusChanged()
+	}
+	return updateLocked(docs[0], update)
+}
+
+func (s *BookingStore) IsRoomAvailable(ctx context.Context, roomID primitive.ObjectID, from, till, now time.Time) (bool, error) {
+	s.coll.mu.RLock()
+	defer s.coll.mu.RUnlock()
+	return db.RoomAvailable(ctx, s.inventory, s.findLocked, roomID, from, till, now)
+}
+
+var _ db.BookingStore = (*BookingStore)(nil)
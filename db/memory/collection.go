@@ -0,0 +1,248 @@
+// Package memory implements the db store interfaces in process memory. It is
+// meant for tests and local development where no MongoDB is available.
+package memory
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/kmogilevskii/hotel-reservation/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// collection keeps documents in their BSON form, in insertion order, so that
+// reads behave like the Mongo stores: values are copied, times are truncated
+// to milliseconds and come back in UTC.
+type collection struct {
+	mu   sync.RWMutex
+	docs []bson.M
+}
+
+func newCollection() *collection {
+	return &collection{}
+}
+
+func toDoc(v any) (bson.M, error) {
+	b, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func fromDoc(doc bson.M, v any) error {
+	b, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(b, v)
+}
+
+func duplicateKeyError(id any) error {
+	return mongo.WriteException{
+		WriteErrors: []mongo.WriteError{{
+			Code:    11000,
+			Message: fmt.Sprintf("E11000 duplicate key error dup key: { _id: %v }", id),
+		}},
+	}
+}
+
+func (c *collection) insert(v any) error {
+	doc, err := toDoc(v)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.insertLocked(doc)
+}
+
+func (c *collection) insertLocked(doc bson.M) error {
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = primitive.NewObjectID()
+	}
+	for _, d := range c.docs {
+		if equal(d["_id"], doc["_id"]) {
+			return duplicateKeyError(doc["_id"])
+		}
+	}
+	c.docs = append(c.docs, doc)
+	return nil
+}
+
+// filterLocked returns the documents matching filter in insertion order.
+func (c *collection) filterLocked(filter db.Map) ([]bson.M, error) {
+	f, err := toDoc(filter)
+	if err != nil {
+		return nil, err
+	}
+	var res []bson.M
+	for _, doc := range c.docs {
+		ok, err := matches(doc, f)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			res = append(res, doc)
+		}
+	}
+	return res, nil
+}
+
+func (c *collection) findOne(filter db.Map, v any) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	docs, err := c.filterLocked(filter)
+	if err != nil {
+		return err
+	}
+	if len(docs) == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return fromDoc(docs[0], v)
+}
+
+func (c *collection) updateOne(filter, update db.Map) error {
+	_, err := c.update(filter, update)
+	return err
+}
+
+// update applies update to the first document matching filter and reports
+// whether there was one.
+func (c *collection) update(filter, update db.Map) (bool, error) {
+	u, err := toDoc(update)
+	if err != nil {
+		return false, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	docs, err := c.filterLocked(filter)
+	if err != nil || len(docs) == 0 {
+		return false, err
+	}
+	return true, updateLocked(docs[0], u)
+}
+
+// updateMany applies update to all documents matching filter and returns how
+// many there were.
+func (c *collection) updateMany(filter, update db.Map) (int64, error) {
+	u, err := toDoc(update)
+	if err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	docs, err := c.filterLocked(filter)
+	if err != nil {
+		return 0, err
+	}
+	for _, doc := range docs {
+		if err := updateLocked(doc, u); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(docs)), nil
+}
+
+// updateLocked applies update to a copy of doc first so that a failing
+// modifier leaves the stored document untouched.
+func updateLocked(doc, update bson.M) error {
+	updated, err := toDoc(doc)
+	if err != nil {
+		return err
+	}
+	if err := apply(updated, update); err != nil {
+		return err
+	}
+	for k := range doc {
+		delete(doc, k)
+	}
+	for k, v := range updated {
+		doc[k] = v
+	}
+	return nil
+}
+
+// upsert replaces the first document matching filter with v, or inserts v
+// if none does.
+func (c *collection) upsert(filter db.Map, v any) error {
+	doc, err := toDoc(v)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	docs, err := c.filterLocked(filter)
+	if err != nil {
+		return err
+	}
+	if len(docs) == 0 {
+		return c.insertLocked(doc)
+	}
+	for k := range docs[0] {
+		delete(docs[0], k)
+	}
+	for k, v := range doc {
+		docs[0][k] = v
+	}
+	return nil
+}
+
+func (c *collection) deleteOne(filter db.Map) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	docs, err := c.filterLocked(filter)
+	if err != nil || len(docs) == 0 {
+		return err
+	}
+	for i, doc := range c.docs {
+		if equal(doc["_id"], docs[0]["_id"]) {
+			c.docs = append(c.docs[:i], c.docs[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (c *collection) drop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.docs = nil
+}
+
+// find decodes the page of documents matching filter, using the same
+// skip/limit arithmetic as the Mongo stores.
+func find[T any](c *collection, filter db.Map, pag *db.Pagination) ([]*T, error) {
+	skip := (pag.Page - 1) * pag.Limit
+	if skip < 0 {
+		return nil, fmt.Errorf("skip value must be non-negative, but received: %d", skip)
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	docs, err := c.filterLocked(filter)
+	if err != nil {
+		return nil, err
+	}
+	if skip >= int64(len(docs)) {
+		return nil, nil
+	}
+	docs = docs[skip:]
+	if pag.Limit > 0 && pag.Limit < int64(len(docs)) {
+		docs = docs[:pag.Limit]
+	}
+	var res []*T
+	for _, doc := range docs {
+		v := new(T)
+		if err := fromDoc(doc, v); err != nil {
+			return nil, err
+		}
+		res = append(res, v)
+	}
+	return res, nil
+}
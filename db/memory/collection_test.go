@@ -0,0 +1,49 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/kmogilevskii/hotel-reservation/db"
+	"github.com/kmogilevskii/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestConcurrentAccess(t *testing.T) {
+	var (
+		ctx        = context.Background()
+		hotelStore = NewHotelStore()
+		roomStore  = NewRoomStore(hotelStore)
+		hotel      = &types.Hotel{ID: primitive.NewObjectID(), Rooms: []primitive.ObjectID{}}
+		wg         sync.WaitGroup
+	)
+	if _, err := hotelStore.Insert(ctx, hotel); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			room := &types.Room{ID: primitive.NewObjectID(), HotelID: hotel.ID}
+			if _, err := roomStore.InsertRoom(ctx, room); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := roomStore.GetRooms(ctx, db.Map{"hotelID": hotel.ID}, &db.Pagination{}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := hotelStore.GetHotelByID(ctx, hotel.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Rooms) != 50 {
+		t.Fatalf("expected 50 rooms on the hotel, got %d", len(got.Rooms))
+	}
+}
@@ -0,0 +1,46 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/kmogilevskii/hotel-reservation/db"
+	"github.com/kmogilevskii/hotel-reservation/types"
+)
+
+type ExchangeRateStore struct {
+	coll *collection
+}
+
+func NewExchangeRateStore() *ExchangeRateStore {
+	return &ExchangeRateStore{coll: newCollection()}
+}
+
+func (s *ExchangeRateStore) PutExchangeRates(ctx context.Context, rates *types.ExchangeRates) error {
+	return s.coll.upsert(db.Map{"_id": rates.Base}, rates)
+}
+
+func (s *ExchangeRateStore) GetExchangeRates(ctx context.Context, base string) (*types.ExchangeRates, error) {
+	var rates types.ExchangeRates
+	if err := s.coll.findOne(db.Map{"_id": base}, &rates); err != nil {
+		return nil, err
+	}
+	return &rates, nil
+}
+
+func (s *ExchangeRateStore) GetAllExchangeRates(ctx context.Context) ([]*types.ExchangeRates, error) {
+	tables, err := find[types.ExchangeRates](s.coll, db.Map{}, &db.Pagination{})
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(tables, func(a, b *types.ExchangeRates) int {
+		return strings.Compare(a.Base, b.Base)
+	})
+	if tables == nil {
+		tables = []*types.ExchangeRates{}
+	}
+	return tables, nil
+}
+
+var _ db.ExchangeRateStore = (*ExchangeRateStore)(nil)
@@ -0,0 +1,65 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/kmogilevskii/hotel-reservation/db"
+	"github.com/kmogilevskii/hotel-reservation/errors"
+	"github.com/kmogilevskii/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type HotelStore struct {
+	coll *collection
+}
+
+func NewHotelStore() *HotelStore {
+	return &HotelStore{
+		coll: newCollection(),
+	}
+}
+
+func (s *HotelStore) Insert(ctx context.Context, hotel *types.Hotel) (*types.Hotel, error) {
+	if err := s.coll.insert(hotel); err != nil {
+		return nil, err
+	}
+	return hotel, nil
+}
+
+func (s *HotelStore) Update(ctx context.Context, filter, update db.Map) error {
+	return s.coll.updateOne(filter, update)
+}
+
+func (s *HotelStore) GetHotels(ctx context.Context, filter db.Map, pag *db.Pagination) ([]*types.Hotel, error) {
+	return find[types.Hotel](s.coll, filter, pag)
+}
+
+func (s *HotelStore) GetHotelByID(ctx context.Context, id string) (*types.Hotel, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	var hotel types.Hotel
+	if err := s.coll.findOne(db.Map{"_id": oid}, &hotel); err != nil {
+		return nil, err
+	}
+	return &hotel, nil
+}
+
+func (s *HotelStore) UpdateHotel(ctx context.Context, id string, params types.UpdateHotelParams) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errors.ErrInvalidID()
+	}
+	return s.coll.updateOne(db.Map{"_id": oid}, db.Map{"$set": params.ToBSON()})
+}
+
+func (s *HotelStore) DeleteHotel(ctx context.Context, id string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errors.ErrInvalidID()
+	}
+	return s.coll.deleteOne(db.Map{"_id": oid})
+}
+
+var _ db.HotelStore = (*HotelStore)(nil)
@@ -0,0 +1,41 @@
+package memory
+
+import (
+	"context"
+	"sync"
+
+	"github.com/kmogilevskii/hotel-reservation/db"
+	"github.com/kmogilevskii/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type InvoiceStore struct {
+	mu     sync.Mutex
+	coll   *collection
+	issued map[primitive.ObjectID]int64
+}
+
+func NewInvoiceStore() *InvoiceStore {
+	return &InvoiceStore{
+		coll:   newCollection(),
+		issued: map[primitive.ObjectID]int64{},
+	}
+}
+
+func (s *InvoiceStore) IssueInvoice(ctx context.Context, invoice *types.IssuedInvoice) (*types.IssuedInvoice, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var issued types.IssuedInvoice
+	if err := s.coll.findOne(db.Map{"_id": invoice.BookingID}, &issued); err == nil {
+		return &issued, nil
+	}
+	issued = *invoice
+	issued.Number = s.issued[invoice.HotelID] + 1
+	if err := s.coll.insert(issued); err != nil {
+		return nil, err
+	}
+	s.issued[invoice.HotelID] = issued.Number
+	return &issued, nil
+}
+
+var _ db.InvoiceStore = (*InvoiceStore)(nil)
@@ -0,0 +1,257 @@
+package memory
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// matches reports whether doc satisfies a Mongo style query filter. Only the
+// operators used by the stores are supported: $and, $or, $nor on the top
+// level and $eq, $ne, $gt, $gte, $lt, $lte, $in, $nin, $exists on fields.
+func matches(doc, filter bson.M) (bool, error) {
+	for key, cond := range filter {
+		var (
+			ok  bool
+			err error
+		)
+		switch key {
+		case "$and", "$or", "$nor":
+			ok, err = matchLogical(doc, key, cond)
+		default:
+			if strings.HasPrefix(key, "$") {
+				return false, fmt.Errorf("unknown top level operator: %s", key)
+			}
+			ok, err = matchField(lookup(doc, key), cond)
+		}
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchLogical(doc bson.M, op string, cond any) (bool, error) {
+	clauses, ok := cond.(primitive.A)
+	if !ok || len(clauses) == 0 {
+		return false, fmt.Errorf("%s must be a nonempty array", op)
+	}
+	for _, clause := range clauses {
+		f, ok := clause.(bson.M)
+		if !ok {
+			return false, fmt.Errorf("%s entries must be documents", op)
+		}
+		ok, err := matches(doc, f)
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case op == "$and" && !ok:
+			return false, nil
+		case op == "$or" && ok:
+			return true, nil
+		case op == "$nor" && ok:
+			return false, nil
+		}
+	}
+	return op != "$or", nil
+}
+
+type missing struct{}
+
+// lookup resolves a dotted path, returning missing{} when a segment is absent.
+func lookup(doc bson.M, path string) any {
+	var cur any = doc
+	for _, part := range strings.Split(path, ".") {
+		m, ok := cur.(bson.M)
+		if !ok {
+			return missing{}
+		}
+		if cur, ok = m[part]; !ok {
+			return missing{}
+		}
+	}
+	return cur
+}
+
+func isOperatorDoc(cond any) (bson.M, bool) {
+	m, ok := cond.(bson.M)
+	if !ok || len(m) == 0 {
+		return nil, false
+	}
+	for k := range m {
+		if !strings.HasPrefix(k, "$") {
+			return nil, false
+		}
+	}
+	return m, true
+}
+
+func matchField(val, cond any) (bool, error) {
+	ops, ok := isOperatorDoc(cond)
+	if !ok {
+		return matchEq(val, cond), nil
+	}
+	for op, arg := range ops {
+		var ok bool
+		switch op {
+		case "$eq":
+			ok = matchEq(val, arg)
+		case "$ne":
+			ok = !matchEq(val, arg)
+		case "$gt", "$gte", "$lt", "$lte":
+			ok = matchCmp(val, op, arg)
+		case "$in", "$nin":
+			list, isList := arg.(primitive.A)
+			if !isList {
+				return false, fmt.Errorf("%s needs an array", op)
+			}
+			for _, v := range list {
+				if matchEq(val, v) {
+					ok = true
+					break
+				}
+			}
+			if op == "$nin" {
+				ok = !ok
+			}
+		case "$exists":
+			_, absent := val.(missing)
+			ok = absent != truthy(arg)
+		default:
+			return false, fmt.Errorf("unknown operator: %s", op)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func truthy(v any) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case nil:
+		return false
+	default:
+		n, ok := number(v)
+		return !ok || n != 0
+	}
+}
+
+// matchEq follows Mongo equality: a missing field equals null and an array
+// field matches if any of its elements does.
+func matchEq(val, want any) bool {
+	if _, ok := val.(missing); ok {
+		return want == nil
+	}
+	if equal(val, want) {
+		return true
+	}
+	if arr, ok := val.(primitive.A); ok {
+		for _, v := range arr {
+			if equal(v, want) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchCmp(val any, op string, arg any) bool {
+	values := []any{val}
+	if arr, ok := val.(primitive.A); ok {
+		values = arr
+	}
+	for _, v := range values {
+		c, ok := compare(v, arg)
+		if !ok {
+			continue
+		}
+		switch {
+		case op == "$gt" && c > 0,
+			op == "$gte" && c >= 0,
+			op == "$lt" && c < 0,
+			op == "$lte" && c <= 0:
+			return true
+		}
+	}
+	return false
+}
+
+func number(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// compare orders two BSON values of the same type bracket. Values of
+// different brackets are not comparable, which is how Mongo treats them in
+// range queries.
+func compare(a, b any) (int, bool) {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	switch x := a.(type) {
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), true
+		}
+	case primitive.DateTime:
+		if y, ok := b.(primitive.DateTime); ok {
+			switch {
+			case x < y:
+				return -1, true
+			case x > y:
+				return 1, true
+			}
+			return 0, true
+		}
+	case primitive.ObjectID:
+		if y, ok := b.(primitive.ObjectID); ok {
+			return bytes.Compare(x[:], y[:]), true
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			switch {
+			case x == y:
+				return 0, true
+			case !x:
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	return 0, false
+}
+
+func equal(a, b any) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if c, ok := compare(a, b); ok {
+		return c == 0
+	}
+	return reflect.DeepEqual(a, b)
+}
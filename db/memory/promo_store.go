@@ -0,0 +1,76 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/kmogilevskii/hotel-reservation/db"
+	"github.com/kmogilevskii/hotel-reservation/errors"
+	"github.com/kmogilevskii/hotel-reservation/types"
+)
+
+type PromoCodeStore struct {
+	coll          *collection
+	firstBookings *collection
+}
+
+func NewPromoCodeStore() *PromoCodeStore {
+	return &PromoCodeStore{coll: newCollection(), firstBookings: newCollection()}
+}
+
+func (s *PromoCodeStore) InsertPromoCode(ctx context.Context, promo *types.PromoCode) (*types.PromoCode, error) {
+	if err := s.coll.insert(promo); err != nil {
+		return nil, err
+	}
+	return promo, nil
+}
+
+func (s *PromoCodeStore) GetPromoCodes(ctx context.Context, filter db.Map, pag *db.Pagination) ([]*types.PromoCode, error) {
+	return find[types.PromoCode](s.coll, filter, pag)
+}
+
+func (s *PromoCodeStore) GetPromoCode(ctx context.Context, code string) (*types.PromoCode, error) {
+	var promo types.PromoCode
+	if err := s.coll.findOne(db.Map{"_id": types.NormalizePromoCode(code)}, &promo); err != nil {
+		return nil, err
+	}
+	return &promo, nil
+}
+
+func (s *PromoCodeStore) RedeemPromoCode(ctx context.Context, code string) error {
+	code = types.NormalizePromoCode(code)
+	for _, redeem := range []func(string) (db.Map, db.Map){db.RedeemCappedUpdate, db.RedeemUncappedUpdate} {
+		filter, update := redeem(code)
+		ok, err := s.coll.update(filter, update)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+	return errors.ErrPromoUsedUp()
+}
+
+func (s *PromoCodeStore) ReleasePromoCode(ctx context.Context, code string) error {
+	code = types.NormalizePromoCode(code)
+	filter, capped, uncapped := db.ReleasePromoUpdate(code)
+	ok, err := s.coll.update(filter, capped)
+	if err != nil || ok {
+		return err
+	}
+	return s.coll.updateOne(db.Map{"_id": code}, uncapped)
+}
+
+func (s *PromoCodeStore) ClaimFirstBooking(ctx context.Context, claim *types.FirstBooking) error {
+	return s.firstBookings.insert(claim)
+}
+
+func (s *PromoCodeStore) ReleaseFirstBooking(ctx context.Context, userID, code string) error {
+	filter, err := db.FirstBookingFilter(userID, code)
+	if err != nil {
+		return err
+	}
+	return s.firstBookings.deleteOne(filter)
+}
+
+var _ db.PromoCodeStore = (*PromoCodeStore)(nil)
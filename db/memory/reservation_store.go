@@ -0,0 +1,44 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/kmogilevskii/hotel-reservation/db"
+	"github.com/kmogilevskii/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type ReservationStore struct {
+	coll *collection
+}
+
+func NewReservationStore() *ReservationStore {
+	return &ReservationStore{
+		coll: newCollection(),
+	}
+}
+
+func (s *ReservationStore) Insert(ctx context.Context, reservation *types.Reservation) (*types.Reservation, error) {
+	if err := s.coll.insert(reservation); err != nil {
+		return nil, err
+	}
+	return reservation, nil
+}
+
+func (s *ReservationStore) GetReservationByID(ctx context.Context, id string) (*types.Reservation, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	var reservation types.Reservation
+	if err := s.coll.findOne(db.Map{"_id": oid}, &reservation); err != nil {
+		return nil, err
+	}
+	return &reservation, nil
+}
+
+func (s *ReservationStore) DeleteReservation(ctx context.Context, id primitive.ObjectID) error {
+	return s.coll.deleteOne(db.Map{"_id": id})
+}
+
+var _ db.ReservationStore = (*ReservationStore)(nil)
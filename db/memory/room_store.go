@@ -0,0 +1,73 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/kmogilevskii/hotel-reservation/db"
+	"github.com/kmogilevskii/hotel-reservation/errors"
+	"github.com/kmogilevskii/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type RoomStore struct {
+	coll       *collection
+	hotelStore db.HotelStore
+}
+
+func NewRoomStore(hotelStore db.HotelStore) *RoomStore {
+	return &RoomStore{
+		coll:       newCollection(),
+		hotelStore: hotelStore,
+	}
+}
+
+func (s *RoomStore) InsertRoom(ctx context.Context, room *types.Room) (*types.Room, error) {
+	if err := s.coll.insert(room); err != nil {
+		return nil, err
+	}
+	filter := db.Map{"_id": room.HotelID}
+	update := db.Map{"$push": db.Map{"rooms": room.ID}}
+	if err := s.hotelStore.Update(ctx, filter, update); err != nil {
+		return nil, err
+	}
+	return room, nil
+}
+
+func (s *RoomStore) GetRooms(ctx context.Context, filter db.Map, pag *db.Pagination) ([]*types.Room, error) {
+	return find[types.Room](s.coll, filter, pag)
+}
+
+func (s *RoomStore) GetRoomByID(ctx context.Context, id string) (*types.Room, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, errors.ErrInvalidID()
+	}
+	var room types.Room
+	if err := s.coll.findOne(db.Map{"_id": oid}, &room); err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+func (s *RoomStore) UpdateRoom(ctx context.Context, id string, params types.UpdateRoomParams) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errors.ErrInvalidID()
+	}
+	return s.coll.updateOne(db.Map{"_id": oid}, db.Map{"$set": params.ToBSON()})
+}
+
+func (s *RoomStore) DeleteRoom(ctx context.Context, id string) error {
+	room, err := s.GetRoomByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.coll.deleteOne(db.Map{"_id": room.ID}); err != nil {
+		return err
+	}
+	filter := db.Map{"_id": room.HotelID}
+	update := db.Map{"$pull": db.Map{"rooms": room.ID}}
+	return s.hotelStore.Update(ctx, filter, update)
+}
+
+var _ db.RoomStore = (*RoomStore)(nil)
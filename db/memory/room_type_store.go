@@ -0,0 +1,73 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/kmogilevskii/hotel-reservation/db"
+	"github.com/kmogilevskii/hotel-reservation/errors"
+	"github.com/kmogilevskii/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type RoomTypeStore struct {
+	coll       *collection
+	hotelStore db.HotelStore
+}
+
+func NewRoomTypeStore(hotelStore db.HotelStore) *RoomTypeStore {
+	return &RoomTypeStore{
+		coll:       newCollection(),
+		hotelStore: hotelStore,
+	}
+}
+
+func (s *RoomTypeStore) InsertRoomType(ctx context.Context, roomType *types.RoomType) (*types.RoomType, error) {
+	if err := s.coll.insert(roomType); err != nil {
+		return nil, err
+	}
+	filter := db.Map{"_id": roomType.HotelID}
+	update := db.Map{"$push": db.Map{"roomTypes": roomType.ID}}
+	if err := s.hotelStore.Update(ctx, filter, update); err != nil {
+		return nil, err
+	}
+	return roomType, nil
+}
+
+func (s *RoomTypeStore) GetRoomTypes(ctx context.Context, filter db.Map, pag *db.Pagination) ([]*types.RoomType, error) {
+	return find[types.RoomType](s.coll, filter, pag)
+}
+
+func (s *RoomTypeStore) GetRoomTypeByID(ctx context.Context, id string) (*types.RoomType, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, errors.ErrInvalidID()
+	}
+	var roomType types.RoomType
+	if err := s.coll.findOne(db.Map{"_id": oid}, &roomType); err != nil {
+		return nil, err
+	}
+	return &roomType, nil
+}
+
+func (s *RoomTypeStore) UpdateRoomType(ctx context.Context, id string, params types.UpdateRoomTypeParams) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errors.ErrInvalidID()
+	}
+	return s.coll.updateOne(db.Map{"_id": oid}, db.Map{"$set": params.ToBSON()})
+}
+
+func (s *RoomTypeStore) DeleteRoomType(ctx context.Context, id string) error {
+	roomType, err := s.GetRoomTypeByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.coll.deleteOne(db.Map{"_id": roomType.ID}); err != nil {
+		return err
+	}
+	filter := db.Map{"_id": roomType.HotelID}
+	update := db.Map{"$pull": db.Map{"roomTypes": roomType.ID}}
+	return s.hotelStore.Update(ctx, filter, update)
+}
+
+var _ db.RoomTypeStore = (*RoomTypeStore)(nil)
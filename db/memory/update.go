@@ -0,0 +1,130 @@
+package memory
+
+import (
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// apply runs the update operators $set, $unset, $inc, $push, $addToSet and
+// $pull against doc in place.
+func apply(doc, update bson.M) error {
+	if len(update) == 0 {
+		return fmt.Errorf("update document must not be empty")
+	}
+	for op, arg := range update {
+		fields, ok := arg.(bson.M)
+		if !ok {
+			return fmt.Errorf("modifier %s needs a document", op)
+		}
+		for path, v := range fields {
+			parent, key := parentOf(doc, path)
+			if parent == nil {
+				return fmt.Errorf("cannot traverse %s", path)
+			}
+			cur, exists := parent[key]
+			switch op {
+			case "$set":
+				parent[key] = v
+			case "$unset":
+				delete(parent, key)
+			case "$inc":
+				delta, ok := number(v)
+				if !ok {
+					return fmt.Errorf("cannot $inc with non-numeric argument")
+				}
+				if !exists {
+					parent[key] = v
+					continue
+				}
+				n, ok := number(cur)
+				if !ok {
+					return fmt.Errorf("cannot apply $inc to a non-numeric value")
+				}
+				parent[key] = incremented(cur, n+delta)
+			case "$push", "$addToSet":
+				arr, err := asArray(cur, exists, op)
+				if err != nil {
+					return err
+				}
+				values := primitive.A{v}
+				if m, ok := v.(bson.M); ok {
+					if each, ok := m["$each"].(primitive.A); ok {
+						values = each
+					}
+				}
+				for _, value := range values {
+					if op == "$addToSet" && matchEq(arr, value) {
+						continue
+					}
+					arr = append(arr, value)
+				}
+				parent[key] = arr
+			case "$pull":
+				arr, err := asArray(cur, exists, op)
+				if err != nil {
+					return err
+				}
+				kept := primitive.A{}
+				for _, value := range arr {
+					ok, err := matchField(value, v)
+					if err != nil {
+						return err
+					}
+					if !ok {
+						kept = append(kept, value)
+					}
+				}
+				parent[key] = kept
+			default:
+				return fmt.Errorf("unknown modifier: %s", op)
+			}
+		}
+	}
+	return nil
+}
+
+func incremented(cur any, n float64) any {
+	switch cur.(type) {
+	case int32:
+		return int32(n)
+	case int64:
+		return int64(n)
+	}
+	return n
+}
+
+func asArray(v any, exists bool, op string) (primitive.A, error) {
+	if !exists || v == nil {
+		return primitive.A{}, nil
+	}
+	arr, ok := v.(primitive.A)
+	if !ok {
+		return nil, fmt.Errorf("%s requires an array field", op)
+	}
+	return append(primitive.A{}, arr...), nil
+}
+
+// parentOf returns the embedded document holding the last segment of path,
+// creating intermediate documents as $set does.
+func parentOf(doc bson.M, path string) (bson.M, string) {
+	parts := strings.Split(path, ".")
+	cur := doc
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := cur[part]
+		if !ok {
+			m := bson.M{}
+			cur[part] = m
+			cur = m
+			continue
+		}
+		m, ok := next.(bson.M)
+		if !ok {
+			return nil, ""
+		}
+		cur = m
+	}
+	return cur, parts[len(parts)-1]
+}
@@ -0,0 +1,74 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/kmogilevskii/hotel-reservation/db"
+	"github.com/kmogilevskii/hotel-reservation/errors"
+	"github.com/kmogilevskii/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type UserStore struct {
+	coll *collection
+}
+
+func NewUserStore() *UserStore {
+	return &UserStore{
+		coll: newCollection(),
+	}
+}
+
+func (s *UserStore) GetUserByID(ctx context.Context, id string) (*types.User, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, errors.ErrInvalidID()
+	}
+	var user types.User
+	if err := s.coll.findOne(db.Map{"_id": oid}, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *UserStore) GetUserByEmail(ctx context.Context, email string) (*types.User, error) {
+	var user types.User
+	if err := s.coll.findOne(db.Map{"email": email}, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *UserStore) GetUsers(ctx context.Context, filter db.Map, pag *db.Pagination) ([]*types.User, error) {
+	return find[types.User](s.coll, filter, pag)
+}
+
+func (s *UserStore) CreateUser(ctx context.Context, user *types.User) (*types.User, error) {
+	if err := s.coll.insert(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *UserStore) DeleteUser(ctx context.Context, id string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errors.ErrInvalidID()
+	}
+	return s.coll.deleteOne(db.Map{"_id": oid})
+}
+
+func (s *UserStore) UpdateUser(ctx context.Context, id string, params types.UpdateUserParams) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errors.ErrInvalidID()
+	}
+	return s.coll.updateOne(db.Map{"_id": oid}, db.Map{"$set": params.ToBSON()})
+}
+
+func (s *UserStore) Drop(ctx context.Context) error {
+	s.coll.drop()
+	return nil
+}
+
+var _ db.UserStore = (*UserStore)(nil)
@@ -0,0 +1,100 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/kmogilevskii/hotel-reservation/db"
+	"github.com/kmogilevskii/hotel-reservation/errors"
+	"github.com/kmogilevskii/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type WaitlistStore struct {
+	coll *collection
+}
+
+func NewWaitlistStore() *WaitlistStore {
+	return &WaitlistStore{coll: newCollection()}
+}
+
+func (s *WaitlistStore) InsertWaitlistEntry(ctx context.Context, entry *types.WaitlistEntry) (*types.WaitlistEntry, error) {
+	if err := s.coll.insert(entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (s *WaitlistStore) GetWaitlistEntryByID(ctx context.Context, id string) (*types.WaitlistEntry, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, errors.ErrInvalidID()
+	}
+	var entry types.WaitlistEntry
+	if err := s.coll.findOne(db.Map{"_id": oid}, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (s *WaitlistStore) GetWaitlist(ctx context.Context, filter db.Map) ([]*types.WaitlistEntry, error) {
+	entries, err := find[types.WaitlistEntry](s.coll, filter, &db.Pagination{Page: 1})
+	if err != nil {
+		return nil, err
+	}
+	if entries == nil {
+		entries = []*types.WaitlistEntry{}
+	}
+	slices.SortStableFunc(entries, func(a, b *types.WaitlistEntry) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return entries, nil
+}
+
+func (s *WaitlistStore) OfferWaitlistHold(ctx context.Context, id string, holdID primitive.ObjectID, at time.Time) error {
+	update := db.Map{"$set": db.Map{"status": types.WaitlistOffered, "holdID": holdID, "offeredAt": at}}
+	return s.updateWaiting(id, update)
+}
+
+func (s *WaitlistStore) MarkWaitlistNotified(ctx context.Context, id string, at time.Time) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errors.ErrInvalidID()
+	}
+	return s.coll.updateOne(db.Map{"_id": oid}, db.Map{"$set": db.Map{"notifiedAt": at}})
+}
+
+func (s *WaitlistStore) LapseWaitlistOffer(ctx context.Context, holdID primitive.ObjectID) error {
+	filter, update := db.LapseOfferUpdate(holdID)
+	return s.coll.updateOne(filter, update)
+}
+
+func (s *WaitlistStore) LeaveWaitlist(ctx context.Context, id string) error {
+	return s.updateWaiting(id, db.Map{"$set": db.Map{"status": types.WaitlistLeft}})
+}
+
+func (s *WaitlistStore) updateWaiting(id string, update db.Map) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errors.ErrInvalidID()
+	}
+	ok, err := s.coll.update(db.Map{"_id": oid, "status": types.WaitlistWaiting}, update)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.ErrNotWaiting()
+	}
+	return nil
+}
+
+func (s *WaitlistStore) WaitlistDepth(ctx context.Context, now time.Time) ([]types.WaitlistDepth, error) {
+	entries, err := s.GetWaitlist(ctx, db.WaitingFilter(now))
+	if err != nil {
+		return nil, err
+	}
+	return db.WaitlistDepths(entries), nil
+}
+
+var _ db.WaitlistStore = (*WaitlistStore)(nil)
@@ -0,0 +1,291 @@
+package db
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/kmogilevskii/hotel-reservation/errors"
+	"github.com/kmogilevskii/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Inventory is what the booking stores check bookings against: the rooms,
+// the room types and hotels they are sold under, and how far those may be
+// overbooked.
+type Inventory struct {
+	Rooms     RoomStore
+	RoomTypes RoomTypeStore
+	Hotels    HotelStore
+}
+
+// Pool is a set of rooms sold interchangeably: the rooms of a room type, or
+// the rooms of a hotel that have no type. The front desk can move a booking
+// to any room of its pool, so each night the pool takes as many bookings as
+// it has rooms plus Extra, its overbooking allowance.
+type Pool struct {
+	Rooms int
+	Extra int
+	key   string
+	value any
+}
+
+// Overlapping matches the bookings of the pool staying during from..till,
+// leaving out the holds that expired by now.
+func (p *Pool) Overlapping(from, till, now time.Time) Map {
+	return overlapping(p.key, p.value, from, till, now)
+}
+
+// FindBookings returns the bookings matching the filter. The booking stores
+// pass their own to share the checks below.
+type FindBookings func(ctx context.Context, filter Map) ([]*types.Booking, error)
+
+// Free returns how many more bookings the pool takes on every night of the
+// stay, ignoring the excluded booking. It is negative when the pool is
+// overbooked beyond its allowance, e.g. after the allowance was lowered.
+func (p *Pool) Free(ctx context.Context, find FindBookings, from, till, now time.Time, exclude primitive.ObjectID) (int, error) {
+	filter := p.Overlapping(from, till, now)
+	filter["_id"] = Map{"$ne": exclude}
+	bookings, err := find(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	return p.Rooms + p.Extra - PeakOccupancy(bookings, from, till), nil
+}
+
+// TypePool returns the pool of the room type's rooms.
+func (inv Inventory) TypePool(ctx context.Context, roomTypeID primitive.ObjectID) (*Pool, error) {
+	roomType, err := inv.RoomTypes.GetRoomTypeByID(ctx, roomTypeID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	hotel, err := inv.Hotels.GetHotelByID(ctx, roomType.HotelID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	rooms, err := inv.Rooms.GetRooms(ctx, Map{"roomTypeID": roomTypeID}, &Pagination{})
+	if err != nil {
+		return nil, err
+	}
+	return &Pool{
+		Rooms: len(rooms),
+		Extra: types.Overbook(len(rooms), roomType.OverbookingPercent(hotel)),
+		key:   "roomTypeID",
+		value: roomTypeID,
+	}, nil
+}
+
+// RoomPool returns the pool the room is sold in.
+func (inv Inventory) RoomPool(ctx context.Context, room *types.Room) (*Pool, error) {
+	if !room.RoomTypeID.IsZero() {
+		return inv.TypePool(ctx, room.RoomTypeID)
+	}
+	hotel, err := inv.Hotels.GetHotelByID(ctx, room.HotelID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	return inv.untypedPool(ctx, hotel)
+}
+
+// HotelPools returns the pools of the hotel's rooms, those of its room types
+// first.
+func (inv Inventory) HotelPools(ctx context.Context, hotel *types.Hotel) ([]*Pool, error) {
+	var pools []*Pool
+	for _, id := range hotel.RoomTypes {
+		pool, err := inv.TypePool(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		pools = append(pools, pool)
+	}
+	pool, err := inv.untypedPool(ctx, hotel)
+	if err != nil {
+		return nil, err
+	}
+	return append(pools, pool), nil
+}
+
+func (inv Inventory) untypedPool(ctx context.Context, hotel *types.Hotel) (*Pool, error) {
+	filter := Map{"hotelID": hotel.ID, "roomTypeID": Map{"$exists": false}}
+	rooms, err := inv.Rooms.GetRooms(ctx, filter, &Pagination{})
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]primitive.ObjectID, len(rooms))
+	for i, room := range rooms {
+		ids[i] = room.ID
+	}
+	return &Pool{
+		Rooms: len(rooms),
+		Extra: types.Overbook(len(rooms), hotel.Overbooking),
+		key:   "roomID",
+		value: Map{"$in": ids},
+	}, nil
+}
+
+// LockKey returns the id bookings are serialized on: their room type if
+// they have one, otherwise the hotel of their room, since the hotel's rooms
+// without a type may be overbooked together. It doesn't depend on the
+// hotel's overbooking setting, so bookings racing a change of it still
+// serialize.
+func (inv Inventory) LockKey(ctx context.Context, booking *types.Booking) (primitive.ObjectID, error) {
+	if !booking.RoomTypeID.IsZero() {
+		return booking.RoomTypeID, nil
+	}
+	room, err := inv.Rooms.GetRoomByID(ctx, booking.RoomID.Hex())
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return room.HotelID, nil
+}
+
+// RoomTaken reports whether another booking stays in the booking's room
+// during its stay.
+func RoomTaken(ctx context.Context, find FindBookings, booking *types.Booking, now time.Time) (bool, error) {
+	filter := OverlappingBookingsFilter(booking.RoomID, booking.FromDate, booking.TillDate, now)
+	filter["_id"] = Map{"$ne": booking.ID}
+	bookings, err := find(ctx, filter)
+	if err != nil {
+		return false, err
+	}
+	return len(bookings) > 0, nil
+}
+
+// CheckConflicts makes sure the booking fits its room and room type during
+// its stay, ignoring the booking itself. It returns errors.ErrAlreadyBooked
+// when the room is taken and errors.ErrSoldOut when the room type is. A
+// taken room can still be booked while its pool has a booking left within
+// its overbooking allowance, the front desk moves one of the guests to
+// another room of the pool. Maintenance blocks always need a free room.
+func CheckConflicts(ctx context.Context, inv Inventory, find FindBookings, booking *types.Booking, now time.Time) error {
+	if !booking.RoomID.IsZero() {
+		if err := checkRoom(ctx, inv, find, booking, now); err != nil {
+			return err
+		}
+	}
+	if !booking.RoomTypeID.IsZero() {
+		pool, err := inv.TypePool(ctx, booking.RoomTypeID)
+		if err != nil {
+			return err
+		}
+		free, err := pool.Free(ctx, find, booking.FromDate, booking.TillDate, now, booking.ID)
+		if err != nil {
+			return err
+		}
+		if free < 1 {
+			return errors.ErrSoldOut()
+		}
+	}
+	return nil
+}
+
+// checkRoom checks the booking's room. Rooms of a type leave counting their
+// pool to the check of the type.
+func checkRoom(ctx context.Context, inv Inventory, find FindBookings, booking *types.Booking, now time.Time) error {
+	taken, err := RoomTaken(ctx, find, booking, now)
+	if err != nil {
+		return err
+	}
+	if !taken && !booking.RoomTypeID.IsZero() {
+		return nil
+	}
+	if taken && booking.Status == types.StatusBlocked {
+		return errors.ErrAlreadyBooked()
+	}
+	room, err := inv.Rooms.GetRoomByID(ctx, booking.RoomID.Hex())
+	if err != nil {
+		return err
+	}
+	pool, err := inv.RoomPool(ctx, room)
+	if err != nil {
+		return err
+	}
+	switch {
+	case pool.Extra == 0 && taken:
+		return errors.ErrAlreadyBooked()
+	case pool.Extra == 0, !booking.RoomTypeID.IsZero():
+		return nil
+	}
+	free, err := pool.Free(ctx, find, booking.FromDate, booking.TillDate, now, booking.ID)
+	if err != nil {
+		return err
+	}
+	if free < 1 {
+		return errors.ErrAlreadyBooked()
+	}
+	return nil
+}
+
+// RoomAvailable reports whether a booking of the room for the stay from..till
+// would go through.
+func RoomAvailable(ctx context.Context, inv Inventory, find FindBookings, roomID primitive.ObjectID, from, till, now time.Time) (bool, error) {
+	room, err := inv.Rooms.GetRoomByID(ctx, roomID.Hex())
+	if err != nil {
+		return false, err
+	}
+	booking := &types.Booking{RoomID: room.ID, RoomTypeID: room.RoomTypeID, FromDate: from, TillDate: till}
+	switch err := CheckConflicts(ctx, inv, find, booking, now); err {
+	case nil:
+		return true, nil
+	case errors.ErrAlreadyBooked(), errors.ErrSoldOut():
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// TypeAvailability returns how many more bookings the room type takes on
+// every night of the stay, counting its overbooking allowance.
+func TypeAvailability(ctx context.Context, inv Inventory, find FindBookings, roomTypeID primitive.ObjectID, from, till, now time.Time) (int, error) {
+	pool, err := inv.TypePool(ctx, roomTypeID)
+	if err != nil {
+		return 0, err
+	}
+	free, err := pool.Free(ctx, find, from, till, now, primitive.NilObjectID)
+	if err != nil {
+		return 0, err
+	}
+	return max(free, 0), nil
+}
+
+// Overflow returns the bookings of a pool of that many rooms that can't be
+// accommodated if every guest shows up, in the order they arrive. Guests
+// keep their rooms in the order they arrive, and of those arriving together
+// the ones who booked first do. Maintenance blocks always keep theirs. A
+// guest who is relocated stays away for the whole stay.
+func Overflow(bookings []*types.Booking, rooms int) []*types.Booking {
+	bookings = slices.Clone(bookings)
+	slices.SortStableFunc(bookings, func(a, b *types.Booking) int {
+		if c := a.FromDate.Compare(b.FromDate); c != 0 {
+			return c
+		}
+		if blocked := a.Status == types.StatusBlocked; blocked != (b.Status == types.StatusBlocked) {
+			if blocked {
+				return -1
+			}
+			return 1
+		}
+		return bookedAt(a).Compare(bookedAt(b))
+	})
+	var staying, overflow []*types.Booking
+	for _, b := range bookings {
+		// stays are half-open, so a departure frees the room for an
+		// arrival at the same time
+		staying = slices.DeleteFunc(staying, func(s *types.Booking) bool {
+			return !s.TillDate.After(b.FromDate)
+		})
+		if len(staying) < rooms || b.Status == types.StatusBlocked {
+			staying = append(staying, b)
+			continue
+		}
+		overflow = append(overflow, b)
+	}
+	return overflow
+}
+
+func bookedAt(booking *types.Booking) time.Time {
+	if len(booking.StatusHistory) > 0 {
+		return booking.StatusHistory[0].At
+	}
+	return booking.ID.Timestamp()
+}
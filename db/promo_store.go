@@ -0,0 +1,147 @@
+package db
+
+import (
+	"context"
+	"os"
+
+	"github.com/kmogilevskii/hotel-reservation/errors"
+	"github.com/kmogilevskii/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type PromoCodeStore interface {
+	// InsertPromoCode fails with a duplicate key error if the code exists.
+	InsertPromoCode(context.Context, *types.PromoCode) (*types.PromoCode, error)
+	GetPromoCodes(context.Context, Map, *Pagination) ([]*types.PromoCode, error)
+	GetPromoCode(context.Context, string) (*types.PromoCode, error)
+	// RedeemPromoCode counts a use of the code. It fails with
+	// ErrPromoUsedUp once a capped code has no uses left.
+	RedeemPromoCode(context.Context, string) error
+	// ReleasePromoCode gives back a use redeemed for a booking that didn't
+	// go through.
+	ReleasePromoCode(context.Context, string) error
+	// ClaimFirstBooking records the guest's first booking promo code. It
+	// fails with a duplicate key error if the guest claimed one before.
+	ClaimFirstBooking(context.Context, *types.FirstBooking) error
+	// ReleaseFirstBooking gives back the guest's claim on the code, once
+	// the booking that made it didn't go through.
+	ReleaseFirstBooking(ctx context.Context, userID, code string) error
+}
+
+// RedeemCappedUpdate checks and decrements usesLeft in one update, so
+// concurrent bookings can't use a code more than MaxUses times.
+func RedeemCappedUpdate(code string) (Map, Map) {
+	return Map{"_id": code, "usesLeft": Map{"$gt": 0}},
+		Map{"$inc": Map{"uses": 1, "usesLeft": -1}}
+}
+
+// RedeemUncappedUpdate only counts uses of codes without a cap.
+func RedeemUncappedUpdate(code string) (Map, Map) {
+	return Map{"_id": code, "usesLeft": Map{"$exists": false}},
+		Map{"$inc": Map{"uses": 1}}
+}
+
+// ReleasePromoUpdate returns the filter matching a capped code and the updates
+// giving a use back to capped and uncapped codes.
+func ReleasePromoUpdate(code string) (Map, Map, Map) {
+	return Map{"_id": code, "usesLeft": Map{"$exists": true}},
+		Map{"$inc": Map{"uses": -1, "usesLeft": 1}},
+		Map{"$inc": Map{"uses": -1}}
+}
+
+// FirstBookingFilter matches the guest's claim on the code.
+func FirstBookingFilter(userID, code string) (Map, error) {
+	oid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.ErrInvalidID()
+	}
+	return Map{"_id": oid, "code": types.NormalizePromoCode(code)}, nil
+}
+
+type MongoPromoCodeStore struct {
+	client        *mongo.Client
+	coll          *mongo.Collection
+	firstBookings *mongo.Collection
+}
+
+func NewMongoPromoCodeStore(client *mongo.Client) *MongoPromoCodeStore {
+	DBNAME := os.Getenv(MONGO_DBNAME_ENV_VARIABLE_NAME)
+	return &MongoPromoCodeStore{
+		client:        client,
+		coll:          client.Database(DBNAME).Collection("promo_codes"),
+		firstBookings: client.Database(DBNAME).Collection("first_bookings"),
+	}
+}
+
+func (m *MongoPromoCodeStore) InsertPromoCode(ctx context.Context, promo *types.PromoCode) (*types.PromoCode, error) {
+	if _, err := m.coll.InsertOne(ctx, promo); err != nil {
+		return nil, err
+	}
+	return promo, nil
+}
+
+func (m *MongoPromoCodeStore) GetPromoCodes(ctx context.Context, filter Map, pag *Pagination) ([]*types.PromoCode, error) {
+	opts := options.FindOptions{}
+	opts.SetSkip(int64(pag.Page-1) * pag.Limit)
+	opts.SetLimit(int64(pag.Limit))
+	resp, err := m.coll.Find(ctx, filter, &opts)
+	if err != nil {
+		return nil, err
+	}
+	var promos []*types.PromoCode
+	if err := resp.All(ctx, &promos); err != nil {
+		return nil, err
+	}
+	return promos, nil
+}
+
+func (m *MongoPromoCodeStore) GetPromoCode(ctx context.Context, code string) (*types.PromoCode, error) {
+	var promo types.PromoCode
+	if err := m.coll.FindOne(ctx, bson.M{"_id": types.NormalizePromoCode(code)}).Decode(&promo); err != nil {
+		return nil, err
+	}
+	return &promo, nil
+}
+
+func (m *MongoPromoCodeStore) RedeemPromoCode(ctx context.Context, code string) error {
+	code = types.NormalizePromoCode(code)
+	for _, redeem := range []func(string) (Map, Map){RedeemCappedUpdate, RedeemUncappedUpdate} {
+		filter, update := redeem(code)
+		res, err := m.coll.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return err
+		}
+		if res.MatchedCount > 0 {
+			return nil
+		}
+	}
+	return errors.ErrPromoUsedUp()
+}
+
+func (m *MongoPromoCodeStore) ReleasePromoCode(ctx context.Context, code string) error {
+	code = types.NormalizePromoCode(code)
+	filter, capped, uncapped := ReleasePromoUpdate(code)
+	res, err := m.coll.UpdateOne(ctx, filter, capped)
+	if err != nil || res.MatchedCount > 0 {
+		return err
+	}
+	_, err = m.coll.UpdateOne(ctx, Map{"_id": code}, uncapped)
+	return err
+}
+
+func (m *MongoPromoCodeStore) ClaimFirstBooking(ctx context.Context, claim *types.FirstBooking) error {
+	_, err := m.firstBookings.InsertOne(ctx, claim)
+	return err
+}
+
+func (m *MongoPromoCodeStore) ReleaseFirstBooking(ctx context.Context, userID, code string) error {
+	filter, err := FirstBookingFilter(userID, code)
+	if err != nil {
+		return err
+	}
+	_, err = m.firstBookings.DeleteOne(ctx, filter)
+	return err
+}
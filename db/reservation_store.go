@@ -0,0 +1,55 @@
+package db
+
+import (
+	"context"
+	"os"
+
+	"github.com/kmogilevskii/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type ReservationStore interface {
+	Insert(context.Context, *types.Reservation) (*types.Reservation, error)
+	GetReservationByID(context.Context, string) (*types.Reservation, error)
+	// DeleteReservation removes a reservation whose rooms didn't get booked.
+	DeleteReservation(context.Context, primitive.ObjectID) error
+}
+
+type MongoReservationStore struct {
+	client *mongo.Client
+	coll   *mongo.Collection
+}
+
+func NewMongoReservationStore(client *mongo.Client) *MongoReservationStore {
+	DBNAME := os.Getenv(MONGO_DBNAME_ENV_VARIABLE_NAME)
+	return &MongoReservationStore{
+		client: client,
+		coll:   client.Database(DBNAME).Collection("reservations"),
+	}
+}
+
+func (m *MongoReservationStore) Insert(ctx context.Context, reservation *types.Reservation) (*types.Reservation, error) {
+	if _, err := m.coll.InsertOne(ctx, reservation); err != nil {
+		return nil, err
+	}
+	return reservation, nil
+}
+
+func (m *MongoReservationStore) GetReservationByID(ctx context.Context, id string) (*types.Reservation, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	var reservation types.Reservation
+	if err := m.coll.FindOne(ctx, bson.M{"_id": oid}).Decode(&reservation); err != nil {
+		return nil, err
+	}
+	return &reservation, nil
+}
+
+func (m *MongoReservationStore) DeleteReservation(ctx context.Context, id primitive.ObjectID) error {
+	_, err := m.coll.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
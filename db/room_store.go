@@ -4,8 +4,10 @@ import (
 	"context"
 	"os"
 
+	"github.com/kmogilevskii/hotel-reservation/errors"
 	"github.com/kmogilevskii/hotel-reservation/types"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -13,6 +15,10 @@ import (
 type RoomStore interface {
 	InsertRoom(context.Context, *types.Room) (*types.Room, error)
 	GetRooms(context.Context, Map, *Pagination) ([]*types.Room, error)
+	GetRoomByID(context.Context, string) (*types.Room, error)
+	UpdateRoom(context.Context, string, types.UpdateRoomParams) error
+	// DeleteRoom removes the room and pulls it from its hotel's room list.
+	DeleteRoom(context.Context, string) error
 }
 
 type MongoRoomStore struct {
@@ -57,3 +63,37 @@ func (m *MongoRoomStore) GetRooms(ctx context.Context, filter Map, pag *Paginati
 	}
 	return rooms, nil
 }
+
+func (m *MongoRoomStore) GetRoomByID(ctx context.Context, id string) (*types.Room, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, errors.ErrInvalidID()
+	}
+	var room types.Room
+	if err := m.coll.FindOne(ctx, bson.M{"_id": oid}).Decode(&room); err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+func (m *MongoRoomStore) UpdateRoom(ctx context.Context, id string, params types.UpdateRoomParams) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errors.ErrInvalidID()
+	}
+	_, err = m.coll.UpdateOne(ctx, bson.M{"_id": oid}, bson.M{"$set": params.ToBSON()})
+	return err
+}
+
+func (m *MongoRoomStore) DeleteRoom(ctx context.Context, id string) error {
+	room, err := m.GetRoomByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if _, err := m.coll.DeleteOne(ctx, bson.M{"_id": room.ID}); err != nil {
+		return err
+	}
+	filter := Map{"_id": room.HotelID}
+	update := Map{"$pull": bson.M{"rooms": room.ID}}
+	return m.hotelStore.Update(ctx, filter, update)
+}
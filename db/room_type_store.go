@@ -0,0 +1,100 @@
+package db
+
+import (
+	"context"
+	"os"
+
+	"github.com/kmogilevskii/hotel-reservation/errors"
+	"github.com/kmogilevskii/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type RoomTypeStore interface {
+	// InsertRoomType stores the room type and adds it to its hotel.
+	InsertRoomType(context.Context, *types.RoomType) (*types.RoomType, error)
+	GetRoomTypes(context.Context, Map, *Pagination) ([]*types.RoomType, error)
+	GetRoomTypeByID(context.Context, string) (*types.RoomType, error)
+	UpdateRoomType(context.Context, string, types.UpdateRoomTypeParams) error
+	// DeleteRoomType removes the room type and pulls it from its hotel's
+	// room type list.
+	DeleteRoomType(context.Context, string) error
+}
+
+type MongoRoomTypeStore struct {
+	client     *mongo.Client
+	coll       *mongo.Collection
+	hotelStore HotelStore
+}
+
+func NewMongoRoomTypeStore(client *mongo.Client, hotelStore HotelStore) *MongoRoomTypeStore {
+	DBNAME := os.Getenv(MONGO_DBNAME_ENV_VARIABLE_NAME)
+	return &MongoRoomTypeStore{
+		client:     client,
+		coll:       client.Database(DBNAME).Collection("room_types"),
+		hotelStore: hotelStore,
+	}
+}
+
+func (m *MongoRoomTypeStore) InsertRoomType(ctx context.Context, roomType *types.RoomType) (*types.RoomType, error) {
+	if _, err := m.coll.InsertOne(ctx, roomType); err != nil {
+		return nil, err
+	}
+	filter := Map{"_id": roomType.HotelID}
+	update := Map{"$push": bson.M{"roomTypes": roomType.ID}}
+	if err := m.hotelStore.Update(ctx, filter, update); err != nil {
+		return nil, err
+	}
+	return roomType, nil
+}
+
+func (m *MongoRoomTypeStore) GetRoomTypes(ctx context.Context, filter Map, pag *Pagination) ([]*types.RoomType, error) {
+	opts := options.FindOptions{}
+	opts.SetSkip(int64(pag.Page-1) * pag.Limit)
+	opts.SetLimit(int64(pag.Limit))
+	resp, err := m.coll.Find(ctx, filter, &opts)
+	if err != nil {
+		return nil, err
+	}
+	var roomTypes []*types.RoomType
+	if err := resp.All(ctx, &roomTypes); err != nil {
+		return nil, err
+	}
+	return roomTypes, nil
+}
+
+func (m *MongoRoomTypeStore) GetRoomTypeByID(ctx context.Context, id string) (*types.RoomType, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, errors.ErrInvalidID()
+	}
+	var roomType types.RoomType
+	if err := m.coll.FindOne(ctx, bson.M{"_id": oid}).Decode(&roomType); err != nil {
+		return nil, err
+	}
+	return &roomType, nil
+}
+
+func (m *MongoRoomTypeStore) UpdateRoomType(ctx context.Context, id string, params types.UpdateRoomTypeParams) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errors.ErrInvalidID()
+	}
+	_, err = m.coll.UpdateOne(ctx, bson.M{"_id": oid}, bson.M{"$set": params.ToBSON()})
+	return err
+}
+
+func (m *MongoRoomTypeStore) DeleteRoomType(ctx context.Context, id string) error {
+	roomType, err := m.GetRoomTypeByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if _, err := m.coll.DeleteOne(ctx, bson.M{"_id": roomType.ID}); err != nil {
+		return err
+	}
+	filter := Map{"_id": roomType.HotelID}
+	update := Map{"$pull": bson.M{"roomTypes": roomType.ID}}
+	return m.hotelStore.Update(ctx, filter, update)
+}
@@ -0,0 +1,1336 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/kmogilevskii/hotel-reservation/db"
+	"github.com/kmogilevskii/hotel-reservation/db/fixtures"
+	"github.com/kmogilevskii/hotel-reservation/db/memory"
+	custom_errors "github.com/kmogilevskii/hotel-reservation/errors"
+	"github.com/kmogilevskii/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The tests in this file form a conformance suite: every case runs against
+// the in-memory stores and, when one is reachable, against MongoDB.
+
+var (
+	mongoOnce   sync.Once
+	mongoClient *mongo.Client
+	mongoErr    error
+)
+
+func connectMongo() (*mongo.Client, error) {
+	mongoOnce.Do(func() {
+		godotenv.Load("../.env")
+		uri := os.Getenv("MONGO_URI")
+		if uri == "" {
+			mongoErr = errors.New("MONGO_URI is not set")
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		opts := options.Client().ApplyURI(uri).SetServerSelectionTimeout(2 * time.Second)
+		client, err := mongo.Connect(ctx, opts)
+		if err == nil {
+			err = client.Ping(ctx, nil)
+		}
+		mongoClient, mongoErr = client, err
+	})
+	return mongoClient, mongoErr
+}
+
+func newMemoryStore(t *testing.T) *db.Store {
+	var (
+		hotelStore    = memory.NewHotelStore()
+		roomStore     = memory.NewRoomStore(hotelStore)
+		roomTypeStore = memory.NewRoomTypeStore(hotelStore)
+	)
+	return &db.Store{
+		User:         memory.NewUserStore(),
+		Hotel:        hotelStore,
+		Room:         roomStore,
+		RoomType:     roomTypeStore,
+		Booking:      memory.NewBookingStore(roomStore, roomTypeStore, hotelStore),
+		Reservation:  memory.NewReservationStore(),
+		PromoCode:    memory.NewPromoCodeStore(),
+		Invoice:      memory.NewInvoiceStore(),
+		ExchangeRate: memory.NewExchangeRateStore(),
+		Waitlist:     memory.NewWaitlistStore(),
+	}
+}
+
+func newMongoStore(t *testing.T) *db.Store {
+	client, err := connectMongo()
+	if err != nil {
+		t.Skipf("mongo is not available: %v", err)
+	}
+	t.Cleanup(func() {
+		DBNAME := os.Getenv(db.MONGO_DBNAME_ENV_VARIABLE_NAME)
+		if err := client.Database(DBNAME).Drop(context.TODO()); err != nil {
+			t.Fatal(err)
+		}
+	})
+	var (
+		hotelStore    = db.NewMongoHotelStore(client)
+		roomStore     = db.NewMongoRoomStore(client, hotelStore)
+		roomTypeStore = db.NewMongoRoomTypeStore(client, hotelStore)
+		invoiceStore  = db.NewMongoInvoiceStore(client)
+	)
+	if err := invoiceStore.CreateIndexes(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+	return &db.Store{
+		User:         db.NewMongoUserStore(client),
+		Hotel:        hotelStore,
+		Room:         roomStore,
+		RoomType:     roomTypeStore,
+		Booking:      db.NewMongoBookingStore(client, roomStore, roomTypeStore, hotelStore),
+		Reservation:  db.NewMongoReservationStore(client),
+		PromoCode:    db.NewMongoPromoCodeStore(client),
+		Invoice:      invoiceStore,
+		ExchangeRate: db.NewMongoExchangeRateStore(client),
+		Waitlist:     db.NewMongoWaitlistStore(client),
+	}
+}
+
+func forEachBackend(t *testing.T, fn func(t *testing.T, store *db.Store)) {
+	backends := []struct {
+		name  string
+		store func(*testing.T) *db.Store
+	}{
+		{"memory", newMemoryStore},
+		{"mongo", newMongoStore},
+	}
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			fn(t, b.store(t))
+		})
+	}
+}
+
+func TestUserStore(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *db.Store) {
+		ctx := context.Background()
+		foo := fixtures.AddUser(store.User, "foo", "bar", false)
+		fixtures.AddUser(store.User, "baz", "qux", true)
+
+		user, err := store.User.GetUserByID(ctx, foo.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Email != foo.Email || user.EncryptedPassword != foo.EncryptedPassword {
+			t.Fatalf("expected %+v, got %+v", foo, user)
+		}
+		if _, err := store.User.GetUserByEmail(ctx, "foo@bar.com"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.User.GetUserByID(ctx, "nope"); err != custom_errors.ErrInvalidID() {
+			t.Fatalf("expected invalid id error, got %v", err)
+		}
+		if _, err := store.User.GetUserByID(ctx, primitive.NewObjectID().Hex()); !errors.Is(err, mongo.ErrNoDocuments) {
+			t.Fatalf("expected no documents error, got %v", err)
+		}
+		if _, err := store.User.CreateUser(ctx, foo); !mongo.IsDuplicateKeyError(err) {
+			t.Fatalf("expected duplicate key error, got %v", err)
+		}
+
+		users, err := store.User.GetUsers(ctx, db.Map{"firstName": "baz"}, &db.Pagination{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(users) != 1 || !users[0].IsAdmin {
+			t.Fatalf("expected the admin user, got %v", users)
+		}
+		users, err = store.User.GetUsers(ctx, db.Map{}, &db.Pagination{Page: 2, Limit: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(users) != 1 || users[0].FirstName != "baz" {
+			t.Fatalf("expected the second user on page 2, got %v", users)
+		}
+
+		params := types.UpdateUserParams{FirstName: "Foo"}
+		if err := store.User.UpdateUser(ctx, foo.ID.Hex(), params); err != nil {
+			t.Fatal(err)
+		}
+		user, _ = store.User.GetUserByID(ctx, foo.ID.Hex())
+		if user.FirstName != "Foo" || user.LastName != "bar" {
+			t.Fatalf("expected only the first name to change, got %+v", user)
+		}
+
+		if err := store.User.DeleteUser(ctx, foo.ID.Hex()); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.User.GetUserByID(ctx, foo.ID.Hex()); !errors.Is(err, mongo.ErrNoDocuments) {
+			t.Fatalf("expected deleted user to be gone, got %v", err)
+		}
+
+		if err := store.User.Drop(ctx); err != nil {
+			t.Fatal(err)
+		}
+		users, err = store.User.GetUsers(ctx, db.Map{}, &db.Pagination{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(users) != 0 {
+			t.Fatalf("expected no users after drop, got %d", len(users))
+		}
+	})
+}
+
+func TestHotelAndRoomStore(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *db.Store) {
+		ctx := context.Background()
+		hilton := fixtures.AddHotel(store.Hotel, "Hilton", "New York", 5)
+		fixtures.AddHotel(store.Hotel, "Ritz", "Paris", 4)
+		single := fixtures.AddRoom(store.Room, hilton.ID, "Single", 99.99)
+		double := fixtures.AddRoom(store.Room, hilton.ID, "Double", 149.99)
+
+		hotel, err := store.Hotel.GetHotelByID(ctx, hilton.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(hotel.Rooms) != 2 || hotel.Rooms[0] != single.ID || hotel.Rooms[1] != double.ID {
+			t.Fatalf("expected rooms to be pushed onto the hotel, got %v", hotel.Rooms)
+		}
+		if _, err := store.Hotel.GetHotelByID(ctx, "nope"); err == nil {
+			t.Fatal("expected an error for an invalid id")
+		}
+
+		hotels, err := store.Hotel.GetHotels(ctx, db.Map{"rating": db.Map{"$gte": 5}}, &db.Pagination{Page: 1, Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(hotels) != 1 || hotels[0].ID != hilton.ID {
+			t.Fatalf("expected only the Hilton, got %v", hotels)
+		}
+		hotels, err = store.Hotel.GetHotels(ctx, db.Map{}, &db.Pagination{Page: 3, Limit: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(hotels) != 0 {
+			t.Fatalf("expected an empty page, got %v", hotels)
+		}
+
+		filter := db.Map{"_id": hilton.ID}
+		update := db.Map{"$pull": db.Map{"rooms": single.ID}}
+		if err := store.Hotel.Update(ctx, filter, update); err != nil {
+			t.Fatal(err)
+		}
+		hotel, _ = store.Hotel.GetHotelByID(ctx, hilton.ID.Hex())
+		if len(hotel.Rooms) != 1 || hotel.Rooms[0] != double.ID {
+			t.Fatalf("expected single room to be pulled, got %v", hotel.Rooms)
+		}
+
+		rooms, err := store.Room.GetRooms(ctx, db.Map{"hotelID": hilton.ID, "size": "Double"}, &db.Pagination{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rooms) != 1 || rooms[0].ID != double.ID || rooms[0].Price != 149.99 {
+			t.Fatalf("expected the double room, got %v", rooms)
+		}
+		rooms, err = store.Room.GetRooms(ctx, db.Map{"hotelID": hilton.ID.Hex()}, &db.Pagination{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rooms) != 0 {
+			t.Fatalf("expected a string id not to match an ObjectID, got %v", rooms)
+		}
+	})
+}
+
+func TestBookingStore(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *db.Store) {
+		ctx := context.Background()
+		var (
+			now    = time.Now()
+			user   = fixtures.AddUser(store.User, "foo", "bar", false)
+			other  = fixtures.AddUser(store.User, "baz", "qux", false)
+			hotel  = fixtures.AddHotel(store.Hotel, "Hilton", "New York", 5)
+			room   = fixtures.AddRoom(store.Room, hotel.ID, "Single", 99.99)
+			first  = fixtures.AddBooking(store.Booking, user.ID, room.ID, now.AddDate(0, 0, 2), now.AddDate(0, 0, 4), 2)
+			second = fixtures.AddBooking(store.Booking, other.ID, room.ID, now.AddDate(0, 0, 6), now.AddDate(0, 0, 8), 1)
+		)
+
+		booking, err := store.Booking.GetBookingByID(ctx, first.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !booking.FromDate.Equal(first.FromDate.Truncate(time.Millisecond)) || booking.NumPersons != 2 {
+			t.Fatalf("expected %+v, got %+v", first, booking)
+		}
+
+		bookings, err := store.Booking.GetBookings(ctx, db.Map{"userID": other.ID.Hex()}, &db.Pagination{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(bookings) != 1 || bookings[0].ID != second.ID {
+			t.Fatalf("expected the second booking, got %v", bookings)
+		}
+		if _, err := store.Booking.GetBookings(ctx, db.Map{"userID": "nope"}, &db.Pagination{}); err != custom_errors.ErrInvalidID() {
+			t.Fatalf("expected invalid id error, got %v", err)
+		}
+
+		filter := db.Map{
+			"roomID":   room.ID,
+			"fromDate": db.Map{"$lt": now.AddDate(0, 0, 7)},
+			"tillDate": db.Map{"$gt": now.AddDate(0, 0, 3)},
+		}
+		bookings, err = store.Booking.GetBookings(ctx, filter, &db.Pagination{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(bookings) != 2 {
+			t.Fatalf("expected both bookings to overlap, got %d", len(bookings))
+		}
+
+		if err := store.Booking.CancelBooking(ctx, first.ID.Hex(), types.Cancellation{}, nil); err != nil {
+			t.Fatal(err)
+		}
+		booking, _ = store.Booking.GetBookingByID(ctx, first.ID.Hex())
+		if booking.Status != types.StatusCanceled {
+			t.Fatal("expected booking to be canceled")
+		}
+		filter = db.Map{
+			"roomID": room.ID,
+			"status": db.Map{"$ne": types.StatusCanceled},
+		}
+		bookings, err = store.Booking.GetBookings(ctx, filter, &db.Pagination{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(bookings) != 1 || bookings[0].ID != second.ID {
+			t.Fatalf("expected only the active booking, got %v", bookings)
+		}
+
+		if _, err := store.Booking.GetBookingByID(ctx, primitive.NewObjectID().Hex()); !errors.Is(err, mongo.ErrNoDocuments) {
+			t.Fatalf("expected no documents error, got %v", err)
+		}
+	})
+}
+
+func TestBookRoomConcurrently(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *db.Store) {
+		var (
+			ctx       = context.Background()
+			now       = time.Now()
+			user      = fixtures.AddUser(store.User, "foo", "bar", false)
+			hotel     = fixtures.AddHotel(store.Hotel, "Hilton", "New York", 5)
+			room      = fixtures.AddRoom(store.Room, hotel.ID, "Single", 99.99)
+			attempts  = 20
+			wg        sync.WaitGroup
+			mu        sync.Mutex
+			successes int
+		)
+		for i := 0; i < attempts; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				// every stay overlaps the nights 5 to 6 of the others
+				booking := &types.Booking{
+					ID:         primitive.NewObjectID(),
+					RoomID:     room.ID,
+					UserID:     user.ID,
+					FromDate:   now.AddDate(0, 0, 2+i%3),
+					TillDate:   now.AddDate(0, 0, 7+i%3),
+					NumPersons: 1,
+				}
+				_, err := store.Booking.BookRoom(ctx, booking, now)
+				switch err {
+				case nil:
+					mu.Lock()
+					successes++
+					mu.Unlock()
+				case custom_errors.ErrAlreadyBooked():
+				default:
+					t.Error(err)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		if successes != 1 {
+			t.Fatalf("expected exactly 1 successful booking, got %d", successes)
+		}
+		bookings, err := store.Booking.GetBookings(ctx, db.Map{"roomID": room.ID}, &db.Pagination{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(bookings) != 1 {
+			t.Fatalf("expected 1 stored booking, got %d", len(bookings))
+		}
+
+		// a stay starting on the day the booked one ends is still possible
+		booking := &types.Booking{
+			ID:       primitive.NewObjectID(),
+			RoomID:   room.ID,
+			UserID:   user.ID,
+			FromDate: bookings[0].TillDate,
+			TillDate: bookings[0].TillDate.AddDate(0, 0, 1),
+		}
+		if _, err := store.Booking.BookRoom(ctx, booking, now); err != nil {
+			t.Fatalf("expected back-to-back booking to succeed, got %v", err)
+		}
+	})
+}
+
+func TestIsRoomAvailable(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2030, time.March, d, 14, 0, 0, 0, time.UTC)
+	}
+	// the room is booked for [10, 20) and had a canceled booking for [22, 25)
+	tests := []struct {
+		name      string
+		from      int
+		till      int
+		available bool
+	}{
+		{"entirely before", 5, 8, true},
+		{"ends when booking starts", 5, 10, true},
+		{"overlaps the start", 5, 12, false},
+		{"same start, shorter", 10, 15, false},
+		{"same start, longer", 10, 25, false},
+		{"identical", 10, 20, false},
+		{"contained", 12, 18, false},
+		{"contains", 5, 21, false},
+		{"same end, later start", 15, 20, false},
+		{"same end, earlier start", 5, 20, false},
+		{"overlaps the end", 18, 21, false},
+		{"starts when booking ends", 20, 22, true},
+		{"entirely after", 25, 28, true},
+		{"over the canceled booking", 22, 25, true},
+	}
+	forEachBackend(t, func(t *testing.T, store *db.Store) {
+		var (
+			ctx      = context.Background()
+			user     = fixtures.AddUser(store.User, "foo", "bar", false)
+			hotel    = fixtures.AddHotel(store.Hotel, "Hilton", "New York", 5)
+			room     = fixtures.AddRoom(store.Room, hotel.ID, "Single", 99.99)
+			other    = fixtures.AddRoom(store.Room, hotel.ID, "Single", 99.99)
+			canceled = fixtures.AddBooking(store.Booking, user.ID, room.ID, day(22), day(25), 1)
+		)
+		fixtures.AddBooking(store.Booking, user.ID, room.ID, day(10), day(20), 1)
+		if err := store.Booking.CancelBooking(ctx, canceled.ID.Hex(), types.Cancellation{}, nil); err != nil {
+			t.Fatal(err)
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				ok, err := store.Booking.IsRoomAvailable(ctx, room.ID, day(tt.from), day(tt.till), time.Now())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ok != tt.available {
+					t.Fatalf("expected available to be %t, got %t", tt.available, ok)
+				}
+				ok, err = store.Booking.IsRoomAvailable(ctx, other.ID, day(tt.from), day(tt.till), time.Now())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !ok {
+					t.Fatal("expected bookings of another room not to conflict")
+				}
+			})
+		}
+	})
+}
+
+func TestIsRoomAvailableBeyondFirstPage(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *db.Store) {
+		var (
+			ctx   = context.Background()
+			start = time.Date(2030, time.January, 1, 14, 0, 0, 0, time.UTC)
+			user  = fixtures.AddUser(store.User, "foo", "bar", false)
+			hotel = fixtures.AddHotel(store.Hotel, "Hilton", "New York", 5)
+			room  = fixtures.AddRoom(store.Room, hotel.ID, "Single", 99.99)
+		)
+		for i := 0; i < 150; i++ {
+			fixtures.AddBooking(store.Booking, user.ID, room.ID, start.AddDate(0, 0, i), start.AddDate(0, 0, i+1), 1)
+		}
+		last := start.AddDate(0, 0, 149)
+		ok, err := store.Booking.IsRoomAvailable(ctx, room.ID, last, last.AddDate(0, 0, 1), time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			t.Fatal("expected the 150th booking to block the room")
+		}
+	})
+}
+
+func TestUpdateAndDeleteHotelsAndRooms(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *db.Store) {
+		var (
+			ctx    = context.Background()
+			hotel  = fixtures.AddHotel(store.Hotel, "Hilton", "New York", 5)
+			single = fixtures.AddRoom(store.Room, hotel.ID, "Single", 99.99)
+			double = fixtures.AddRoom(store.Room, hotel.ID, "Double", 149.99)
+			rating = 3
+		)
+		err := store.Hotel.UpdateHotel(ctx, hotel.ID.Hex(), types.UpdateHotelParams{Name: "Hilton Midtown", Rating: &rating})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := store.Hotel.GetHotelByID(ctx, hotel.ID.Hex())
+		if got.Name != "Hilton Midtown" || got.Location != "New York" || got.Rating != 3 {
+			t.Fatalf("unexpected hotel after update %+v", got)
+		}
+
+		seaside := true
+		if err := store.Room.UpdateRoom(ctx, single.ID.Hex(), types.UpdateRoomParams{Seaside: &seaside}); err != nil {
+			t.Fatal(err)
+		}
+		room, err := store.Room.GetRoomByID(ctx, single.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !room.Seaside || room.Size != "Single" || room.Price != 99.99 {
+			t.Fatalf("unexpected room after update %+v", room)
+		}
+		if _, err := store.Room.GetRoomByID(ctx, "nope"); err != custom_errors.ErrInvalidID() {
+			t.Fatalf("expected invalid id error, got %v", err)
+		}
+
+		if err := store.Room.DeleteRoom(ctx, single.ID.Hex()); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.Room.GetRoomByID(ctx, single.ID.Hex()); !errors.Is(err, mongo.ErrNoDocuments) {
+			t.Fatalf("expected deleted room to be gone, got %v", err)
+		}
+		got, _ = store.Hotel.GetHotelByID(ctx, hotel.ID.Hex())
+		if len(got.Rooms) != 1 || got.Rooms[0] != double.ID {
+			t.Fatalf("expected the deleted room to be pulled from the hotel, got %v", got.Rooms)
+		}
+
+		if err := store.Hotel.DeleteHotel(ctx, hotel.ID.Hex()); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.Hotel.GetHotelByID(ctx, hotel.ID.Hex()); !errors.Is(err, mongo.ErrNoDocuments) {
+			t.Fatalf("expected deleted hotel to be gone, got %v", err)
+		}
+	})
+}
+
+func TestCancelBooking(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *db.Store) {
+		var (
+			ctx          = context.Background()
+			now          = time.Now()
+			user         = fixtures.AddUser(store.User, "foo", "bar", false)
+			hotel        = fixtures.AddHotel(store.Hotel, "Hilton", "New York", 5)
+			room         = fixtures.AddRoom(store.Room, hotel.ID, "Single", 99.99)
+			booking      = fixtures.AddBooking(store.Booking, user.ID, room.ID, now.AddDate(0, 0, 2), now.AddDate(0, 0, 4), 1)
+			cancellation = types.Cancellation{
+				CanceledBy: user.ID,
+				CanceledAt: now.Truncate(time.Millisecond).UTC(),
+				Refund:     types.Money{Amount: 1500, Currency: "USD"},
+				Penalty:    types.Money{Amount: 500, Currency: "USD"},
+			}
+			payment = &types.Payment{
+				Status:        types.PaymentCaptured,
+				Captured:      types.Money{Amount: 2000, Currency: "USD"},
+				Refunded:      types.Money{Currency: "USD"},
+				PendingRefund: &types.PendingRefund{Amount: cancellation.Refund, Since: cancellation.CanceledAt},
+			}
+		)
+		if err := store.Booking.CancelBooking(ctx, booking.ID.Hex(), cancellation, payment); err != nil {
+			t.Fatal(err)
+		}
+		got, _ := store.Booking.GetBookingByID(ctx, booking.ID.Hex())
+		if got.Status != types.StatusCanceled || got.Cancellation == nil || *got.Cancellation != cancellation {
+			t.Fatalf("expected %+v to be stored, got %+v", cancellation, got.Cancellation)
+		}
+		if got.Payment == nil || got.Payment.PendingRefund == nil || *got.Payment.PendingRefund != *payment.PendingRefund {
+			t.Fatalf("expected the refund owed to be stored with the cancellation, got %+v", got.Payment)
+		}
+		for _, tt := range []struct {
+			before time.Time
+			want   int
+		}{{now.Add(time.Minute), 1}, {now.Add(-time.Minute), 0}} {
+			owing, err := store.Booking.GetBookings(ctx, db.PendingRefundsFilter(tt.before), &db.Pagination{Page: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(owing) != tt.want {
+				t.Fatalf("expected %d refunds owed since before %s, got %d", tt.want, tt.before, len(owing))
+			}
+		}
+		change := types.StatusChange{Status: types.StatusCanceled, By: user.ID, At: cancellation.CanceledAt}
+		if len(got.StatusHistory) != 1 || got.StatusHistory[0] != change {
+			t.Fatalf("expected %+v in the status history, got %+v", change, got.StatusHistory)
+		}
+		if err := store.Booking.CancelBooking(ctx, booking.ID.Hex(), cancellation, nil); err != custom_errors.ErrStatusChanged() {
+			t.Fatalf("expected status changed error, got %v", err)
+		}
+	})
+}
+
+func TestModifyBooking(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *db.Store) {
+		var (
+			ctx     = context.Background()
+			now     = time.Now()
+			user    = fixtures.AddUser(store.User, "foo", "bar", false)
+			hotel   = fixtures.AddHotel(store.Hotel, "Hilton", "New York", 5)
+			room    = fixtures.AddRoom(store.Room, hotel.ID, "Single", 99.99)
+			booking = fixtures.AddBooking(store.Booking, user.ID, room.ID, now.AddDate(0, 0, 2), now.AddDate(0, 0, 4), 1)
+		)
+		fixtures.AddBooking(store.Booking, user.ID, room.ID, now.AddDate(0, 0, 6), now.AddDate(0, 0, 8), 1)
+
+		booking.FromDate = now.AddDate(0, 0, 3)
+		booking.TillDate = now.AddDate(0, 0, 6)
+		booking.NumPersons = 2
+		if err := store.Booking.ModifyBooking(ctx, booking, now); err != nil {
+			t.Fatal(err)
+		}
+		got, _ := store.Booking.GetBookingByID(ctx, booking.ID.Hex())
+		if !got.TillDate.Equal(booking.TillDate.Truncate(time.Millisecond)) || got.NumPersons != 2 {
+			t.Fatalf("expected the booking to be modified, got %+v", got)
+		}
+
+		booking.TillDate = now.AddDate(0, 0, 7)
+		if err := store.Booking.ModifyBooking(ctx, booking, now); err != custom_errors.ErrAlreadyBooked() {
+			t.Fatalf("expected already booked error, got %v", err)
+		}
+
+		booking.TillDate = now.AddDate(0, 0, 5)
+		store.Booking.CancelBooking(ctx, booking.ID.Hex(), types.Cancellation{}, nil)
+		if err := store.Booking.ModifyBooking(ctx, booking, now); err != custom_errors.ErrStatusChanged() {
+			t.Fatalf("expected status changed error, got %v", err)
+		}
+	})
+}
+
+func TestUpdateBookingStatus(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *db.Store) {
+		var (
+			ctx     = context.Background()
+			now     = time.Now()
+			user    = fixtures.AddUser(store.User, "foo", "bar", false)
+			hotel   = fixtures.AddHotel(store.Hotel, "Hilton", "New York", 5)
+			room    = fixtures.AddRoom(store.Room, hotel.ID, "Single", 99.99)
+			booking = fixtures.AddBooking(store.Booking, user.ID, room.ID, now, now.AddDate(0, 0, 2), 1)
+			change  = types.StatusChange{Status: types.StatusNoShow, By: user.ID, At: now.Truncate(time.Millisecond).UTC()}
+		)
+		if err := store.Booking.UpdateBookingStatus(ctx, booking.ID.Hex(), types.StatusConfirmed, change); err != nil {
+			t.Fatal(err)
+		}
+		got, _ := store.Booking.GetBookingByID(ctx, booking.ID.Hex())
+		if got.Status != types.StatusNoShow || len(got.StatusHistory) != 1 || got.StatusHistory[0] != change {
+			t.Fatalf("expected the no-show to be recorded, got %+v", got)
+		}
+		if err := store.Booking.UpdateBookingStatus(ctx, booking.ID.Hex(), types.StatusConfirmed, change); err != custom_errors.ErrStatusChanged() {
+			t.Fatalf("expected status changed error, got %v", err)
+		}
+		ok, err := store.Booking.IsRoomAvailable(ctx, room.ID, now, now.AddDate(0, 0, 2), now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Fatal("expected a no-show to release the room")
+		}
+	})
+}
+
+func TestHolds(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *db.Store) {
+		var (
+			ctx   = context.Background()
+			now   = time.Now()
+			from  = now.AddDate(0, 0, 1)
+			till  = now.AddDate(0, 0, 3)
+			user  = fixtures.AddUser(store.User, "foo", "bar", false)
+			hotel = fixtures.AddHotel(store.Hotel, "Hilton", "New York", 5)
+			room  = fixtures.AddRoom(store.Room, hotel.ID, "Single", 99.99)
+		)
+		hold := func(expiresAt time.Time) *types.Booking {
+			booking, err := store.Booking.BookRoom(ctx, &types.Booking{
+				ID:            primitive.NewObjectID(),
+				RoomID:        room.ID,
+				UserID:        user.ID,
+				FromDate:      from,
+				TillDate:      till,
+				NumPersons:    1,
+				Status:        types.StatusPending,
+				HoldExpiresAt: &expiresAt,
+			}, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return booking
+		}
+
+		expired := hold(now.Add(-time.Second))
+		ok, err := store.Booking.IsRoomAvailable(ctx, room.ID, from, till, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Fatal("expected an expired hold not to block the room")
+		}
+		change := types.StatusChange{Status: types.StatusConfirmed, By: user.ID, At: now}
+		if err := store.Booking.ConfirmHold(ctx, expired.ID.Hex(), change); err != custom_errors.ErrHoldExpired() {
+			t.Fatalf("expected hold expired error, got %v", err)
+		}
+
+		active := hold(now.Add(time.Minute))
+		if _, err := store.Booking.BookRoom(ctx, &types.Booking{RoomID: room.ID, FromDate: from, TillDate: till}, now); err != custom_errors.ErrAlreadyBooked() {
+			t.Fatalf("expected a hold to block the room, got %v", err)
+		}
+		ok, err = store.Booking.IsRoomAvailable(ctx, room.ID, from, till, now.Add(2*time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Fatal("expected a hold to stop blocking the room once it expired")
+		}
+
+		holds, err := store.Booking.ExpireHolds(ctx, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(holds) != 1 || holds[0].ID != expired.ID || holds[0].Status != types.StatusExpired {
+			t.Fatalf("expected the expired hold to be returned, got %+v", holds)
+		}
+		got, _ := store.Booking.GetBookingByID(ctx, expired.ID.Hex())
+		if got.Status != types.StatusExpired || len(got.StatusHistory) != 1 {
+			t.Fatalf("expected the hold to have expired, got %+v", got)
+		}
+
+		if err := store.Booking.ConfirmHold(ctx, active.ID.Hex(), change); err != nil {
+			t.Fatal(err)
+		}
+		got, _ = store.Booking.GetBookingByID(ctx, active.ID.Hex())
+		if got.Status != types.StatusConfirmed || got.HoldExpiresAt != nil {
+			t.Fatalf("expected the hold to be confirmed, got %+v", got)
+		}
+		if holds, _ := store.Booking.ExpireHolds(ctx, now.Add(time.Hour)); len(holds) != 0 {
+			t.Fatalf("expected confirmed bookings never to expire, got %d expired", len(holds))
+		}
+	})
+}
+
+func TestBalances(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *db.Store) {
+		var (
+			ctx   = context.Background()
+			now   = time.Now().Truncate(time.Millisecond).UTC()
+			user  = fixtures.AddUser(store.User, "foo", "bar", false)
+			hotel = fixtures.AddHotel(store.Hotel, "Hilton", "New York", 5)
+			room  = fixtures.AddRoom(store.Room, hotel.ID, "Single", 99.99)
+		)
+		book := func(daysOut int, dueAt time.Time) *types.Booking {
+			booking := fixtures.AddBooking(store.Booking, user.ID, room.ID, now.AddDate(0, 0, daysOut), now.AddDate(0, 0, daysOut+1), 1)
+			booking.Balance = &types.Balance{
+				Amount: types.Money{Amount: 8000, Currency: "USD"},
+				DueAt:  dueAt,
+				Status: types.BalanceDue,
+			}
+			if err := store.Booking.UpdatePayment(ctx, booking); err != nil {
+				t.Fatal(err)
+			}
+			return booking
+		}
+		late := book(2, now.Add(-time.Hour))
+		upcoming := book(4, now.Add(time.Hour))
+
+		n, err := store.Booking.MarkBalancesOverdue(ctx, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Fatalf("expected 1 balance to be overdue, got %d", n)
+		}
+		got, _ := store.Booking.GetBookingByID(ctx, late.ID.Hex())
+		if got.Balance == nil || got.Balance.Status != types.BalanceOverdue || got.Balance.Amount.Amount != 8000 {
+			t.Fatalf("expected the balance to be overdue, got %+v", got.Balance)
+		}
+
+		payment := &types.Payment{Status: types.PaymentCaptured, Captured: types.Money{Amount: 8000, Currency: "USD"}}
+		if err := store.Booking.PayBalance(ctx, upcoming.ID.Hex(), payment, now); err != nil {
+			t.Fatal(err)
+		}
+		got, _ = store.Booking.GetBookingByID(ctx, upcoming.ID.Hex())
+		if got.Balance.Status != types.BalancePaid || got.Balance.PaidAt == nil || !got.Balance.PaidAt.Equal(now) || got.Payment == nil {
+			t.Fatalf("expected the balance to be paid, got %+v %+v", got.Balance, got.Payment)
+		}
+		if err := store.Booking.PayBalance(ctx, upcoming.ID.Hex(), payment, now); err != custom_errors.ErrStatusChanged() {
+			t.Fatalf("expected status changed error, got %v", err)
+		}
+		if n, _ := store.Booking.MarkBalancesOverdue(ctx, now.Add(2*time.Hour)); n != 0 {
+			t.Fatalf("expected paid balances never to be overdue, got %d", n)
+		}
+
+		early := book(6, now.Add(-2*time.Hour))
+		for i, want := range []*types.Booking{early, late, upcoming} {
+			page, err := store.Booking.GetBalances(ctx, db.Map{"balance": db.Map{"$exists": true}}, &db.Pagination{Page: int64(i + 1), Limit: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page) != 1 || page[0].ID != want.ID {
+				t.Fatalf("expected page %d to hold the balance due at %s, got %+v", i+1, want.Balance.DueAt, page)
+			}
+		}
+	})
+}
+
+func TestBookRooms(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *db.Store) {
+		var (
+			ctx   = context.Background()
+			from  = time.Now().AddDate(0, 0, 1)
+			till  = time.Now().AddDate(0, 0, 3)
+			user  = fixtures.AddUser(store.User, "foo", "bar", false)
+			hotel = fixtures.AddHotel(store.Hotel, "Hilton", "New York", 5)
+			free  = fixtures.AddRoom(store.Room, hotel.ID, "Double", 120)
+			taken = fixtures.AddRoom(store.Room, hotel.ID, "Double", 120)
+			other = fixtures.AddRoom(store.Room, hotel.ID, "Double", 120)
+		)
+		fixtures.AddBooking(store.Booking, user.ID, taken.ID, from, till, 1)
+		group := func(rooms ...*types.Room) []*types.Booking {
+			bookings := make([]*types.Booking, len(rooms))
+			for i, room := range rooms {
+				bookings[i] = &types.Booking{
+					ID:       primitive.NewObjectID(),
+					RoomID:   room.ID,
+					UserID:   user.ID,
+					FromDate: from,
+					TillDate: till,
+					Status:   types.StatusConfirmed,
+				}
+			}
+			return bookings
+		}
+
+		if _, err := store.Booking.BookRooms(ctx, group(free, taken), time.Now()); err != custom_errors.ErrAlreadyBooked() {
+			t.Fatalf("expected already booked error, got %v", err)
+		}
+		ok, err := store.Booking.IsRoomAvailable(ctx, free.ID, from, till, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Fatal("expected no room of a failed group to be booked")
+		}
+		if _, err := store.Booking.BookRooms(ctx, group(free, free), time.Now()); err != custom_errors.ErrAlreadyBooked() {
+			t.Fatalf("expected already booked error booking a room twice, got %v", err)
+		}
+
+		if _, err := store.Booking.BookRooms(ctx, group(free, other), time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		for _, room := range []*types.Room{free, other} {
+			ok, err := store.Booking.IsRoomAvailable(ctx, room.ID, from, till, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok {
+				t.Fatalf("expected room %s to be booked", room.ID.Hex())
+			}
+		}
+	})
+}
+
+func TestMigrateBookingStatus(t *testing.T) {
+	client, err := connectMongo()
+	if err != nil {
+		t.Skipf("mongo is not available: %v", err)
+	}
+	store := newMongoStore(t)
+	coll := client.Database(os.Getenv(db.MONGO_DBNAME_ENV_VARIABLE_NAME)).Collection("bookings")
+	var (
+		ctx      = context.Background()
+		active   = primitive.NewObjectID()
+		canceled = primitive.NewObjectID()
+	)
+	_, err = coll.InsertMany(ctx, []any{
+		bson.M{"_id": active, "roomID": primitive.NewObjectID()},
+		bson.M{"_id": canceled, "roomID": primitive.NewObjectID(), "canceled": true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Booking.(*db.MongoBookingStore).MigrateStatus(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for id, want := range map[primitive.ObjectID]types.BookingStatus{active: types.StatusConfirmed, canceled: types.StatusCanceled} {
+		booking, err := store.Booking.GetBookingByID(ctx, id.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if booking.Status != want {
+			t.Fatalf("expected status %s, got %s", want, booking.Status)
+		}
+	}
+}
+
+func TestRoomTypeInventory(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *db.Store) {
+		var (
+			ctx   = context.Background()
+			day   = time.Now().AddDate(0, 0, 1).Truncate(24 * time.Hour)
+			user  = fixtures.AddUser(store.User, "foo", "bar", false)
+			hotel = fixtures.AddHotel(store.Hotel, "Hilton", "New York", 5)
+		)
+		roomType, err := store.RoomType.InsertRoomType(ctx, &types.RoomType{ID: primitive.NewObjectID(), HotelID: hotel.ID, Name: "Double, seaside"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rooms []*types.Room
+		for i := 0; i < 2; i++ {
+			room, err := store.Room.InsertRoom(ctx, &types.Room{ID: primitive.NewObjectID(), HotelID: hotel.ID, RoomTypeID: roomType.ID})
+			if err != nil {
+				t.Fatal(err)
+			}
+			rooms = append(rooms, room)
+		}
+		book := func(from, till int) (*types.Booking, error) {
+			return store.Booking.BookRoom(ctx, &types.Booking{
+				ID:         primitive.NewObjectID(),
+				RoomTypeID: roomType.ID,
+				UserID:     user.ID,
+				FromDate:   day.AddDate(0, 0, from),
+				TillDate:   day.AddDate(0, 0, till),
+				Status:     types.StatusConfirmed,
+			}, time.Now())
+		}
+
+		first, err := book(0, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the second booking overlaps the first one on night 1 only
+		second, err := book(1, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := book(1, 2); err != custom_errors.ErrSoldOut() {
+			t.Fatalf("expected sold out error, got %v", err)
+		}
+		// nights 2 and 3 only have the second booking, night 0 only the first
+		if _, err := book(2, 4); err != nil {
+			t.Fatalf("expected a room to be left from night 2, got %v", err)
+		}
+		for _, tt := range []struct{ from, till, want int }{{0, 1, 1}, {0, 3, 0}, {3, 4, 1}, {4, 5, 2}} {
+			free, err := store.Booking.RoomTypeAvailability(ctx, roomType.ID, day.AddDate(0, 0, tt.from), day.AddDate(0, 0, tt.till), time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if free != tt.want {
+				t.Fatalf("expected %d rooms free for nights %d-%d, got %d", tt.want, tt.from, tt.till, free)
+			}
+		}
+
+		if err := store.Booking.AssignRoom(ctx, first.ID.Hex(), rooms[0].ID, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Booking.AssignRoom(ctx, second.ID.Hex(), rooms[0].ID, time.Now()); err != custom_errors.ErrAlreadyBooked() {
+			t.Fatalf("expected already booked error assigning an occupied room, got %v", err)
+		}
+		if err := store.Booking.AssignRoom(ctx, second.ID.Hex(), rooms[1].ID, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		// reassigning a booking to the room it already has doesn't conflict
+		if err := store.Booking.AssignRoom(ctx, first.ID.Hex(), rooms[0].ID, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		got, _ := store.Booking.GetBookingByID(ctx, second.ID.Hex())
+		if got.RoomID != rooms[1].ID || got.RoomTypeID != roomType.ID {
+			t.Fatalf("expected the booking to stay of its type in room %s, got %+v", rooms[1].ID.Hex(), got)
+		}
+		updated, _ := store.Hotel.GetHotelByID(ctx, hotel.ID.Hex())
+		if len(updated.RoomTypes) != 1 || updated.RoomTypes[0] != roomType.ID {
+			t.Fatalf("expected the room type to be added to the hotel, got %v", updated.RoomTypes)
+		}
+	})
+}
+
+func TestOverbookRoomsConcurrently(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *db.Store) {
+		var (
+			ctx         = context.Background()
+			now         = time.Now()
+			user        = fixtures.AddUser(store.User, "foo", "bar", false)
+			hotel       = fixtures.AddHotel(store.Hotel, "Hilton", "New York", 5)
+			rooms       = []*types.Room{fixtures.AddRoom(store.Room, hotel.ID, "Single", 99.99), fixtures.AddRoom(store.Room, hotel.ID, "Single", 99.99)}
+			overbooking = 50
+			attempts    = 20
+			wg          sync.WaitGroup
+			mu          sync.Mutex
+			successes   int
+		)
+		if err := store.Hotel.UpdateHotel(ctx, hotel.ID.Hex(), types.UpdateHotelParams{Overbooking: &overbooking}); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < attempts; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				booking := &types.Booking{
+					ID:         primitive.NewObjectID(),
+					RoomID:     rooms[i%2].ID,
+					UserID:     user.ID,
+					FromDate:   now.AddDate(0, 0, 2),
+					TillDate:   now.AddDate(0, 0, 4),
+					NumPersons: 1,
+				}
+				_, err := store.Booking.BookRoom(ctx, booking, now)
+				switch err {
+				case nil:
+					mu.Lock()
+					successes++
+					mu.Unlock()
+				case custom_errors.ErrAlreadyBooked():
+				default:
+					t.Error(err)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		// two rooms and half of them on top
+		if successes != 3 {
+			t.Fatalf("expected exactly 3 successful bookings, got %d", successes)
+		}
+		available, err := store.Booking.IsRoomAvailable(ctx, rooms[0].ID, now.AddDate(0, 0, 3), now.AddDate(0, 0, 5), now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if available {
+			t.Fatal("expected the rooms to be unavailable once the allowance is used up")
+		}
+		block := &types.Booking{
+			ID:       primitive.NewObjectID(),
+			RoomID:   rooms[0].ID,
+			FromDate: now.AddDate(0, 0, 4),
+			TillDate: now.AddDate(0, 0, 5),
+			Status:   types.StatusBlocked,
+		}
+		if _, err := store.Booking.BookRoom(ctx, block, now); err != nil {
+			t.Fatalf("expected a block after the stays to succeed, got %v", err)
+		}
+	})
+}
+
+func TestRedeemPromoCodeConcurrently(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *db.Store) {
+		var (
+			ctx       = context.Background()
+			capped    = types.NewPromoCodeFromParams(types.CreatePromoCodeParams{Code: "autumn15", Percent: 15, MaxUses: 5})
+			uncapped  = types.NewPromoCodeFromParams(types.CreatePromoCodeParams{Code: "WELCOME", Percent: 5})
+			attempts  = 20
+			wg        sync.WaitGroup
+			mu        sync.Mutex
+			successes int
+		)
+		for _, promo := range []*types.PromoCode{capped, uncapped} {
+			if _, err := store.PromoCode.InsertPromoCode(ctx, promo); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := store.PromoCode.InsertPromoCode(ctx, capped); !mongo.IsDuplicateKeyError(err) {
+			t.Fatalf("expected a duplicate key error for an existing code, got %v", err)
+		}
+		for i := 0; i < attempts; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				switch err := store.PromoCode.RedeemPromoCode(ctx, "Autumn15"); err {
+				case nil:
+					mu.Lock()
+					successes++
+					mu.Unlock()
+				case custom_errors.ErrPromoUsedUp():
+				default:
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+		if successes != 5 {
+			t.Fatalf("expected exactly 5 redemptions, got %d", successes)
+		}
+		promo, err := store.PromoCode.GetPromoCode(ctx, "AUTUMN15")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if promo.Uses != 5 || promo.UsesLeft == nil || *promo.UsesLeft != 0 {
+			t.Fatalf("expected 5 uses and none left, got %d %v", promo.Uses, promo.UsesLeft)
+		}
+
+		if err := store.PromoCode.ReleasePromoCode(ctx, "AUTUMN15"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.PromoCode.RedeemPromoCode(ctx, "AUTUMN15"); err != nil {
+			t.Fatalf("expected a released use to be redeemable, got %v", err)
+		}
+		for i := 0; i < 3; i++ {
+			if err := store.PromoCode.RedeemPromoCode(ctx, "WELCOME"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if promo, _ := store.PromoCode.GetPromoCode(ctx, "WELCOME"); promo.Uses != 3 || promo.UsesLeft != nil {
+			t.Fatalf("expected an uncapped code to only be counted, got %+v", promo)
+		}
+	})
+}
+
+func TestClaimFirstBookingConcurrently(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *db.Store) {
+		var (
+			ctx       = context.Background()
+			userID    = primitive.NewObjectID()
+			attempts  = 10
+			wg        sync.WaitGroup
+			mu        sync.Mutex
+			successes int
+		)
+		for i := 0; i < attempts; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := store.PromoCode.ClaimFirstBooking(ctx, &types.FirstBooking{UserID: userID, Code: "WELCOME10", ClaimedAt: time.Now()})
+				switch {
+				case err == nil:
+					mu.Lock()
+					successes++
+					mu.Unlock()
+				case !mongo.IsDuplicateKeyError(err):
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+		if successes != 1 {
+			t.Fatalf("expected exactly one claim, got %d", successes)
+		}
+
+		// releasing another code's claim keeps this one
+		if err := store.PromoCode.ReleaseFirstBooking(ctx, userID.Hex(), "OTHER"); err != nil {
+			t.Fatal(err)
+		}
+		claim := &types.FirstBooking{UserID: userID, Code: "WELCOME10", ClaimedAt: time.Now()}
+		if err := store.PromoCode.ClaimFirstBooking(ctx, claim); !mongo.IsDuplicateKeyError(err) {
+			t.Fatalf("expected the claim to be kept, got %v", err)
+		}
+		if err := store.PromoCode.ReleaseFirstBooking(ctx, userID.Hex(), "welcome10"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.PromoCode.ClaimFirstBooking(ctx, claim); err != nil {
+			t.Fatalf("expected a released claim to be claimable, got %v", err)
+		}
+	})
+}
+
+func TestIssueInvoicesConcurrently(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *db.Store) {
+		var (
+			ctx      = context.Background()
+			hotelID  = primitive.NewObjectID()
+			bookings = make([]primitive.ObjectID, 10)
+			numbers  = make([]int64, 2*len(bookings))
+			wg       sync.WaitGroup
+		)
+		for i := range bookings {
+			bookings[i] = primitive.NewObjectID()
+		}
+		// every booking is invoiced twice at the same time
+		for i := range numbers {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				issued, err := store.Invoice.IssueInvoice(ctx, &types.IssuedInvoice{
+					BookingID: bookings[i/2],
+					HotelID:   hotelID,
+					IssuedAt:  time.Now(),
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				numbers[i] = issued.Number
+			}(i)
+		}
+		wg.Wait()
+		seen := map[int64]bool{}
+		for i := 0; i < len(numbers); i += 2 {
+			if numbers[i] != numbers[i+1] {
+				t.Fatalf("expected a booking to get one number, got %d and %d", numbers[i], numbers[i+1])
+			}
+			seen[numbers[i]] = true
+		}
+		for n := int64(1); n <= int64(len(bookings)); n++ {
+			if !seen[n] {
+				t.Fatalf("expected numbers 1 to %d without gaps, got %v", len(bookings), numbers)
+			}
+		}
+
+		other, err := store.Invoice.IssueInvoice(ctx, &types.IssuedInvoice{BookingID: primitive.NewObjectID(), HotelID: primitive.NewObjectID()})
+		if err != nil || other.Number != 1 {
+			t.Fatalf("expected another hotel to start at 1, got %+v %v", other, err)
+		}
+	})
+}
+
+func TestExchangeRateStore(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *db.Store) {
+		ctx := context.Background()
+		if _, err := store.ExchangeRate.GetExchangeRates(ctx, "USD"); !errors.Is(err, mongo.ErrNoDocuments) {
+			t.Fatalf("expected no documents error, got %v", err)
+		}
+		tables := []*types.ExchangeRates{
+			{Base: "USD", Rates: map[string]float64{"EUR": 0.9}, UpdatedAt: time.Now()},
+			{Base: "CHF", Rates: map[string]float64{"EUR": 1.05, "USD": 1.15}, UpdatedAt: time.Now()},
+			{Base: "USD", Rates: map[string]float64{"EUR": 0.92, "GBP": 0.8}, UpdatedAt: time.Now()},
+		}
+		for _, table := range tables {
+			if err := store.ExchangeRate.PutExchangeRates(ctx, table); err != nil {
+				t.Fatal(err)
+			}
+		}
+		usd, err := store.ExchangeRate.GetExchangeRates(ctx, "USD")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(usd.Rates) != 2 || usd.Rates["EUR"] != 0.92 {
+			t.Fatalf("expected the upload to replace the USD table, got %+v", usd)
+		}
+		all, err := store.ExchangeRate.GetAllExchangeRates(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(all) != 2 || all[0].Base != "CHF" || all[1].Base != "USD" {
+			t.Fatalf("expected a table per base currency, got %+v", all)
+		}
+	})
+}
+
+func TestWaitlistStore(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, store *db.Store) {
+		ctx := context.Background()
+		var (
+			now      = time.Now()
+			hotels   = []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID()}
+			roomID   = primitive.NewObjectID()
+			typeID   = primitive.NewObjectID()
+			from     = now.AddDate(0, 0, 3)
+			till     = now.AddDate(0, 0, 5)
+			newEntry = func(hotelID, roomID, roomTypeID primitive.ObjectID, from, till time.Time, age time.Duration) *types.WaitlistEntry {
+				entry := &types.WaitlistEntry{
+					ID:         primitive.NewObjectID(),
+					UserID:     primitive.NewObjectID(),
+					HotelID:    hotelID,
+					RoomID:     roomID,
+					RoomTypeID: roomTypeID,
+					FromDate:   from,
+					TillDate:   till,
+					NumPersons: 1,
+					Status:     types.WaitlistWaiting,
+					CreatedAt:  now.Add(-age),
+				}
+				if _, err := store.Waitlist.InsertWaitlistEntry(ctx, entry); err != nil {
+					t.Fatal(err)
+				}
+				return entry
+			}
+		)
+		var (
+			newer    = newEntry(hotels[0], roomID, typeID, from, till, time.Minute)
+			older    = newEntry(hotels[0], roomID, typeID, from.AddDate(0, 0, 1), till, time.Hour)
+			anyRoom  = newEntry(hotels[0], primitive.NilObjectID, typeID, from, till, 2*time.Hour)
+			later    = newEntry(hotels[0], roomID, typeID, till, till.AddDate(0, 0, 1), 3*time.Hour)
+			other    = newEntry(hotels[1], primitive.NewObjectID(), primitive.NilObjectID, from, till, time.Hour)
+			_        = newEntry(hotels[1], primitive.NewObjectID(), primitive.NilObjectID, now.AddDate(0, 0, -1), till, time.Hour)
+			canceled = &types.Booking{RoomID: roomID, RoomTypeID: typeID, FromDate: from, TillDate: till}
+		)
+
+		freed, err := store.Waitlist.GetWaitlist(ctx, db.FreedStayFilter(canceled, now))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(freed) != 3 || freed[0].ID != anyRoom.ID || freed[1].ID != older.ID || freed[2].ID != newer.ID {
+			t.Fatalf("expected the entries overlapping the stay, the longest waiting first, got %+v", freed)
+		}
+		// a room of the type other than the canceled one frees up the
+		// type only
+		canceled.RoomID = primitive.NewObjectID()
+		freed, err = store.Waitlist.GetWaitlist(ctx, db.FreedStayFilter(canceled, now))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(freed) != 1 || freed[0].ID != anyRoom.ID {
+			t.Fatalf("expected only the entry of the room type, got %+v", freed)
+		}
+
+		depths, err := store.Waitlist.WaitlistDepth(ctx, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []types.WaitlistDepth{{HotelID: hotels[0], Waiting: 4}, {HotelID: hotels[1], Waiting: 1}}
+		if len(depths) != 2 || depths[0] != want[0] || depths[1] != want[1] {
+			t.Fatalf("expected %+v, got %+v", want, depths)
+		}
+
+		holdID := primitive.NewObjectID()
+		if err := store.Waitlist.OfferWaitlistHold(ctx, older.ID.Hex(), holdID, now); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Waitlist.OfferWaitlistHold(ctx, older.ID.Hex(), primitive.NewObjectID(), now); err != custom_errors.ErrNotWaiting() {
+			t.Fatalf("expected not waiting error, got %v", err)
+		}
+		offered, err := store.Waitlist.GetWaitlistEntryByID(ctx, older.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if offered.Status != types.WaitlistOffered || offered.HoldID != holdID || offered.OfferedAt == nil {
+			t.Fatalf("expected the entry to be offered the hold, got %+v", offered)
+		}
+		unnotified, err := store.Waitlist.GetWaitlist(ctx, db.UnnotifiedOffersFilter(now.Add(time.Minute)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(unnotified) != 1 || unnotified[0].ID != older.ID {
+			t.Fatalf("expected the offered entry to wait for a notification, got %+v", unnotified)
+		}
+		if err := store.Waitlist.MarkWaitlistNotified(ctx, older.ID.Hex(), now); err != nil {
+			t.Fatal(err)
+		}
+		if unnotified, _ := store.Waitlist.GetWaitlist(ctx, db.UnnotifiedOffersFilter(now.Add(time.Minute))); len(unnotified) != 0 {
+			t.Fatalf("expected no entry to wait for a notification, got %+v", unnotified)
+		}
+		if err := store.Waitlist.LapseWaitlistOffer(ctx, primitive.NewObjectID()); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Waitlist.LapseWaitlistOffer(ctx, holdID); err != nil {
+			t.Fatal(err)
+		}
+		if lapsed, _ := store.Waitlist.GetWaitlistEntryByID(ctx, older.ID.Hex()); lapsed.Status != types.WaitlistLapsed || lapsed.NotifiedAt == nil {
+			t.Fatalf("expected the entry to lapse, got %+v", lapsed)
+		}
+		if err := store.Waitlist.LeaveWaitlist(ctx, later.ID.Hex()); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Waitlist.LeaveWaitlist(ctx, older.ID.Hex()); err != custom_errors.ErrNotWaiting() {
+			t.Fatalf("expected not waiting error, got %v", err)
+		}
+		if err := store.Waitlist.LeaveWaitlist(ctx, "nope"); err != custom_errors.ErrInvalidID() {
+			t.Fatalf("expected invalid id error, got %v", err)
+		}
+		depths, err = store.Waitlist.WaitlistDepth(ctx, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(depths) != 2 || depths[0].Waiting != 2 || depths[1].HotelID != other.HotelID {
+			t.Fatalf("expected the offered and left entries not to count, got %+v", depths)
+		}
+	})
+}
@@ -0,0 +1,195 @@
+package db
+
+import (
+	"context"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/kmogilevskii/hotel-reservation/errors"
+	"github.com/kmogilevskii/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type WaitlistStore interface {
+	InsertWaitlistEntry(context.Context, *types.WaitlistEntry) (*types.WaitlistEntry, error)
+	GetWaitlistEntryByID(context.Context, string) (*types.WaitlistEntry, error)
+	// GetWaitlist returns the entries matching the filter, the longest
+	// waiting first.
+	GetWaitlist(context.Context, Map) ([]*types.WaitlistEntry, error)
+	// OfferWaitlistHold records the hold a waiting entry was given. It
+	// returns errors.ErrNotWaiting when the entry is no longer waiting.
+	OfferWaitlistHold(ctx context.Context, id string, holdID primitive.ObjectID, at time.Time) error
+	// MarkWaitlistNotified records when the guest was told about the hold
+	// they were offered.
+	MarkWaitlistNotified(ctx context.Context, id string, at time.Time) error
+	// LapseWaitlistOffer moves the entry offered the hold to lapsed, if the
+	// hold was offered to one.
+	LapseWaitlistOffer(ctx context.Context, holdID primitive.ObjectID) error
+	// LeaveWaitlist takes a waiting entry off the waitlist. It returns
+	// errors.ErrNotWaiting when the entry is no longer waiting.
+	LeaveWaitlist(context.Context, string) error
+	// WaitlistDepth counts the entries still waiting for a stay after now
+	// per hotel.
+	WaitlistDepth(ctx context.Context, now time.Time) ([]types.WaitlistDepth, error)
+}
+
+// WaitingFilter matches the entries still waiting for a stay that hasn't
+// begun by now.
+func WaitingFilter(now time.Time) Map {
+	return Map{
+		"status":   types.WaitlistWaiting,
+		"fromDate": Map{"$gt": now},
+	}
+}
+
+// FreedStayFilter matches the waiting entries a canceled booking may have
+// freed the dates of: those of its room and those of its room type that
+// don't ask for a particular room, overlapping its stay.
+func FreedStayFilter(canceled *types.Booking, now time.Time) Map {
+	var freed []Map
+	if !canceled.RoomID.IsZero() {
+		freed = append(freed, Map{"roomID": canceled.RoomID})
+	}
+	if !canceled.RoomTypeID.IsZero() {
+		freed = append(freed, Map{"roomID": Map{"$exists": false}, "roomTypeID": canceled.RoomTypeID})
+	}
+	filter := WaitingFilter(now)
+	filter["fromDate"] = Map{"$gt": now, "$lt": canceled.TillDate}
+	filter["tillDate"] = Map{"$gt": canceled.FromDate}
+	filter["$or"] = freed
+	return filter
+}
+
+// UnnotifiedOffersFilter matches the entries offered a hold before the given
+// time whose guest couldn't be told about it yet.
+func UnnotifiedOffersFilter(before time.Time) Map {
+	return Map{
+		"status":     types.WaitlistOffered,
+		"offeredAt":  Map{"$lt": before},
+		"notifiedAt": Map{"$exists": false},
+	}
+}
+
+// LapseOfferUpdate returns the filter matching the entry offered the hold and
+// the update lapsing it.
+func LapseOfferUpdate(holdID primitive.ObjectID) (Map, Map) {
+	return Map{"holdID": holdID, "status": types.WaitlistOffered},
+		Map{"$set": Map{"status": types.WaitlistLapsed}}
+}
+
+// WaitlistDepths counts the entries per hotel, the hotels with the most
+// guests waiting first.
+func WaitlistDepths(entries []*types.WaitlistEntry) []types.WaitlistDepth {
+	counts := map[primitive.ObjectID]int{}
+	for _, e := range entries {
+		counts[e.HotelID]++
+	}
+	depths := []types.WaitlistDepth{}
+	for id, n := range counts {
+		depths = append(depths, types.WaitlistDepth{HotelID: id, Waiting: n})
+	}
+	slices.SortFunc(depths, func(a, b types.WaitlistDepth) int {
+		if a.Waiting != b.Waiting {
+			return b.Waiting - a.Waiting
+		}
+		return strings.Compare(a.HotelID.Hex(), b.HotelID.Hex())
+	})
+	return depths
+}
+
+type MongoWaitlistStore struct {
+	client *mongo.Client
+	coll   *mongo.Collection
+}
+
+func NewMongoWaitlistStore(client *mongo.Client) *MongoWaitlistStore {
+	DBNAME := os.Getenv(MONGO_DBNAME_ENV_VARIABLE_NAME)
+	return &MongoWaitlistStore{
+		client: client,
+		coll:   client.Database(DBNAME).Collection("waitlist"),
+	}
+}
+
+func (m *MongoWaitlistStore) InsertWaitlistEntry(ctx context.Context, entry *types.WaitlistEntry) (*types.WaitlistEntry, error) {
+	if _, err := m.coll.InsertOne(ctx, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (m *MongoWaitlistStore) GetWaitlistEntryByID(ctx context.Context, id string) (*types.WaitlistEntry, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, errors.ErrInvalidID()
+	}
+	var entry types.WaitlistEntry
+	if err := m.coll.FindOne(ctx, bson.M{"_id": oid}).Decode(&entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (m *MongoWaitlistStore) GetWaitlist(ctx context.Context, filter Map) ([]*types.WaitlistEntry, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}})
+	resp, err := m.coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	entries := []*types.WaitlistEntry{}
+	if err := resp.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (m *MongoWaitlistStore) OfferWaitlistHold(ctx context.Context, id string, holdID primitive.ObjectID, at time.Time) error {
+	update := bson.M{"$set": bson.M{"status": types.WaitlistOffered, "holdID": holdID, "offeredAt": at}}
+	return m.updateWaiting(ctx, id, update)
+}
+
+func (m *MongoWaitlistStore) MarkWaitlistNotified(ctx context.Context, id string, at time.Time) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errors.ErrInvalidID()
+	}
+	_, err = m.coll.UpdateOne(ctx, bson.M{"_id": oid}, bson.M{"$set": bson.M{"notifiedAt": at}})
+	return err
+}
+
+func (m *MongoWaitlistStore) LapseWaitlistOffer(ctx context.Context, holdID primitive.ObjectID) error {
+	filter, update := LapseOfferUpdate(holdID)
+	_, err := m.coll.UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (m *MongoWaitlistStore) LeaveWaitlist(ctx context.Context, id string) error {
+	return m.updateWaiting(ctx, id, bson.M{"$set": bson.M{"status": types.WaitlistLeft}})
+}
+
+func (m *MongoWaitlistStore) updateWaiting(ctx context.Context, id string, update bson.M) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return errors.ErrInvalidID()
+	}
+	res, err := m.coll.UpdateOne(ctx, bson.M{"_id": oid, "status": types.WaitlistWaiting}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return errors.ErrNotWaiting()
+	}
+	return nil
+}
+
+func (m *MongoWaitlistStore) WaitlistDepth(ctx context.Context, now time.Time) ([]types.WaitlistDepth, error) {
+	entries, err := m.GetWaitlist(ctx, WaitingFilter(now))
+	if err != nil {
+		return nil, err
+	}
+	return WaitlistDepths(entries), nil
+}
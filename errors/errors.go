@@ -1,6 +1,7 @@
 package errors
 
 import (
+	"fmt"
 	"net/http"
 
 	"github.com/gofiber/fiber/v2"
@@ -60,7 +61,84 @@ func ErrResourceNotFound(res string) Error {
 
 func ErrAlreadyBooked() Error {
 	return Error{
-		Code: http.StatusBadRequest,
+		Code: http.StatusConflict,
 		Err:  "room is already booked",
 	}
 }
+
+func ErrHasUpcomingBookings(res string) Error {
+	return Error{
+		Code: http.StatusConflict,
+		Err:  res + " has upcoming bookings",
+	}
+}
+
+func ErrAlreadyCanceled() Error {
+	return Error{
+		Code: http.StatusConflict,
+		Err:  "booking is already canceled",
+	}
+}
+
+func ErrStayStarted() Error {
+	return Error{
+		Code: http.StatusConflict,
+		Err:  "stay has already started",
+	}
+}
+
+func ErrIllegalTransition(from, to string) Error {
+	return Error{
+		Code: http.StatusConflict,
+		Err:  fmt.Sprintf("booking cannot go from %s to %s", from, to),
+	}
+}
+
+func ErrStatusChanged() Error {
+	return Error{
+		Code: http.StatusConflict,
+		Err:  "booking status has changed in the meantime",
+	}
+}
+
+func ErrSoldOut() Error {
+	return Error{
+		Code: http.StatusConflict,
+		Err:  "no rooms of this type are left for these dates",
+	}
+}
+
+func ErrHoldExpired() Error {
+	return Error{
+		Code: http.StatusConflict,
+		Err:  "hold has expired",
+	}
+}
+
+func ErrPromoUsedUp() Error {
+	return Error{
+		Code: http.StatusConflict,
+		Err:  "promo code has been used up",
+	}
+}
+
+func ErrPaymentDeclined() Error {
+	return Error{
+		Code: http.StatusPaymentRequired,
+		Err:  "payment was declined",
+	}
+}
+
+func ErrNothingDue() Error {
+	return Error{
+		Code: http.StatusConflict,
+		Err:  "booking has no balance left to pay",
+	}
+}
+
+func ErrNotWaiting() Error {
+	return Error{
+		Code: http.StatusConflict,
+		Err:  "guest is no longer waiting",
+	}
+}
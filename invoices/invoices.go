@@ -0,0 +1,155 @@
+// Package invoices turns bookings into invoices, listing everything charged
+// and paid for a stay, and renders them as PDF.
+package invoices
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kmogilevskii/hotel-reservation/pricing"
+	"github.com/kmogilevskii/hotel-reservation/types"
+)
+
+// FormatNumber returns how an invoice number is printed, e.g. INV-000042.
+func FormatNumber(number int64) string {
+	return fmt.Sprintf("INV-%06d", number)
+}
+
+// FormatMoney prints an amount in minor units with as many decimals as the
+// currency has.
+func FormatMoney(m types.Money) string {
+	sign, amount := "", m.Amount
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	exp := pricing.Exponent(m.Currency)
+	if exp == 0 {
+		return fmt.Sprintf("%s%d %s", sign, amount, m.Currency)
+	}
+	unit := int64(math.Pow10(exp))
+	return fmt.Sprintf("%s%d.%0*d %s", sign, amount/unit, exp, amount%unit, m.Currency)
+}
+
+// New builds the invoice of a priced booking issued under the number.
+func New(issued *types.IssuedInvoice, booking *types.Booking, hotel *types.Hotel, guest *types.User) *types.Invoice {
+	var (
+		total    = booking.Price.Total
+		currency = total.Currency
+		money    = func(amount int64) types.Money { return types.Money{Amount: amount, Currency: currency} }
+		zone     = hotel.Zone()
+	)
+	invoice := &types.Invoice{
+		Number:    FormatNumber(issued.Number),
+		IssuedAt:  issued.IssuedAt,
+		BookingID: booking.ID,
+		Hotel:     types.InvoiceParty{Name: hotel.Name, Address: hotel.Location},
+		Guest: types.InvoiceParty{
+			Name:  strings.TrimSpace(guest.FirstName + " " + guest.LastName),
+			Email: guest.Email,
+		},
+		FromDate: booking.FromDate.In(zone),
+		TillDate: booking.TillDate.In(zone),
+		Charges:  []types.InvoiceLine{},
+		Payments: []types.InvoiceLine{},
+	}
+	for _, night := range booking.Price.Nights {
+		date := night.Date
+		description := "Night of " + night.Date.Format("Mon 2006-01-02")
+		if night.Season != "" {
+			description += " (" + night.Season + ")"
+		}
+		invoice.Charges = append(invoice.Charges, types.InvoiceLine{
+			Kind:        types.LineNight,
+			Description: description,
+			Date:        &date,
+			Amount:      money(night.Rate),
+		})
+		if night.ExtraGuests > 0 {
+			invoice.Charges = append(invoice.Charges, types.InvoiceLine{
+				Kind:        types.LineExtraGuests,
+				Description: "Extra guests, night of " + night.Date.Format(time.DateOnly),
+				Date:        &date,
+				Amount:      money(night.ExtraGuests),
+			})
+		}
+	}
+	if d := booking.Discount; d != nil {
+		invoice.Charges = append(invoice.Charges, types.InvoiceLine{
+			Kind:        types.LineDiscount,
+			Description: "Promo code " + d.Code,
+			Amount:      money(-d.Amount.Amount),
+		})
+	}
+	for _, charge := range booking.Price.Charges {
+		invoice.Charges = append(invoice.Charges, chargeLine(charge, money))
+	}
+	// a canceled stay only costs the penalty
+	if c := booking.Cancellation; c != nil && c.Penalty.Amount < total.Amount {
+		canceledAt := c.CanceledAt.In(zone)
+		invoice.Charges = append(invoice.Charges, types.InvoiceLine{
+			Kind:        types.LineCancellation,
+			Description: "Canceled on " + canceledAt.Format(time.DateOnly),
+			Date:        &canceledAt,
+			Amount:      money(c.Penalty.Amount - total.Amount),
+		})
+		total.Amount = c.Penalty.Amount
+	}
+
+	var paid int64
+	if booking.Payment != nil {
+		for _, tx := range booking.Payment.Transactions {
+			at := tx.At.In(zone)
+			switch tx.Kind {
+			case types.TransactionCapture:
+				paid += tx.Amount.Amount
+				invoice.Payments = append(invoice.Payments, types.InvoiceLine{
+					Kind:        types.LinePayment,
+					Description: "Payment " + tx.ID,
+					Date:        &at,
+					Amount:      tx.Amount,
+				})
+			case types.TransactionRefund:
+				paid -= tx.Amount.Amount
+				invoice.Payments = append(invoice.Payments, types.InvoiceLine{
+					Kind:        types.LineRefund,
+					Description: "Refund " + tx.ID,
+					Date:        &at,
+					Amount:      money(-tx.Amount.Amount),
+				})
+			}
+		}
+	}
+	invoice.Total = total
+	invoice.Paid = money(paid)
+	invoice.Due = money(total.Amount - paid)
+	return invoice
+}
+
+// chargeLine describes how a fee or tax of the price adds up, e.g.
+// "City tax, 6 x 2.50 EUR" or "VAT 10% of 330.00 EUR".
+func chargeLine(charge types.PriceCharge, money func(int64) types.Money) types.InvoiceLine {
+	if charge.Kind == types.ChargeVAT {
+		description := fmt.Sprintf("%s %s%% of %s", charge.Name, strconv.FormatFloat(charge.Percent, 'f', -1, 64), FormatMoney(money(charge.Base)))
+		if charge.Included {
+			description += " (included)"
+		}
+		return types.InvoiceLine{
+			Kind:        types.LineTax,
+			Description: description,
+			Amount:      money(charge.Amount),
+			Included:    charge.Included,
+		}
+	}
+	description := charge.Name
+	if charge.Quantity > 1 {
+		description += fmt.Sprintf(", %d x %s", charge.Quantity, FormatMoney(money(charge.UnitAmount)))
+	}
+	return types.InvoiceLine{
+		Kind:        types.LineFee,
+		Description: description,
+		Amount:      money(charge.Amount),
+	}
+}
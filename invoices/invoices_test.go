@@ -0,0 +1,205 @@
+package invoices
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kmogilevskii/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func eur(amount int64) types.Money {
+	return types.Money{Amount: amount, Currency: "EUR"}
+}
+
+func testInvoice() *types.Invoice {
+	var (
+		checkIn = time.Date(2030, time.March, 8, 15, 0, 0, 0, time.UTC)
+		paidAt  = time.Date(2030, time.February, 1, 9, 30, 0, 0, time.UTC)
+		hotel   = &types.Hotel{Name: "Hôtel du Lac", Location: "Geneva"}
+		guest   = &types.User{FirstName: "Ada", LastName: "Lovelace", Email: "ada@example.com"}
+		issued  = &types.IssuedInvoice{Number: 42, IssuedAt: paidAt}
+	)
+	booking := &types.Booking{
+		ID:       primitive.NewObjectID(),
+		FromDate: checkIn,
+		TillDate: checkIn.AddDate(0, 0, 2).Add(-4 * time.Hour),
+		Price: &types.PriceBreakdown{
+			Nights: []types.NightPrice{
+				{Date: time.Date(2030, time.March, 8, 0, 0, 0, 0, time.UTC), Weekend: true, Rate: 15000, ExtraGuests: 2000, Amount: 17000},
+				{Date: time.Date(2030, time.March, 9, 0, 0, 0, 0, time.UTC), Weekend: true, Season: "Spring fair", Rate: 18000, ExtraGuests: 2000, Amount: 20000},
+			},
+			Total: eur(33300),
+		},
+		Discount: &types.Discount{Code: "SPRING10", Amount: eur(3700)},
+		Payment: &types.Payment{
+			Status:   types.PaymentPartiallyRefunded,
+			Captured: eur(33300),
+			Refunded: eur(5000),
+			Transactions: []types.Transaction{
+				{ID: "auth_1", Kind: types.TransactionAuthorize, Amount: eur(33300), At: paidAt},
+				{ID: "cap_2", Kind: types.TransactionCapture, Amount: eur(33300), ParentID: "auth_1", At: paidAt},
+				{ID: "ref_3", Kind: types.TransactionRefund, Amount: eur(5000), ParentID: "cap_2", At: paidAt.AddDate(0, 0, 1)},
+			},
+		},
+	}
+	return New(issued, booking, hotel, guest)
+}
+
+func TestNew(t *testing.T) {
+	invoice := testInvoice()
+	if invoice.Number != "INV-000042" || invoice.Guest.Name != "Ada Lovelace" {
+		t.Fatalf("unexpected invoice header %+v", invoice)
+	}
+	// 150.00 + 20.00 extra guests, 180.00 + 20.00 extra guests, less 37.00
+	// off with the promo code
+	want := []struct {
+		kind   types.LineKind
+		amount int64
+	}{
+		{types.LineNight, 15000},
+		{types.LineExtraGuests, 2000},
+		{types.LineNight, 18000},
+		{types.LineExtraGuests, 2000},
+		{types.LineDiscount, -3700},
+	}
+	if len(invoice.Charges) != len(want) {
+		t.Fatalf("expected %d charges, got %+v", len(want), invoice.Charges)
+	}
+	var sum int64
+	for i, w := range want {
+		line := invoice.Charges[i]
+		if line.Kind != w.kind || line.Amount != eur(w.amount) {
+			t.Fatalf("expected charge %d to be %s %d, got %+v", i, w.kind, w.amount, line)
+		}
+		sum += line.Amount.Amount
+	}
+	if invoice.Charges[2].Description != "Night of Sat 2030-03-09 (Spring fair)" {
+		t.Fatalf("unexpected description %q", invoice.Charges[2].Description)
+	}
+	if sum != invoice.Total.Amount || invoice.Total != eur(33300) {
+		t.Fatalf("expected the charges to add up to the total, got %d and %+v", sum, invoice.Total)
+	}
+	// 333.00 paid and 50.00 refunded
+	if len(invoice.Payments) != 2 || invoice.Payments[1].Amount != eur(-5000) {
+		t.Fatalf("expected a payment and a refund, got %+v", invoice.Payments)
+	}
+	if invoice.Paid != eur(28300) || invoice.Due != eur(5000) {
+		t.Fatalf("expected 283.00 paid and 50.00 due, got %+v %+v", invoice.Paid, invoice.Due)
+	}
+}
+
+func TestNewCanceled(t *testing.T) {
+	var (
+		hotel   = &types.Hotel{Name: "Hilton"}
+		booking = &types.Booking{
+			Price: &types.PriceBreakdown{Total: eur(20000)},
+			Cancellation: &types.Cancellation{
+				CanceledAt: time.Date(2030, time.March, 1, 12, 0, 0, 0, time.UTC),
+				Penalty:    eur(6000),
+				Refund:     eur(14000),
+			},
+		}
+	)
+	invoice := New(&types.IssuedInvoice{Number: 1}, booking, hotel, &types.User{})
+	last := invoice.Charges[len(invoice.Charges)-1]
+	if last.Kind != types.LineCancellation || last.Amount != eur(-14000) || invoice.Total != eur(6000) {
+		t.Fatalf("expected only the penalty to be charged, got %+v %+v", last, invoice.Total)
+	}
+}
+
+func TestNewTaxes(t *testing.T) {
+	booking := &types.Booking{
+		Price: &types.PriceBreakdown{
+			Nights: []types.NightPrice{
+				{Date: time.Date(2030, time.March, 4, 0, 0, 0, 0, time.UTC), Rate: 10000, Amount: 10000},
+			},
+			Charges: []types.PriceCharge{
+				{Kind: types.ChargeFee, Name: "City tax", Basis: types.PerPerson, Quantity: 2, UnitAmount: 250, Amount: 500},
+				{Kind: types.ChargeFee, Name: "Cleaning", Basis: types.PerStay, Quantity: 1, UnitAmount: 3000, Amount: 3000},
+				{Kind: types.ChargeVAT, Name: "VAT", Percent: 7.7, Base: 13000, Amount: 930, Included: true},
+			},
+			Total: eur(13500),
+		},
+	}
+	invoice := New(&types.IssuedInvoice{Number: 1}, booking, &types.Hotel{}, &types.User{})
+	want := []types.InvoiceLine{
+		{Kind: types.LineFee, Description: "City tax, 2 x 2.50 EUR", Amount: eur(500)},
+		{Kind: types.LineFee, Description: "Cleaning", Amount: eur(3000)},
+		{Kind: types.LineTax, Description: "VAT 7.7% of 130.00 EUR (included)", Amount: eur(930), Included: true},
+	}
+	if len(invoice.Charges) != 4 {
+		t.Fatalf("expected a night and 3 charges, got %+v", invoice.Charges)
+	}
+	var sum int64
+	for i, line := range invoice.Charges {
+		if i > 0 && line != want[i-1] {
+			t.Fatalf("expected charge %d to be %+v, got %+v", i, want[i-1], line)
+		}
+		if !line.Included {
+			sum += line.Amount.Amount
+		}
+	}
+	if sum != invoice.Total.Amount {
+		t.Fatalf("expected the charges to add up to %d, got %d", invoice.Total.Amount, sum)
+	}
+}
+
+func TestFormatMoney(t *testing.T) {
+	for amount, want := range map[int64]string{0: "0.00 EUR", 5: "0.05 EUR", 33300: "333.00 EUR", -3705: "-37.05 EUR"} {
+		if got := FormatMoney(eur(amount)); got != want {
+			t.Fatalf("expected %s, got %s", want, got)
+		}
+	}
+	for m, want := range map[types.Money]string{{Amount: 15000, Currency: "JPY"}: "15000 JPY", {Amount: 12345, Currency: "KWD"}: "12.345 KWD", {Amount: -5, Currency: "BHD"}: "-0.005 BHD"} {
+		if got := FormatMoney(m); got != want {
+			t.Fatalf("expected %s, got %s", want, got)
+		}
+	}
+}
+
+func TestWritePDF(t *testing.T) {
+	invoice := testInvoice()
+	var buf bytes.Buffer
+	if err := WritePDF(&buf, invoice); err != nil {
+		t.Fatal(err)
+	}
+	pdf := buf.String()
+	if !strings.HasPrefix(pdf, "%PDF-1.4\n") || !strings.HasSuffix(pdf, "%%EOF\n") {
+		t.Fatal("expected a complete PDF document")
+	}
+	for _, text := range []string{"(INVOICE INV-000042)", `(H\364tel du Lac)`, "(Balance due", "50.00 EUR)"} {
+		if !strings.Contains(pdf, text) {
+			t.Fatalf("expected the PDF to contain %s", text)
+		}
+	}
+
+	// every cross-reference entry must point at its object
+	start, err := strconv.Atoi(regexp.MustCompile(`startxref\n(\d+)`).FindStringSubmatch(pdf)[1])
+	if err != nil || !strings.HasPrefix(pdf[start:], "xref\n") {
+		t.Fatalf("expected startxref to point at the xref table, got %d", start)
+	}
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllStringSubmatch(pdf[start:], -1)
+	for i, entry := range entries {
+		offset, _ := strconv.Atoi(entry[1])
+		if want := strconv.Itoa(i+1) + " 0 obj"; !strings.HasPrefix(pdf[offset:], want) {
+			t.Fatalf("expected object %d at offset %d", i+1, offset)
+		}
+	}
+
+	// long folios continue on further pages
+	for i := 0; i < 100; i++ {
+		invoice.Charges = append(invoice.Charges, invoice.Charges[0])
+	}
+	buf.Reset()
+	if err := WritePDF(&buf, invoice); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "/Count 3") {
+		t.Fatal("expected the folio to take three pages")
+	}
+}
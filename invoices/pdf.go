@@ -0,0 +1,153 @@
+package invoices
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/kmogilevskii/hotel-reservation/types"
+)
+
+// The PDF is laid out in a monospaced font, so columns line up by padding
+// text and no font metrics are needed.
+const (
+	pageWidth    = 595 // A4 in points
+	pageHeight   = 842
+	margin       = 50
+	fontSize     = 10
+	titleSize    = 16
+	leading      = 14
+	linesPerPage = (pageHeight - 2*margin) / leading
+	columns      = 80
+)
+
+type pdfLine struct {
+	text string
+	size int
+}
+
+// WritePDF renders the invoice as a PDF document.
+func WritePDF(w io.Writer, invoice *types.Invoice) error {
+	lines := layout(invoice)
+	var pages [][]pdfLine
+	for len(lines) > linesPerPage {
+		pages, lines = append(pages, lines[:linesPerPage]), lines[linesPerPage:]
+	}
+	pages = append(pages, lines)
+
+	var (
+		buf     bytes.Buffer
+		offsets []int
+	)
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+	buf.WriteString("%PDF-1.4\n")
+	// objects 1 to 3 are the catalog, the page tree and the font, every
+	// page is followed by its content stream
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 4+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>")
+	for i, page := range pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, 5+2*i))
+		content := pageContent(page)
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content))
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func pageContent(lines []pdfLine) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "BT\n%d TL\n%d %d Td\n", leading, margin, pageHeight-margin)
+	size := 0
+	for _, line := range lines {
+		if line.size != size {
+			size = line.size
+			fmt.Fprintf(&b, "/F1 %d Tf\n", size)
+		}
+		fmt.Fprintf(&b, "(%s) Tj T*\n", escape(line.text))
+	}
+	b.WriteString("ET")
+	return b.String()
+}
+
+// escape makes text a PDF string literal in WinAnsi encoding. Characters it
+// can't encode are replaced.
+func escape(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 0x20 && r < 0x7f:
+			b.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+func layout(invoice *types.Invoice) []pdfLine {
+	var lines []pdfLine
+	add := func(format string, args ...any) {
+		lines = append(lines, pdfLine{text: fmt.Sprintf(format, args...), size: fontSize})
+	}
+	row := func(description, amount string) {
+		width := columns - len(amount) - 1
+		if len(description) > width {
+			description = description[:width]
+		}
+		add("%-*s %s", width, description, amount)
+	}
+	rule := strings.Repeat("-", columns)
+
+	lines = append(lines, pdfLine{text: "INVOICE " + invoice.Number, size: titleSize})
+	add("")
+	add("%s", invoice.Hotel.Name)
+	add("%s", invoice.Hotel.Address)
+	add("")
+	add("Issued:    %s", invoice.IssuedAt.Format(time.DateOnly))
+	add("Billed to: %s", invoice.Guest.Name)
+	if invoice.Guest.Email != "" {
+		add("           %s", invoice.Guest.Email)
+	}
+	add("Booking:   %s", invoice.BookingID.Hex())
+	add("Stay:      %s to %s", invoice.FromDate.Format(time.DateOnly), invoice.TillDate.Format(time.DateOnly))
+	add("")
+	add("Charges")
+	add("%s", rule)
+	for _, line := range invoice.Charges {
+		row(line.Description, FormatMoney(line.Amount))
+	}
+	add("%s", rule)
+	row("Total", FormatMoney(invoice.Total))
+	add("")
+	add("Payments")
+	add("%s", rule)
+	for _, line := range invoice.Payments {
+		row(fmt.Sprintf("%s  %s", line.Date.Format(time.DateOnly), line.Description), FormatMoney(line.Amount))
+	}
+	add("%s", rule)
+	row("Paid", FormatMoney(invoice.Paid))
+	row("Balance due", FormatMoney(invoice.Due))
+	return lines
+}
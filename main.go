@@ -2,14 +2,21 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"os"
+	"time"
+	// hotel timezones must resolve even where the OS has no tz database
+	_ "time/tzdata"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/joho/godotenv"
 	"github.com/kmogilevskii/hotel-reservation/api"
 	"github.com/kmogilevskii/hotel-reservation/db"
 	"github.com/kmogilevskii/hotel-reservation/errors"
+	"github.com/kmogilevskii/hotel-reservation/notify"
+	"github.com/kmogilevskii/hotel-reservation/payments"
+	"github.com/kmogilevskii/hotel-reservation/types"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -22,32 +29,65 @@ func main() {
 	MONGO_URI := os.Getenv("MONGO_URI")
 	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(MONGO_URI))
 
+	if err != nil {
+		log.Fatal(err)
+	}
+	paymentProvider, err := newPaymentProvider()
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	var (
-		userStore    = db.NewMongoUserStore(client)
-		hotelStore   = db.NewMongoHotelStore(client)
-		roomStore    = db.NewMongoRoomStore(client, hotelStore)
-		bookingStore = db.NewMongoBookingStore(client)
-		store        = &db.Store{
-			User:    userStore,
-			Hotel:   hotelStore,
-			Room:    roomStore,
-			Booking: bookingStore,
+		userStore     = db.NewMongoUserStore(client)
+		hotelStore    = db.NewMongoHotelStore(client)
+		roomStore     = db.NewMongoRoomStore(client, hotelStore)
+		roomTypeStore = db.NewMongoRoomTypeStore(client, hotelStore)
+		bookingStore  = db.NewMongoBookingStore(client, roomStore, roomTypeStore, hotelStore)
+		invoiceStore  = db.NewMongoInvoiceStore(client)
+		store         = &db.Store{
+			User:         userStore,
+			Hotel:        hotelStore,
+			Room:         roomStore,
+			RoomType:     roomTypeStore,
+			Booking:      bookingStore,
+			Reservation:  db.NewMongoReservationStore(client),
+			PromoCode:    db.NewMongoPromoCodeStore(client),
+			Invoice:      invoiceStore,
+			ExchangeRate: db.NewMongoExchangeRateStore(client),
+			Waitlist:     db.NewMongoWaitlistStore(client),
 		}
-		userHandler    = api.NewUserHandler(userStore)
-		hotelHandler   = api.NewHotelHandler(store)
-		roomHandler    = api.NewRoomHandler(store)
-		bookingHandler = api.NewBookingHandler(store)
-		authHandler    = api.NewAuthHandler(userStore)
-		app            = fiber.New(config)
-		auth           = app.Group("/api")
-		apiv1          = app.Group("/api/v1", api.JWTAuthentication(userStore))
-		admin          = app.Group("/admin", api.JWTAuthentication(userStore), api.AdminAuth)
+		// guests are only notified in the log until email is set up
+		notifier            = notify.Log{}
+		userHandler         = api.NewUserHandler(userStore)
+		hotelHandler        = api.NewHotelHandler(store)
+		roomHandler         = api.NewRoomHandler(store, paymentProvider)
+		bookingHandler      = api.NewBookingHandler(store, paymentProvider, notifier)
+		reservationHandler  = api.NewReservationHandler(store, paymentProvider, notifier)
+		roomTypeHandler     = api.NewRoomTypeHandler(store, paymentProvider)
+		availabilityHandler = api.NewAvailabilityHandler(store)
+		blockHandler        = api.NewBlockHandler(store, notifier)
+		promoCodeHandler    = api.NewPromoCodeHandler(store)
+		invoiceHandler      = api.NewInvoiceHandler(store)
+		exchangeRateHandler = api.NewExchangeRateHandler(store)
+		waitlistHandler     = api.NewWaitlistHandler(store)
+		authHandler         = api.NewAuthHandler(userStore)
+		app                 = fiber.New(config)
+		auth                = app.Group("/api")
+		apiv1               = app.Group("/api/v1", api.JWTAuthentication(userStore))
+		admin               = app.Group("/admin", api.JWTAuthentication(userStore), api.AdminAuth)
 	)
 
+	if err := bookingStore.MigrateStatus(context.TODO()); err != nil {
+		log.Fatal(err)
+	}
+	if err := invoiceStore.CreateIndexes(context.TODO()); err != nil {
+		log.Fatal(err)
+	}
+	go expireHolds(store, notifier, holdSweepInterval)
+	go settleBalances(store, paymentProvider, notifier, balanceSweepInterval)
+	go retryRefunds(bookingStore, paymentProvider, refundSweepInterval)
+	go retryWaitlistNotifications(store, notifier, notifySweepInterval)
+
 	// auth
 	auth.Post("/auth", authHandler.HandleAuthenticate)
 	auth.Post("/user", userHandler.HandlePostUser)
@@ -62,23 +102,157 @@ func main() {
 	apiv1.Get("/hotel", hotelHandler.HandleGetHotels)
 	apiv1.Get("/hotel/:id", hotelHandler.HandleGetHotel)
 	apiv1.Get("/hotel/:id/rooms", hotelHandler.HandleGetRooms)
+	apiv1.Get("/hotel/:id/room-types", roomTypeHandler.HandleGetRoomTypes)
 
 	// room handlers
 	apiv1.Post("/room/:id/book", roomHandler.HandleBookRoom)
+	apiv1.Post("/room/:id/hold", roomHandler.HandleHoldRoom)
+	apiv1.Post("/room/:id/quote", roomHandler.HandleQuoteRoom)
 	apiv1.Get("/room", roomHandler.HandleGetRooms)
+	apiv1.Post("/room-type/:id/book", roomTypeHandler.HandleBookRoomType)
+
+	// waitlist handlers
+	apiv1.Post("/room/:id/waitlist", waitlistHandler.HandleJoinRoomWaitlist)
+	apiv1.Post("/room-type/:id/waitlist", waitlistHandler.HandleJoinRoomTypeWaitlist)
+	apiv1.Get("/waitlist", waitlistHandler.HandleGetWaitlist)
+	apiv1.Delete("/waitlist/:id", waitlistHandler.HandleLeaveWaitlist)
+
+	// availability handlers
+	apiv1.Get("/availability", availabilityHandler.HandleGetAvailability)
 
 	// booking handlers
 	apiv1.Get("/booking/:id", bookingHandler.HandleGetBooking)
 	apiv1.Delete("/booking/:id", bookingHandler.HandleCancelBooking)
+	apiv1.Patch("/booking/:id", bookingHandler.HandleModifyBooking)
+	apiv1.Post("/booking/:id/confirm", bookingHandler.HandleConfirmHold)
+	apiv1.Post("/booking/:id/pay", bookingHandler.HandlePayBalance)
+	apiv1.Get("/booking/:id/invoice", invoiceHandler.HandleGetInvoice)
+
+	// reservation handlers
+	apiv1.Post("/reservation", reservationHandler.HandlePostReservation)
+	apiv1.Get("/reservation/:id", reservationHandler.HandleGetReservation)
+	apiv1.Delete("/reservation/:id", reservationHandler.HandleCancelReservation)
+	apiv1.Delete("/reservation/:id/room/:roomID", reservationHandler.HandleCancelReservationRoom)
 
 	// admin route
 	admin.Get("/booking", bookingHandler.HandleGetBookings)
+	admin.Post("/booking/:id/check-in", bookingHandler.HandleUpdateStatus(types.StatusCheckedIn))
+	admin.Post("/booking/:id/check-out", bookingHandler.HandleUpdateStatus(types.StatusCheckedOut))
+	admin.Post("/booking/:id/no-show", bookingHandler.HandleUpdateStatus(types.StatusNoShow))
+	admin.Post("/booking/:id/assign", bookingHandler.HandleAssignRoom)
+	admin.Get("/balance", bookingHandler.HandleGetBalances)
+	admin.Get("/relocation", bookingHandler.HandleGetRelocations)
 	admin.Get("/user", userHandler.HandleGetUsers)
+	admin.Post("/hotel", hotelHandler.HandlePostHotel)
+	admin.Put("/hotel/:id", hotelHandler.HandlePutHotel)
+	admin.Delete("/hotel/:id", hotelHandler.HandleDeleteHotel)
+	admin.Post("/room", roomHandler.HandlePostRoom)
+	admin.Put("/room/:id", roomHandler.HandlePutRoom)
+	admin.Delete("/room/:id", roomHandler.HandleDeleteRoom)
+	admin.Post("/room/:id/block", blockHandler.HandlePostBlock)
+	admin.Get("/block", blockHandler.HandleGetBlocks)
+	admin.Delete("/block/:id", blockHandler.HandleDeleteBlock)
+	admin.Post("/promo", promoCodeHandler.HandlePostPromoCode)
+	admin.Get("/promo", promoCodeHandler.HandleGetPromoCodes)
+	admin.Post("/room-type", roomTypeHandler.HandlePostRoomType)
+	admin.Put("/room-type/:id", roomTypeHandler.HandlePutRoomType)
+	admin.Get("/exchange-rates", exchangeRateHandler.HandleGetExchangeRates)
+	admin.Put("/exchange-rates/:base", exchangeRateHandler.HandlePutExchangeRates)
+	admin.Get("/waitlist", waitlistHandler.HandleGetWaitlistDepth)
 
 	listenAddr := os.Getenv("HTTP_LISTEN_ADDRESS")
 	app.Listen(listenAddr)
 }
 
+// holdSweepInterval is how often expired holds are released. Availability
+// checks already ignore expired holds, the sweep keeps their status honest,
+// gives back the promo code uses they redeemed and offers their dates to the
+// waitlist.
+const holdSweepInterval = time.Minute
+
+func expireHolds(store *db.Store, notifier notify.Notifier, every time.Duration) {
+	for now := range time.Tick(every) {
+		n, err := api.ExpireHolds(context.Background(), store, notifier, now)
+		if err != nil {
+			log.Printf("expiring holds: %v", err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("expired %d holds", n)
+		}
+	}
+}
+
+// balanceSweepInterval is how often unpaid balances are marked overdue and,
+// where their schedule says so, canceled.
+const balanceSweepInterval = 10 * time.Minute
+
+func settleBalances(store *db.Store, provider payments.Provider, notifier notify.Notifier, every time.Duration) {
+	for now := range time.Tick(every) {
+		overdue, canceled, err := api.SettleOverdueBalances(context.Background(), store, provider, notifier, now)
+		if err != nil {
+			log.Printf("settling balances: %v", err)
+			continue
+		}
+		if overdue > 0 || canceled > 0 {
+			log.Printf("%d balances overdue, %d bookings canceled", overdue, canceled)
+		}
+	}
+}
+
+// refundSweepInterval is how often refunds that failed are tried again. Only
+// refunds owed for longer than that are picked up, the others may still be
+// underway.
+const refundSweepInterval = 5 * time.Minute
+
+func retryRefunds(store db.BookingStore, provider payments.Provider, every time.Duration) {
+	for now := range time.Tick(every) {
+		n, err := api.RetryRefunds(context.Background(), store, provider, now.Add(-every))
+		if err != nil {
+			log.Printf("retrying refunds: %v", err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("refunded %d bookings", n)
+		}
+	}
+}
+
+// notifySweepInterval is how often guests offered a hold from the waitlist
+// are notified again when that failed. Only offers older than that are
+// picked up, the others may still be underway.
+const notifySweepInterval = 5 * time.Minute
+
+func retryWaitlistNotifications(store *db.Store, notifier notify.Notifier, every time.Duration) {
+	for now := range time.Tick(every) {
+		n, err := api.RetryWaitlistNotifications(context.Background(), store, notifier, now.Add(-every), now)
+		if err != nil {
+			log.Printf("retrying waitlist notifications: %v", err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("notified %d guests of their waitlist holds", n)
+		}
+	}
+}
+
+// newPaymentProvider returns the provider named by PAYMENT_PROVIDER. There is
+// no real provider yet, and the fake forgets its payments on restart, so it
+// is only allowed with APP_ENV=development.
+func newPaymentProvider() (payments.Provider, error) {
+	switch name := os.Getenv("PAYMENT_PROVIDER"); name {
+	case "fake":
+		if env := os.Getenv("APP_ENV"); env != "development" {
+			return nil, fmt.Errorf("the fake payment provider can't be used outside development, APP_ENV is %q", env)
+		}
+		return payments.NewFake(), nil
+	case "":
+		return nil, fmt.Errorf("PAYMENT_PROVIDER is not set")
+	default:
+		return nil, fmt.Errorf("unknown payment provider %q", name)
+	}
+}
+
 func init() {
 	if err := godotenv.Load(); err != nil {
 		log.Fatal("Error loading .env file")
@@ -0,0 +1,38 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Fake keeps the messages it is given, so tests can check who was told
+// what.
+type Fake struct {
+	// Fail makes every notification fail, as when email is down.
+	Fail bool
+
+	mu   sync.Mutex
+	sent []Message
+}
+
+func NewFake() *Fake {
+	return &Fake{}
+}
+
+func (f *Fake) Notify(ctx context.Context, msg Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Fail {
+		return fmt.Errorf("cannot notify user %s", msg.UserID.Hex())
+	}
+	f.sent = append(f.sent, msg)
+	return nil
+}
+
+// Sent returns the messages delivered so far, oldest first.
+func (f *Fake) Sent() []Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Message(nil), f.sent...)
+}
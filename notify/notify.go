@@ -0,0 +1,33 @@
+// Package notify lets guests know about things that happen to their
+// bookings while they are away.
+package notify
+
+import (
+	"context"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Message is what a guest is told.
+type Message struct {
+	UserID  primitive.ObjectID
+	Email   string
+	Subject string
+	Body    string
+	// BookingID is the booking the message is about, if any.
+	BookingID primitive.ObjectID
+}
+
+// Notifier delivers messages to guests, e.g. by email.
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// Log writes messages to the standard logger instead of delivering them.
+type Log struct{}
+
+func (Log) Notify(ctx context.Context, msg Message) error {
+	log.Printf("notify %s: %s: %s", msg.Email, msg.Subject, msg.Body)
+	return nil
+}
@@ -0,0 +1,113 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/kmogilevskii/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Fake is an in-process provider for tests and local development. It
+// enforces the same rules a real provider would, but keeps its transactions
+// in memory only, so they are lost on restart.
+type Fake struct {
+	// DeclineAbove makes authorizations of larger amounts fail with
+	// ErrDeclined, 0 accepts every amount.
+	DeclineAbove int64
+	// FailRefunds makes every refund fail, as when the provider is down.
+	FailRefunds bool
+
+	mu       sync.Mutex
+	auths    map[string]*fakeAuth
+	captures map[string]*fakeCapture
+}
+
+type fakeAuth struct {
+	amount   types.Money
+	captured bool
+	voided   bool
+}
+
+type fakeCapture struct {
+	amount   types.Money
+	refunded int64
+}
+
+func NewFake() *Fake {
+	return &Fake{
+		auths:    map[string]*fakeAuth{},
+		captures: map[string]*fakeCapture{},
+	}
+}
+
+// nextID returns an ID unique across restarts, so transactions recorded on
+// bookings never collide with those of an earlier run.
+func (f *Fake) nextID(kind types.TransactionKind) string {
+	return fmt.Sprintf("fake_%s_%s", kind, primitive.NewObjectID().Hex())
+}
+
+func (f *Fake) Authorize(ctx context.Context, amount types.Money, reference string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if amount.Amount <= 0 {
+		return "", fmt.Errorf("cannot authorize %d %s", amount.Amount, amount.Currency)
+	}
+	if f.DeclineAbove > 0 && amount.Amount > f.DeclineAbove {
+		return "", ErrDeclined
+	}
+	id := f.nextID(types.TransactionAuthorize)
+	f.auths[id] = &fakeAuth{amount: amount}
+	return id, nil
+}
+
+func (f *Fake) Capture(ctx context.Context, authorizationID string, amount types.Money) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	auth, ok := f.auths[authorizationID]
+	switch {
+	case !ok:
+		return "", fmt.Errorf("unknown authorization %s", authorizationID)
+	case auth.captured || auth.voided:
+		return "", fmt.Errorf("authorization %s is closed", authorizationID)
+	case amount.Currency != auth.amount.Currency || amount.Amount > auth.amount.Amount:
+		return "", fmt.Errorf("cannot capture %d %s of authorization %s", amount.Amount, amount.Currency, authorizationID)
+	}
+	auth.captured = true
+	id := f.nextID(types.TransactionCapture)
+	f.captures[id] = &fakeCapture{amount: amount}
+	return id, nil
+}
+
+func (f *Fake) Refund(ctx context.Context, captureID string, amount types.Money) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	capture, ok := f.captures[captureID]
+	switch {
+	case f.FailRefunds:
+		return "", fmt.Errorf("refunding capture %s: provider unavailable", captureID)
+	case !ok:
+		return "", fmt.Errorf("unknown capture %s", captureID)
+	case amount.Currency != capture.amount.Currency || amount.Amount > capture.amount.Amount-capture.refunded:
+		return "", fmt.Errorf("cannot refund %d %s of capture %s", amount.Amount, amount.Currency, captureID)
+	}
+	capture.refunded += amount.Amount
+	return f.nextID(types.TransactionRefund), nil
+}
+
+func (f *Fake) Void(ctx context.Context, authorizationID string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	auth, ok := f.auths[authorizationID]
+	switch {
+	case !ok:
+		return "", fmt.Errorf("unknown authorization %s", authorizationID)
+	case auth.captured || auth.voided:
+		return "", fmt.Errorf("authorization %s is closed", authorizationID)
+	}
+	auth.voided = true
+	return f.nextID(types.TransactionVoid), nil
+}
+
+var _ Provider = (*Fake)(nil)
@@ -0,0 +1,165 @@
+// Package payments charges guests for their bookings through a payment
+// provider.
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kmogilevskii/hotel-reservation/types"
+)
+
+// ErrDeclined is returned by providers refusing to authorize a payment.
+var ErrDeclined = errors.New("payment declined")
+
+// Provider is a payment service provider. Every method returns the ID of the
+// transaction it carried out.
+type Provider interface {
+	// Authorize reserves the amount on the guest's payment method.
+	// Reference identifies what is paid for, e.g. a booking ID.
+	Authorize(ctx context.Context, amount types.Money, reference string) (string, error)
+	// Capture collects up to the authorized amount.
+	Capture(ctx context.Context, authorizationID string, amount types.Money) (string, error)
+	// Refund gives back up to the captured amount.
+	Refund(ctx context.Context, captureID string, amount types.Money) (string, error)
+	// Void releases an authorization that wasn't captured.
+	Void(ctx context.Context, authorizationID string) (string, error)
+}
+
+// Authorize reserves the amount and starts tracking the payment.
+func Authorize(ctx context.Context, provider Provider, amount types.Money, reference string) (*types.Payment, error) {
+	id, err := provider.Authorize(ctx, amount, reference)
+	if err != nil {
+		return nil, err
+	}
+	zero := types.Money{Currency: amount.Currency}
+	return &types.Payment{
+		Status:     types.PaymentAuthorized,
+		Authorized: amount,
+		Captured:   zero,
+		Refunded:   zero,
+		Transactions: []types.Transaction{{
+			ID:     id,
+			Kind:   types.TransactionAuthorize,
+			Amount: amount,
+			At:     time.Now(),
+		}},
+	}, nil
+}
+
+// Capture collects everything authorized on the payment.
+func Capture(ctx context.Context, provider Provider, payment *types.Payment) error {
+	auth, ok := payment.Last(types.TransactionAuthorize)
+	if !ok || payment.Status != types.PaymentAuthorized {
+		return fmt.Errorf("cannot capture a %s payment", payment.Status)
+	}
+	id, err := provider.Capture(ctx, auth.ID, auth.Amount)
+	if err != nil {
+		return err
+	}
+	record(payment, id, types.TransactionCapture, auth.Amount, auth.ID)
+	payment.Captured.Amount += auth.Amount.Amount
+	payment.Status = types.PaymentCaptured
+	return nil
+}
+
+// Void releases the payment's authorization.
+func Void(ctx context.Context, provider Provider, payment *types.Payment) error {
+	auth, ok := payment.Last(types.TransactionAuthorize)
+	if !ok || payment.Status != types.PaymentAuthorized {
+		return fmt.Errorf("cannot void a %s payment", payment.Status)
+	}
+	id, err := provider.Void(ctx, auth.ID)
+	if err != nil {
+		return err
+	}
+	record(payment, id, types.TransactionVoid, auth.Amount, auth.ID)
+	payment.Status = types.PaymentVoided
+	return nil
+}
+
+// Charge authorizes and captures the amount on top of what the payment
+// collected so far, starting a new payment when there is none. It is used
+// for balances due after the deposit.
+func Charge(ctx context.Context, provider Provider, payment *types.Payment, amount types.Money, reference string) (*types.Payment, error) {
+	if payment != nil && amount.Currency != payment.Captured.Currency {
+		return nil, fmt.Errorf("cannot charge %s on a payment in %s", amount.Currency, payment.Captured.Currency)
+	}
+	authID, err := provider.Authorize(ctx, amount, reference)
+	if err != nil {
+		return nil, err
+	}
+	captureID, err := provider.Capture(ctx, authID, amount)
+	if err != nil {
+		if _, voidErr := provider.Void(ctx, authID); voidErr != nil {
+			return nil, voidErr
+		}
+		return nil, err
+	}
+	if payment == nil {
+		zero := types.Money{Currency: amount.Currency}
+		payment = &types.Payment{Authorized: zero, Captured: zero, Refunded: zero}
+	}
+	record(payment, authID, types.TransactionAuthorize, amount, "")
+	record(payment, captureID, types.TransactionCapture, amount, authID)
+	payment.Authorized.Amount += amount.Amount
+	payment.Captured.Amount += amount.Amount
+	payment.Status = types.PaymentCaptured
+	if payment.Refunded.Amount > 0 {
+		payment.Status = types.PaymentPartiallyRefunded
+	}
+	return payment, nil
+}
+
+// Refund gives back the amount of what was captured on the payment. When it
+// was captured in several parts, the latest ones are refunded first.
+func Refund(ctx context.Context, provider Provider, payment *types.Payment, amount types.Money) error {
+	if _, ok := payment.Last(types.TransactionCapture); !ok {
+		return fmt.Errorf("cannot refund a %s payment", payment.Status)
+	}
+	if amount.Currency != payment.Captured.Currency || amount.Amount > payment.Captured.Amount-payment.Refunded.Amount {
+		return fmt.Errorf("cannot refund %d %s of a payment", amount.Amount, amount.Currency)
+	}
+	refunded := map[string]int64{}
+	for _, tx := range payment.Transactions {
+		if tx.Kind == types.TransactionRefund {
+			refunded[tx.ParentID] += tx.Amount.Amount
+		}
+	}
+	left := amount.Amount
+	for i := len(payment.Transactions) - 1; i >= 0 && left > 0; i-- {
+		capture := payment.Transactions[i]
+		if capture.Kind != types.TransactionCapture {
+			continue
+		}
+		part := min(left, capture.Amount.Amount-refunded[capture.ID])
+		if part <= 0 {
+			continue
+		}
+		refund := types.Money{Amount: part, Currency: amount.Currency}
+		id, err := provider.Refund(ctx, capture.ID, refund)
+		if err != nil {
+			return err
+		}
+		record(payment, id, types.TransactionRefund, refund, capture.ID)
+		payment.Refunded.Amount += part
+		left -= part
+	}
+	payment.Status = types.PaymentPartiallyRefunded
+	if payment.Refunded.Amount == payment.Captured.Amount {
+		payment.Status = types.PaymentRefunded
+	}
+	return nil
+}
+
+func record(payment *types.Payment, id string, kind types.TransactionKind, amount types.Money, parentID string) {
+	payment.Transactions = append(payment.Transactions, types.Transaction{
+		ID:       id,
+		Kind:     kind,
+		Amount:   amount,
+		ParentID: parentID,
+		At:       time.Now(),
+	})
+}
@@ -0,0 +1,140 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/kmogilevskii/hotel-reservation/types"
+)
+
+func usd(amount int64) types.Money {
+	return types.Money{Amount: amount, Currency: "USD"}
+}
+
+func TestCaptureAndRefund(t *testing.T) {
+	var (
+		ctx      = context.Background()
+		provider = NewFake()
+	)
+	payment, err := Authorize(ctx, provider, usd(20000), "booking")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Capture(ctx, provider, payment); err != nil {
+		t.Fatal(err)
+	}
+	if payment.Status != types.PaymentCaptured || payment.Captured != usd(20000) {
+		t.Fatalf("expected 200.00 to be captured, got %+v", payment)
+	}
+	if err := Refund(ctx, provider, payment, usd(5000)); err != nil {
+		t.Fatal(err)
+	}
+	if payment.Status != types.PaymentPartiallyRefunded || payment.Refunded != usd(5000) {
+		t.Fatalf("expected 50.00 to be refunded, got %+v", payment)
+	}
+	if err := Refund(ctx, provider, payment, usd(20000)); err == nil {
+		t.Fatal("expected refunding more than is left to fail")
+	}
+	if err := Refund(ctx, provider, payment, usd(15000)); err != nil {
+		t.Fatal(err)
+	}
+	if payment.Status != types.PaymentRefunded {
+		t.Fatalf("expected the payment to be fully refunded, got %s", payment.Status)
+	}
+
+	// transaction ids tell the kind and never repeat, not even across
+	// restarts
+	seen := map[string]bool{}
+	for i, tx := range payment.Transactions {
+		if !strings.HasPrefix(tx.ID, fmt.Sprintf("fake_%s_", tx.Kind)) || seen[tx.ID] {
+			t.Fatalf("expected transaction %d to have a unique %s id, got %s", i, tx.Kind, tx.ID)
+		}
+		seen[tx.ID] = true
+	}
+	if other := NewFake().nextID(types.TransactionAuthorize); other == payment.Transactions[0].ID {
+		t.Fatalf("expected another provider to hand out other ids, got %s twice", other)
+	}
+	if payment.Transactions[3].ParentID != payment.Transactions[1].ID {
+		t.Fatalf("expected refunds to apply to the capture, got %+v", payment.Transactions[3])
+	}
+}
+
+func TestVoid(t *testing.T) {
+	var (
+		ctx      = context.Background()
+		provider = NewFake()
+	)
+	payment, err := Authorize(ctx, provider, usd(10000), "booking")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Void(ctx, provider, payment); err != nil {
+		t.Fatal(err)
+	}
+	if payment.Status != types.PaymentVoided {
+		t.Fatalf("expected the payment to be voided, got %s", payment.Status)
+	}
+	if err := Capture(ctx, provider, payment); err == nil {
+		t.Fatal("expected capturing a voided payment to fail")
+	}
+	auth, _ := payment.Last(types.TransactionAuthorize)
+	if _, err := provider.Capture(ctx, auth.ID, usd(10000)); err == nil {
+		t.Fatal("expected the provider to refuse capturing a voided authorization")
+	}
+}
+
+func TestDecline(t *testing.T) {
+	provider := NewFake()
+	provider.DeclineAbove = 50000
+	if _, err := Authorize(context.Background(), provider, usd(50001), "booking"); !errors.Is(err, ErrDeclined) {
+		t.Fatalf("expected the payment to be declined, got %v", err)
+	}
+	if _, err := Authorize(context.Background(), provider, usd(50000), "booking"); err != nil {
+		t.Fatalf("expected the payment to be authorized, got %v", err)
+	}
+}
+
+func TestChargeBalance(t *testing.T) {
+	var (
+		ctx      = context.Background()
+		provider = NewFake()
+	)
+	deposit, err := Authorize(ctx, provider, usd(4000), "booking")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Capture(ctx, provider, deposit); err != nil {
+		t.Fatal(err)
+	}
+	payment, err := Charge(ctx, provider, deposit, usd(16000), "booking")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payment.Status != types.PaymentCaptured || payment.Captured != usd(20000) {
+		t.Fatalf("expected 200.00 to be captured in total, got %+v", payment)
+	}
+	if _, err := Charge(ctx, provider, payment, types.Money{Amount: 100, Currency: "EUR"}, "booking"); err == nil {
+		t.Fatal("expected charging another currency to fail")
+	}
+
+	// the refund takes all of the balance and part of the deposit
+	if err := Refund(ctx, provider, payment, usd(18000)); err != nil {
+		t.Fatal(err)
+	}
+	balanceCapture := payment.Transactions[3]
+	refunds := payment.Transactions[4:]
+	if len(refunds) != 2 || refunds[0].ParentID != balanceCapture.ID || refunds[0].Amount != usd(16000) || refunds[1].Amount != usd(2000) {
+		t.Fatalf("expected the balance to be refunded before the deposit, got %+v", refunds)
+	}
+	if payment.Status != types.PaymentPartiallyRefunded {
+		t.Fatalf("expected the payment to be partially refunded, got %s", payment.Status)
+	}
+
+	fresh, err := Charge(ctx, provider, nil, usd(5000), "booking")
+	if err != nil || fresh.Captured != usd(5000) || fresh.Refunded != usd(0) {
+		t.Fatalf("expected a new payment of 50.00, got %+v %v", fresh, err)
+	}
+}
@@ -0,0 +1,44 @@
+package pricing
+
+import (
+	"time"
+
+	"github.com/kmogilevskii/hotel-reservation/types"
+)
+
+// CancellationPolicy returns the policy of the rates, falling back to the
+// policy of the hotel. It returns nil when neither has one.
+func CancellationPolicy(hotel *types.Hotel, rates types.RoomRates) *types.CancellationPolicy {
+	if rates.CancellationPolicy != nil {
+		return rates.CancellationPolicy
+	}
+	return hotel.CancellationPolicy
+}
+
+// Cancel works out what is kept and what is refunded when the booking is
+// canceled at the given time, according to the policy stored on it. Bookings
+// without a policy are refunded in full. The penalty is taken out of what
+// the guest paid so far, so a deposit can be kept whole while the balance
+// was never charged.
+func Cancel(booking *types.Booking, at time.Time) types.Cancellation {
+	var total types.Money
+	if booking.Price != nil {
+		total = booking.Price.Total
+	}
+	paid := Paid(booking)
+	penalty := types.Money{Currency: total.Currency}
+	if policy := booking.CancellationPolicy; policy != nil {
+		deadline := booking.FromDate.AddDate(0, 0, -policy.FreeUntilDays)
+		switch {
+		case policy.NonRefundable:
+			penalty.Amount = total.Amount
+		case !at.Before(deadline):
+			penalty.Amount = total.Amount * int64(policy.PenaltyPercent) / 100
+		}
+	}
+	return types.Cancellation{
+		CanceledAt: at,
+		Penalty:    penalty,
+		Refund:     types.Money{Amount: max(paid.Amount-penalty.Amount, 0), Currency: total.Currency},
+	}
+}
@@ -0,0 +1,57 @@
+package pricing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kmogilevskii/hotel-reservation/types"
+)
+
+func TestCancel(t *testing.T) {
+	checkIn := time.Date(2030, time.March, 10, 14, 0, 0, 0, time.UTC)
+	price := &types.PriceBreakdown{Total: types.Money{Amount: 40000, Currency: "EUR"}}
+	payment := &types.Payment{Status: types.PaymentCaptured, Captured: price.Total, Refunded: types.Money{Currency: "EUR"}}
+	tests := []struct {
+		name    string
+		policy  *types.CancellationPolicy
+		at      time.Time
+		penalty int64
+	}{
+		{"no policy", nil, checkIn.Add(-time.Hour), 0},
+		{"before the free deadline", &types.CancellationPolicy{FreeUntilDays: 7, PenaltyPercent: 25}, checkIn.AddDate(0, 0, -8), 0},
+		{"on the free deadline", &types.CancellationPolicy{FreeUntilDays: 7, PenaltyPercent: 25}, checkIn.AddDate(0, 0, -7), 10000},
+		{"after the free deadline", &types.CancellationPolicy{FreeUntilDays: 7, PenaltyPercent: 25}, checkIn.AddDate(0, 0, -1), 10000},
+		{"full penalty", &types.CancellationPolicy{FreeUntilDays: 2, PenaltyPercent: 100}, checkIn.AddDate(0, 0, -1), 40000},
+		{"non-refundable", &types.CancellationPolicy{NonRefundable: true}, checkIn.AddDate(0, -1, 0), 40000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			booking := &types.Booking{FromDate: checkIn, Price: price, Payment: payment, CancellationPolicy: tt.policy}
+			c := Cancel(booking, tt.at)
+			if c.Penalty.Amount != tt.penalty || c.Refund.Amount != price.Total.Amount-tt.penalty {
+				t.Fatalf("expected a penalty of %d, got %+v", tt.penalty, c)
+			}
+			if c.Refund.Currency != "EUR" || !c.CanceledAt.Equal(tt.at) {
+				t.Fatalf("unexpected cancellation %+v", c)
+			}
+		})
+	}
+}
+
+func TestCancellationPolicy(t *testing.T) {
+	var (
+		hotelPolicy = &types.CancellationPolicy{FreeUntilDays: 3}
+		ratePolicy  = &types.CancellationPolicy{NonRefundable: true}
+		hotel       = &types.Hotel{CancellationPolicy: hotelPolicy}
+	)
+	if p := CancellationPolicy(hotel, Rates(&types.Room{}, "")); p != hotelPolicy {
+		t.Fatalf("expected the hotel policy, got %+v", p)
+	}
+	room := &types.Room{Rates: &types.RoomRates{CancellationPolicy: ratePolicy}}
+	if p := CancellationPolicy(hotel, Rates(room, "")); p != ratePolicy {
+		t.Fatalf("expected the rate policy, got %+v", p)
+	}
+	if p := CancellationPolicy(&types.Hotel{}, Rates(&types.Room{}, "")); p != nil {
+		t.Fatalf("expected no policy, got %+v", p)
+	}
+}
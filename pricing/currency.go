@@ -0,0 +1,95 @@
+package pricing
+
+import (
+	"math"
+	"slices"
+
+	"github.com/kmogilevskii/hotel-reservation/types"
+)
+
+// exponents are the currencies whose minor unit isn't a hundredth of the
+// major one, per ISO 4217.
+var exponents = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0,
+	"PYG": 0, "RWF": 0, "UGX": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+}
+
+// Exponent returns how many decimals the minor unit of the currency has,
+// e.g. 2 for USD cents, 0 for JPY and 3 for KWD fils.
+func Exponent(currency string) int {
+	if exp, ok := exponents[currency]; ok {
+		return exp
+	}
+	return 2
+}
+
+// ToMinorUnits converts a decimal price such as 99.99 to the minor units of
+// the currency, cents for USD.
+func ToMinorUnits(price float64, currency string) int64 {
+	return int64(math.Round(price * math.Pow10(Exponent(currency))))
+}
+
+// FromMinorUnits converts an amount in the minor units of the currency back
+// to a decimal price.
+func FromMinorUnits(amount int64, currency string) float64 {
+	return float64(amount) / math.Pow10(Exponent(currency))
+}
+
+// Convert converts an amount in minor units of the rate's source currency
+// at the rate, rounding to the nearest minor unit of the target currency.
+func Convert(amount int64, rate types.ExchangeRate) int64 {
+	shift := math.Pow10(Exponent(rate.To) - Exponent(rate.From))
+	return int64(math.Round(float64(amount) * rate.Rate * shift))
+}
+
+// ConvertMoney converts money in the rate's source currency, other money is
+// returned as is.
+func ConvertMoney(m types.Money, rate types.ExchangeRate) types.Money {
+	if m.Currency != rate.From {
+		return m
+	}
+	return types.Money{Amount: Convert(m.Amount, rate), Currency: rate.To}
+}
+
+// ConvertRates converts the rates to the rate's target currency. Stays
+// priced at the converted rates cost the same, night by night, as the
+// converted rates say.
+func ConvertRates(rates types.RoomRates, rate types.ExchangeRate) types.RoomRates {
+	if rates.Currency != rate.From {
+		return rates
+	}
+	rates.Currency = rate.To
+	rates.Weekday = Convert(rates.Weekday, rate)
+	rates.Weekend = Convert(rates.Weekend, rate)
+	rates.ExtraGuest = Convert(rates.ExtraGuest, rate)
+	rates.Seasons = slices.Clone(rates.Seasons)
+	for i, s := range rates.Seasons {
+		rates.Seasons[i].Weekday = Convert(s.Weekday, rate)
+		rates.Seasons[i].Weekend = Convert(s.Weekend, rate)
+	}
+	return rates
+}
+
+// ConvertTaxes converts the fees of the rules to the rate's target currency.
+func ConvertTaxes(rules *types.TaxRules, rate types.ExchangeRate) *types.TaxRules {
+	if rules == nil {
+		return nil
+	}
+	converted := *rules
+	converted.Fees = slices.Clone(rules.Fees)
+	for i, fee := range converted.Fees {
+		converted.Fees[i].Amount = ConvertMoney(fee.Amount, rate)
+	}
+	return &converted
+}
+
+// ConvertDiscountRule converts the fixed amount of a discount to the rate's
+// target currency.
+func ConvertDiscountRule(rule types.DiscountRule, rate types.ExchangeRate) types.DiscountRule {
+	if rule.Fixed != nil {
+		fixed := ConvertMoney(*rule.Fixed, rate)
+		rule.Fixed = &fixed
+	}
+	return rule
+}
@@ -0,0 +1,102 @@
+package pricing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kmogilevskii/hotel-reservation/types"
+)
+
+func TestConvertRates(t *testing.T) {
+	var (
+		rate  = types.ExchangeRate{From: "USD", To: "EUR", Rate: 0.9137}
+		rates = types.RoomRates{
+			Currency:   "USD",
+			Weekday:    10000,
+			Weekend:    12550,
+			ExtraGuest: 1999,
+			Seasons: []types.SeasonalRate{
+				{Name: "spring break", From: day(time.March, 10), Till: day(time.March, 17), Weekday: 20000},
+			},
+		}
+	)
+	converted := ConvertRates(rates, rate)
+	// 100.00, 125.50 and 19.99 USD at 0.9137
+	if converted.Currency != "EUR" || converted.Weekday != 9137 || converted.Weekend != 11467 || converted.ExtraGuest != 1826 {
+		t.Fatalf("unexpected converted rates %+v", converted)
+	}
+	if converted.Seasons[0].Weekday != 18274 || rates.Seasons[0].Weekday != 20000 {
+		t.Fatalf("expected the seasons to be converted on a copy, got %+v and %+v", converted.Seasons, rates.Seasons)
+	}
+	if again := ConvertRates(converted, rate); again.Weekday != converted.Weekday {
+		t.Fatalf("expected rates in another currency to be left alone, got %+v", again)
+	}
+
+	// a stay at the converted rates costs what the converted rates say
+	price, err := ComputeRates(converted, day(time.March, 7), day(time.March, 10), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if price.Total != (types.Money{Amount: 9137 + 2*11467 + 3*1826, Currency: "EUR"}) {
+		t.Fatalf("unexpected total %+v", price.Total)
+	}
+}
+
+func TestMinorUnits(t *testing.T) {
+	for _, tc := range []struct {
+		currency string
+		price    float64
+		amount   int64
+	}{
+		{"USD", 99.99, 9999},
+		{"JPY", 15000, 15000},
+		{"KWD", 12.345, 12345},
+	} {
+		if got := ToMinorUnits(tc.price, tc.currency); got != tc.amount {
+			t.Fatalf("expected %v %s to be %d minor units, got %d", tc.price, tc.currency, tc.amount, got)
+		}
+		if got := FromMinorUnits(tc.amount, tc.currency); got != tc.price {
+			t.Fatalf("expected %d minor units of %s to be %v, got %v", tc.amount, tc.currency, tc.price, got)
+		}
+	}
+
+	// converting accounts for the decimals of either currency: 100.00 USD
+	// are 15000 JPY and 30.750 KWD
+	if got := Convert(10000, types.ExchangeRate{From: "USD", To: "JPY", Rate: 150}); got != 15000 {
+		t.Fatalf("expected 15000 JPY, got %d", got)
+	}
+	if got := Convert(10000, types.ExchangeRate{From: "USD", To: "KWD", Rate: 0.3075}); got != 30750 {
+		t.Fatalf("expected 30750 fils, got %d", got)
+	}
+	if got := Convert(15000, types.ExchangeRate{From: "JPY", To: "USD", Rate: 1.0 / 150}); got != 10000 {
+		t.Fatalf("expected 10000 cents, got %d", got)
+	}
+}
+
+func TestConvertTaxesAndDiscounts(t *testing.T) {
+	var (
+		rate  = types.ExchangeRate{From: "USD", To: "EUR", Rate: 0.9}
+		rules = &types.TaxRules{
+			VAT:  &types.VAT{Percent: 10},
+			Fees: []types.Fee{{Name: "City tax", Amount: types.Money{Amount: 250, Currency: "USD"}, Basis: types.PerNight}},
+		}
+	)
+	converted := ConvertTaxes(rules, rate)
+	if converted.Fees[0].Amount != (types.Money{Amount: 225, Currency: "EUR"}) || converted.VAT != rules.VAT {
+		t.Fatalf("unexpected converted taxes %+v", converted)
+	}
+	if rules.Fees[0].Amount.Currency != "USD" {
+		t.Fatal("expected the rules to be converted on a copy")
+	}
+	if ConvertTaxes(nil, rate) != nil {
+		t.Fatal("expected no rules to stay nil")
+	}
+
+	fixed := types.DiscountRule{Fixed: &types.Money{Amount: 2000, Currency: "USD"}}
+	if rule := ConvertDiscountRule(fixed, rate); *rule.Fixed != (types.Money{Amount: 1800, Currency: "EUR"}) || fixed.Fixed.Amount != 2000 {
+		t.Fatalf("unexpected converted discount %+v", rule.Fixed)
+	}
+	if rule := ConvertDiscountRule(types.DiscountRule{Percent: 15}, rate); rule.Percent != 15 || rule.Fixed != nil {
+		t.Fatalf("expected a percentage to stay as is, got %+v", rule)
+	}
+}
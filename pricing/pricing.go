@@ -0,0 +1,149 @@
+// Package pricing computes what a stay costs, night by night.
+package pricing
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kmogilevskii/hotel-reservation/types"
+)
+
+// DefaultCurrency is assumed for hotels without a base currency.
+const DefaultCurrency = "USD"
+
+// Currency returns the base currency of the hotel.
+func Currency(hotel *types.Hotel) string {
+	if hotel.Currency != "" {
+		return hotel.Currency
+	}
+	return DefaultCurrency
+}
+
+// Rates returns the rates the room is priced with. Rooms without configured
+// rates are charged their list price every night. Prices without a currency
+// are in the given one, the default currency if it is empty.
+func Rates(room *types.Room, currency string) types.RoomRates {
+	return rates(room.Price, room.Rates, currency)
+}
+
+// TypeRates returns the rates the rooms of a type are sold at.
+func TypeRates(roomType *types.RoomType, currency string) types.RoomRates {
+	return rates(roomType.Price, roomType.Rates, currency)
+}
+
+func rates(price float64, configured *types.RoomRates, currency string) types.RoomRates {
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+	if configured != nil {
+		rates := *configured
+		if rates.Currency == "" {
+			rates.Currency = currency
+		}
+		return rates
+	}
+	return types.RoomRates{
+		Currency: currency,
+		Weekday:  ToMinorUnits(price, currency),
+	}
+}
+
+func date(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// Nights returns the calendar date of every night between from and till.
+func Nights(from, till time.Time) []time.Time {
+	var nights []time.Time
+	for d := date(from); d.Before(date(till)); d = d.AddDate(0, 0, 1) {
+		nights = append(nights, d)
+	}
+	return nights
+}
+
+// IsWeekend reports whether the night starting on the given date is a
+// weekend night, i.e. a Friday or a Saturday.
+func IsWeekend(night time.Time) bool {
+	wd := night.Weekday()
+	return wd == time.Friday || wd == time.Saturday
+}
+
+func season(rates types.RoomRates, night time.Time) (types.SeasonalRate, bool) {
+	for _, s := range rates.Seasons {
+		if !night.Before(date(s.From)) && night.Before(date(s.Till)) {
+			return s, true
+		}
+	}
+	return types.SeasonalRate{}, false
+}
+
+func nightlyRate(weekday, weekend int64, isWeekend bool) int64 {
+	if isWeekend && weekend > 0 {
+		return weekend
+	}
+	return weekday
+}
+
+// Compute prices a stay of the given number of guests in the room.
+func Compute(room *types.Room, from, till time.Time, guests int) (*types.PriceBreakdown, error) {
+	return ComputeRates(Rates(room, ""), from, till, guests)
+}
+
+// ComputeRates prices a stay of the given number of guests at the rates.
+func ComputeRates(rates types.RoomRates, from, till time.Time, guests int) (*types.PriceBreakdown, error) {
+	if guests < 1 {
+		return nil, fmt.Errorf("a stay needs at least one guest")
+	}
+	nights := Nights(from, till)
+	if len(nights) == 0 {
+		return nil, fmt.Errorf("a stay must be at least one night long")
+	}
+	var (
+		included = rates.IncludedGuests
+		total    int64
+	)
+	if included == 0 {
+		included = 1
+	}
+	extraGuests := int64(max(guests-included, 0))
+	breakdown := &types.PriceBreakdown{
+		Nights: make([]types.NightPrice, 0, len(nights)),
+	}
+	for _, night := range nights {
+		np := types.NightPrice{
+			Date:        night,
+			Weekend:     IsWeekend(night),
+			ExtraGuests: extraGuests * rates.ExtraGuest,
+		}
+		if s, ok := season(rates, night); ok {
+			np.Season = s.Name
+			np.Rate = nightlyRate(s.Weekday, s.Weekend, np.Weekend)
+		} else {
+			np.Rate = nightlyRate(rates.Weekday, rates.Weekend, np.Weekend)
+		}
+		np.Amount = np.Rate + np.ExtraGuests
+		total += np.Amount
+		breakdown.Nights = append(breakdown.Nights, np)
+	}
+	breakdown.Total = types.Money{Amount: total, Currency: rates.Currency}
+	return breakdown, nil
+}
+
+// ApplyDiscount takes the rule's discount off the price total and returns it.
+// Fixed discounts never take off more than the total.
+func ApplyDiscount(price *types.PriceBreakdown, code string, rule types.DiscountRule) (*types.Discount, error) {
+	amount := price.Total.Amount * int64(rule.Percent) / 100
+	if rule.Fixed != nil {
+		if rule.Fixed.Currency != price.Total.Currency {
+			return nil, fmt.Errorf("promo code %s only applies to prices in %s", code, rule.Fixed.Currency)
+		}
+		amount = min(rule.Fixed.Amount, price.Total.Amount)
+	}
+	price.Total.Amount -= amount
+	return &types.Discount{
+		Code:   code,
+		Rule:   rule,
+		Amount: types.Money{Amount: amount, Currency: price.Total.Currency},
+	}, nil
+}
@@ -0,0 +1,131 @@
+package pricing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kmogilevskii/hotel-reservation/types"
+)
+
+func day(month time.Month, d int) time.Time {
+	return time.Date(2030, month, d, 14, 0, 0, 0, time.UTC)
+}
+
+func TestCompute(t *testing.T) {
+	// 2030-03-01 is a Friday
+	rates := &types.RoomRates{
+		Currency:       "EUR",
+		Weekday:        10000,
+		Weekend:        15000,
+		ExtraGuest:     2500,
+		IncludedGuests: 2,
+		Seasons: []types.SeasonalRate{
+			{Name: "spring break", From: day(time.March, 10), Till: day(time.March, 17), Weekday: 20000},
+		},
+	}
+	tests := []struct {
+		name     string
+		room     types.Room
+		from     time.Time
+		till     time.Time
+		guests   int
+		nights   int
+		total    int64
+		currency string
+	}{
+		{"list price", types.Room{Price: 99.99}, day(time.March, 4), day(time.March, 6), 3, 2, 19998, "USD"},
+		{"weekday nights", types.Room{Rates: rates}, day(time.March, 4), day(time.March, 7), 2, 3, 30000, "EUR"},
+		{"over a weekend", types.Room{Rates: rates}, day(time.March, 7), day(time.March, 10), 1, 3, 40000, "EUR"},
+		{"extra guests", types.Room{Rates: rates}, day(time.March, 4), day(time.March, 6), 4, 2, 30000, "EUR"},
+		{"season without weekend rate", types.Room{Rates: rates}, day(time.March, 15), day(time.March, 18), 2, 3, 50000, "EUR"},
+		{"default currency", types.Room{Rates: &types.RoomRates{Weekday: 5000}}, day(time.March, 4), day(time.March, 5), 1, 1, 5000, DefaultCurrency},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			price, err := Compute(&tt.room, tt.from, tt.till, tt.guests)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(price.Nights) != tt.nights {
+				t.Fatalf("expected %d nights, got %d", tt.nights, len(price.Nights))
+			}
+			if price.Total.Amount != tt.total || price.Total.Currency != tt.currency {
+				t.Fatalf("expected %d %s, got %+v", tt.total, tt.currency, price.Total)
+			}
+			var sum int64
+			for _, n := range price.Nights {
+				sum += n.Amount
+			}
+			if sum != price.Total.Amount {
+				t.Fatalf("nights add up to %d, total is %d", sum, price.Total.Amount)
+			}
+		})
+	}
+}
+
+func TestComputeBreakdown(t *testing.T) {
+	room := types.Room{Rates: &types.RoomRates{
+		Weekday:    10000,
+		Weekend:    12000,
+		ExtraGuest: 1000,
+		Seasons: []types.SeasonalRate{
+			{Name: "high", From: day(time.March, 2), Till: day(time.March, 3), Weekday: 30000, Weekend: 35000},
+		},
+	}}
+	price, err := Compute(&room, day(time.March, 1), day(time.March, 4), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []types.NightPrice{
+		{Date: time.Date(2030, time.March, 1, 0, 0, 0, 0, time.UTC), Weekend: true, Rate: 12000, ExtraGuests: 1000, Amount: 13000},
+		{Date: time.Date(2030, time.March, 2, 0, 0, 0, 0, time.UTC), Weekend: true, Season: "high", Rate: 35000, ExtraGuests: 1000, Amount: 36000},
+		{Date: time.Date(2030, time.March, 3, 0, 0, 0, 0, time.UTC), Rate: 10000, ExtraGuests: 1000, Amount: 11000},
+	}
+	for i, n := range price.Nights {
+		if n != want[i] {
+			t.Fatalf("night %d: expected %+v, got %+v", i, want[i], n)
+		}
+	}
+}
+
+func TestComputeRejectsEmptyStays(t *testing.T) {
+	room := types.Room{Price: 100}
+	if _, err := Compute(&room, day(time.March, 1), day(time.March, 1).Add(time.Hour), 1); err == nil {
+		t.Fatal("expected an error for a stay without nights")
+	}
+	if _, err := Compute(&room, day(time.March, 1), day(time.March, 2), 0); err == nil {
+		t.Fatal("expected an error for a stay without guests")
+	}
+}
+
+func TestApplyDiscount(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     types.DiscountRule
+		discount int64
+		wantErr  bool
+	}{
+		{"percentage", types.DiscountRule{Percent: 15}, 4500, false},
+		{"fixed", types.DiscountRule{Fixed: &types.Money{Amount: 5000, Currency: "USD"}}, 5000, false},
+		{"fixed above the total", types.DiscountRule{Fixed: &types.Money{Amount: 50000, Currency: "USD"}}, 30000, false},
+		{"fixed in another currency", types.DiscountRule{Fixed: &types.Money{Amount: 5000, Currency: "EUR"}}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			price := &types.PriceBreakdown{Total: types.Money{Amount: 30000, Currency: "USD"}}
+			d, err := ApplyDiscount(price, "CODE", tt.rule)
+			if tt.wantErr {
+				if err == nil || price.Total.Amount != 30000 {
+					t.Fatalf("expected an error and an untouched price, got %v %+v", err, price.Total)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.Amount.Amount != tt.discount || price.Total.Amount != 30000-tt.discount || d.Code != "CODE" {
+				t.Fatalf("expected %d off, got %+v and a total of %+v", tt.discount, d, price.Total)
+			}
+		})
+	}
+}
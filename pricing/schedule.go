@@ -0,0 +1,102 @@
+package pricing
+
+import (
+	"time"
+
+	"github.com/kmogilevskii/hotel-reservation/types"
+)
+
+// Schedule works out the deposit due when a stay arriving at from is booked
+// at now for the total, and the balance left after it. It returns nil when
+// everything is due at booking: the rates have no schedule, there is nothing
+// left after the deposit, or the balance would already be due.
+func Schedule(schedule *types.PaymentSchedule, total types.Money, from, now time.Time) *types.Balance {
+	if schedule == nil {
+		return nil
+	}
+	deposit := total.Amount * int64(schedule.DepositPercent) / 100
+	dueAt := from.AddDate(0, 0, -schedule.BalanceDueDays)
+	if deposit >= total.Amount || !now.Before(dueAt) {
+		return nil
+	}
+	balance := &types.Balance{
+		Deposit: types.Money{Amount: deposit, Currency: total.Currency},
+		Amount:  types.Money{Amount: total.Amount - deposit, Currency: total.Currency},
+		Status:  types.BalanceDue,
+	}
+	setDueDate(balance, schedule, dueAt)
+	return balance
+}
+
+// Reschedule works out the balance after the stay changed to arrive at from
+// and cost the total, with what the guest paid so far counting as the
+// deposit. A stay cheaper than what was paid leaves nothing to pay. A stay
+// that was paid in full only gets a balance again when it got dearer and
+// the rest isn't due yet, otherwise the balance is returned as it was and
+// the difference has to be settled right away, see Outstanding.
+func Reschedule(schedule *types.PaymentSchedule, balance *types.Balance, total, paid types.Money, from, now time.Time) *types.Balance {
+	if schedule == nil {
+		return balance
+	}
+	dueAt := from.AddDate(0, 0, -schedule.BalanceDueDays)
+	if balance == nil || balance.Status == types.BalancePaid {
+		if total.Amount <= paid.Amount || !now.Before(dueAt) {
+			return balance
+		}
+		balance = &types.Balance{Status: types.BalanceDue}
+	}
+	updated := *balance
+	updated.Deposit = paid
+	updated.Amount = types.Money{Amount: max(total.Amount-paid.Amount, 0), Currency: total.Currency}
+	if updated.Amount.Amount == 0 {
+		updated.Status = types.BalancePaid
+		updated.PaidAt = &now
+		return &updated
+	}
+	updated.PaidAt = nil
+	setDueDate(&updated, schedule, dueAt)
+	updated.Status = types.BalanceDue
+	if !now.Before(updated.DueAt) {
+		updated.Status = types.BalanceOverdue
+	}
+	return &updated
+}
+
+func setDueDate(balance *types.Balance, schedule *types.PaymentSchedule, dueAt time.Time) {
+	balance.DueAt = dueAt
+	balance.CancelAt = nil
+	if schedule.AutoCancel {
+		cancelAt := dueAt.Add(time.Duration(schedule.GraceHours) * time.Hour)
+		balance.CancelAt = &cancelAt
+	}
+}
+
+// Paid returns how much of the booking's price the guest has paid so far,
+// what was captured less what was refunded. Nothing was paid on bookings
+// made before payments were taken or only authorized so far.
+func Paid(booking *types.Booking) types.Money {
+	var paid types.Money
+	if booking.Price != nil {
+		paid.Currency = booking.Price.Total.Currency
+	}
+	if p := booking.Payment; p != nil {
+		paid = types.Money{Amount: p.Captured.Amount - p.Refunded.Amount, Currency: p.Captured.Currency}
+	}
+	return paid
+}
+
+// Outstanding returns what the guest owes on the booking beyond what was
+// paid and what is left on the balance, negative when the guest paid more
+// than the stay costs. Bookings made before payments were taken owe
+// nothing.
+func Outstanding(booking *types.Booking) types.Money {
+	if booking.Price == nil || booking.Payment == nil && booking.Balance == nil {
+		return types.Money{}
+	}
+	owed := booking.Price.Total
+	owed.Amount -= Paid(booking).Amount
+	if b := booking.Balance; b != nil && b.Status != types.BalancePaid {
+		owed.Amount -= b.Amount.Amount
+	}
+	return owed
+}
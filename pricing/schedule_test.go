@@ -0,0 +1,134 @@
+package pricing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kmogilevskii/hotel-reservation/types"
+)
+
+func TestSchedule(t *testing.T) {
+	var (
+		checkIn = time.Date(2030, time.March, 10, 14, 0, 0, 0, time.UTC)
+		total   = types.Money{Amount: 50000, Currency: "EUR"}
+		deposit = &types.PaymentSchedule{DepositPercent: 20}
+		weekOut = &types.PaymentSchedule{BalanceDueDays: 7, AutoCancel: true, GraceHours: 24}
+	)
+	tests := []struct {
+		name     string
+		schedule *types.PaymentSchedule
+		now      time.Time
+		deposit  int64
+		balance  int64
+		dueAt    time.Time
+	}{
+		{"no schedule", nil, checkIn.AddDate(0, -1, 0), 50000, 0, time.Time{}},
+		{"deposit with the rest at check-in", deposit, checkIn.AddDate(0, -1, 0), 10000, 40000, checkIn},
+		{"full amount a week before arrival", weekOut, checkIn.AddDate(0, -1, 0), 0, 50000, checkIn.AddDate(0, 0, -7)},
+		{"booked within the week", weekOut, checkIn.AddDate(0, 0, -3), 50000, 0, time.Time{}},
+		{"full deposit", &types.PaymentSchedule{DepositPercent: 100}, checkIn.AddDate(0, -1, 0), 50000, 0, time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			balance := Schedule(tt.schedule, total, checkIn, tt.now)
+			if tt.balance == 0 {
+				if balance != nil {
+					t.Fatalf("expected everything to be due at booking, got %+v", balance)
+				}
+				return
+			}
+			if balance == nil || balance.Deposit.Amount != tt.deposit || balance.Amount.Amount != tt.balance {
+				t.Fatalf("expected a deposit of %d and a balance of %d, got %+v", tt.deposit, tt.balance, balance)
+			}
+			if !balance.DueAt.Equal(tt.dueAt) || balance.Status != types.BalanceDue || balance.Amount.Currency != "EUR" {
+				t.Fatalf("expected the balance to be due at %s, got %+v", tt.dueAt, balance)
+			}
+		})
+	}
+
+	balance := Schedule(weekOut, total, checkIn, checkIn.AddDate(0, -1, 0))
+	if want := checkIn.AddDate(0, 0, -6); balance.CancelAt == nil || !balance.CancelAt.Equal(want) {
+		t.Fatalf("expected the booking to be canceled a day after the balance was due, got %v", balance.CancelAt)
+	}
+	if balance := Schedule(deposit, total, checkIn, checkIn.AddDate(0, -1, 0)); balance.CancelAt != nil {
+		t.Fatalf("expected no automatic cancellation, got %v", balance.CancelAt)
+	}
+}
+
+func TestReschedule(t *testing.T) {
+	var (
+		checkIn  = time.Date(2030, time.March, 10, 14, 0, 0, 0, time.UTC)
+		now      = checkIn.AddDate(0, -1, 0)
+		schedule = &types.PaymentSchedule{DepositPercent: 20, BalanceDueDays: 2}
+		balance  = Schedule(schedule, types.Money{Amount: 50000, Currency: "EUR"}, checkIn, now)
+		paid     = balance.Deposit
+	)
+	// a night longer, arriving a day earlier
+	moved := Reschedule(schedule, balance, types.Money{Amount: 60000, Currency: "EUR"}, paid, checkIn.AddDate(0, 0, -1), now)
+	if moved.Deposit.Amount != 10000 || moved.Amount.Amount != 50000 || !moved.DueAt.Equal(checkIn.AddDate(0, 0, -3)) {
+		t.Fatalf("expected 500.00 due 3 days before the old arrival, got %+v", moved)
+	}
+	if balance.Amount.Amount != 40000 {
+		t.Fatal("expected the original balance to be left alone")
+	}
+	cheaper := Reschedule(schedule, balance, types.Money{Amount: 8000, Currency: "EUR"}, paid, checkIn, now)
+	if cheaper.Amount.Amount != 0 || cheaper.Status != types.BalancePaid {
+		t.Fatalf("expected the deposit to cover the stay, got %+v", cheaper)
+	}
+	late := Reschedule(schedule, balance, types.Money{Amount: 50000, Currency: "EUR"}, paid, now.AddDate(0, 0, 1), now)
+	if late.Status != types.BalanceOverdue {
+		t.Fatalf("expected a balance due before now to be overdue, got %+v", late)
+	}
+
+	// paid in full, with nothing or everything left of the balance
+	full := types.Money{Amount: 50000, Currency: "EUR"}
+	settled := &types.Balance{Deposit: paid, Amount: types.Money{Amount: 40000, Currency: "EUR"}, Status: types.BalancePaid, PaidAt: &now}
+	for _, b := range []*types.Balance{nil, settled} {
+		dearer := Reschedule(schedule, b, types.Money{Amount: 60000, Currency: "EUR"}, full, checkIn, now)
+		if dearer == nil || dearer.Status != types.BalanceDue || dearer.Deposit != full || dearer.Amount.Amount != 10000 || dearer.PaidAt != nil {
+			t.Fatalf("expected the extra 100.00 to be due later, got %+v", dearer)
+		}
+		if same := Reschedule(schedule, b, types.Money{Amount: 40000, Currency: "EUR"}, full, checkIn, now); same != b {
+			t.Fatalf("expected a cheaper stay to keep the balance, got %+v", same)
+		}
+		if due := Reschedule(schedule, b, types.Money{Amount: 60000, Currency: "EUR"}, full, now.AddDate(0, 0, 1), now); due != b {
+			t.Fatalf("expected the extra to be due right away, got %+v", due)
+		}
+	}
+}
+
+func TestCancelWithDeposit(t *testing.T) {
+	checkIn := time.Date(2030, time.March, 10, 14, 0, 0, 0, time.UTC)
+	booking := &types.Booking{
+		FromDate:           checkIn,
+		Price:              &types.PriceBreakdown{Total: types.Money{Amount: 50000, Currency: "EUR"}},
+		CancellationPolicy: &types.CancellationPolicy{FreeUntilDays: 7, PenaltyPercent: 10},
+		Payment: &types.Payment{
+			Status:   types.PaymentCaptured,
+			Captured: types.Money{Amount: 10000, Currency: "EUR"},
+			Refunded: types.Money{Currency: "EUR"},
+		},
+		Balance: &types.Balance{
+			Deposit: types.Money{Amount: 10000, Currency: "EUR"},
+			Amount:  types.Money{Amount: 40000, Currency: "EUR"},
+			Status:  types.BalanceDue,
+		},
+	}
+	tests := []struct {
+		name   string
+		at     time.Time
+		refund int64
+	}{
+		{"free cancellation refunds the deposit", checkIn.AddDate(0, 0, -10), 10000},
+		{"the penalty comes out of the deposit", checkIn.AddDate(0, 0, -1), 5000},
+	}
+	for _, tt := range tests {
+		if c := Cancel(booking, tt.at); c.Refund.Amount != tt.refund {
+			t.Fatalf("%s: expected a refund of %d, got %+v", tt.name, tt.refund, c)
+		}
+	}
+	booking.CancellationPolicy.PenaltyPercent = 50
+	if c := Cancel(booking, checkIn.AddDate(0, 0, -1)); c.Refund.Amount != 0 || c.Penalty.Amount != 25000 {
+		t.Fatalf("expected the whole deposit to be kept, got %+v", c)
+	}
+}
@@ -0,0 +1,81 @@
+package pricing
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/kmogilevskii/hotel-reservation/types"
+)
+
+// ApplyTaxes itemizes the hotel's fees and VAT on a priced stay, after any
+// discount was taken off. Fees and exclusive VAT are added to the total,
+// inclusive VAT is already part of it and only itemized. VAT is levied on
+// the room total and the taxable fees.
+func ApplyTaxes(price *types.PriceBreakdown, rules *types.TaxRules, stay types.BookParams) error {
+	price.Charges = nil
+	if rules == nil {
+		return nil
+	}
+	currency := price.Total.Currency
+	base := price.Total.Amount
+	for _, fee := range rules.Fees {
+		if fee.Amount.Currency != currency {
+			return fmt.Errorf("fee %s is charged in %s, not in %s", fee.Name, fee.Amount.Currency, currency)
+		}
+		quantity := feeQuantity(fee, stay)
+		charge := types.PriceCharge{
+			Kind:       types.ChargeFee,
+			Name:       fee.Name,
+			Basis:      fee.Basis,
+			Quantity:   quantity,
+			UnitAmount: fee.Amount.Amount,
+			Amount:     int64(quantity) * fee.Amount.Amount,
+		}
+		if fee.Taxable {
+			base += charge.Amount
+		}
+		price.Total.Amount += charge.Amount
+		price.Charges = append(price.Charges, charge)
+	}
+	if vat := rules.VAT; vat != nil {
+		basisPoints := int64(math.Round(vat.Percent * 100))
+		charge := types.PriceCharge{
+			Kind:     types.ChargeVAT,
+			Name:     "VAT",
+			Percent:  vat.Percent,
+			Base:     base,
+			Included: vat.Inclusive,
+		}
+		if vat.Inclusive {
+			charge.Amount = divRound(base*basisPoints, 10000+basisPoints)
+		} else {
+			charge.Amount = divRound(base*basisPoints, 10000)
+			price.Total.Amount += charge.Amount
+		}
+		price.Charges = append(price.Charges, charge)
+	}
+	return nil
+}
+
+func feeQuantity(fee types.Fee, stay types.BookParams) int {
+	persons := stay.NumPersons
+	if fee.AdultsOnly {
+		persons = stay.Adults()
+	}
+	nights := len(Nights(stay.FromDate.Time, stay.TillDate.Time))
+	switch fee.Basis {
+	case types.PerNight:
+		return nights
+	case types.PerPerson:
+		return persons
+	case types.PerPersonPerNight:
+		return persons * nights
+	default:
+		return 1
+	}
+}
+
+// divRound divides the non-negative a by b, rounding halves up.
+func divRound(a, b int64) int64 {
+	return (a + b/2) / b
+}
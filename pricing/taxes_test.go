@@ -0,0 +1,116 @@
+package pricing
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kmogilevskii/hotel-reservation/types"
+)
+
+func TestApplyTaxes(t *testing.T) {
+	var (
+		eur   = func(amount int64) types.Money { return types.Money{Amount: amount, Currency: "EUR"} }
+		rates = types.RoomRates{Currency: "EUR", Weekday: 10000, IncludedGuests: 3}
+		// 2 adults and a child for 3 weekday nights at 100.00
+		stay       = types.BookParams{FromDate: types.Date{Time: day(time.March, 4)}, TillDate: types.Date{Time: day(time.March, 7)}, NumPersons: 3, NumChildren: 1}
+		cityTax    = types.Fee{Name: "City tax", Amount: eur(250), Basis: types.PerPersonPerNight, AdultsOnly: true}
+		cleaning   = types.Fee{Name: "Cleaning", Amount: eur(3000), Basis: types.PerStay, Taxable: true}
+		towels     = types.Fee{Name: "Towels", Amount: eur(500), Basis: types.PerPerson}
+		breakfast  = types.Fee{Name: "Breakfast", Amount: eur(1500), Basis: types.PerPersonPerNight, Taxable: true}
+		parking    = types.Fee{Name: "Parking", Amount: eur(800), Basis: types.PerNight}
+		percentOff = &types.DiscountRule{Percent: 20}
+	)
+	tests := []struct {
+		name     string
+		rules    *types.TaxRules
+		discount *types.DiscountRule
+		charges  []types.PriceCharge
+		total    int64
+	}{
+		{
+			name:  "no taxes",
+			total: 30000,
+		},
+		{
+			// 300.00 + 2 adults x 3 nights x 2.50 + 30.00 + 10% of 330.00
+			name: "exclusive VAT",
+			rules: &types.TaxRules{
+				VAT:  &types.VAT{Percent: 10},
+				Fees: []types.Fee{cityTax, cleaning},
+			},
+			charges: []types.PriceCharge{
+				{Kind: types.ChargeFee, Name: "City tax", Basis: types.PerPersonPerNight, Quantity: 6, UnitAmount: 250, Amount: 1500},
+				{Kind: types.ChargeFee, Name: "Cleaning", Basis: types.PerStay, Quantity: 1, UnitAmount: 3000, Amount: 3000},
+				{Kind: types.ChargeVAT, Name: "VAT", Percent: 10, Base: 33000, Amount: 3300},
+			},
+			total: 37800,
+		},
+		{
+			// 7.7% VAT is included in 300.00 + 50.00 as 350.00 x 7.7 / 107.7
+			name: "inclusive VAT",
+			rules: &types.TaxRules{
+				VAT: &types.VAT{Percent: 7.7, Inclusive: true},
+				Fees: []types.Fee{
+					{Name: "Cleaning", Amount: eur(5000), Basis: types.PerStay, Taxable: true},
+					towels,
+				},
+			},
+			charges: []types.PriceCharge{
+				{Kind: types.ChargeFee, Name: "Cleaning", Basis: types.PerStay, Quantity: 1, UnitAmount: 5000, Amount: 5000},
+				{Kind: types.ChargeFee, Name: "Towels", Basis: types.PerPerson, Quantity: 3, UnitAmount: 500, Amount: 1500},
+				{Kind: types.ChargeVAT, Name: "VAT", Percent: 7.7, Base: 35000, Amount: 2502, Included: true},
+			},
+			total: 36500,
+		},
+		{
+			// 240.00 after 20% off + 3 guests x 3 nights x 15.00 + 10% of
+			// 375.00, parking isn't taxed
+			name:     "after a discount",
+			discount: percentOff,
+			rules: &types.TaxRules{
+				VAT:  &types.VAT{Percent: 10},
+				Fees: []types.Fee{breakfast, parking},
+			},
+			charges: []types.PriceCharge{
+				{Kind: types.ChargeFee, Name: "Breakfast", Basis: types.PerPersonPerNight, Quantity: 9, UnitAmount: 1500, Amount: 13500},
+				{Kind: types.ChargeFee, Name: "Parking", Basis: types.PerNight, Quantity: 3, UnitAmount: 800, Amount: 2400},
+				{Kind: types.ChargeVAT, Name: "VAT", Percent: 10, Base: 37500, Amount: 3750},
+			},
+			total: 43650,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			price, err := ComputeRates(rates, stay.FromDate.Time, stay.TillDate.Time, stay.NumPersons)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.discount != nil {
+				if _, err := ApplyDiscount(price, "SPRING", *tt.discount); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := ApplyTaxes(price, tt.rules, stay); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(price.Charges, tt.charges) {
+				t.Fatalf("expected charges %+v, got %+v", tt.charges, price.Charges)
+			}
+			if price.Total != eur(tt.total) {
+				t.Fatalf("expected a total of %d, got %+v", tt.total, price.Total)
+			}
+		})
+	}
+}
+
+func TestApplyTaxesCurrency(t *testing.T) {
+	price := &types.PriceBreakdown{Total: types.Money{Amount: 10000, Currency: "USD"}}
+	rules := &types.TaxRules{Fees: []types.Fee{
+		{Name: "City tax", Amount: types.Money{Amount: 200, Currency: "EUR"}, Basis: types.PerStay},
+	}}
+	stay := types.BookParams{FromDate: types.Date{Time: day(time.March, 4)}, TillDate: types.Date{Time: day(time.March, 5)}, NumPersons: 1}
+	if err := ApplyTaxes(price, rules, stay); err == nil {
+		t.Fatal("expected a fee in another currency to be rejected")
+	}
+}
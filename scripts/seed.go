@@ -43,7 +43,7 @@ func init() {
 	hotelStore = db.NewMongoHotelStore(client)
 	roomStore = db.NewMongoRoomStore(client, hotelStore)
 	userStore = db.NewMongoUserStore(client)
-	bookingStore = db.NewMongoBookingStore(client)
+	bookingStore = db.NewMongoBookingStore(client, roomStore, db.NewMongoRoomTypeStore(client, hotelStore), hotelStore)
 }
 
 func main() {
@@ -8,28 +8,216 @@ import (
 )
 
 type Booking struct {
-	ID         primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
-	RoomID     primitive.ObjectID `json:"roomID,omitempty" bson:"roomID,omitempty"`
+	ID     primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	RoomID primitive.ObjectID `json:"roomID,omitempty" bson:"roomID,omitempty"`
+	// RoomTypeID is set on bookings of a room type. Their RoomID stays empty
+	// until the front desk assigns a room.
+	RoomTypeID primitive.ObjectID `json:"roomTypeID,omitempty" bson:"roomTypeID,omitempty"`
 	UserID     primitive.ObjectID `json:"userID,omitempty" bson:"userID,omitempty"`
-	FromDate   time.Time          `json:"fromDate,omitempty" bson:"fromDate,omitempty"`
-	TillDate   time.Time          `json:"tillDate,omitempty" bson:"tillDate,omitempty"`
-	NumPersons int                `json:"numPersons,omitempty" bson:"numPersons,omitempty"`
-	Canceled   bool               `json:"canceled,omitempty" bson:"canceled,omitempty"`
+	// FromDate and TillDate are the hotel's check-in time on the arrival
+	// date and check-out time on the departure date.
+	FromDate   time.Time `json:"fromDate,omitempty" bson:"fromDate,omitempty"`
+	TillDate   time.Time `json:"tillDate,omitempty" bson:"tillDate,omitempty"`
+	NumPersons int       `json:"numPersons,omitempty" bson:"numPersons,omitempty"`
+	// NumChildren is how many of the NumPersons guests are children.
+	NumChildren int             `json:"numChildren,omitempty" bson:"numChildren,omitempty"`
+	Status      BookingStatus   `json:"status,omitempty" bson:"status,omitempty"`
+	Price       *PriceBreakdown `json:"price,omitempty" bson:"price,omitempty"`
+	// Currency is what the guest is charged in. ExchangeRate is the rate
+	// the price was converted at from the hotel's currency, nil when the
+	// guest pays in that currency.
+	Currency     string        `json:"currency,omitempty" bson:"currency,omitempty"`
+	ExchangeRate *ExchangeRate `json:"exchangeRate,omitempty" bson:"exchangeRate,omitempty"`
+	// Discount is what a promo code took off the price total.
+	Discount *Discount `json:"discount,omitempty" bson:"discount,omitempty"`
+	// Payment is what the guest was charged for the booking so far.
+	Payment *Payment `json:"payment,omitempty" bson:"payment,omitempty"`
+	// PaymentSchedule is the schedule in effect when the booking was made.
+	PaymentSchedule *PaymentSchedule `json:"paymentSchedule,omitempty" bson:"paymentSchedule,omitempty"`
+	// Balance is what is left to pay after the deposit, nil when the whole
+	// price was due at booking.
+	Balance *Balance `json:"balance,omitempty" bson:"balance,omitempty"`
+	// CancellationPolicy is the policy in effect when the booking was made.
+	CancellationPolicy *CancellationPolicy `json:"cancellationPolicy,omitempty" bson:"cancellationPolicy,omitempty"`
+	Cancellation       *Cancellation       `json:"cancellation,omitempty" bson:"cancellation,omitempty"`
+	StatusHistory      []StatusChange      `json:"statusHistory,omitempty" bson:"statusHistory,omitempty"`
+	// HoldExpiresAt is set on pending bookings that only hold the room while
+	// the guest checks out. The hold is released once it passes.
+	HoldExpiresAt *time.Time `json:"holdExpiresAt,omitempty" bson:"holdExpiresAt,omitempty"`
+	// ReservationID links the bookings of a group reservation together.
+	ReservationID primitive.ObjectID `json:"reservationID,omitempty" bson:"reservationID,omitempty"`
+	// Reason is why a maintenance block keeps the room off sale.
+	Reason string `json:"reason,omitempty" bson:"reason,omitempty"`
+}
+
+type BookingStatus string
+
+const (
+	StatusPending    BookingStatus = "pending"
+	StatusConfirmed  BookingStatus = "confirmed"
+	StatusCheckedIn  BookingStatus = "checked-in"
+	StatusCheckedOut BookingStatus = "checked-out"
+	StatusNoShow     BookingStatus = "no-show"
+	StatusCanceled   BookingStatus = "canceled"
+	StatusExpired    BookingStatus = "expired"
+	// StatusBlocked marks maintenance blocks. They take a room off sale like
+	// a booking does, but have no guest.
+	StatusBlocked BookingStatus = "blocked"
+)
+
+// ReleasedStatuses are the statuses of bookings that no longer occupy their
+// room.
+var ReleasedStatuses = []BookingStatus{StatusCanceled, StatusNoShow, StatusExpired}
+
+// ActiveStatuses are the statuses of bookings that occupy a room now or
+// will later on, so they can be moved to another room.
+var ActiveStatuses = []BookingStatus{StatusPending, StatusConfirmed, StatusCheckedIn}
+
+// OpenStatuses are the statuses of bookings whose stay hasn't begun yet, so
+// they can still be modified or canceled.
+var OpenStatuses = []BookingStatus{StatusPending, StatusConfirmed}
+
+var bookingTransitions = map[BookingStatus][]BookingStatus{
+	StatusPending:   {StatusConfirmed, StatusCanceled, StatusExpired},
+	StatusConfirmed: {StatusCheckedIn, StatusNoShow, StatusCanceled},
+	StatusCheckedIn: {StatusCheckedOut},
+	StatusBlocked:   {StatusCanceled},
+}
+
+// CanTransitionTo reports whether a booking in status s may move to next.
+func (s BookingStatus) CanTransitionTo(next BookingStatus) bool {
+	for _, to := range bookingTransitions[s] {
+		if to == next {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusChange records who moved a booking into a status and when. By is
+// empty for changes made by the system, such as expiring holds.
+type StatusChange struct {
+	Status BookingStatus      `json:"status" bson:"status"`
+	By     primitive.ObjectID `json:"by" bson:"by"`
+	At     time.Time          `json:"at" bson:"at"`
 }
 
 type BookParams struct {
-	FromDate   time.Time `json:"fromDate,omitempty" bson:"fromDate,omitempty" validate:"required"`
-	TillDate   time.Time `json:"tillDate,omitempty" bson:"tillDate,omitempty" validate:"required"`
-	NumPersons int       `json:"numPersons,omitempty" bson:"numPersons,omitempty" validate:"required,gt=0"`
+	FromDate   Date `json:"fromDate,omitempty" bson:"fromDate,omitempty" validate:"required"`
+	TillDate   Date `json:"tillDate,omitempty" bson:"tillDate,omitempty" validate:"required"`
+	NumPersons int  `json:"numPersons,omitempty" bson:"numPersons,omitempty" validate:"required,gt=0"`
+	// NumChildren is how many of the NumPersons guests are children. Every
+	// party needs at least one adult.
+	NumChildren int    `json:"numChildren,omitempty" bson:"numChildren,omitempty" validate:"min=0,ltfield=NumPersons"`
+	PromoCode   string `json:"promoCode,omitempty" bson:"promoCode,omitempty"`
+	// QuoteToken books the stay at the price it was quoted at.
+	QuoteToken string `json:"quoteToken,omitempty" bson:"quoteToken,omitempty"`
 }
 
+// Adults returns how many of the guests are adults.
+func (p BookParams) Adults() int {
+	return p.NumPersons - p.NumChildren
+}
+
+// Validate checks the stay as calendar dates: arrival can't be before today
+// where FromDate is and the stay has to last at least a night.
 func (p *BookParams) Validate() error {
-	now := time.Now()
-	if now.After(p.FromDate) || now.After(p.TillDate) {
+	validate := newValidator()
+	if err := validate.Struct(p); err != nil {
+		return err
+	}
+	from, till := civilDate(p.FromDate.Time), civilDate(p.TillDate.Time)
+	if from.Before(civilDate(time.Now().In(p.FromDate.Location()))) {
 		return fmt.Errorf("cannot book in the past")
 	}
-	if p.FromDate.After(p.TillDate) {
+	if !from.Before(till) {
 		return fmt.Errorf("from date should be before till date")
 	}
 	return nil
 }
+
+// LocalizeFor turns the stay's calendar dates into the hotel's check-in and
+// check-out times in its timezone.
+func (p BookParams) LocalizeFor(hotel *Hotel) BookParams {
+	p.FromDate = Date{Time: hotel.CheckInAt(p.FromDate.Time)}
+	p.TillDate = Date{Time: hotel.CheckOutAt(p.TillDate.Time)}
+	return p
+}
+
+// ValidateFor checks the stay against what the room can hold on top of
+// Validate.
+func (p *BookParams) ValidateFor(capacity Capacity) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	return capacity.CheckOccupancy(p.Adults(), p.NumChildren)
+}
+
+// Stay returns the booking's stay.
+func (b *Booking) Stay() BookParams {
+	return BookParams{
+		FromDate:    Date{Time: b.FromDate},
+		TillDate:    Date{Time: b.TillDate},
+		NumPersons:  b.NumPersons,
+		NumChildren: b.NumChildren,
+	}
+}
+
+// BlockRoomParams takes a room off sale for maintenance for the nights in
+// [FromDate, TillDate).
+type BlockRoomParams struct {
+	FromDate Date   `json:"fromDate"`
+	TillDate Date   `json:"tillDate"`
+	Reason   string `json:"reason" validate:"required,min=2,max=200"`
+}
+
+// Stay returns the nights the block covers as a stay.
+func (p BlockRoomParams) Stay() BookParams {
+	return BookParams{FromDate: p.FromDate, TillDate: p.TillDate, NumPersons: 1}
+}
+
+// Validate checks the reason. The nights are checked as a stay once they
+// are localized for the room's hotel, see BookParams.Validate.
+func (p *BlockRoomParams) Validate() error {
+	return newValidator().Struct(p)
+}
+
+// ModifyBookingParams changes the stay of a booking. Fields left out keep
+// their current value.
+type ModifyBookingParams struct {
+	FromDate    Date `json:"fromDate,omitempty"`
+	TillDate    Date `json:"tillDate,omitempty"`
+	NumPersons  int  `json:"numPersons,omitempty"`
+	NumChildren *int `json:"numChildren,omitempty"`
+}
+
+// Apply returns the stay resulting from applying the changes to the booking.
+func (p ModifyBookingParams) Apply(b *Booking) BookParams {
+	params := BookParams{
+		FromDate:    Date{Time: b.FromDate},
+		TillDate:    Date{Time: b.TillDate},
+		NumPersons:  b.NumPersons,
+		NumChildren: b.NumChildren,
+	}
+	if !p.FromDate.IsZero() {
+		params.FromDate = p.FromDate
+	}
+	if !p.TillDate.IsZero() {
+		params.TillDate = p.TillDate
+	}
+	if p.NumPersons != 0 {
+		params.NumPersons = p.NumPersons
+	}
+	if p.NumChildren != nil {
+		params.NumChildren = *p.NumChildren
+	}
+	return params
+}
+
+// Relocation is an arrival the hotel has no room for if every guest shows
+// up, because its rooms were overbooked. The guest has to be put up
+// elsewhere.
+type Relocation struct {
+	HotelID primitive.ObjectID `json:"hotelID"`
+	Booking *Booking           `json:"booking"`
+}
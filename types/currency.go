@@ -0,0 +1,30 @@
+package types
+
+import (
+	"context"
+	"time"
+)
+
+// ExchangeRates is a table of what one unit of Base buys in other
+// currencies. Admins upload a table per base currency, a new upload replaces
+// the previous one.
+type ExchangeRates struct {
+	Base      string             `bson:"_id" json:"base" validate:"currency"`
+	Rates     map[string]float64 `bson:"rates" json:"rates" validate:"required,dive,keys,currency,endkeys,gt=0"`
+	UpdatedAt time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
+
+func (r *ExchangeRates) Validate(ctx context.Context) error {
+	return newValidator().StructCtx(ctx, r)
+}
+
+// ExchangeRate is the rate a price was converted at from one currency to
+// another. Bookings keep it, so changes to the stay are charged at the rate
+// the guest booked at.
+type ExchangeRate struct {
+	From string  `bson:"from" json:"from"`
+	To   string  `bson:"to" json:"to"`
+	Rate float64 `bson:"rate" json:"rate"`
+	// AsOf is when the table the rate was taken from was uploaded.
+	AsOf time.Time `bson:"asOf" json:"asOf"`
+}
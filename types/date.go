@@ -0,0 +1,50 @@
+package types
+
+import (
+	"encoding/json"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// Date is a date in a request, such as the arrival date of a stay. It
+// decodes plain calendar dates like "2030-03-10" as well as RFC 3339
+// timestamps, and is stored and encoded like the time it holds.
+type Date struct {
+	time.Time
+}
+
+func (d *Date) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	if s == "" {
+		*d = Date{}
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		if t, err = time.Parse(time.DateOnly, s); err != nil {
+			return err
+		}
+	}
+	*d = Date{Time: t}
+	return nil
+}
+
+func (d Date) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	return bson.MarshalValue(d.Time)
+}
+
+func (d *Date) UnmarshalBSONValue(t bsontype.Type, b []byte) error {
+	return bson.RawValue{Type: t, Value: b}.Unmarshal(&d.Time)
+}
+
+// civilDate returns midnight UTC of the calendar date t has in its own
+// location, so dates from different locations compare by what they say.
+func civilDate(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
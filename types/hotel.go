@@ -1,6 +1,10 @@
 package types
 
 import (
+	"context"
+	"fmt"
+	"time"
+
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -10,25 +14,303 @@ type Hotel struct {
 	Name     string               `bson:"name" json:"name"`
 	Location string               `bson:"location" json:"location"`
 	Rooms    []primitive.ObjectID `bson:"rooms" json:"rooms"`
-	Rating   int                  `bson:"rating" json:"rating"`
+	// RoomTypes are the categories the hotel sells its rooms as.
+	RoomTypes []primitive.ObjectID `bson:"roomTypes,omitempty" json:"roomTypes,omitempty"`
+	Rating    int                  `bson:"rating" json:"rating"`
+	// Currency is the base currency the hotel's rooms are priced in, unless
+	// their rates say otherwise.
+	Currency string `bson:"currency,omitempty" json:"currency,omitempty"`
+	// CancellationPolicy applies to all rooms without a policy of their own.
+	// Without any policy bookings can be canceled for free until check-in.
+	CancellationPolicy *CancellationPolicy `bson:"cancellationPolicy,omitempty" json:"cancellationPolicy,omitempty"`
+	// Timezone is the IANA name of the hotel's timezone, stays are counted
+	// in local nights. Hotels without one are on UTC.
+	Timezone string `bson:"timezone,omitempty" json:"timezone,omitempty"`
+	// CheckInTime and CheckOutTime are local times of day like "15:00".
+	CheckInTime  string `bson:"checkInTime,omitempty" json:"checkInTime,omitempty"`
+	CheckOutTime string `bson:"checkOutTime,omitempty" json:"checkOutTime,omitempty"`
+	// Restrictions apply to every room of the hotel.
+	Restrictions []StayRestriction `bson:"restrictions,omitempty" json:"restrictions,omitempty"`
+	// Taxes are the fees and taxes charged on every stay, in the hotel's
+	// currency.
+	Taxes *TaxRules `bson:"taxes,omitempty" json:"taxes,omitempty"`
+	// Overbooking is the percentage of its rooms the hotel sells on top of
+	// them each night to make up for no-shows. Room types may override it.
+	Overbooking int `bson:"overbooking,omitempty" json:"overbooking,omitempty"`
+}
+
+const (
+	DefaultCheckInTime  = "15:00"
+	DefaultCheckOutTime = "11:00"
+	timeOfDayLayout     = "15:04"
+)
+
+// Zone returns the hotel's timezone, UTC when it has none.
+func (h *Hotel) Zone() *time.Location {
+	loc, err := time.LoadLocation(h.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// CheckInAt returns when guests arriving on the date can check in. Only the
+// calendar date of date matters, as written in its own location.
+func (h *Hotel) CheckInAt(date time.Time) time.Time {
+	return h.at(date, h.CheckInTime, DefaultCheckInTime)
+}
+
+// CheckOutAt returns when guests leaving on the date have to check out.
+func (h *Hotel) CheckOutAt(date time.Time) time.Time {
+	return h.at(date, h.CheckOutTime, DefaultCheckOutTime)
+}
+
+func (h *Hotel) at(date time.Time, timeOfDay, fallback string) time.Time {
+	t, err := time.Parse(timeOfDayLayout, timeOfDay)
+	if err != nil {
+		t, _ = time.Parse(timeOfDayLayout, fallback)
+	}
+	y, m, d := date.Date()
+	return time.Date(y, m, d, t.Hour(), t.Minute(), 0, 0, h.Zone())
+}
+
+// validateLocalTimes checks the timezone and times of day hotel params come
+// with, if any.
+func validateLocalTimes(timezone, checkIn, checkOut string) error {
+	if _, err := time.LoadLocation(timezone); err != nil {
+		return fmt.Errorf("unknown timezone %q", timezone)
+	}
+	for _, t := range []string{checkIn, checkOut} {
+		if _, err := time.Parse(timeOfDayLayout, t); t != "" && err != nil {
+			return fmt.Errorf("invalid time of day %q, expected HH:MM", t)
+		}
+	}
+	return nil
 }
 
 type Room struct {
 	ID      primitive.ObjectID `bson:"_id" json:"id,omitempty"`
 	Size    string             `bson:"size" json:"size"`
 	Seaside bool               `bson:"seaside" json:"seaside"`
+	// Price is the nightly list price. It is only used for pricing stays
+	// when the room has no Rates configured.
 	Price   float64            `bson:"price" json:"price"`
+	Rates   *RoomRates         `bson:"rates,omitempty" json:"rates,omitempty"`
 	HotelID primitive.ObjectID `bson:"hotelID" json:"hotelID"`
+	// RoomTypeID is the type the room is sold as, if any. Stays in rooms of
+	// a type are priced and sized by the type.
+	RoomTypeID   primitive.ObjectID `bson:"roomTypeID,omitempty" json:"roomTypeID,omitempty"`
+	Restrictions []StayRestriction  `bson:"restrictions,omitempty" json:"restrictions,omitempty"`
+	Capacity     `bson:",inline"`
+}
+
+// Capacity is how many guests a room sleeps. MaxAdults and MaxChildren fit
+// in the regular beds, ExtraBeds more on extra beds. Without MaxAdults no
+// capacity is configured and any party fits.
+type Capacity struct {
+	MaxAdults   int `bson:"maxAdults,omitempty" json:"maxAdults,omitempty"`
+	MaxChildren int `bson:"maxChildren,omitempty" json:"maxChildren,omitempty"`
+	ExtraBeds   int `bson:"extraBeds,omitempty" json:"extraBeds,omitempty"`
+}
+
+// CheckOccupancy reports why the given party can't stay in the room, if it
+// can't. Guests that don't fit the regular beds need an extra bed each.
+func (c Capacity) CheckOccupancy(adults, children int) error {
+	if c.MaxAdults == 0 {
+		return nil
+	}
+	extra := max(adults-c.MaxAdults, 0) + max(children-c.MaxChildren, 0)
+	if extra > c.ExtraBeds {
+		return fmt.Errorf("room sleeps at most %d adults and %d children with %d extra beds", c.MaxAdults, c.MaxChildren, c.ExtraBeds)
+	}
+	return nil
+}
+
+type CreateHotelParams struct {
+	Name               string              `json:"name" validate:"required,min=2,max=100"`
+	Location           string              `json:"location" validate:"required,min=2,max=100"`
+	Rating             int                 `json:"rating" validate:"min=0,max=5"`
+	Currency           string              `json:"currency" validate:"omitempty,currency"`
+	CancellationPolicy *CancellationPolicy `json:"cancellationPolicy"`
+	Timezone           string              `json:"timezone"`
+	CheckInTime        string              `json:"checkInTime"`
+	CheckOutTime       string              `json:"checkOutTime"`
+	Restrictions       []StayRestriction   `json:"restrictions" validate:"dive"`
+	Taxes              *TaxRules           `json:"taxes"`
+	Overbooking        int                 `json:"overbooking" validate:"min=0,max=100"`
+}
+
+func (p *CreateHotelParams) Validate(ctx context.Context) error {
+	validate := newValidator()
+	if err := validate.StructCtx(ctx, p); err != nil {
+		return err
+	}
+	return validateLocalTimes(p.Timezone, p.CheckInTime, p.CheckOutTime)
+}
+
+func NewHotelFromParams(params CreateHotelParams) *Hotel {
+	return &Hotel{
+		ID:                 primitive.NewObjectID(),
+		Name:               params.Name,
+		Location:           params.Location,
+		Rooms:              []primitive.ObjectID{},
+		Rating:             params.Rating,
+		Currency:           params.Currency,
+		CancellationPolicy: params.CancellationPolicy,
+		Timezone:           params.Timezone,
+		CheckInTime:        params.CheckInTime,
+		CheckOutTime:       params.CheckOutTime,
+		Restrictions:       params.Restrictions,
+		Taxes:              params.Taxes,
+		Overbooking:        params.Overbooking,
+	}
 }
 
 type UpdateHotelParams struct {
-	Rooms []primitive.ObjectID `bson:"rooms" json:"rooms"`
+	Name               string              `json:"name" validate:"omitempty,min=2,max=100"`
+	Location           string              `json:"location" validate:"omitempty,min=2,max=100"`
+	Rating             *int                `json:"rating" validate:"omitempty,min=0,max=5"`
+	Currency           string              `json:"currency" validate:"omitempty,currency"`
+	CancellationPolicy *CancellationPolicy `json:"cancellationPolicy"`
+	Timezone           string              `json:"timezone"`
+	CheckInTime        string              `json:"checkInTime"`
+	CheckOutTime       string              `json:"checkOutTime"`
+	// Restrictions replace the hotel's restrictions, an empty list lifts
+	// them all.
+	Restrictions []StayRestriction `json:"restrictions" validate:"dive"`
+	// Taxes replace the hotel's tax rules.
+	Taxes       *TaxRules `json:"taxes"`
+	Overbooking *int      `json:"overbooking" validate:"omitempty,min=0,max=100"`
+}
+
+func (p *UpdateHotelParams) Validate(ctx context.Context) error {
+	validate := newValidator()
+	if err := validate.StructCtx(ctx, p); err != nil {
+		return err
+	}
+	return validateLocalTimes(p.Timezone, p.CheckInTime, p.CheckOutTime)
 }
 
 func (p UpdateHotelParams) ToBSON() bson.M {
 	m := bson.M{}
-	if len(p.Rooms) != 0 {
-		m["rooms"] = p.Rooms
+	if len(p.Name) != 0 {
+		m["name"] = p.Name
+	}
+	if len(p.Location) != 0 {
+		m["location"] = p.Location
+	}
+	if p.Rating != nil {
+		m["rating"] = *p.Rating
+	}
+	if len(p.Currency) != 0 {
+		m["currency"] = p.Currency
+	}
+	if p.CancellationPolicy != nil {
+		m["cancellationPolicy"] = p.CancellationPolicy
+	}
+	if len(p.Timezone) != 0 {
+		m["timezone"] = p.Timezone
+	}
+	if len(p.CheckInTime) != 0 {
+		m["checkInTime"] = p.CheckInTime
+	}
+	if len(p.CheckOutTime) != 0 {
+		m["checkOutTime"] = p.CheckOutTime
+	}
+	if p.Taxes != nil {
+		m["taxes"] = p.Taxes
+	}
+	if p.Restrictions != nil {
+		m["restrictions"] = p.Restrictions
+	}
+	if p.Overbooking != nil {
+		m["overbooking"] = *p.Overbooking
+	}
+	return m
+}
+
+// Overbook returns how many bookings rooms sold at the overbooking
+// percentage may take on top of them each night, rounded down.
+func Overbook(rooms, percent int) int {
+	return rooms * percent / 100
+}
+
+type CreateRoomParams struct {
+	HotelID      string            `json:"hotelID" validate:"required,len=24,hexadecimal"`
+	Size         string            `json:"size" validate:"required,min=2,max=50"`
+	Seaside      bool              `json:"seaside"`
+	Price        float64           `json:"price" validate:"required,gt=0"`
+	Rates        *RoomRates        `json:"rates"`
+	RoomTypeID   string            `json:"roomTypeID" validate:"omitempty,len=24,hexadecimal"`
+	MaxAdults    int               `json:"maxAdults" validate:"min=0"`
+	MaxChildren  int               `json:"maxChildren" validate:"min=0"`
+	ExtraBeds    int               `json:"extraBeds" validate:"min=0"`
+	Restrictions []StayRestriction `json:"restrictions" validate:"dive"`
+}
+
+func (p *CreateRoomParams) Validate(ctx context.Context) error {
+	validate := newValidator()
+	return validate.StructCtx(ctx, p)
+}
+
+func NewRoomFromParams(hotelID primitive.ObjectID, params CreateRoomParams) *Room {
+	return &Room{
+		ID:           primitive.NewObjectID(),
+		Size:         params.Size,
+		Seaside:      params.Seaside,
+		Price:        params.Price,
+		Rates:        params.Rates,
+		HotelID:      hotelID,
+		Restrictions: params.Restrictions,
+		Capacity: Capacity{
+			MaxAdults:   params.MaxAdults,
+			MaxChildren: params.MaxChildren,
+			ExtraBeds:   params.ExtraBeds,
+		},
+	}
+}
+
+type UpdateRoomParams struct {
+	Size         string            `json:"size" validate:"omitempty,min=2,max=50"`
+	Seaside      *bool             `json:"seaside"`
+	Price        float64           `json:"price" validate:"omitempty,gt=0"`
+	Rates        *RoomRates        `json:"rates"`
+	MaxAdults    *int              `json:"maxAdults" validate:"omitempty,min=0"`
+	MaxChildren  *int              `json:"maxChildren" validate:"omitempty,min=0"`
+	ExtraBeds    *int              `json:"extraBeds" validate:"omitempty,min=0"`
+	Restrictions []StayRestriction `json:"restrictions" validate:"dive"`
+}
+
+func (p *UpdateRoomParams) Validate(ctx context.Context) error {
+	validate := newValidator()
+	return validate.StructCtx(ctx, p)
+}
+
+func (p UpdateRoomParams) ToBSON() bson.M {
+	m := bson.M{}
+	if len(p.Size) != 0 {
+		m["size"] = p.Size
+	}
+	if p.Seaside != nil {
+		m["seaside"] = *p.Seaside
+	}
+	if p.Price != 0 {
+		m["price"] = p.Price
+	}
+	if p.Rates != nil {
+		m["rates"] = p.Rates
+	}
+	if p.MaxAdults != nil {
+		m["maxAdults"] = *p.MaxAdults
+	}
+	if p.MaxChildren != nil {
+		m["maxChildren"] = *p.MaxChildren
+	}
+	if p.ExtraBeds != nil {
+		m["extraBeds"] = *p.ExtraBeds
+	}
+	if p.Restrictions != nil {
+		m["restrictions"] = p.Restrictions
 	}
 	return m
 }
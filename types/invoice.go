@@ -0,0 +1,67 @@
+package types
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// IssuedInvoice records the number a booking was invoiced under. Numbers
+// run in sequence per hotel.
+type IssuedInvoice struct {
+	BookingID primitive.ObjectID `bson:"_id" json:"bookingID"`
+	HotelID   primitive.ObjectID `bson:"hotelID" json:"hotelID"`
+	Number    int64              `bson:"number" json:"number"`
+	IssuedAt  time.Time          `bson:"issuedAt" json:"issuedAt"`
+}
+
+type LineKind string
+
+const (
+	LineNight        LineKind = "night"
+	LineExtraGuests  LineKind = "extra-guests"
+	LineDiscount     LineKind = "discount"
+	LineFee          LineKind = "fee"
+	LineTax          LineKind = "tax"
+	LineCancellation LineKind = "cancellation"
+	LinePayment      LineKind = "payment"
+	LineRefund       LineKind = "refund"
+)
+
+// InvoiceLine is an entry of a booking's folio. Charges are positive,
+// discounts and credits negative. Payments and refunds are listed with what
+// the guest paid or got back.
+type InvoiceLine struct {
+	Kind        LineKind   `json:"kind"`
+	Description string     `json:"description"`
+	Date        *time.Time `json:"date,omitempty"`
+	Amount      Money      `json:"amount"`
+	// Included lines are already part of other charges, such as inclusive
+	// VAT, and don't add to the total.
+	Included bool `json:"included,omitempty"`
+}
+
+type InvoiceParty struct {
+	Name    string `json:"name"`
+	Address string `json:"address,omitempty"`
+	Email   string `json:"email,omitempty"`
+}
+
+// Invoice is the folio of a booking under the number it was issued with.
+// Its lines always show the booking as it is now.
+type Invoice struct {
+	Number    string             `json:"number"`
+	IssuedAt  time.Time          `json:"issuedAt"`
+	BookingID primitive.ObjectID `json:"bookingID"`
+	Hotel     InvoiceParty       `json:"hotel"`
+	Guest     InvoiceParty       `json:"guest"`
+	FromDate  time.Time          `json:"fromDate"`
+	TillDate  time.Time          `json:"tillDate"`
+	Charges   []InvoiceLine      `json:"charges"`
+	Payments  []InvoiceLine      `json:"payments"`
+	// Total is what the stay costs after discounts and credits, Paid what
+	// the guest paid net of refunds and Due the difference.
+	Total Money `json:"total"`
+	Paid  Money `json:"paid"`
+	Due   Money `json:"due"`
+}
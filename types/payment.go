@@ -0,0 +1,102 @@
+package types
+
+import "time"
+
+type PaymentStatus string
+
+const (
+	PaymentAuthorized        PaymentStatus = "authorized"
+	PaymentCaptured          PaymentStatus = "captured"
+	PaymentVoided            PaymentStatus = "voided"
+	PaymentPartiallyRefunded PaymentStatus = "partially-refunded"
+	PaymentRefunded          PaymentStatus = "refunded"
+)
+
+type TransactionKind string
+
+const (
+	TransactionAuthorize TransactionKind = "authorize"
+	TransactionCapture   TransactionKind = "capture"
+	TransactionRefund    TransactionKind = "refund"
+	TransactionVoid      TransactionKind = "void"
+)
+
+// Transaction is an operation the payment provider carried out. ParentID is
+// the transaction a capture, refund or void applies to.
+type Transaction struct {
+	ID       string          `bson:"id" json:"id"`
+	Kind     TransactionKind `bson:"kind" json:"kind"`
+	Amount   Money           `bson:"amount" json:"amount"`
+	ParentID string          `bson:"parentID,omitempty" json:"parentID,omitempty"`
+	At       time.Time       `bson:"at" json:"at"`
+}
+
+// Payment tracks what was charged for a booking. Amounts are totals over all
+// of its transactions.
+type Payment struct {
+	Status       PaymentStatus `bson:"status" json:"status"`
+	Authorized   Money         `bson:"authorized" json:"authorized"`
+	Captured     Money         `bson:"captured" json:"captured"`
+	Refunded     Money         `bson:"refunded" json:"refunded"`
+	Transactions []Transaction `bson:"transactions" json:"transactions"`
+	// PendingRefund is owed back to the guest but wasn't refunded yet. It is
+	// stored along with the change that made it owed, so a refund that fails
+	// is retried later.
+	PendingRefund *PendingRefund `bson:"pendingRefund,omitempty" json:"pendingRefund,omitempty"`
+}
+
+// PendingRefund is an amount owed back to the guest since the booking
+// changed at Since.
+type PendingRefund struct {
+	Amount Money     `bson:"amount" json:"amount"`
+	Since  time.Time `bson:"since" json:"since"`
+}
+
+// Last returns the most recent transaction of the kind, if any.
+func (p *Payment) Last(kind TransactionKind) (Transaction, bool) {
+	for i := len(p.Transactions) - 1; i >= 0; i-- {
+		if p.Transactions[i].Kind == kind {
+			return p.Transactions[i], true
+		}
+	}
+	return Transaction{}, false
+}
+
+// PaymentSchedule splits what a stay costs into a deposit charged when it is
+// booked and a balance due later. A 20% deposit with the rest at check-in is
+// DepositPercent 20, the full amount a week before arrival is BalanceDueDays
+// 7 without a deposit.
+type PaymentSchedule struct {
+	DepositPercent int `bson:"depositPercent" json:"depositPercent" validate:"min=0,max=100"`
+	// BalanceDueDays is how many days before check-in the balance is due,
+	// 0 meaning at check-in.
+	BalanceDueDays int `bson:"balanceDueDays" json:"balanceDueDays" validate:"min=0"`
+	// AutoCancel cancels bookings whose balance is still unpaid GraceHours
+	// after it was due, which has to be before check-in. Without it they are
+	// only marked overdue.
+	AutoCancel bool `bson:"autoCancel,omitempty" json:"autoCancel,omitempty"`
+	GraceHours int  `bson:"graceHours,omitempty" json:"graceHours,omitempty" validate:"min=0"`
+}
+
+type BalanceStatus string
+
+const (
+	BalanceDue     BalanceStatus = "due"
+	BalanceOverdue BalanceStatus = "overdue"
+	BalancePaid    BalanceStatus = "paid"
+)
+
+// UnpaidBalanceStatuses are the statuses of balances still to be paid.
+var UnpaidBalanceStatuses = []BalanceStatus{BalanceDue, BalanceOverdue}
+
+// Balance is what is left to pay on a booking after its deposit.
+type Balance struct {
+	Deposit Money         `bson:"deposit" json:"deposit"`
+	Amount  Money         `bson:"amount" json:"amount"`
+	DueAt   time.Time     `bson:"dueAt" json:"dueAt"`
+	Status  BalanceStatus `bson:"status" json:"status"`
+	// CancelAt is when the booking gets canceled if the balance is still
+	// unpaid. It is only set when the schedule cancels automatically.
+	CancelAt *time.Time `bson:"cancelAt,omitempty" json:"cancelAt,omitempty"`
+	PaidAt   *time.Time `bson:"paidAt,omitempty" json:"paidAt,omitempty"`
+}
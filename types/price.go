@@ -0,0 +1,74 @@
+package types
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Money is an amount in minor units (cents) of an ISO 4217 currency.
+type Money struct {
+	Amount   int64  `bson:"amount" json:"amount"`
+	Currency string `bson:"currency" json:"currency"`
+}
+
+// RoomRates configures how a room is priced per night. All amounts are in
+// minor units of Currency.
+type RoomRates struct {
+	Currency string `bson:"currency" json:"currency" validate:"omitempty,currency"`
+	Weekday  int64  `bson:"weekday" json:"weekday" validate:"min=0"`
+	// Weekend applies to Friday and Saturday nights, 0 means the weekday rate.
+	Weekend int64 `bson:"weekend,omitempty" json:"weekend,omitempty" validate:"min=0"`
+	// ExtraGuest is charged per night for every guest above IncludedGuests,
+	// which defaults to 1.
+	ExtraGuest     int64          `bson:"extraGuest,omitempty" json:"extraGuest,omitempty" validate:"min=0"`
+	IncludedGuests int            `bson:"includedGuests,omitempty" json:"includedGuests,omitempty" validate:"min=0"`
+	Seasons        []SeasonalRate `bson:"seasons,omitempty" json:"seasons,omitempty" validate:"dive"`
+	// CancellationPolicy overrides the policy of the hotel for this rate.
+	CancellationPolicy *CancellationPolicy `bson:"cancellationPolicy,omitempty" json:"cancellationPolicy,omitempty"`
+	// PaymentSchedule lets guests pay a deposit when booking and the rest
+	// later. Without one the whole price is charged at booking.
+	PaymentSchedule *PaymentSchedule `bson:"paymentSchedule,omitempty" json:"paymentSchedule,omitempty"`
+}
+
+// SeasonalRate overrides the room rates for the nights in [From, Till).
+type SeasonalRate struct {
+	Name    string    `bson:"name" json:"name"`
+	From    time.Time `bson:"from" json:"from" validate:"required"`
+	Till    time.Time `bson:"till" json:"till" validate:"required"`
+	Weekday int64     `bson:"weekday" json:"weekday" validate:"min=0"`
+	Weekend int64     `bson:"weekend,omitempty" json:"weekend,omitempty" validate:"min=0"`
+}
+
+type NightPrice struct {
+	Date        time.Time `bson:"date" json:"date"`
+	Weekend     bool      `bson:"weekend,omitempty" json:"weekend,omitempty"`
+	Season      string    `bson:"season,omitempty" json:"season,omitempty"`
+	Rate        int64     `bson:"rate" json:"rate"`
+	ExtraGuests int64     `bson:"extraGuests,omitempty" json:"extraGuests,omitempty"`
+	Amount      int64     `bson:"amount" json:"amount"`
+}
+
+type PriceBreakdown struct {
+	Nights []NightPrice `bson:"nights" json:"nights"`
+	// Charges are the fees and taxes of the hotel on top of the nights.
+	Charges []PriceCharge `bson:"charges,omitempty" json:"charges,omitempty"`
+	Total   Money         `bson:"total" json:"total"`
+}
+
+// CancellationPolicy describes what a guest gets back when cancelling. A
+// booking can be canceled for free until FreeUntilDays days before check-in,
+// after that PenaltyPercent of the total is kept. NonRefundable rates keep
+// everything.
+type CancellationPolicy struct {
+	FreeUntilDays  int  `bson:"freeUntilDays" json:"freeUntilDays" validate:"min=0"`
+	PenaltyPercent int  `bson:"penaltyPercent" json:"penaltyPercent" validate:"min=0,max=100"`
+	NonRefundable  bool `bson:"nonRefundable,omitempty" json:"nonRefundable,omitempty"`
+}
+
+type Cancellation struct {
+	CanceledBy primitive.ObjectID `bson:"canceledBy" json:"canceledBy"`
+	CanceledAt time.Time          `bson:"canceledAt" json:"canceledAt"`
+	Penalty    Money              `bson:"penalty" json:"penalty"`
+	Refund     Money              `bson:"refund" json:"refund"`
+}
@@ -0,0 +1,133 @@
+package types
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DiscountRule takes either Percent percent or a Fixed amount off the total
+// of a stay.
+type DiscountRule struct {
+	Percent int    `bson:"percent,omitempty" json:"percent,omitempty" validate:"min=0,max=100"`
+	Fixed   *Money `bson:"fixed,omitempty" json:"fixed,omitempty"`
+}
+
+// PromoCode is a code guests enter when booking to get a discount. The code
+// itself is the key, so codes are unique.
+type PromoCode struct {
+	Code         string `bson:"_id" json:"code"`
+	DiscountRule `bson:",inline"`
+	// FirstBookingOnly limits the code to guests who never booked before.
+	FirstBookingOnly bool `bson:"firstBookingOnly,omitempty" json:"firstBookingOnly,omitempty"`
+	// HotelIDs limits the code to these hotels, any hotel if empty.
+	HotelIDs []primitive.ObjectID `bson:"hotelIDs,omitempty" json:"hotelIDs,omitempty"`
+	// ValidFrom and ValidTill bound when bookings can use the code, zero
+	// values leave the window open.
+	ValidFrom time.Time `bson:"validFrom,omitempty" json:"validFrom,omitempty"`
+	ValidTill time.Time `bson:"validTill,omitempty" json:"validTill,omitempty"`
+	// MaxUses caps how many bookings can use the code, 0 means no cap.
+	MaxUses int `bson:"maxUses,omitempty" json:"maxUses,omitempty"`
+	Uses    int `bson:"uses" json:"uses"`
+	// UsesLeft is only set on capped codes. Redeeming a code decrements it
+	// in the same update that checks it, so concurrent bookings can't go
+	// over the cap.
+	UsesLeft  *int      `bson:"usesLeft,omitempty" json:"usesLeft,omitempty"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// Check returns why the code can't be used at the time for a booking in the
+// hotel, if it can't.
+func (p *PromoCode) Check(hotelID primitive.ObjectID, at time.Time) error {
+	if !p.ValidFrom.IsZero() && at.Before(p.ValidFrom) {
+		return fmt.Errorf("promo code %s is not valid yet", p.Code)
+	}
+	if !p.ValidTill.IsZero() && !at.Before(p.ValidTill) {
+		return fmt.Errorf("promo code %s has expired", p.Code)
+	}
+	if len(p.HotelIDs) == 0 {
+		return nil
+	}
+	for _, id := range p.HotelIDs {
+		if id == hotelID {
+			return nil
+		}
+	}
+	return fmt.Errorf("promo code %s is not valid for this hotel", p.Code)
+}
+
+// Discount is what a promo code took off a booking's total.
+type Discount struct {
+	Code   string       `bson:"code" json:"code"`
+	Rule   DiscountRule `bson:"rule" json:"rule"`
+	Amount Money        `bson:"amount" json:"amount"`
+	// FirstBookingOnly is set when the code was only valid on the guest's
+	// first booking, which the booking then claimed.
+	FirstBookingOnly bool `bson:"firstBookingOnly,omitempty" json:"firstBookingOnly,omitempty"`
+}
+
+// FirstBooking records which booking of a guest got a first booking promo
+// code. The user is the key, so a guest can only claim one.
+type FirstBooking struct {
+	UserID    primitive.ObjectID `bson:"_id" json:"userID"`
+	Code      string             `bson:"code" json:"code"`
+	ClaimedAt time.Time          `bson:"claimedAt" json:"claimedAt"`
+}
+
+type CreatePromoCodeParams struct {
+	Code             string    `json:"code" validate:"required,min=3,max=32,alphanum"`
+	Percent          int       `json:"percent" validate:"min=0,max=100"`
+	Fixed            *Money    `json:"fixed"`
+	FirstBookingOnly bool      `json:"firstBookingOnly"`
+	HotelIDs         []string  `json:"hotelIDs" validate:"dive,len=24,hexadecimal"`
+	ValidFrom        time.Time `json:"validFrom"`
+	ValidTill        time.Time `json:"validTill"`
+	MaxUses          int       `json:"maxUses" validate:"min=0"`
+}
+
+func (p *CreatePromoCodeParams) Validate(ctx context.Context) error {
+	validate := newValidator()
+	if err := validate.StructCtx(ctx, p); err != nil {
+		return err
+	}
+	if (p.Percent > 0) == (p.Fixed != nil) {
+		return fmt.Errorf("a promo code takes off either a percentage or a fixed amount")
+	}
+	if p.Fixed != nil && (p.Fixed.Amount <= 0 || len(p.Fixed.Currency) != 3) {
+		return fmt.Errorf("a fixed discount needs a positive amount and a currency")
+	}
+	if !p.ValidFrom.IsZero() && !p.ValidTill.IsZero() && !p.ValidFrom.Before(p.ValidTill) {
+		return fmt.Errorf("validFrom should be before validTill")
+	}
+	return nil
+}
+
+// NewPromoCodeFromParams expects validated params. Codes are stored upper
+// case and matched case-insensitively.
+func NewPromoCodeFromParams(params CreatePromoCodeParams) *PromoCode {
+	promo := &PromoCode{
+		Code:             NormalizePromoCode(params.Code),
+		DiscountRule:     DiscountRule{Percent: params.Percent, Fixed: params.Fixed},
+		FirstBookingOnly: params.FirstBookingOnly,
+		ValidFrom:        params.ValidFrom,
+		ValidTill:        params.ValidTill,
+		MaxUses:          params.MaxUses,
+		CreatedAt:        time.Now(),
+	}
+	for _, id := range params.HotelIDs {
+		oid, _ := primitive.ObjectIDFromHex(id)
+		promo.HotelIDs = append(promo.HotelIDs, oid)
+	}
+	if params.MaxUses > 0 {
+		left := params.MaxUses
+		promo.UsesLeft = &left
+	}
+	return promo
+}
+
+func NormalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
@@ -0,0 +1,66 @@
+package types
+
+import (
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Reservation groups the bookings of several rooms made at once, e.g. for a
+// conference or a wedding. Each room keeps its own booking that links back
+// to the reservation.
+type Reservation struct {
+	ID        primitive.ObjectID   `json:"id,omitempty" bson:"_id,omitempty"`
+	UserID    primitive.ObjectID   `json:"userID,omitempty" bson:"userID,omitempty"`
+	FromDate  time.Time            `json:"fromDate,omitempty" bson:"fromDate,omitempty"`
+	TillDate  time.Time            `json:"tillDate,omitempty" bson:"tillDate,omitempty"`
+	Bookings  []primitive.ObjectID `json:"bookings" bson:"bookings"`
+	CreatedAt time.Time            `json:"createdAt,omitempty" bson:"createdAt,omitempty"`
+}
+
+// GroupBookParams books either the listed rooms or Count rooms of the given
+// room type, all for the same stay.
+type GroupBookParams struct {
+	FromDate Date `json:"fromDate"`
+	TillDate Date `json:"tillDate"`
+	// NumPersons is the number of guests staying in each room.
+	NumPersons  int      `json:"numPersons"`
+	NumChildren int      `json:"numChildren"`
+	RoomIDs     []string `json:"roomIDs"`
+	RoomTypeID  string   `json:"roomTypeID"`
+	Count       int      `json:"count"`
+}
+
+// Stay returns the stay every room of the group is booked for.
+func (p GroupBookParams) Stay() BookParams {
+	return BookParams{
+		FromDate:    p.FromDate,
+		TillDate:    p.TillDate,
+		NumPersons:  p.NumPersons,
+		NumChildren: p.NumChildren,
+	}
+}
+
+func (p GroupBookParams) Validate() error {
+	stay := p.Stay()
+	if err := stay.Validate(); err != nil {
+		return err
+	}
+	byType := p.RoomTypeID != "" || p.Count != 0
+	switch {
+	case len(p.RoomIDs) > 0 && byType:
+		return fmt.Errorf("either list the rooms or ask for a number of rooms of a type, not both")
+	case len(p.RoomIDs) > 0:
+		seen := make(map[string]bool, len(p.RoomIDs))
+		for _, id := range p.RoomIDs {
+			if seen[id] {
+				return fmt.Errorf("room %s is listed more than once", id)
+			}
+			seen[id] = true
+		}
+	case p.RoomTypeID == "" || p.Count < 1:
+		return fmt.Errorf("a room type and a count are required when no rooms are listed")
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// StayRestriction limits the stays that can be sold for the dates in
+// [From, Till). MinNights, MaxNights and ClosedToArrival apply to stays
+// arriving on those dates, Blackout closes every night in between.
+type StayRestriction struct {
+	Name string `bson:"name,omitempty" json:"name,omitempty"`
+	From Date   `bson:"from" json:"from" validate:"required"`
+	Till Date   `bson:"till" json:"till" validate:"required,gtfield=From"`
+	// Weekdays narrows the restriction down to these days of the week,
+	// 0 being Sunday. Without any it applies to every day.
+	Weekdays        []time.Weekday `bson:"weekdays,omitempty" json:"weekdays,omitempty" validate:"dive,min=0,max=6"`
+	MinNights       int            `bson:"minNights,omitempty" json:"minNights,omitempty" validate:"min=0"`
+	MaxNights       int            `bson:"maxNights,omitempty" json:"maxNights,omitempty" validate:"min=0"`
+	ClosedToArrival bool           `bson:"closedToArrival,omitempty" json:"closedToArrival,omitempty"`
+	Blackout        bool           `bson:"blackout,omitempty" json:"blackout,omitempty"`
+}
+
+// appliesOn reports whether the restriction covers the calendar date.
+func (r StayRestriction) appliesOn(date time.Time) bool {
+	if date.Before(civilDate(r.From.Time)) || !date.Before(civilDate(r.Till.Time)) {
+		return false
+	}
+	if len(r.Weekdays) == 0 {
+		return true
+	}
+	for _, wd := range r.Weekdays {
+		if wd == date.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns why the restriction doesn't allow the stay, if it doesn't.
+func (r StayRestriction) Check(stay BookParams) error {
+	var (
+		arrival   = civilDate(stay.FromDate.Time)
+		departure = civilDate(stay.TillDate.Time)
+		nights    = int(departure.Sub(arrival).Hours() / 24)
+		reasons   []string
+	)
+	if r.appliesOn(arrival) {
+		day := arrival.Format(time.DateOnly)
+		if r.ClosedToArrival {
+			reasons = append(reasons, fmt.Sprintf("no arrivals on %s %s", arrival.Weekday(), day))
+		}
+		if r.MinNights > 0 && nights < r.MinNights {
+			reasons = append(reasons, fmt.Sprintf("stays arriving on %s must be at least %d nights", day, r.MinNights))
+		}
+		if r.MaxNights > 0 && nights > r.MaxNights {
+			reasons = append(reasons, fmt.Sprintf("stays arriving on %s can be at most %d nights", day, r.MaxNights))
+		}
+	}
+	if r.Blackout {
+		for night := arrival; night.Before(departure); night = night.AddDate(0, 0, 1) {
+			if r.appliesOn(night) {
+				reasons = append(reasons, fmt.Sprintf("closed on the night of %s", night.Format(time.DateOnly)))
+				break
+			}
+		}
+	}
+	if len(reasons) == 0 {
+		return nil
+	}
+	msg := strings.Join(reasons, ", ")
+	if r.Name != "" {
+		msg = fmt.Sprintf("%s (%s)", msg, r.Name)
+	}
+	return errors.New(msg)
+}
+
+// CheckRestrictions checks the stay against all restrictions, reporting
+// every one it violates.
+func CheckRestrictions(restrictions []StayRestriction, stay BookParams) error {
+	var reasons []string
+	for _, r := range restrictions {
+		if err := r.Check(stay); err != nil {
+			reasons = append(reasons, err.Error())
+		}
+	}
+	if len(reasons) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(reasons, "; "))
+}
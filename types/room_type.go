@@ -0,0 +1,137 @@
+package types
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RoomType is a category of rooms sold interchangeably, e.g. "Double,
+// seaside". Guests book a type and the front desk assigns one of its rooms
+// later on.
+type RoomType struct {
+	ID      primitive.ObjectID `bson:"_id" json:"id,omitempty"`
+	HotelID primitive.ObjectID `bson:"hotelID" json:"hotelID"`
+	Name    string             `bson:"name" json:"name"`
+	Size    string             `bson:"size" json:"size"`
+	Seaside bool               `bson:"seaside" json:"seaside"`
+	Price   float64            `bson:"price" json:"price"`
+	Rates   *RoomRates         `bson:"rates,omitempty" json:"rates,omitempty"`
+	// Restrictions apply to stays in any room of the type.
+	Restrictions []StayRestriction `bson:"restrictions,omitempty" json:"restrictions,omitempty"`
+	// Overbooking overrides the hotel's overbooking percentage for the
+	// type, nil keeps it.
+	Overbooking *int `bson:"overbooking,omitempty" json:"overbooking,omitempty"`
+	Capacity    `bson:",inline"`
+}
+
+// OverbookingPercent returns the percentage of its rooms the type is
+// overbooked by in the hotel.
+func (t *RoomType) OverbookingPercent(hotel *Hotel) int {
+	if t.Overbooking != nil {
+		return *t.Overbooking
+	}
+	return hotel.Overbooking
+}
+
+type CreateRoomTypeParams struct {
+	HotelID      string            `json:"hotelID" validate:"required,len=24,hexadecimal"`
+	Name         string            `json:"name" validate:"required,min=2,max=100"`
+	Size         string            `json:"size" validate:"required,min=2,max=50"`
+	Seaside      bool              `json:"seaside"`
+	Price        float64           `json:"price" validate:"required,gt=0"`
+	Rates        *RoomRates        `json:"rates"`
+	MaxAdults    int               `json:"maxAdults" validate:"min=0"`
+	MaxChildren  int               `json:"maxChildren" validate:"min=0"`
+	ExtraBeds    int               `json:"extraBeds" validate:"min=0"`
+	Restrictions []StayRestriction `json:"restrictions" validate:"dive"`
+	Overbooking  *int              `json:"overbooking" validate:"omitempty,min=0,max=100"`
+}
+
+func (p *CreateRoomTypeParams) Validate(ctx context.Context) error {
+	validate := newValidator()
+	return validate.StructCtx(ctx, p)
+}
+
+func NewRoomTypeFromParams(hotelID primitive.ObjectID, params CreateRoomTypeParams) *RoomType {
+	return &RoomType{
+		ID:           primitive.NewObjectID(),
+		HotelID:      hotelID,
+		Name:         params.Name,
+		Size:         params.Size,
+		Seaside:      params.Seaside,
+		Price:        params.Price,
+		Rates:        params.Rates,
+		Restrictions: params.Restrictions,
+		Overbooking:  params.Overbooking,
+		Capacity: Capacity{
+			MaxAdults:   params.MaxAdults,
+			MaxChildren: params.MaxChildren,
+			ExtraBeds:   params.ExtraBeds,
+		},
+	}
+}
+
+type UpdateRoomTypeParams struct {
+	Name         string            `json:"name" validate:"omitempty,min=2,max=100"`
+	Size         string            `json:"size" validate:"omitempty,min=2,max=50"`
+	Seaside      *bool             `json:"seaside"`
+	Price        float64           `json:"price" validate:"omitempty,gt=0"`
+	Rates        *RoomRates        `json:"rates"`
+	MaxAdults    *int              `json:"maxAdults" validate:"omitempty,min=0"`
+	MaxChildren  *int              `json:"maxChildren" validate:"omitempty,min=0"`
+	ExtraBeds    *int              `json:"extraBeds" validate:"omitempty,min=0"`
+	Restrictions []StayRestriction `json:"restrictions" validate:"dive"`
+	Overbooking  *int              `json:"overbooking" validate:"omitempty,min=0,max=100"`
+}
+
+func (p *UpdateRoomTypeParams) Validate(ctx context.Context) error {
+	validate := newValidator()
+	return validate.StructCtx(ctx, p)
+}
+
+func (p UpdateRoomTypeParams) ToBSON() bson.M {
+	m := bson.M{}
+	if len(p.Name) != 0 {
+		m["name"] = p.Name
+	}
+	if len(p.Size) != 0 {
+		m["size"] = p.Size
+	}
+	if p.Seaside != nil {
+		m["seaside"] = *p.Seaside
+	}
+	if p.Price != 0 {
+		m["price"] = p.Price
+	}
+	if p.Rates != nil {
+		m["rates"] = p.Rates
+	}
+	if p.MaxAdults != nil {
+		m["maxAdults"] = *p.MaxAdults
+	}
+	if p.MaxChildren != nil {
+		m["maxChildren"] = *p.MaxChildren
+	}
+	if p.ExtraBeds != nil {
+		m["extraBeds"] = *p.ExtraBeds
+	}
+	if p.Restrictions != nil {
+		m["restrictions"] = p.Restrictions
+	}
+	if p.Overbooking != nil {
+		m["overbooking"] = *p.Overbooking
+	}
+	return m
+}
+
+// AssignRoomParams picks the physical room a booking stays in.
+type AssignRoomParams struct {
+	RoomID string `json:"roomID" validate:"required,len=24,hexadecimal"`
+}
+
+func (p *AssignRoomParams) Validate(ctx context.Context) error {
+	validate := newValidator()
+	return validate.StructCtx(ctx, p)
+}
@@ -0,0 +1,79 @@
+package types
+
+import "fmt"
+
+// ChargeBasis is what a fee is charged for.
+type ChargeBasis string
+
+const (
+	PerStay           ChargeBasis = "per-stay"
+	PerNight          ChargeBasis = "per-night"
+	PerPerson         ChargeBasis = "per-person"
+	PerPersonPerNight ChargeBasis = "per-person-per-night"
+)
+
+// Fee is a fixed charge on top of the room rate, such as a cleaning fee or
+// a city tax.
+type Fee struct {
+	Name   string      `bson:"name" json:"name" validate:"required"`
+	Amount Money       `bson:"amount" json:"amount"`
+	Basis  ChargeBasis `bson:"basis" json:"basis" validate:"oneof=per-stay per-night per-person per-person-per-night"`
+	// AdultsOnly leaves children out of the guests counted per person.
+	AdultsOnly bool `bson:"adultsOnly,omitempty" json:"adultsOnly,omitempty"`
+	// Taxable fees are subject to VAT like the room.
+	Taxable bool `bson:"taxable,omitempty" json:"taxable,omitempty"`
+}
+
+// VAT is levied on the room and on taxable fees. Inclusive VAT is already
+// part of the rates and fees and only itemized, exclusive VAT is added on
+// top.
+type VAT struct {
+	Percent   float64 `bson:"percent" json:"percent" validate:"gt=0,max=100"`
+	Inclusive bool    `bson:"inclusive,omitempty" json:"inclusive,omitempty"`
+}
+
+// TaxRules are the taxes and fees a hotel charges on every stay.
+type TaxRules struct {
+	VAT  *VAT  `bson:"vat,omitempty" json:"vat,omitempty"`
+	Fees []Fee `bson:"fees,omitempty" json:"fees,omitempty" validate:"dive"`
+}
+
+// Check returns why the rules can't be charged by a hotel whose rates are in
+// the currency, if they can't. Fees are charged in the rates' currency and
+// never take anything off.
+func (r *TaxRules) Check(currency string) error {
+	for _, fee := range r.Fees {
+		if fee.Amount.Amount < 0 {
+			return fmt.Errorf("fee %s can't be negative", fee.Name)
+		}
+		if fee.Amount.Currency != currency {
+			return fmt.Errorf("fee %s has to be charged in %s, the hotel's currency", fee.Name, currency)
+		}
+	}
+	return nil
+}
+
+type ChargeKind string
+
+const (
+	ChargeFee ChargeKind = "fee"
+	ChargeVAT ChargeKind = "vat"
+)
+
+// PriceCharge is a fee or tax itemized on a price.
+type PriceCharge struct {
+	Kind ChargeKind `bson:"kind" json:"kind"`
+	Name string     `bson:"name" json:"name"`
+	// Basis, Quantity and UnitAmount say how a fee adds up, e.g. 2 persons
+	// times 3 nights at 2.50.
+	Basis      ChargeBasis `bson:"basis,omitempty" json:"basis,omitempty"`
+	Quantity   int         `bson:"quantity,omitempty" json:"quantity,omitempty"`
+	UnitAmount int64       `bson:"unitAmount,omitempty" json:"unitAmount,omitempty"`
+	// Percent and Base say how VAT adds up.
+	Percent float64 `bson:"percent,omitempty" json:"percent,omitempty"`
+	Base    int64   `bson:"base,omitempty" json:"base,omitempty"`
+	Amount  int64   `bson:"amount" json:"amount"`
+	// Included charges are already part of the price and don't add to the
+	// total.
+	Included bool `bson:"included,omitempty" json:"included,omitempty"`
+}
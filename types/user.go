@@ -3,7 +3,6 @@ package types
 import (
 	"context"
 
-	"github.com/go-playground/validator"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"golang.org/x/crypto/bcrypt"
@@ -40,7 +39,7 @@ func (p UpdateUserParams) ToBSON() bson.M {
 }
 
 func (p *CreateUserParams) Validate(ctx context.Context) error {
-	validate := validator.New()
+	validate := newValidator()
 	if err := validate.StructCtx(ctx, p); err != nil {
 		return err
 	}
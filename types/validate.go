@@ -0,0 +1,35 @@
+package types
+
+import (
+	"reflect"
+	"regexp"
+
+	"github.com/go-playground/validator"
+)
+
+var currencyCode = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// newValidator returns the validator all params are checked with. Besides
+// the built-in tags it knows the currency tag, which accepts three letter
+// codes like EUR, checks dates like the times they hold and makes sure
+// payment schedules cancel unpaid bookings before arrival.
+func newValidator() *validator.Validate {
+	validate := validator.New()
+	validate.RegisterCustomTypeFunc(func(field reflect.Value) interface{} {
+		return field.Interface().(Date).Time
+	}, Date{})
+	validate.RegisterValidation("currency", func(fl validator.FieldLevel) bool {
+		return currencyCode.MatchString(fl.Field().String())
+	})
+	validate.RegisterStructValidation(validatePaymentSchedule, PaymentSchedule{})
+	return validate
+}
+
+// validatePaymentSchedule makes sure bookings are canceled before the guest
+// arrives, once the stay started they can only be canceled at the desk.
+func validatePaymentSchedule(sl validator.StructLevel) {
+	schedule := sl.Current().Interface().(PaymentSchedule)
+	if schedule.AutoCancel && schedule.GraceHours >= schedule.BalanceDueDays*24 {
+		sl.ReportError(schedule.GraceHours, "GraceHours", "graceHours", "beforearrival", "")
+	}
+}
@@ -0,0 +1,63 @@
+package types
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type WaitlistStatus string
+
+const (
+	// WaitlistWaiting entries wait for their dates to free up.
+	WaitlistWaiting WaitlistStatus = "waiting"
+	// WaitlistOffered entries were given a hold on the freed dates.
+	WaitlistOffered WaitlistStatus = "offered"
+	// WaitlistLeft entries were taken off the waitlist by the guest.
+	WaitlistLeft WaitlistStatus = "left"
+	// WaitlistLapsed entries let the hold they were offered expire, the
+	// dates go to the next guest waiting.
+	WaitlistLapsed WaitlistStatus = "lapsed"
+)
+
+// WaitlistEntry is a guest waiting for a sold-out stay in a room or, when
+// RoomID is empty, in any room of a room type. Like bookings, entries of a
+// room in a room type carry the room type too.
+type WaitlistEntry struct {
+	ID         primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UserID     primitive.ObjectID `json:"userID" bson:"userID"`
+	HotelID    primitive.ObjectID `json:"hotelID" bson:"hotelID"`
+	RoomID     primitive.ObjectID `json:"roomID,omitempty" bson:"roomID,omitempty"`
+	RoomTypeID primitive.ObjectID `json:"roomTypeID,omitempty" bson:"roomTypeID,omitempty"`
+	// FromDate and TillDate are the hotel's check-in and check-out times,
+	// like on bookings.
+	FromDate    time.Time      `json:"fromDate" bson:"fromDate"`
+	TillDate    time.Time      `json:"tillDate" bson:"tillDate"`
+	NumPersons  int            `json:"numPersons" bson:"numPersons"`
+	NumChildren int            `json:"numChildren,omitempty" bson:"numChildren,omitempty"`
+	Status      WaitlistStatus `json:"status" bson:"status"`
+	CreatedAt   time.Time      `json:"createdAt" bson:"createdAt"`
+	// HoldID is the pending booking the guest was offered once the dates
+	// freed up.
+	HoldID    primitive.ObjectID `json:"holdID,omitempty" bson:"holdID,omitempty"`
+	OfferedAt *time.Time         `json:"offeredAt,omitempty" bson:"offeredAt,omitempty"`
+	// NotifiedAt is when the guest was told about the hold. It stays empty
+	// while notifying them fails, until a retry gets through.
+	NotifiedAt *time.Time `json:"notifiedAt,omitempty" bson:"notifiedAt,omitempty"`
+}
+
+// Stay returns the stay the guest is waiting for.
+func (e *WaitlistEntry) Stay() BookParams {
+	return BookParams{
+		FromDate:    Date{Time: e.FromDate},
+		TillDate:    Date{Time: e.TillDate},
+		NumPersons:  e.NumPersons,
+		NumChildren: e.NumChildren,
+	}
+}
+
+// WaitlistDepth is how many guests wait for a stay at a hotel.
+type WaitlistDepth struct {
+	HotelID primitive.ObjectID `json:"hotelID"`
+	Waiting int                `json:"waiting"`
+}